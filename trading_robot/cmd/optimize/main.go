@@ -0,0 +1,138 @@
+// Command optimize searches strategy parameters by backtests of trades recorded in the trading robot journal and
+// writes ranked results to CSV or JSON, the format is chosen by the output file extension.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/optimizer"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/replay"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+// shown is the number of the best candidates printed
+const shown = 10
+
+func main() {
+	path := flag.String("journal", "journal.jsonl", "journal of the trades, its rotated files are read first")
+	period := flag.String("period", "", "candle period if the journal has no startup config")
+	strategy := flag.String("strategy", optimizer.EMAStrategy, "strategy to optimize: ema or macd")
+	emaPeriod := flag.String("ema-period", "10:200:10", "EMA period range min:max:step")
+	macdShort := flag.String("macd-short", "6:18:2", "MACD short period range")
+	macdLong := flag.String("macd-long", "20:40:2", "MACD long period range")
+	macdSignal := flag.String("macd-signal", "5:13:2", "MACD signal period range")
+	search := flag.String("search", "grid", "search of the parameter space: grid or random")
+	samples := flag.Int("samples", 100, "number of random search candidates")
+	seed := flag.Int64("seed", 1, "seed of random search")
+	objective := flag.String("objective", optimizer.PnLObjective, "ranking objective: pnl, sharpe or drawdown")
+	folds := flag.Int("folds", 0, "number of walk-forward windows, zero ranks candidates on all trades")
+	train := flag.Float64("train", optimizer.DefaultTrainFraction, "train part of walk-forward windows")
+	workers := flag.Int("workers", 0, "number of parallel backtests, the number of CPUs if zero")
+	out := flag.String("out", "optimize.csv", "output file, .json for JSON report and CSV otherwise")
+	verbose := flag.Bool("verbose", false, "log processing of the backtested candles and orders")
+	flag.Parse()
+
+	logger := log.NewLogger()
+	logger.SetLevel(logrus.FatalLevel)
+	if *verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+
+	space := optimizer.Space{Strategy: *strategy}
+	for _, r := range []struct {
+		value string
+		dest  *optimizer.Range
+	}{
+		{*emaPeriod, &space.EMAPeriod},
+		{*macdShort, &space.MACDShort},
+		{*macdLong, &space.MACDLong},
+		{*macdSignal, &space.MACDSignal},
+	} {
+		var err error
+		if *r.dest, err = optimizer.ParseRange(r.value); err != nil {
+			logger.Fatalf("Parse parameters failed: %s", err)
+		}
+	}
+
+	var (
+		candidates []optimizer.Params
+		err        error
+	)
+	switch *search {
+	case "grid":
+		candidates, err = space.Grid()
+	case "random":
+		candidates, err = space.Random(*samples, rand.New(rand.NewSource(*seed)))
+	default:
+		err = fmt.Errorf("unknown search %q", *search)
+	}
+	if err != nil {
+		logger.Fatalf("Setup search failed: %s", err)
+	}
+
+	cfg, err := config.ParseSettings(nil)
+	if err != nil {
+		logger.Fatalf("Parse default settings failed: %s", err)
+	}
+	cfg.Pair.Period = *period
+	records, err := journal.NewReader(*path)
+	if err != nil {
+		logger.Fatalf("Open journal failed: %s", err)
+	}
+	trades, cfg, err := replay.LoadTrades(records, cfg)
+	_ = records.Close()
+	if err != nil {
+		logger.Fatalf("Load trades failed: %s", err)
+	}
+
+	fmt.Printf("Backtesting %d candidates on %d trades\n", len(candidates), len(trades))
+	report, err := optimizer.Optimize(trades, candidates, cfg, optimizer.Settings{
+		Objective:     *objective,
+		Folds:         *folds,
+		TrainFraction: *train,
+		Workers:       *workers,
+	}, logger)
+	if err != nil {
+		logger.Fatalf("Optimize failed: %s", err)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		logger.Fatalf("Create output failed: %s", err)
+	}
+	if filepath.Ext(*out) == ".json" {
+		err = optimizer.WriteJSON(file, report)
+	} else {
+		err = optimizer.WriteCSV(file, report)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.Fatalf("Write output failed: %s", err)
+	}
+
+	for i, e := range report.Evaluations {
+		if i == shown {
+			break
+		}
+		fmt.Printf("%d. %s: score %g, pnl %g, sharpe %g, max drawdown %g, fills %d\n",
+			e.Rank, e.Params, e.Score, e.Train.PnL, e.Train.Sharpe, e.Train.MaxDrawdown, e.Train.Fills)
+	}
+	for _, f := range report.Folds {
+		fmt.Printf("Fold %d: best %s, train pnl %g, test from %s pnl %g, sharpe %g, max drawdown %g\n",
+			f.Fold, f.Best, f.Train.PnL, f.TestStart.UTC().Format("2006-01-02 15:04"), f.Test.PnL, f.Test.Sharpe, f.Test.MaxDrawdown)
+	}
+	if report.WalkForward != nil {
+		fmt.Printf("Walk-forward test: pnl %g, sharpe %g, max drawdown %g\n",
+			report.WalkForward.PnL, report.WalkForward.Sharpe, report.WalkForward.MaxDrawdown)
+	}
+	fmt.Printf("Results written to %s\n", *out)
+}
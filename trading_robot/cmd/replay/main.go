@@ -0,0 +1,54 @@
+// Command replay feeds a journal recorded by the trading robot back through candles generation, strategy and
+// processor and prints candles decided differently than recorded. It exits with status 1 if any decision diverges.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/replay"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	path := flag.String("journal", "journal.jsonl", "journal to replay, its rotated files are replayed first")
+	period := flag.String("period", "", "candle period until the startup config of the journal is replayed")
+	verbose := flag.Bool("verbose", false, "log processing of the replayed candles and orders")
+	flag.Parse()
+
+	logger := log.NewLogger()
+	logger.SetLevel(logrus.WarnLevel)
+	if *verbose {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+
+	cfg, err := config.ParseSettings(nil)
+	if err != nil {
+		logger.Fatalf("Parse default settings failed: %s", err)
+	}
+	cfg.Pair.Period = *period
+
+	records, err := journal.NewReader(*path)
+	if err != nil {
+		logger.Fatalf("Open journal failed: %s", err)
+	}
+	defer records.Close()
+
+	report, err := replay.Run(records, cfg, logger)
+	if err != nil {
+		logger.Fatalf("Replay failed: %s", err)
+	}
+
+	for _, d := range report.Divergences {
+		fmt.Println(d)
+	}
+	fmt.Printf("Replayed %d records: %d trades, %d candles, %d actions, %d divergences\n",
+		report.Records, report.Trades, report.Candles, report.Actions, len(report.Divergences))
+	if len(report.Divergences) > 0 {
+		os.Exit(1)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,17 +13,33 @@ import (
 
 	"github.com/keruch/tfs-go-hw/trading_robot/config"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/orders"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/processor"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/repository"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/router"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/signals"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/sizing"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/trailing"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/notifier"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/snapshot"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/tg"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
 )
 
 func main() {
+	dryRun := flag.Bool("dry-run", false, "simulate orders on live market data instead of sending them")
+	flag.Parse()
+
 	// setup logger and config
 	logger := log.NewLogger()
+	if *dryRun {
+		config.EnableDryRun()
+	}
 	err := config.SetupConfig()
 	if err != nil {
 		logger.Panicf("Setup config failed: %s", err)
@@ -29,16 +47,40 @@ func main() {
 	logger.Info("Setup config")
 
 	// setup strategy
-	strategy := indicator.SetupEMA100Strategy()
+	strategy, ema := indicator.SetupEMAStrategy(config.GetEMAPeriod())
 	logger.Info("Setup strategy")
 
+	// setup journal of everything the bot sees and does
+	var (
+		events *journal.Journal
+		exOpts []exchange.Option
+	)
+	if settings := config.GetJournal(); settings.Path != "" {
+		events, err = journal.Open(journal.Settings{
+			Path:     settings.Path,
+			MaxSize:  int64(settings.MaxSizeMB) << 20,
+			MaxFiles: settings.MaxFiles,
+		}, logger)
+		if err != nil {
+			logger.Panicf("Setup journal failed: %s", err)
+		}
+		defer events.Close()
+		events.Record(journal.ConfigRecord, "", configChange{Source: journal.StartupSource, Settings: config.GetRedactedSettings()})
+		exOpts = append(exOpts, exchange.WithJournal(events))
+		logger.Infof("Setup journal %s", settings.Path)
+	}
+
 	// setup exchange
-	kraken, err := exchange.NewKrakenExchange(logger)
+	ex, err := exchange.New(config.GetExchangeVenue(), logger, exOpts...)
 	if err != nil {
 		logger.Panicf("Setup exchange failed: %s", err)
 	}
-	defer kraken.CloseConnection()
-	logger.Info("Setup exchange")
+	if config.IsDryRun() {
+		ex = exchange.NewDryRun(ex, logger)
+		logger.Warn("Dry run: orders are simulated and never sent to exchange")
+	}
+	defer ex.CloseConnection()
+	logger.Infof("Setup %s exchange", ex.Venue())
 
 	// setup repository
 	repo, err := repository.NewPostgreSQLPool(config.GetDatabaseURL(), logger)
@@ -48,18 +90,116 @@ func main() {
 	logger.Info("Setup repository")
 
 	// setup telegram bot
-	telegram, err := tg.NewTelegramBot(config.GetTelegramBotToken(), logger)
+	telegram, err := tg.NewTelegramBot(config.GetTelegramBotToken(), repo, logger)
 	if err != nil {
 		logger.Panicf("Setup telegram failed: %s", err)
 	}
 	logger.Info("Setup telegram bot")
 
+	// setup notifier
+	notify, err := setupNotifier(telegram, logger)
+	if err != nil {
+		logger.Panicf("Setup notifier failed: %s", err)
+	}
+	defer notify.Close()
+	logger.Info("Setup notifier")
+
+	// setup events stream
+	hub := stream.NewHub(stream.DefaultBufferSize, logger)
+	logger.Info("Setup events stream")
+
+	// load state saved by previous run
+	var (
+		store    *snapshot.Store
+		state    botState
+		restored bool
+	)
+	if path := config.GetSnapshotPath(); path != "" {
+		store = snapshot.NewStore(path, logger)
+		state, restored = loadState(store, ex.Venue(), logger)
+	}
+
+	// setup orders manager, it is reconciled with the exchange once the processor is set up
+	manager := orders.NewManager(ex, notify, hub, logger)
+	if events != nil {
+		manager.SetJournal(events)
+	}
+	if restored {
+		if err = manager.Restore(state.Orders); err != nil {
+			logger.Errorf("Restore orders failed: %s", err)
+		}
+	}
+	logger.Info("Setup orders manager")
+
+	// setup trailing stops
+	trailer := trailing.NewTrailer(ex, repo, notify, logger)
+	trailer.SetOrderSender(manager)
+	trailer.SetSettings(trailingSettings(config.GetTrailing()))
+	if restored {
+		trailer.Restore(state.Trailing)
+	}
+	logger.Info("Setup trailing stops")
+
+	// setup position sizing, orders are sized by trading quantity on venues not reporting balances
+	sizer := setupSizer(ex, logger)
+	if sizer != nil && restored {
+		sizer.Restore(state.Sizing)
+	}
+	logger.Info("Setup position sizing")
+
 	// setup orders processor
-	proc := processor.NewOrdersProcessor(strategy, repo, kraken, telegram, logger)
+	proc := processor.NewOrdersProcessor(strategy, repo, ex, notify, hub, logger)
+	proc.SetOrderSender(manager)
+	if events != nil {
+		proc.SetJournal(events)
+	}
+	proc.SetTrailer(trailer)
+	if sizer != nil {
+		proc.SetSizer(sizer)
+	}
+	proc.SetTradingQuantity(config.GetTradingQuantity())
+	proc.SetPriceMultiplier(config.GetPriceMultiplier())
+	proc.SetProtection(processor.Protection{
+		StopLoss:      config.GetStopLoss(),
+		TakeProfit:    config.GetTakeProfit(),
+		TriggerSignal: config.GetTriggerSignal(),
+	})
+	proc.SetSignalSettings(signalSettings(config.GetSignals()))
+	if restored {
+		// settings changed with control API override config values
+		if err = proc.Restore(state.Processor); err != nil {
+			logger.Errorf("Restore processor failed: %s", err)
+		}
+	}
 	logger.Info("Setup processor")
 
+	// fills of protective orders change the position, orders filled while the bot was down are applied
+	// by reconciliation, orders left on exchange by previous runs are flagged as orphaned
+	manager.SetFillHandler(proc)
+	if err = manager.Reconcile(); err != nil {
+		logger.Errorf("Reconcile orders failed: %s", err)
+	}
+	logger.Info("Reconcile orders")
+
+	// apply safe to change settings on config file change
+	watchConfig(proc, trailer, sizer, ema, events, logger)
+
 	// setup router
-	r := router.NewRouter(kraken, proc, logger)
+	r := router.NewRouter(ex, proc, config.GetRedactedSettings, logger)
+	auth, err := router.NewAuthenticator(router.AuthMode(config.GetAuthMode()), config.GetAuthKeys(), config.GetAuthMaxSkew())
+	if err != nil {
+		logger.Panicf("Setup authenticator failed: %s", err)
+	}
+	audit, err := log.NewAuditLogger(config.GetAuditLogPath())
+	if err != nil {
+		logger.Panicf("Setup audit log failed: %s", err)
+	}
+	r.UseAuth(auth, audit)
+	if events != nil {
+		r.UseJournal(events)
+	}
+	r.HandleStream(hub)
+	r.HandleOrders(manager)
 	logger.Info("Setup router")
 
 	// setup server
@@ -76,17 +216,45 @@ func main() {
 	// start tg bot
 	go telegram.Serve(botCtx)
 
+	// report exchange connection state
+	go watchConnection(botCtx, ex.ConnectionStates(), hub, notify, logger)
+
 	logger.Info("Starting bot")
 	// start processing
 	var shutdownWait sync.WaitGroup
 	proc.StartTradingBotProcessor(botCtx, &shutdownWait)
+	manager.StartPolling(botCtx, orders.DefaultPollInterval, &shutdownWait)
+	if restored && len(state.Pairs) > 0 {
+		if err = ex.SubscribePairs(state.Pairs...); err != nil {
+			logger.Errorf("Restore pairs %v failed: %s", state.Pairs, err)
+		} else {
+			logger.Infof("Restored pairs %v", state.Pairs)
+		}
+	}
+	if store != nil {
+		store.StartSaving(botCtx, config.GetSnapshotInterval(), func() interface{} {
+			return collectState(ex, proc, manager, trailer, sizer)
+		}, &shutdownWait)
+	}
 
-	// setup signals handler
+	// setup shutdown handler, shutdown is triggered either by signal or by the control endpoint
 	shutdownSig := make(chan os.Signal, 1)
 	signal.Notify(shutdownSig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	shutdownReq := make(chan struct{}, 1)
+	r.HandleShutdown(ex, proc, notify, func() {
+		select {
+		case shutdownReq <- struct{}{}:
+		default: // shutdown already requested
+		}
+	})
 
 	go func() {
-		<-shutdownSig
+		select {
+		case <-shutdownSig:
+			logger.Info("Shutdown by signal")
+		case <-shutdownReq:
+			logger.Info("Shutdown by request")
+		}
 
 		// give 5 seconds to shutdown
 		forceShutdownCtx, forceShutdown := context.WithTimeout(botCtx, time.Second*5)
@@ -99,25 +267,216 @@ func main() {
 		}()
 
 		// Trigger graceful shutdown
-		err = srv.Shutdown(forceShutdownCtx)
-		if err != nil {
+		if err := srv.Shutdown(forceShutdownCtx); err != nil {
 			logger.Panic(err)
 		}
 		logger.Info("Server done")
 
 		botShutdown()
-		err = kraken.CloseConnection()
-		if err != nil {
+		if err := ex.CloseConnection(); err != nil {
 			logger.Panic(err)
 		}
 		logger.Info("WS exchange connection done")
 	}()
 
-	if err = srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if certFile, keyFile := config.GetTLSFiles(); certFile != "" && keyFile != "" {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		logger.Panicf("ListenAndServe: %s", err)
 	}
 
 	shutdownWait.Wait()
 
+	// processing is stopped, so the final snapshot is consistent
+	if store != nil {
+		if err = store.Save(collectState(ex, proc, manager, trailer, sizer)); err != nil {
+			logger.Errorf("Save snapshot failed: %s", err)
+		} else {
+			logger.Info("Snapshot saved")
+		}
+	}
+
 	logger.Infof("Trading robot close")
 }
+
+// configChange is the journal record of settings loaded on startup or reloaded from the config file
+type configChange struct {
+	Source   string                 `json:"source"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// watchConfig applies changed trading and strategy settings on config reload. Only changed values are applied,
+// so settings set with control API are kept on unrelated config changes. Reloads are recorded to the journal if it is set.
+func watchConfig(proc *processor.OrdersProcessor, trailer *trailing.Trailer, sizer *sizing.Sizer, ema *indicator.EMAEvaluator,
+	events *journal.Journal, logger *log.Logger) {
+	var mu sync.Mutex
+	current := config.Reloadable{
+		Trading: config.TradingConfig{
+			Quantity:      config.GetTradingQuantity(),
+			Multiplier:    config.GetPriceMultiplier(),
+			StopLoss:      config.GetStopLoss(),
+			TakeProfit:    config.GetTakeProfit(),
+			TriggerSignal: config.GetTriggerSignal(),
+			Trailing:      config.GetTrailing(),
+			Sizing:        config.GetSizing(),
+			Signals:       config.GetSignals(),
+		},
+		Strategy: config.StrategyConfig{EMAPeriod: config.GetEMAPeriod()},
+	}
+
+	config.WatchConfig(func(r config.Reloadable) {
+		mu.Lock()
+		defer mu.Unlock()
+		if events != nil {
+			events.Record(journal.ConfigRecord, "", configChange{Source: journal.FileSource, Settings: config.GetRedactedSettings()})
+		}
+		if r.Trading.Quantity != current.Trading.Quantity {
+			proc.SetTradingQuantity(r.Trading.Quantity)
+			logger.Infof("Trading quantity reloaded: %d", r.Trading.Quantity)
+		}
+		if r.Trading.Multiplier != current.Trading.Multiplier {
+			proc.SetPriceMultiplier(r.Trading.Multiplier)
+			logger.Infof("Price multiplier reloaded: %g", r.Trading.Multiplier)
+		}
+		if r.Trading.StopLoss != current.Trading.StopLoss || r.Trading.TakeProfit != current.Trading.TakeProfit ||
+			r.Trading.TriggerSignal != current.Trading.TriggerSignal {
+			proc.SetProtection(processor.Protection{
+				StopLoss:      r.Trading.StopLoss,
+				TakeProfit:    r.Trading.TakeProfit,
+				TriggerSignal: r.Trading.TriggerSignal,
+			})
+			logger.Infof("Protection reloaded: stop loss %g, take profit %g, trigger %s",
+				r.Trading.StopLoss, r.Trading.TakeProfit, r.Trading.TriggerSignal)
+		}
+		if r.Trading.Trailing != current.Trading.Trailing {
+			trailer.SetSettings(trailingSettings(r.Trading.Trailing))
+			logger.Infof("Trailing stop reloaded: %s mode, distance %g", r.Trading.Trailing.Mode, r.Trading.Trailing.Distance)
+		}
+		if r.Trading.Sizing != current.Trading.Sizing && sizer != nil {
+			sizer.SetSettings(sizingSettings(r.Trading.Sizing))
+			logger.Infof("Sizing reloaded: %s rule, fraction %g", r.Trading.Sizing.Rule, r.Trading.Sizing.Fraction)
+		}
+		if r.Trading.Signals != current.Trading.Signals {
+			proc.SetSignalSettings(signalSettings(r.Trading.Signals))
+			logger.Infof("Signals reloaded: cooldown %s, pyramiding %d", r.Trading.Signals.Cooldown, r.Trading.Signals.Pyramiding)
+		}
+		if r.Strategy.EMAPeriod != current.Strategy.EMAPeriod {
+			ema.SetPeriod(r.Strategy.EMAPeriod)
+			logger.Infof("EMA period reloaded: %d", r.Strategy.EMAPeriod)
+		}
+		current = r
+	}, logger)
+}
+
+func signalSettings(c config.SignalsConfig) signals.Settings {
+	return signals.Settings{
+		Cooldown:   c.Cooldown,
+		Pyramiding: c.Pyramiding,
+	}
+}
+
+func trailingSettings(c config.TrailingConfig) trailing.Settings {
+	return trailing.Settings{
+		Mode:      c.Mode,
+		Distance:  c.Distance,
+		ATRPeriod: c.ATRPeriod,
+	}
+}
+
+// setupSizer returns sizer of orders, nil if the venue does not report balances and instruments
+func setupSizer(ex exchange.Exchange, logger *log.Logger) *sizing.Sizer {
+	venue := ex
+	if dryRun, ok := ex.(*exchange.DryRunExchange); ok {
+		// simulated orders are sized by balances and instruments of the live account
+		venue = dryRun.Exchange
+	}
+	if _, ok := venue.(sizing.Account); !ok {
+		if rule := config.GetSizing().Rule; rule != sizing.FixedRule {
+			logger.Errorf("%s venue does not report balances, %s sizing is disabled and orders are sized by trading quantity", ex.Venue(), rule)
+		}
+		return nil
+	}
+	sizer := sizing.NewSizer(ex.(sizing.Account), logger)
+	sizer.SetSettings(sizingSettings(config.GetSizing()))
+	return sizer
+}
+
+func sizingSettings(c config.SizingConfig) sizing.Settings {
+	return sizing.Settings{
+		Rule:      c.Rule,
+		Fraction:  c.Fraction,
+		ATRPeriod: c.ATRPeriod,
+	}
+}
+
+// watchConnection logs exchange connection states, streams them and notifies about connection failures
+func watchConnection(ctx context.Context, states <-chan utils.ConnEvent, hub *stream.Hub, notify *notifier.Composite, logger *log.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-states:
+			data := stream.Connection{State: string(e.State)}
+			if e.Err != nil {
+				data.Error = e.Err.Error()
+			}
+			hub.Publish(stream.NewEvent(stream.ConnectionEvent, "", data))
+
+			switch e.State {
+			case utils.StateConnected:
+				logger.Info("Exchange connected")
+			case utils.StateStale, utils.StateDisconnected, utils.StateResubscribing:
+				logger.Warnf("Exchange connection %s: %s", e.State, e.Err)
+				notify.NotifyError(fmt.Sprintf("Exchange connection %s: %s", e.State, e.Err))
+			default:
+				logger.Debugf("Exchange connection %s: %v", e.State, e.Err)
+			}
+		}
+	}
+}
+
+// setupNotifier creates notifier with telegram and all backends enabled in config
+func setupNotifier(telegram *tg.TelegramBot, logger *log.Logger) (*notifier.Composite, error) {
+	channels := []notifier.Channel{newChannel("telegram", notifier.NewUsersBackend("telegram", telegram))}
+
+	if u := config.GetWebhookURL(); u != "" {
+		channels = append(channels, newChannel("webhook", notifier.NewWebhook(u)))
+	}
+
+	if e := config.GetEmailSettings(); e.Address != "" {
+		channels = append(channels, newChannel("email", &notifier.Email{
+			Address:  e.Address,
+			Username: e.Username,
+			Password: e.Password,
+			From:     e.From,
+			To:       e.To,
+		}))
+	}
+
+	if path := config.GetNotifierFilePath(); path != "" {
+		file, err := notifier.NewFile(path)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, newChannel("file", file))
+	}
+
+	return notifier.NewComposite(config.GetNotifierTimeout(), logger, channels...)
+}
+
+func newChannel(name string, backend notifier.Backend) notifier.Channel {
+	s := config.GetNotifierChannel(name)
+	ch := notifier.Channel{
+		Backend:  backend,
+		Template: s.Template,
+		Retries:  s.Retries,
+		Backoff:  s.Backoff,
+	}
+	if s.RateLimit > 0 {
+		ch.Limiter = ratelimit.NewTokenBucket(float64(s.RateLimit), s.RateInterval)
+	}
+	return ch
+}
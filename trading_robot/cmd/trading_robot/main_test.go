@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/krakensim"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/orders"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/processor"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/router"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/sizing"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/trailing"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/snapshot"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type repoStub struct {
+	mu     sync.Mutex
+	orders []domain.CreateOrderResponse
+}
+
+func (r *repoStub) StoreToDB(_ context.Context, response domain.CreateOrderResponse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.orders = append(r.orders, response)
+	return nil
+}
+
+func (r *repoStub) StoreStopMove(context.Context, domain.StopMove) error {
+	return nil
+}
+
+func (r *repoStub) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.orders)
+}
+
+type notifierStub struct{}
+
+func (notifierStub) NotifyUsers(string) {}
+func (notifierStub) NotifyError(string) {}
+
+// TestTradingRobot runs the bot wiring against Kraken simulator: pair is subscribed with control API,
+// trades are aggregated to candles and the strategy signal is executed on the simulator
+func TestTradingRobot(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	viper.Set("pair.period", string(domain.CandlePeriod1m))
+	defer viper.Reset()
+
+	const pair = "PI_XBTUSD"
+	sim := krakensim.New("public", "c2VjcmV0")
+	defer sim.Close()
+
+	ex, err := exchange.NewKrakenExchange(logger,
+		exchange.WithEndpoints(sim.RESTURL(), sim.WSURL()),
+		exchange.WithCredentials("public", "c2VjcmV0"),
+		exchange.WithWSConn(func(c *utils.RetryableWSConn) { c.MinBackoff = time.Millisecond }),
+	)
+	a.NoError(err)
+	defer ex.CloseConnection()
+
+	strategy, _ := indicator.SetupEMAStrategy(2)
+	repo := &repoStub{}
+	hub := stream.NewHub(stream.DefaultBufferSize, logger)
+	proc := processor.NewOrdersProcessor(strategy, repo, ex, notifierStub{}, hub, logger)
+	proc.SetPriceMultiplier(0.01)
+	proc.SetProtection(processor.Protection{StopLoss: 0.05})
+	manager := orders.NewManager(ex, notifierStub{}, hub, logger)
+	manager.SetFillHandler(proc)
+	a.NoError(manager.Reconcile())
+	proc.SetOrderSender(manager)
+	trailer := trailing.NewTrailer(ex, repo, notifierStub{}, logger)
+	trailer.SetOrderSender(manager)
+	proc.SetTrailer(trailer)
+	sizer := sizing.NewSizer(ex, logger)
+	proc.SetSizer(sizer)
+
+	r := router.NewRouter(ex, proc, config.GetRedactedSettings, logger)
+	r.HandleStream(hub)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	orderEvents := hub.Subscribe(stream.Filter{Types: map[stream.EventType]bool{stream.OrderEvent: true}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	proc.StartTradingBotProcessor(ctx, &wg)
+
+	testID := 0
+	t.Logf("\tTest %d:\tsubscribe pair with control API", testID)
+	{
+		resp, err := http.Post(srv.URL+"/subscribe/"+pair, "application/json", nil)
+		a.NoError(err)
+		a.Equal(http.StatusOK, resp.StatusCode)
+		_ = resp.Body.Close()
+		a.Eventually(func() bool { return sim.Subscribed(kraken.TradesFeed, pair) }, 2*time.Second, 10*time.Millisecond)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlong signal is executed", testID)
+	{
+		// candles are closed by the first trade of the next minute: 100, 110 and the order is sent on 110 close
+		start := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+		sim.PlayPath(pair, start, time.Minute, 100, 110, 110)
+
+		select {
+		case e := <-orderEvents.Events():
+			order := e.Data.(domain.CreateOrderResponse)
+			a.Equal(string(domain.BuyOrder), order.Side)
+			a.Equal(kraken.PlacedStatus, order.Status)
+		case <-time.After(2 * time.Second):
+			t.Fatal("order event not published")
+		}
+
+		a.Equal(100.0, sim.Position(pair))
+		a.Equal(100, proc.GetPosition())
+		a.Eventually(func() bool { return repo.count() == 1 }, time.Second, 10*time.Millisecond, "Order should be stored")
+		a.Eventually(func() bool { return len(sim.Orders()) == 2 }, time.Second, 10*time.Millisecond, "Stop loss should be placed")
+		signalID := domain.SignalID(pair, start.Add(time.Minute), domain.BuyOrder)
+		a.Equal(domain.ClientOrderID(signalID, 1), sim.Orders()[0].CliOrdID, "Order should have client order ID of the signal")
+
+		stop := sim.Orders()[1]
+		a.Equal(domain.StopOrder, stop.Type)
+		a.Equal(string(domain.SellOrder), stop.Side)
+		a.Equal(104.5, stop.StopPrice)
+		a.Equal(domain.MarkPriceTrigger, stop.TriggerSignal)
+		a.True(stop.ReduceOnly)
+		a.Equal(domain.ProtectiveOrderID(signalID, domain.StopOrder), stop.CliOrdID)
+
+		a.Eventually(func() bool { return len(manager.Orders()) == 2 }, time.Second, 10*time.Millisecond)
+		a.Equal([]string{
+			sim.Orders()[0].ID + " " + string(orders.StateFilled),
+			stop.ID + " " + string(orders.StateOpen),
+		}, orderStates(manager))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop loss closes position", testID)
+	{
+		sim.Trade(pair, 100, 1, time.Date(2021, 11, 25, 19, 3, 0, 0, time.UTC))
+		a.Equal(0.0, sim.Position(pair))
+
+		// the trade closes the last candle, halt waits until it is processed
+		closed := time.Date(2021, 11, 25, 19, 2, 0, 0, time.UTC)
+		a.Eventually(func() bool { return proc.GetLastCandleTime().Equal(closed) }, time.Second, 10*time.Millisecond)
+		proc.Halt()
+		proc.Resume()
+
+		a.Equal(100, proc.GetPosition())
+		a.NoError(manager.Reconcile())
+		a.Equal(0, proc.GetPosition(), "Fill of the stop loss should close position of the bot")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstate is restored after restart", testID)
+	{
+		store := snapshot.NewStore(filepath.Join(t.TempDir(), "state.json"), logger)
+		a.NoError(store.Save(collectState(ex, proc, manager, trailer, sizer)))
+
+		_, found := loadState(store, exchange.BinanceVenue, logger)
+		a.False(found, "Snapshot of other venue should be ignored")
+
+		state, found := loadState(store, exchange.KrakenVenue, logger)
+		a.True(found)
+		a.Equal([]string{pair}, state.Pairs)
+
+		restoredStrategy, _ := indicator.SetupEMAStrategy(2)
+		restored := processor.NewOrdersProcessor(restoredStrategy, repo, ex, notifierStub{}, hub, logger)
+		a.NoError(restored.Restore(state.Processor))
+		a.Zero(restored.GetPosition())
+		a.Equal(0.01, restored.GetPriceMultiplier())
+		a.Equal(proc.GetStrategyState(), restored.GetStrategyState())
+
+		restoredManager := orders.NewManager(ex, notifierStub{}, hub, logger)
+		a.NoError(restoredManager.Restore(state.Orders))
+		a.Equal(orderStates(manager), orderStates(restoredManager))
+	}
+}
+
+func orderStates(m *orders.Manager) []string {
+	ids := make([]string, 0)
+	for _, o := range m.Orders() {
+		ids = append(ids, o.ID+" "+string(o.State))
+	}
+	return ids
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/orders"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/processor"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/sizing"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/trailing"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/snapshot"
+)
+
+// botState is the state persisted between restarts
+type botState struct {
+	Venue     string             `json:"venue"`
+	Time      time.Time          `json:"time"`
+	Pairs     []string           `json:"pairs"`
+	Processor processor.Snapshot `json:"processor"`
+	Orders    []orders.Order     `json:"orders"`
+	Trailing  trailing.Snapshot  `json:"trailing"`
+	Sizing    sizing.Snapshot    `json:"sizing"`
+}
+
+// collectState returns current state, sizer is nil on venues not reporting balances
+func collectState(ex exchange.Exchange, proc *processor.OrdersProcessor, manager *orders.Manager,
+	trailer *trailing.Trailer, sizer *sizing.Sizer) botState {
+	state := botState{
+		Venue:     ex.Venue(),
+		Time:      time.Now(),
+		Pairs:     ex.GetPairs(),
+		Processor: proc.Snapshot(),
+		Orders:    manager.Orders(),
+		Trailing:  trailer.Snapshot(),
+	}
+	if sizer != nil {
+		state.Sizing = sizer.Snapshot()
+	}
+	return state
+}
+
+// loadState returns state saved by the previous run on the same venue, state of other venue is ignored
+func loadState(store *snapshot.Store, venue string, logger *log.Logger) (botState, bool) {
+	var state botState
+	found, err := store.Load(&state)
+	switch {
+	case err != nil:
+		logger.Errorf("Load snapshot failed, starting from scratch: %s", err)
+		return botState{}, false
+	case !found:
+		logger.Info("No snapshot found, starting from scratch")
+		return botState{}, false
+	case state.Venue != venue:
+		logger.Warnf("Snapshot of %s venue ignored", state.Venue)
+		return botState{}, false
+	}
+	logger.Infof("Loaded snapshot saved at %s", state.Time.Format(time.RFC3339))
+	return state, true
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
 	"github.com/spf13/viper"
@@ -14,13 +16,98 @@ func SetupConfig() error {
 	viper.AddConfigPath("./config")
 	viper.AddConfigPath("./trading_robot")
 	viper.AddConfigPath("./trading_robot/config")
+	setDefaults(viper.GetViper())
+	setupEnv()
 
 	err := viper.ReadInConfig()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = Load()
+	return err
+}
+
+func setDefaults(v *viper.Viper) {
+	// control API is protected unless auth is explicitly disabled
+	v.SetDefault("server.auth.mode", "api_key")
+	v.SetDefault("exchange.venue", "kraken")
+	v.SetDefault("trading.quantity", 100)
+	v.SetDefault("trading.trigger_signal", "mark")
+	v.SetDefault("trading.trailing.atr_period", 14)
+	v.SetDefault("trading.sizing.rule", "fixed")
+	v.SetDefault("trading.sizing.atr_period", 14)
+	v.SetDefault("strategy.ema_period", 100)
+	v.SetDefault("snapshot.interval", "30s")
+	v.SetDefault("journal.max_size_mb", 100)
+	v.SetDefault("journal.max_files", 10)
+}
+
+const redacted = "REDACTED"
+
+// secretKeys are substrings of setting names whose values must not be exposed
+var secretKeys = []string{"key", "token", "password", "secret"}
+
+// GetRedactedSettings returns all settings with secret values replaced
+func GetRedactedSettings() map[string]interface{} {
+	return redact(viper.AllSettings(), false)
+}
+
+// redact replaces values of secret settings, all values of a secret section are secret, e.g. API keys of clients
+// in server.auth.keys named after the clients. The structure is kept, so redacted settings are still parsed.
+func redact(settings map[string]interface{}, secret bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(settings))
+	for key, val := range settings {
+		secretKey := secret || isSecret(key)
+		if nested, ok := val.(map[string]interface{}); ok {
+			out[key] = redact(nested, secretKey)
+			continue
+		}
+		out[key] = val
+		if secretKey && val != "" {
+			out[key] = redacted
+		}
+	}
+	return out
+}
+
+func isSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func GetExchangeVenue() string {
+	return viper.GetString("exchange.venue")
+}
+
+// EnableDryRun turns dry run on whatever the config file says, it should be called before SetupConfig
+func EnableDryRun() {
+	viper.Set("exchange.dry_run", true)
+}
+
+func IsDryRun() bool {
+	return viper.GetBool("exchange.dry_run")
+}
+
+func GetSnapshotPath() string {
+	return viper.GetString("snapshot.path")
+}
+
+func GetSnapshotInterval() time.Duration {
+	return viper.GetDuration("snapshot.interval")
+}
+
+func GetJournal() JournalConfig {
+	return JournalConfig{
+		Path:      viper.GetString("journal.path"),
+		MaxSizeMB: viper.GetInt("journal.max_size_mb"),
+		MaxFiles:  viper.GetInt("journal.max_files"),
+	}
 }
 
 func GetPrivateKey() string {
@@ -52,3 +139,128 @@ func GetTelegramBotToken() string {
 func GetServerAddress() string {
 	return viper.GetString("server.address")
 }
+
+func GetTradingQuantity() int {
+	return viper.GetInt("trading.quantity")
+}
+
+func GetPriceMultiplier() float64 {
+	return viper.GetFloat64("trading.multiplier")
+}
+
+// GetStopLoss returns distance of stop loss orders from entry price as a fraction of the price
+func GetStopLoss() float64 {
+	return viper.GetFloat64("trading.stop_loss")
+}
+
+// GetTakeProfit returns distance of take profit orders from entry price as a fraction of the price
+func GetTakeProfit() float64 {
+	return viper.GetFloat64("trading.take_profit")
+}
+
+func GetTriggerSignal() string {
+	return viper.GetString("trading.trigger_signal")
+}
+
+func GetTrailing() TrailingConfig {
+	return TrailingConfig{
+		Mode:      viper.GetString("trading.trailing.mode"),
+		Distance:  viper.GetFloat64("trading.trailing.distance"),
+		ATRPeriod: viper.GetInt("trading.trailing.atr_period"),
+	}
+}
+
+func GetSizing() SizingConfig {
+	return SizingConfig{
+		Rule:      viper.GetString("trading.sizing.rule"),
+		Fraction:  viper.GetFloat64("trading.sizing.fraction"),
+		ATRPeriod: viper.GetInt("trading.sizing.atr_period"),
+	}
+}
+
+func GetSignals() SignalsConfig {
+	return SignalsConfig{
+		Cooldown:   viper.GetDuration("trading.signals.cooldown"),
+		Pyramiding: viper.GetInt("trading.signals.pyramiding"),
+	}
+}
+
+func GetEMAPeriod() int {
+	return viper.GetInt("strategy.ema_period")
+}
+
+// ChannelSettings are delivery settings of the notification channel
+type ChannelSettings struct {
+	Template     string
+	Retries      int
+	Backoff      time.Duration
+	RateLimit    int           // max messages per rate interval, unlimited if zero
+	RateInterval time.Duration // minute by default
+}
+
+func GetNotifierTimeout() time.Duration {
+	return viper.GetDuration("notifier.timeout")
+}
+
+func GetNotifierChannel(name string) ChannelSettings {
+	prefix := "notifier." + name + "."
+	s := ChannelSettings{
+		Template:     viper.GetString(prefix + "template"),
+		Retries:      viper.GetInt(prefix + "retries"),
+		Backoff:      viper.GetDuration(prefix + "backoff"),
+		RateLimit:    viper.GetInt(prefix + "rate_limit"),
+		RateInterval: viper.GetDuration(prefix + "rate_interval"),
+	}
+	if s.RateInterval == 0 {
+		s.RateInterval = time.Minute
+	}
+	return s
+}
+
+func GetWebhookURL() string {
+	return viper.GetString("notifier.webhook.url")
+}
+
+type EmailSettings struct {
+	Address  string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func GetEmailSettings() EmailSettings {
+	return EmailSettings{
+		Address:  viper.GetString("notifier.email.address"),
+		Username: viper.GetString("notifier.email.username"),
+		Password: viper.GetString("notifier.email.password"),
+		From:     viper.GetString("notifier.email.from"),
+		To:       viper.GetStringSlice("notifier.email.to"),
+	}
+}
+
+func GetNotifierFilePath() string {
+	return viper.GetString("notifier.file.path")
+}
+
+func GetAuthMode() string {
+	return viper.GetString("server.auth.mode")
+}
+
+// GetAuthKeys returns map of client names to their API keys or HMAC secrets
+func GetAuthKeys() map[string]string {
+	return viper.GetStringMapString("server.auth.keys")
+}
+
+func GetAuthMaxSkew() time.Duration {
+	return viper.GetDuration("server.auth.max_skew")
+}
+
+func GetAuditLogPath() string {
+	return viper.GetString("server.audit_log")
+}
+
+// GetTLSFiles returns certificate and key files, TLS is disabled if they are empty
+func GetTLSFiles() (certFile, keyFile string) {
+	return viper.GetString("server.tls_cert"), viper.GetString("server.tls_key")
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	a := assert.New(t)
+
+	t.Logf("\tTest %d:\tsecrets redacted in nested settings", 0)
+	{
+		settings := map[string]interface{}{
+			"api": map[string]interface{}{
+				"private_key":  "c2VjcmV0",
+				"public_key":   "public",
+				"tg_bot_token": "",
+			},
+			"database": map[string]interface{}{
+				"address":  "localhost",
+				"password": "qwerty",
+			},
+			"server": map[string]interface{}{
+				"address": ":8091",
+				"auth": map[string]interface{}{
+					"mode": "api_key",
+					"keys": map[string]interface{}{
+						"alice": "alice-secret",
+						"bob":   "bob-secret",
+					},
+				},
+			},
+		}
+
+		expected := map[string]interface{}{
+			"api": map[string]interface{}{
+				"private_key":  redacted,
+				"public_key":   redacted,
+				"tg_bot_token": "",
+			},
+			"database": map[string]interface{}{
+				"address":  "localhost",
+				"password": redacted,
+			},
+			"server": map[string]interface{}{
+				"address": ":8091",
+				"auth": map[string]interface{}{
+					"mode": "api_key",
+					"keys": map[string]interface{}{
+						"alice": redacted,
+						"bob":   redacted,
+					},
+				},
+			},
+		}
+		a.Equal(expected, redact(settings, false))
+		a.Equal("qwerty", settings["database"].(map[string]interface{})["password"], "Source settings should not change")
+	}
+}
+
+func setValidConfig() {
+	viper.Reset()
+	setupEnv()
+	viper.Set("pair.period", "1m")
+	viper.Set("exchange.venue", "kraken")
+	viper.Set("api.private_key", "c2VjcmV0")
+	viper.Set("api.public_key", "public")
+	viper.Set("api.tg_bot_token", "token")
+	viper.Set("server.address", ":8091")
+	viper.Set("server.auth.mode", "api_key")
+	viper.Set("server.auth.keys", map[string]string{"alice": "secret"})
+	viper.Set("database.address", "localhost")
+	viper.Set("database.name", "trading")
+	viper.Set("database.scheme", "postgres")
+	viper.Set("trading.quantity", 100)
+	viper.Set("strategy.ema_period", 100)
+	viper.Set("snapshot.interval", "30s")
+}
+
+func TestLoad(t *testing.T) {
+	a := assert.New(t)
+	defer viper.Reset()
+
+	testID := 0
+	t.Logf("\tTest %d:\tvalid config", testID)
+	{
+		setValidConfig()
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(":8091", cfg.Server.Address)
+		a.Equal(Reloadable{Trading: TradingConfig{Quantity: 100}, Strategy: StrategyConfig{EMAPeriod: 100}}, cfg.Reloadable())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tmissing server address", testID)
+	{
+		setValidConfig()
+		viper.Set("server.address", "")
+		_, err := Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tauth keys", testID)
+	{
+		setValidConfig()
+		viper.Set("server.auth.keys", map[string]string{})
+		_, err := Load()
+		a.Error(err, "Keys are required in api_key mode")
+
+		viper.Set("server.auth.mode", "hmac")
+		_, err = Load()
+		a.Error(err, "Keys are required in hmac mode")
+
+		viper.Set("server.auth.mode", "none")
+		_, err = Load()
+		a.NoError(err)
+
+		setValidConfig()
+		viper.Set("server.auth.keys", map[string]string{"alice": ""})
+		_, err = Load()
+		a.Error(err, "Empty keys are invalid")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid period and quantity", testID)
+	{
+		setValidConfig()
+		viper.Set("pair.period", "5m")
+		_, err := Load()
+		a.Error(err)
+
+		setValidConfig()
+		viper.Set("trading.quantity", 0)
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tprotective orders settings", testID)
+	{
+		setValidConfig()
+		viper.Set("trading.stop_loss", 0.02)
+		viper.Set("trading.take_profit", 0.05)
+		viper.Set("trading.trigger_signal", "last")
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(TradingConfig{Quantity: 100, StopLoss: 0.02, TakeProfit: 0.05, TriggerSignal: "last"}, cfg.Trading)
+
+		viper.Set("trading.stop_loss", 1)
+		_, err = Load()
+		a.Error(err)
+
+		setValidConfig()
+		viper.Set("trading.trigger_signal", "bid")
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\ttrailing stop settings", testID)
+	{
+		setValidConfig()
+		viper.Set("trading.trailing.mode", "atr")
+		viper.Set("trading.trailing.distance", 2.5)
+		viper.Set("trading.trailing.atr_period", 14)
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(TrailingConfig{Mode: "atr", Distance: 2.5, ATRPeriod: 14}, cfg.Trading.Trailing)
+		a.Equal(cfg.Trading.Trailing, GetTrailing())
+
+		viper.Set("trading.trailing.mode", "chandelier")
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsizing settings", testID)
+	{
+		setValidConfig()
+		viper.Set("trading.sizing.rule", "risk")
+		viper.Set("trading.sizing.fraction", 0.01)
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(SizingConfig{Rule: "risk", Fraction: 0.01}, cfg.Trading.Sizing)
+		a.Equal(cfg.Trading.Sizing, GetSizing())
+
+		viper.Set("trading.sizing.fraction", 0)
+		_, err = Load()
+		a.Error(err, "Risk rule needs fraction")
+
+		viper.Set("trading.sizing.rule", "fixed")
+		_, err = Load()
+		a.NoError(err)
+
+		viper.Set("trading.sizing.rule", "kelly")
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsignal settings", testID)
+	{
+		setValidConfig()
+		viper.Set("trading.signals.cooldown", "5m")
+		viper.Set("trading.signals.pyramiding", 2)
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(SignalsConfig{Cooldown: 5 * time.Minute, Pyramiding: 2}, cfg.Trading.Signals)
+		a.Equal(cfg.Trading.Signals, GetSignals())
+
+		viper.Set("trading.signals.pyramiding", -1)
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\texchange venue", testID)
+	{
+		setValidConfig()
+		viper.Set("exchange.venue", "binance")
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal("binance", cfg.Exchange.Venue)
+		a.False(IsDryRun())
+
+		viper.Set("exchange.dry_run", true)
+		cfg, err = Load()
+		a.NoError(err)
+		a.True(cfg.Exchange.DryRun)
+		a.True(IsDryRun())
+
+		viper.Set("exchange.venue", "ftx")
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\texchange keys", testID)
+	{
+		setValidConfig()
+		viper.Set("api.private_key", "")
+		_, err := Load()
+		a.Error(err, "Keys are required for live trading")
+
+		viper.Set("exchange.dry_run", true)
+		_, err = Load()
+		a.NoError(err, "Keys are optional in dry run")
+
+		viper.Set("api.private_key", "not base64!")
+		_, err = Load()
+		a.Error(err)
+
+		setValidConfig()
+		EnableDryRun()
+		a.True(IsDryRun())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsnapshot settings", testID)
+	{
+		setValidConfig()
+		viper.Set("snapshot.path", "state.json")
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(SnapshotConfig{Path: "state.json", Interval: 30 * time.Second}, cfg.Snapshot)
+
+		viper.Set("snapshot.interval", "0s")
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tjournal settings", testID)
+	{
+		setValidConfig()
+		viper.Set("journal.path", "journal.jsonl")
+		viper.Set("journal.max_size_mb", 10)
+		cfg, err := Load()
+		a.NoError(err)
+		a.Equal(JournalConfig{Path: "journal.jsonl", MaxSizeMB: 10}, cfg.Journal)
+
+		viper.Set("journal.max_files", -1)
+		_, err = Load()
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tenvironment overrides", testID)
+	{
+		a.NoError(os.Setenv("TRADING_API_PRIVATE_KEY", "ZW52"))
+		a.NoError(os.Setenv("TRADING_TRADING_MULTIPLIER", "0.01"))
+		defer os.Unsetenv("TRADING_API_PRIVATE_KEY")
+		defer os.Unsetenv("TRADING_TRADING_MULTIPLIER")
+
+		viper.Reset()
+		setupEnv()
+		a.Equal("ZW52", GetPrivateKey())
+		a.Equal(0.01, GetPriceMultiplier())
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tsettings decoded from JSON", testID)
+	{
+		var settings map[string]interface{}
+		a.NoError(json.Unmarshal([]byte(`{
+			"pair": {"period": "2m"},
+			"api": {"private_key": "REDACTED"},
+			"trading": {"quantity": 10, "multiplier": 0.01, "signals": {"cooldown": "5m", "pyramiding": 2}},
+			"strategy": {"ema_period": 20}
+		}`), &settings))
+		cfg, err := ParseSettings(settings)
+		a.NoError(err)
+		a.Equal("2m", cfg.Pair.Period)
+		a.Equal(Reloadable{
+			Trading: TradingConfig{
+				Quantity:      10,
+				Multiplier:    0.01,
+				TriggerSignal: "mark",
+				Trailing:      TrailingConfig{ATRPeriod: 14},
+				Sizing:        SizingConfig{Rule: "fixed", ATRPeriod: 14},
+				Signals:       SignalsConfig{Cooldown: 5 * time.Minute, Pyramiding: 2},
+			},
+			Strategy: StrategyConfig{EMAPeriod: 20},
+		}, cfg.Reloadable())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tdefaults of missing settings", testID)
+	{
+		cfg, err := ParseSettings(nil)
+		a.NoError(err)
+		a.Equal(100, cfg.Trading.Quantity)
+		a.Equal(100, cfg.Strategy.EMAPeriod)
+		a.Empty(cfg.Pair.Period)
+	}
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-playground/validator/v10"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// envPrefix is prefix of environment variables overriding config values, e.g. TRADING_API_PRIVATE_KEY,
+// so keys of the trading section are overridden with TRADING_TRADING_* variables
+const envPrefix = "TRADING"
+
+// Config is typed representation of the config file validated at startup
+type Config struct {
+	Pair     PairConfig     `mapstructure:"pair"`
+	Exchange ExchangeConfig `mapstructure:"exchange"`
+	API      APIConfig      `mapstructure:"api"`
+	Server   ServerConfig   `mapstructure:"server"`
+	Database DatabaseConfig `mapstructure:"database"`
+	Trading  TradingConfig  `mapstructure:"trading"`
+	Strategy StrategyConfig `mapstructure:"strategy"`
+	Snapshot SnapshotConfig `mapstructure:"snapshot"`
+	Journal  JournalConfig  `mapstructure:"journal"`
+}
+
+type PairConfig struct {
+	Period string `mapstructure:"period" validate:"oneof=1m 2m 10m"`
+}
+
+// ExchangeConfig sets the venue, with dry run orders are simulated on live market data instead of being sent
+type ExchangeConfig struct {
+	Venue  string `mapstructure:"venue" validate:"oneof=kraken binance"`
+	DryRun bool   `mapstructure:"dry_run"`
+}
+
+// APIConfig holds credentials, exchange keys are not required with dry run
+type APIConfig struct {
+	PrivateKey string `mapstructure:"private_key" validate:"omitempty,base64"`
+	PublicKey  string `mapstructure:"public_key"`
+	TgBotToken string `mapstructure:"tg_bot_token" validate:"required"`
+}
+
+type ServerConfig struct {
+	Address  string     `mapstructure:"address" validate:"required"`
+	AuditLog string     `mapstructure:"audit_log"`
+	TLSCert  string     `mapstructure:"tls_cert" validate:"required_with=TLSKey"`
+	TLSKey   string     `mapstructure:"tls_key" validate:"required_with=TLSCert"`
+	Auth     AuthConfig `mapstructure:"auth"`
+}
+
+type AuthConfig struct {
+	Mode string            `mapstructure:"mode" validate:"oneof=api_key hmac none"`
+	Keys map[string]string `mapstructure:"keys" validate:"dive,required"`
+}
+
+type DatabaseConfig struct {
+	Address  string `mapstructure:"address" validate:"required"`
+	Port     string `mapstructure:"port"`
+	Name     string `mapstructure:"name" validate:"required"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Scheme   string `mapstructure:"scheme" validate:"required"`
+}
+
+// TradingConfig holds order settings, they are applied on config reload
+type TradingConfig struct {
+	Quantity      int     `mapstructure:"quantity" validate:"gt=0"`
+	Multiplier    float64 `mapstructure:"multiplier" validate:"gte=0"`
+	StopLoss      float64 `mapstructure:"stop_loss" validate:"gte=0,lt=1"`
+	TakeProfit    float64 `mapstructure:"take_profit" validate:"gte=0"`
+	TriggerSignal string  `mapstructure:"trigger_signal" validate:"omitempty,oneof=mark index last"`
+
+	Trailing TrailingConfig `mapstructure:"trailing"`
+	Sizing   SizingConfig   `mapstructure:"sizing"`
+	Signals  SignalsConfig  `mapstructure:"signals"`
+}
+
+// SignalsConfig sets min time between signal actions of a pair and the max number of adds to an open position,
+// repeated signals do not add to the position if pyramiding is zero
+type SignalsConfig struct {
+	Cooldown   time.Duration `mapstructure:"cooldown" validate:"gte=0"`
+	Pyramiding int           `mapstructure:"pyramiding" validate:"gte=0"`
+}
+
+// SizingConfig sets how orders are sized: fixed quantity, or a fraction of equity for the other rules
+type SizingConfig struct {
+	Rule      string  `mapstructure:"rule" validate:"omitempty,oneof=fixed equity risk volatility"`
+	Fraction  float64 `mapstructure:"fraction" validate:"gte=0,required_if=Rule equity,required_if=Rule risk,required_if=Rule volatility"`
+	ATRPeriod int     `mapstructure:"atr_period" validate:"gte=0"`
+}
+
+// TrailingConfig sets trailing stop distance from the best price since entry, trailing is disabled if mode is empty
+type TrailingConfig struct {
+	Mode      string  `mapstructure:"mode" validate:"omitempty,oneof=percent absolute atr"`
+	Distance  float64 `mapstructure:"distance" validate:"gte=0"`
+	ATRPeriod int     `mapstructure:"atr_period" validate:"gte=0"`
+}
+
+// StrategyConfig holds strategy parameters, they are applied on config reload
+type StrategyConfig struct {
+	EMAPeriod int `mapstructure:"ema_period" validate:"gt=1"`
+}
+
+// SnapshotConfig sets where and how often bot state is saved, saving is disabled if path is empty
+type SnapshotConfig struct {
+	Path     string        `mapstructure:"path"`
+	Interval time.Duration `mapstructure:"interval" validate:"gt=0"`
+}
+
+// JournalConfig sets where the event journal is written and how it is rotated, journal is disabled if path is empty
+type JournalConfig struct {
+	Path      string `mapstructure:"path"`
+	MaxSizeMB int    `mapstructure:"max_size_mb" validate:"gte=0"`
+	MaxFiles  int    `mapstructure:"max_files" validate:"gte=0"`
+}
+
+// Reloadable is a part of config that is safe to change without restart
+type Reloadable struct {
+	Trading  TradingConfig
+	Strategy StrategyConfig
+}
+
+func (c Config) Reloadable() Reloadable {
+	return Reloadable{
+		Trading:  c.Trading,
+		Strategy: c.Strategy,
+	}
+}
+
+// Load returns validated config with environment overrides applied
+func Load() (Config, error) {
+	var cfg Config
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return Config{}, err
+	}
+	if err := validator.New().Struct(cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid config: %w", err)
+	}
+	if !cfg.Exchange.DryRun && (cfg.API.PrivateKey == "" || cfg.API.PublicKey == "") {
+		return Config{}, errors.New("invalid config: api keys are required unless exchange runs dry")
+	}
+	if cfg.Server.Auth.Mode != "none" && len(cfg.Server.Auth.Keys) == 0 {
+		return Config{}, fmt.Errorf("invalid config: server.auth.keys are required in %s auth mode", cfg.Server.Auth.Mode)
+	}
+	return cfg, nil
+}
+
+// ParseSettings returns config of the settings map, e.g. settings of a journal config record. Defaults are applied
+// to missing settings, the config is not validated as secrets of recorded settings are redacted.
+func ParseSettings(settings map[string]interface{}) (Config, error) {
+	v := viper.New()
+	setDefaults(v)
+	if err := v.MergeConfigMap(settings); err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// WatchConfig calls onChange with reloadable settings every time the config file changes.
+// Invalid configs are logged and ignored, settings that are not reloadable require restart.
+func WatchConfig(onChange func(Reloadable), logger *log.Logger) {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		cfg, err := Load()
+		if err != nil {
+			logger.Errorf("Reload config %s failed: %s", e.Name, err)
+			return
+		}
+		logger.Infof("Config %s reloaded", e.Name)
+		onChange(cfg.Reloadable())
+	})
+	viper.WatchConfig()
+}
+
+// setupEnv allows to override every config value with environment variable
+func setupEnv() {
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+	// viper knows only keys from the config file, so bind the rest to get them from environment too
+	bindEnvs(reflect.TypeOf(Config{}), "")
+}
+
+func bindEnvs(t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			bindEnvs(field.Type, key+".")
+		case reflect.Map:
+			// maps can't be set with a single variable
+		default:
+			_ = viper.BindEnv(key)
+		}
+	}
+}
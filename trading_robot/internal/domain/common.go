@@ -2,6 +2,7 @@ package domain
 
 import (
 	"fmt"
+	"hash/fnv"
 	"strconv"
 	"time"
 )
@@ -11,6 +12,14 @@ const (
 	SubscribePair   = "/subscribe/{pair}"
 	PairVar         = "pair"
 
+	StatusOperation   = "/status"
+	ConfigOperation   = "/config"
+	StrategyOperation = "/strategy"
+	PairsOperation    = "/pairs"
+	StreamOperation   = "/stream"
+	MetricsOperation  = "/metrics"
+	OrdersOperation   = "/orders"
+
 	ShutdownOperation = "/shutdown"
 	SetQuantity       = "/quantity/{value}"
 	SetMultiplier     = "/multiplier/{value}"
@@ -24,7 +33,39 @@ const (
 	BuyOrder  OrderType = "buy"
 )
 
-const IocOrder = "ioc"
+// Order types, exchanges translate them to their own order types
+const (
+	IocOrder        = "ioc"
+	MarketOrder     = "mkt"
+	LimitOrder      = "lmt"
+	PostOnlyOrder   = "post"
+	StopOrder       = "stp"
+	TakeProfitOrder = "take_profit"
+)
+
+// Trigger signals of stop and take profit orders, the price that triggers the order
+const (
+	MarkPriceTrigger  = "mark"
+	IndexPriceTrigger = "index"
+	LastPriceTrigger  = "last"
+)
+
+// ReduceOnly is the value of Order.ReduceOnly for orders that can only reduce position
+const ReduceOnly = "true"
+
+// ExecutionEvent is type of order event set by exchanges when order is filled
+const ExecutionEvent = "EXECUTION"
+
+// Order statuses set by exchanges in CreateOrderResponse
+const (
+	PlacedStatus    = "placed"
+	CancelledStatus = "cancelled"
+)
+
+const (
+	LongPosition  = "long"
+	ShortPosition = "short"
+)
 
 type UnixTS time.Time
 
@@ -37,6 +78,10 @@ func (t *UnixTS) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+func (t UnixTS) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatInt(time.Time(t).UnixMilli(), 10)), nil
+}
+
 func (t UnixTS) String() string {
 	return fmt.Sprint(time.Time(t))
 }
@@ -65,17 +110,24 @@ type CreateOrderResponse struct {
 	OrderType      string  `json:"orderType,omitempty"`
 	Symbol         string  `json:"symbol,omitempty"`
 	Side           string  `json:"side,omitempty"`
-	Size           int     `json:"size,omitempty"`
+	Size           int     `json:"size,omitempty"` // executed size if OrderEventType is ExecutionEvent
 	LimitPrice     float64 `json:"limitPrice,omitempty"`
 	Result         string  `json:"result,omitempty"`
 	Status         string  `json:"status,omitempty"`
 	OrderID        string  `json:"order_id,omitempty"`
+	CliOrdID       string  `json:"cli_ord_id,omitempty"`
 	ReceivedTime   string  `json:"receivedTime,omitempty"`
 	OrderEventType string  `json:"order_event_type"`
+	FilledSize     float64 `json:"filled_size,omitempty"` // size executed by the time the order is acknowledged
+	Simulated      bool    `json:"simulated,omitempty"`   // order of dry run, it was not sent to exchange
 }
 
 func (r CreateOrderResponse) String() string {
-	return fmt.Sprintf(`Created new order:
+	title := "Created new order"
+	if r.Simulated {
+		title = "Simulated new order"
+	}
+	return fmt.Sprintf(`%s:
 OrderType: %s
 Symbol: %s
 Side: %s
@@ -84,7 +136,7 @@ LimitPrice: %v
 Result: %s
 Status: %s
 OrderID: %s
-Time: %s`, r.OrderType, r.Symbol, r.Side, r.Size, r.LimitPrice, r.Result, r.Status, r.OrderID, r.ReceivedTime)
+Time: %s`, title, r.OrderType, r.Symbol, r.Side, r.Size, r.LimitPrice, r.Result, r.Status, r.OrderID, r.ReceivedTime)
 }
 
 func CreateIocOrder(orderType OrderType, pair string, price float64, quantity int) Order {
@@ -97,6 +149,145 @@ func CreateIocOrder(orderType OrderType, pair string, price float64, quantity in
 	}
 }
 
+// CreateMarketOrder creates reduce-only market order, used to close positions
+func CreateMarketOrder(orderType OrderType, pair string, quantity int) Order {
+	return Order{
+		OrderType:  MarketOrder,
+		Symbol:     pair,
+		Side:       string(orderType),
+		Size:       quantity,
+		ReduceOnly: ReduceOnly,
+	}
+}
+
+// CreateLimitOrder creates order resting on the book until it is filled or cancelled. Post-only order is rejected
+// instead of being executed at once, so it is always a maker order.
+func CreateLimitOrder(orderType OrderType, pair string, price float64, quantity int, postOnly bool) Order {
+	o := Order{
+		OrderType:  LimitOrder,
+		Symbol:     pair,
+		Side:       string(orderType),
+		Size:       quantity,
+		LimitPrice: price,
+	}
+	if postOnly {
+		o.OrderType = PostOnlyOrder
+	}
+	return o
+}
+
+// CreateStopOrder creates reduce-only stop loss order, it is executed at market when the trigger signal
+// price crosses stop price: rises to it for buy orders and falls to it for sell orders
+func CreateStopOrder(orderType OrderType, pair string, stopPrice float64, quantity int, triggerSignal string) Order {
+	return Order{
+		OrderType:     StopOrder,
+		Symbol:        pair,
+		Side:          string(orderType),
+		Size:          quantity,
+		StopPrice:     stopPrice,
+		TriggerSignal: triggerSignal,
+		ReduceOnly:    ReduceOnly,
+	}
+}
+
+// CreateTakeProfitOrder creates reduce-only take profit order, it is executed at market when the trigger signal
+// price reaches stop price: falls to it for buy orders and rises to it for sell orders
+func CreateTakeProfitOrder(orderType OrderType, pair string, stopPrice float64, quantity int, triggerSignal string) Order {
+	return Order{
+		OrderType:     TakeProfitOrder,
+		Symbol:        pair,
+		Side:          string(orderType),
+		Size:          quantity,
+		StopPrice:     stopPrice,
+		TriggerSignal: triggerSignal,
+		ReduceOnly:    ReduceOnly,
+	}
+}
+
+// Opposite returns side that reduces position opened by the side
+func (t OrderType) Opposite() OrderType {
+	if t == BuyOrder {
+		return SellOrder
+	}
+	return BuyOrder
+}
+
+// MaxClientOrderIDLength is the shortest limit of client order ID length among supported exchanges
+const MaxClientOrderIDLength = 36
+
+// maxSignalPairLength keeps client order IDs within MaxClientOrderIDLength, longer pairs are hashed
+const maxSignalPairLength = 16
+
+// SignalID identifies the strategy signal of the pair candle, it is the same when the candle is processed again,
+// e.g. after restart
+func SignalID(pair string, candle time.Time, side OrderType) string {
+	if len(pair) > maxSignalPairLength {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(pair))
+		pair = fmt.Sprintf("%08x", h.Sum32())
+	}
+	return fmt.Sprintf("%s-%d-%s", pair, candle.Unix(), side)
+}
+
+// ClientOrderID returns client order ID of the order attempt for the signal. Attempts rejected by exchange
+// get new IDs, while resends of the same attempt keep it, so exchange rejects duplicates.
+func ClientOrderID(signalID string, attempt int) string {
+	return fmt.Sprintf("%s-%d", signalID, attempt)
+}
+
+// ProtectiveOrderID returns client order ID of stop loss ("sl"), take profit ("tp") or market exit ("ex") order
+// closing the position entered on the signal
+func ProtectiveOrderID(signalID, orderType string) string {
+	suffix := "sl"
+	switch orderType {
+	case TakeProfitOrder:
+		suffix = "tp"
+	case MarketOrder:
+		suffix = "ex"
+	}
+	return fmt.Sprintf("%s-%s", signalID, suffix)
+}
+
+// OpenOrder is an order resting on exchange, sizes are in contracts
+type OpenOrder struct {
+	OrderID      string  `json:"order_id"`
+	CliOrdID     string  `json:"cli_ord_id,omitempty"`
+	Symbol       string  `json:"symbol"`
+	Side         string  `json:"side"`
+	OrderType    string  `json:"order_type"`
+	LimitPrice   float64 `json:"limit_price,omitempty"`
+	StopPrice    float64 `json:"stop_price,omitempty"`
+	UnfilledSize float64 `json:"unfilled_size"`
+	FilledSize   float64 `json:"filled_size"`
+	ReceivedTime string  `json:"received_time"`
+}
+
+// Fill is an execution of an order, size is in contracts
+type Fill struct {
+	FillID   string  `json:"fill_id"`
+	OrderID  string  `json:"order_id"`
+	CliOrdID string  `json:"cli_ord_id,omitempty"`
+	Symbol   string  `json:"symbol"`
+	Side     string  `json:"side"`
+	Size     float64 `json:"size"`
+	Price    float64 `json:"price"`
+	Time     string  `json:"time"`
+}
+
+type Position struct {
+	Symbol string  `json:"symbol"`
+	Side   string  `json:"side"`
+	Size   float64 `json:"size"`
+	Price  float64 `json:"price"`
+}
+
+// Balance is the margin account state in the settlement currency, e.g. USD
+type Balance struct {
+	Equity          float64 `json:"equity"`           // portfolio value including unrealized PnL
+	AvailableMargin float64 `json:"available_margin"` // margin available for new positions
+	InitialMargin   float64 `json:"initial_margin"`   // margin used by open positions and orders
+}
+
 type Ticker struct {
 	Time    UnixTS  `json:"time" validate:"required"`
 	Pair    string  `json:"pair" validate:"required"`
@@ -22,6 +22,15 @@ func TestUnixTime(t *testing.T) {
 		a.Equal("2021-02-02 15:40:57.781 +0300 MSK", ts.String())
 	}
 
+	testID++
+	t.Logf("\tTest %d:\tMarshalJSON unix time", testID)
+	{
+		data, err := json.Marshal(UnixTS(time.UnixMilli(1612269657781)))
+		a.NoError(err)
+		a.Equal(`1612269657781`, string(data))
+	}
+
+	testID++
 	t.Logf("\tTest %d:\tUnmarshalJSON unix time error", testID)
 	{
 		timeJSON := []byte(`4161a26487269657781`)
@@ -31,3 +40,76 @@ func TestUnixTime(t *testing.T) {
 		a.Equal(UnixTS(time.Time{}), ts)
 	}
 }
+
+func TestClientOrderID(t *testing.T) {
+	a := assert.New(t)
+	candle := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+
+	testID := 0
+	t.Logf("\tTest %d:\tsignal ID is deterministic", testID)
+	{
+		id := SignalID("PI_XBTUSD", candle, BuyOrder)
+		a.Equal("PI_XBTUSD-1637866800-buy", id)
+		a.Equal(id, SignalID("PI_XBTUSD", candle.In(time.Local), BuyOrder))
+		a.NotEqual(id, SignalID("PI_XBTUSD", candle, SellOrder))
+		a.NotEqual(id, SignalID("PI_XBTUSD", candle.Add(time.Minute), BuyOrder))
+		a.Equal("PI_XBTUSD-1637866800-buy-1", ClientOrderID(id, 1))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlong pairs are hashed", testID)
+	{
+		id := ClientOrderID(SignalID("VERY_LONG_PAIR_SYMBOL_USD", candle, SellOrder), MaxClientOrderIDLength)
+		a.LessOrEqual(len(id), MaxClientOrderIDLength)
+		a.NotEqual(SignalID("VERY_LONG_PAIR_SYMBOL_USD", candle, SellOrder), SignalID("VERY_LONG_PAIR_SYMBOL_EUR", candle, SellOrder))
+	}
+	testID++
+	t.Logf("\tTest %d:\tprotective order IDs", testID)
+	{
+		id := SignalID("VERY_LONG_PAIR_SYMBOL_USD", candle, SellOrder)
+		a.Equal(id+"-sl", ProtectiveOrderID(id, StopOrder))
+		a.Equal(id+"-tp", ProtectiveOrderID(id, TakeProfitOrder))
+		a.Equal(id+"-ex", ProtectiveOrderID(id, MarketOrder))
+		a.LessOrEqual(len(ProtectiveOrderID(id, StopOrder)), MaxClientOrderIDLength)
+	}
+}
+
+func TestCreateOrders(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tlimit orders", testID)
+	{
+		o := CreateLimitOrder(BuyOrder, "PI_XBTUSD", 57000, 10, false)
+		a.Equal(Order{OrderType: LimitOrder, Symbol: "PI_XBTUSD", Side: "buy", Size: 10, LimitPrice: 57000}, o)
+		a.Equal(PostOnlyOrder, CreateLimitOrder(BuyOrder, "PI_XBTUSD", 57000, 10, true).OrderType)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop and take profit orders are reduce-only", testID)
+	{
+		o := CreateStopOrder(SellOrder, "PI_XBTUSD", 56000, 10, MarkPriceTrigger)
+		a.Equal(Order{
+			OrderType:     StopOrder,
+			Symbol:        "PI_XBTUSD",
+			Side:          "sell",
+			Size:          10,
+			StopPrice:     56000,
+			TriggerSignal: MarkPriceTrigger,
+			ReduceOnly:    ReduceOnly,
+		}, o)
+
+		o = CreateTakeProfitOrder(BuyOrder, "PI_XBTUSD", 55000, 10, LastPriceTrigger)
+		a.Equal(TakeProfitOrder, o.OrderType)
+		a.Equal(55000.0, o.StopPrice)
+		a.Equal(ReduceOnly, o.ReduceOnly)
+		a.Zero(o.LimitPrice)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\topposite side", testID)
+	{
+		a.Equal(SellOrder, BuyOrder.Opposite())
+		a.Equal(BuyOrder, SellOrder.Opposite())
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+import "errors"
+
+// Order errors are venue neutral kinds of exchange errors, exchanges wrap them with details,
+// so the callers can decide with errors.Is whether to retry, resize or give up
+var (
+	ErrInsufficientFunds = errors.New("insufficient available funds")
+	ErrWouldNotExecute   = errors.New("order would not execute")
+	ErrInvalidSize       = errors.New("invalid order size")
+	ErrInvalidPrice      = errors.New("invalid order price")
+	ErrMarketUnavailable = errors.New("market is unavailable")
+	ErrPositionLimit     = errors.New("position limit violated")
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrDuplicateOrder    = errors.New("duplicate client order id")
+	ErrNotExecuted       = errors.New("order of client order id is not executed")
+	ErrRateLimited       = errors.New("api rate limit exceeded")
+	ErrAuthentication    = errors.New("authentication failed")
+	ErrInvalidRequest    = errors.New("invalid request")
+	ErrExchangeFailure   = errors.New("exchange failure")
+	ErrOrderRejected     = errors.New("order rejected")
+)
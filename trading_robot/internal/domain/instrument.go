@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Instrument is the exchange specification of the pair, orders must be rounded to its increments
+type Instrument struct {
+	Symbol        string  `json:"symbol"`
+	TickSize      float64 `json:"tick_size"`      // min price increment
+	ContractSize  float64 `json:"contract_size"`  // value of one contract in the contract currency
+	SizePrecision int     `json:"size_precision"` // decimals of size, negative if size is a multiple of ten
+	Inverse       bool    `json:"inverse"`        // contract size is in the quote currency, e.g. one USD of BTC
+	InitialMargin float64 `json:"initial_margin"` // margin of a position as a fraction of its value, zero if unknown
+	Tradeable     bool    `json:"tradeable"`
+}
+
+// ContractValue returns value of one contract in the quote currency at the price
+func (i Instrument) ContractValue(price float64) float64 {
+	if i.Inverse {
+		return i.ContractSize
+	}
+	return i.ContractSize * price
+}
+
+// PriceDecimals returns number of decimals in prices of the instrument
+func (i Instrument) PriceDecimals() int {
+	return decimals(i.TickSize)
+}
+
+// RoundPrice rounds the price to the nearest tick, price is returned as is if tick size is unknown
+func (i Instrument) RoundPrice(price float64) float64 {
+	if i.TickSize <= 0 {
+		return price
+	}
+	rounded := math.Round(price/i.TickSize) * i.TickSize
+	// drop float error of the multiplication, e.g. 0.30000000000000004 for 3 ticks of 0.1
+	rounded, _ = strconv.ParseFloat(strconv.FormatFloat(rounded, 'f', i.PriceDecimals(), 64), 64)
+	return rounded
+}
+
+// MinSize returns the min order size in contracts, sizes are integer so it is at least one contract
+func (i Instrument) MinSize() int {
+	if i.SizePrecision >= 0 {
+		return 1
+	}
+	return int(math.Pow10(-i.SizePrecision))
+}
+
+// RoundSize rounds the size down to the size increment, sizes below MinSize are rejected with ErrInvalidSize
+func (i Instrument) RoundSize(size int) (int, error) {
+	step := i.MinSize()
+	rounded := size / step * step
+	if rounded < step {
+		return 0, fmt.Errorf("%w: size %d of %s is below min size %d", ErrInvalidSize, size, i.Symbol, step)
+	}
+	return rounded, nil
+}
+
+// Normalize rounds size and prices of the order to valid increments of the instrument
+func (i Instrument) Normalize(order Order) (Order, error) {
+	if !i.Tradeable {
+		return Order{}, fmt.Errorf("%w: %s is not tradeable", ErrMarketUnavailable, i.Symbol)
+	}
+	size, err := i.RoundSize(order.Size)
+	if err != nil {
+		return Order{}, err
+	}
+	order.Size = size
+	if order.LimitPrice > 0 {
+		order.LimitPrice = i.RoundPrice(order.LimitPrice)
+	}
+	if order.StopPrice > 0 {
+		order.StopPrice = i.RoundPrice(order.StopPrice)
+	}
+	return order, nil
+}
+
+// decimals returns number of decimals in the shortest representation of the increment
+func decimals(increment float64) int {
+	s := strconv.FormatFloat(increment, 'f', -1, 64)
+	for i := range s {
+		if s[i] == '.' {
+			return len(s) - i - 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tprices are rounded to the nearest tick", testID)
+	{
+		for _, tc := range []struct {
+			tick, price, expected float64
+		}{
+			{0.5, 104.3, 104.5},
+			{0.5, 104.2, 104},
+			{0.01, 1.23456, 1.23},
+			{0.1, 0.3, 0.3},
+			{0.05, 2000.07, 2000.05},
+			{0, 104.3, 104.3},
+		} {
+			a.Equal(tc.expected, Instrument{TickSize: tc.tick}.RoundPrice(tc.price), "tick %v price %v", tc.tick, tc.price)
+		}
+		a.Equal(2, Instrument{TickSize: 0.01}.PriceDecimals())
+		a.Equal(0, Instrument{TickSize: 1}.PriceDecimals())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsizes are rounded down to the size increment", testID)
+	{
+		size, err := Instrument{SizePrecision: 0}.RoundSize(7)
+		a.NoError(err)
+		a.Equal(7, size)
+
+		size, err = Instrument{SizePrecision: -1}.RoundSize(27)
+		a.NoError(err)
+		a.Equal(20, size)
+
+		_, err = Instrument{Symbol: "PI_XBTUSD", SizePrecision: -1}.RoundSize(7)
+		a.True(errors.Is(err, ErrInvalidSize))
+		a.EqualError(err, "invalid order size: size 7 of PI_XBTUSD is below min size 10")
+
+		_, err = Instrument{}.RoundSize(0)
+		a.True(errors.Is(err, ErrInvalidSize))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torder is normalized", testID)
+	{
+		ins := Instrument{Symbol: "PI_XBTUSD", TickSize: 0.5, ContractSize: 1, Inverse: true, Tradeable: true}
+		order, err := ins.Normalize(CreateStopOrder(SellOrder, "PI_XBTUSD", 104.3, 10, MarkPriceTrigger))
+		a.NoError(err)
+		a.Equal(104.5, order.StopPrice)
+		a.Equal(0.0, order.LimitPrice)
+		a.Equal(10, order.Size)
+
+		a.Equal(1.0, ins.ContractValue(50000))
+		a.Equal(50.0, Instrument{ContractSize: 0.001}.ContractValue(50000))
+
+		ins.Tradeable = false
+		_, err = ins.Normalize(CreateMarketOrder(SellOrder, "PI_XBTUSD", 10))
+		a.True(errors.Is(err, ErrMarketUnavailable))
+	}
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// Methods of moving trailing stops
+const (
+	StopPlaced   = "place"   // exchange stop order placed
+	StopEdited   = "edit"    // exchange stop order edited in place
+	StopReplaced = "replace" // exchange stop order cancelled and placed again
+	StopLocal    = "local"   // stop is kept by the bot and exit is sent as market order
+)
+
+// StopMove is a change of trailing stop price of the pair position
+type StopMove struct {
+	Pair      string    `json:"pair"`
+	Side      string    `json:"side"`               // side of the stop order
+	OrderID   string    `json:"order_id,omitempty"` // empty for stops kept by the bot
+	Method    string    `json:"method"`
+	BestPrice float64   `json:"best_price"` // best price since entry
+	OldStop   float64   `json:"old_stop"`   // zero for a new stop
+	NewStop   float64   `json:"new_stop"`
+	Time      time.Time `json:"time"`
+}
+
+func (m StopMove) String() string {
+	return fmt.Sprintf(`Trailing stop moved:
+Symbol: %s
+Side: %s
+Stop: %v -> %v
+BestPrice: %v
+Method: %s
+OrderID: %s`, m.Pair, m.Side, m.OldStop, m.NewStop, m.BestPrice, m.Method, m.OrderID)
+}
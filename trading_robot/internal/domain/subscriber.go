@@ -0,0 +1,31 @@
+package domain
+
+type NotifyPreference string
+
+const (
+	NotifyAll     NotifyPreference = "all"
+	NotifyOrders  NotifyPreference = "orders"
+	NotifyErrors  NotifyPreference = "errors"
+	NotifySummary NotifyPreference = "summary"
+)
+
+// Subscriber is a Telegram user subscribed to bot notifications. Subscribers are identified by chat ID
+// because Telegram usernames are optional and can be changed.
+type Subscriber struct {
+	ChatID     int64
+	Username   string
+	Preference NotifyPreference
+}
+
+func NewSubscriber(chatID int64, username string) Subscriber {
+	return Subscriber{
+		ChatID:     chatID,
+		Username:   username,
+		Preference: NotifyAll,
+	}
+}
+
+// Accepts reports whether the subscriber wants to receive messages of the given kind
+func (s Subscriber) Accepts(kind NotifyPreference) bool {
+	return s.Preference == NotifyAll || s.Preference == kind
+}
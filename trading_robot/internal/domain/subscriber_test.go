@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriberAccepts(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tnew subscriber accepts everything", testID)
+	{
+		s := NewSubscriber(42, "")
+		a.Equal(NotifyAll, s.Preference)
+		a.True(s.Accepts(NotifyOrders))
+		a.True(s.Accepts(NotifyErrors))
+		a.True(s.Accepts(NotifySummary))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torders only subscriber", testID)
+	{
+		s := Subscriber{ChatID: 42, Preference: NotifyOrders}
+		a.True(s.Accepts(NotifyOrders))
+		a.False(s.Accepts(NotifyErrors))
+		a.False(s.Accepts(NotifySummary))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsummary only subscriber", testID)
+	{
+		s := Subscriber{ChatID: 42, Preference: NotifySummary}
+		a.False(s.Accepts(NotifyOrders))
+		a.False(s.Accepts(NotifyErrors))
+		a.True(s.Accepts(NotifySummary))
+	}
+}
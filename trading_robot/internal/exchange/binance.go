@@ -0,0 +1,554 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rhttp "github.com/hashicorp/go-retryablehttp"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/binance"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
+)
+
+// BinanceExchange is Binance USDⓈ-M futures adapter. Sizes are in contracts of binance.ContractSize
+// of the base asset, so the same trading quantity means comparable positions on both venues.
+type BinanceExchange struct {
+	logger *log.Logger
+
+	restURL    url.URL
+	publicKey  string
+	privateKey string
+
+	client       *rhttp.Client
+	conn         *utils.RetryableWSConn
+	limiter      *ratelimit.TokenBucket // request weight budget
+	orderLimiter *ratelimit.TokenBucket
+
+	requestID int64
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu    sync.RWMutex
+	pairs map[string]bool
+
+	priceMu   sync.RWMutex
+	lastPrice domain.Price
+
+	journal Journal
+}
+
+// NewBinanceExchange creates Binance USDⓈ-M futures adapter, by default it connects to Binance testnet
+func NewBinanceExchange(logger *log.Logger, opts ...Option) (*BinanceExchange, error) {
+	o := newOptions(
+		url.URL{Scheme: binance.Scheme, Host: binance.Host},
+		url.URL{Scheme: binance.WsScheme, Host: binance.WsHost, Path: binance.WsPath},
+		opts,
+	)
+	b := &BinanceExchange{
+		logger:     logger,
+		restURL:    o.restURL,
+		publicKey:  o.publicKey,
+		privateKey: o.privateKey,
+		done:       make(chan struct{}),
+		pairs:      make(map[string]bool),
+		journal:    o.journal,
+	}
+
+	rwsconn := &utils.RetryableWSConn{
+		URL:          o.wsURL,
+		MaxRetries:   binance.MaxRetries,
+		StaleTimeout: binance.StaleTimeout,
+	}
+	if o.configureWS != nil {
+		o.configureWS(rwsconn)
+	}
+
+	_, err := rwsconn.RetryableDial()
+	if err != nil {
+		return nil, err
+	}
+
+	limiter := ratelimit.NewTokenBucket(binance.RateLimitBudget, binance.RateLimitInterval)
+
+	client := rhttp.NewClient()
+	client.Logger = logger
+	// retries spend the rate limit budget too, only GET requests are retried
+	client.RequestLogHook = func(_ rhttp.Logger, req *http.Request, retry int) {
+		if retry > 0 {
+			_ = limiter.Wait(req.Context(), binance.EndpointWeight(operationByPath(req.URL.Path)))
+		}
+	}
+
+	b.client = client
+	b.conn = rwsconn
+	b.limiter = limiter
+	b.orderLimiter = ratelimit.NewTokenBucket(binance.OrderRateLimit, binance.OrderRateInterval)
+
+	// Binance has no heartbeat feed, requests keep the feed alive when no pairs are subscribed
+	go b.keepalive()
+
+	return b, nil
+}
+
+func operationByPath(path string) binance.Operation {
+	switch path {
+	case binance.OpenOrdersPath:
+		return binance.OpenOrders
+	case binance.PositionRiskPath:
+		return binance.PositionRisk
+	case binance.UserTradesPath:
+		return binance.UserTrades
+	default:
+		return binance.NewOrder
+	}
+}
+
+func (b *BinanceExchange) keepalive() {
+	ticker := time.NewTicker(binance.KeepalivePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := b.sendRequest(binance.ListSubscriptionsMethod); err != nil {
+				b.logger.Errorf("Keepalive request failed: %s", err)
+			}
+		}
+	}
+}
+
+// send sends signed request of the operation and returns response body if it succeeded
+func (b *BinanceExchange) send(operation binance.Operation, order domain.Order) ([]byte, error) {
+	params, err := binance.QueryByOperation(order, operation)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := b.createRequest(operation, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = b.acquire(operation); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var resp *http.Response
+	if req.Method == http.MethodGet {
+		resp, err = b.client.Do(req)
+	} else {
+		// orders and cancels must not be resent on failure, the first attempt could have been executed
+		b.journal.Record(journal.ExchangeRequest, order.Symbol, exchangeRequest{Operation: string(operation), Order: order})
+		resp, err = b.client.HTTPClient.Do(req.Request)
+	}
+	metrics.RESTLatency.WithLabelValues(string(operation)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		b.recordResponse(req.Method, order, exchangeResponse{Operation: string(operation), Error: err.Error()})
+		return nil, err
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b.logger.Trace(string(data))
+	b.recordResponse(req.Method, order, exchangeResponse{Operation: string(operation), Status: resp.StatusCode, Body: rawMessage(data)})
+
+	var errResp *binance.ErrorResponse
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		// error responses of proxies and gateways are not JSON
+		if json.Unmarshal(data, &errResp) != nil {
+			errResp = nil
+		}
+	}
+	if err = binance.CheckResponse(operation, resp.StatusCode, errResp); err != nil {
+		return nil, checkRateLimit(b.limiter, string(operation), err)
+	}
+
+	return data, nil
+}
+
+// recordResponse records response of requests changing orders, responses of reads are not recorded
+func (b *BinanceExchange) recordResponse(method string, order domain.Order, r exchangeResponse) {
+	if method != http.MethodGet {
+		b.journal.Record(journal.ExchangeResponse, order.Symbol, r)
+	}
+}
+
+// acquire takes endpoint weight from the rate limit budget, new orders are limited by the orders budget too
+func (b *BinanceExchange) acquire(operation binance.Operation) error {
+	if err := acquire(b.limiter, binance.EndpointWeight(operation), binance.MaxRateLimitWait, string(operation)); err != nil {
+		return err
+	}
+	if operation == binance.NewOrder {
+		return acquire(b.orderLimiter, 1, binance.MaxRateLimitWait, string(operation))
+	}
+	return nil
+}
+
+func (b *BinanceExchange) createRequest(operation binance.Operation, params binance.QueryParams) (*rhttp.Request, error) {
+	method, path, err := binance.GetEndpoint(operation)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	for key, val := range params {
+		q.Add(key, val)
+	}
+	q.Set(binance.Timestamp, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	q.Set(binance.RecvWindowParam, binance.RecvWindow)
+	query := q.Encode()
+
+	u := &url.URL{
+		Scheme:   b.restURL.Scheme,
+		Host:     b.restURL.Host,
+		Path:     path,
+		RawQuery: query + "&" + binance.Signature + "=" + binance.Sign(b.privateKey, query),
+	}
+
+	req, err := rhttp.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(binance.APIKey, b.publicKey)
+
+	return req, nil
+}
+
+func (b *BinanceExchange) GetPrices(ctx context.Context) <-chan domain.Price {
+	out := make(chan domain.Price)
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				b.logger.Info("Get Prices done")
+				return
+			default:
+				_, data, reconnected, err := b.conn.ReadMessage()
+				if errors.Is(err, utils.ErrClosed) {
+					b.logger.Info("Get Prices done: connection closed")
+					return
+				}
+				if err != nil {
+					b.logger.Error(err)
+					continue
+				}
+
+				if reconnected {
+					if !resubscribe(ctx, b.conn, b.updateConnection, b.logger) {
+						return
+					}
+					continue
+				}
+
+				b.logger.Trace(string(data))
+				b.journal.Record(journal.WSMessage, "", rawMessage(data))
+				metrics.WSMessages.WithLabelValues(binance.ParseFeed(data)).Inc()
+
+				price, ok := binance.ParseTrade(data)
+				if ok {
+					b.priceMu.Lock()
+					b.lastPrice = price
+					b.priceMu.Unlock()
+					out <- price
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// GetLastPrice returns the last received price, false if there were no prices yet
+func (b *BinanceExchange) GetLastPrice() (domain.Price, bool) {
+	b.priceMu.RLock()
+	defer b.priceMu.RUnlock()
+	return b.lastPrice, b.lastPrice.ProductID != ""
+}
+
+func (b *BinanceExchange) IsConnected() bool {
+	return b.conn.IsConnected()
+}
+
+// ConnectionStates returns WebSocket connection state events
+func (b *BinanceExchange) ConnectionStates() <-chan utils.ConnEvent {
+	return b.conn.States()
+}
+
+func (b *BinanceExchange) GetPairs() []string {
+	b.mu.RLock()
+	pairs := make([]string, 0, len(b.pairs))
+	for pair := range b.pairs {
+		pairs = append(pairs, pair)
+	}
+	b.mu.RUnlock()
+	sort.Strings(pairs)
+	return pairs
+}
+
+func (b *BinanceExchange) CloseConnection() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return b.conn.Close()
+}
+
+func (b *BinanceExchange) SubscribePairs(pairs ...string) error {
+	b.mu.RLock()
+	pairsCount := len(b.pairs)
+	b.mu.RUnlock()
+	if pairsCount >= 1 {
+		return ErrTooManyPairs
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	for _, pair := range pairs {
+		b.pairs[pair] = true
+	}
+	b.mu.Unlock()
+	return b.sendRequest(binance.SubscribeMethod, tradeStreams(pairs)...)
+}
+
+func (b *BinanceExchange) UnsubscribePairs(pairs ...string) error {
+	b.mu.Lock()
+	for _, pair := range pairs {
+		delete(b.pairs, pair)
+	}
+	b.mu.Unlock()
+	return b.sendRequest(binance.UnsubscribeMethod, tradeStreams(pairs)...)
+}
+
+func tradeStreams(pairs []string) []string {
+	streams := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		streams = append(streams, binance.StreamName(pair, binance.AggTradeStream))
+	}
+	return streams
+}
+
+func (b *BinanceExchange) sendRequest(method binance.Method, streams ...string) error {
+	r := binance.Request{
+		Method: method,
+		Params: streams,
+		ID:     atomic.AddInt64(&b.requestID, 1),
+	}
+	reconnected, err := b.conn.WriteJSON(r)
+	if err != nil {
+		return err
+	}
+
+	if reconnected {
+		if err = b.updateConnection(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *BinanceExchange) updateConnection() error {
+	pairs := b.GetPairs()
+	b.mu.Lock()
+	b.pairs = make(map[string]bool)
+	b.mu.Unlock()
+	return b.SubscribePairs(pairs...)
+}
+
+// CreateOrder sends the order, orders with client order ID are resent after network errors
+func (b *BinanceExchange) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	return sendOrder(order, b.createOrder, b.findOrder, b.logger)
+}
+
+func (b *BinanceExchange) createOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	data, err := b.send(binance.NewOrder, order)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	return orderResponse(order, data)
+}
+
+// findOrder queries the order by client order ID, orders that expired or were cancelled without
+// execution are reported with domain.ErrNotExecuted
+func (b *BinanceExchange) findOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	data, err := b.send(binance.QueryOrder, domain.Order{Symbol: order.Symbol, CliOrdID: order.CliOrdID})
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	resp, err := orderResponse(order, data)
+	if errors.Is(err, domain.ErrWouldNotExecute) || errors.Is(err, domain.ErrOrderRejected) {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: %s: %s", domain.ErrNotExecuted, order.CliOrdID, err)
+	}
+	return resp, err
+}
+
+func orderResponse(order domain.Order, data []byte) (domain.CreateOrderResponse, error) {
+	var o binance.Order
+	if err := json.Unmarshal(data, &o); err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	if err := binance.CheckOrder(o); err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+
+	resp := domain.CreateOrderResponse{
+		OrderType:    order.OrderType,
+		Symbol:       o.Symbol,
+		Side:         order.Side,
+		Size:         order.Size,
+		LimitPrice:   order.LimitPrice,
+		Result:       o.Status,
+		Status:       domain.PlacedStatus,
+		OrderID:      strconv.FormatInt(o.OrderID, 10),
+		CliOrdID:     o.ClientOrderID,
+		ReceivedTime: time.UnixMilli(o.UpdateTime).UTC().Format(binance.TimeLayout),
+	}
+	if o.ExecutedQty > 0 {
+		// ioc orders may be filled partially
+		resp.FilledSize = binance.ToContracts(o.ExecutedQty)
+		resp.Size = int(resp.FilledSize)
+		resp.OrderEventType = domain.ExecutionEvent
+	}
+	return resp, nil
+}
+
+// CancelOrder cancels order by OrderID or CliOrdID, Symbol is required
+func (b *BinanceExchange) CancelOrder(order domain.Order) error {
+	_, err := b.send(binance.CancelOrder, order)
+	return err
+}
+
+func (b *BinanceExchange) GetOpenOrders() ([]domain.OpenOrder, error) {
+	data, err := b.send(binance.OpenOrders, domain.Order{})
+	if err != nil {
+		return nil, err
+	}
+
+	var open []binance.Order
+	if err = json.Unmarshal(data, &open); err != nil {
+		return nil, err
+	}
+
+	orders := make([]domain.OpenOrder, 0, len(open))
+	for _, o := range open {
+		orders = append(orders, domain.OpenOrder{
+			OrderID:      strconv.FormatInt(o.OrderID, 10),
+			CliOrdID:     o.ClientOrderID,
+			Symbol:       o.Symbol,
+			Side:         binance.DomainSide(o.Side),
+			OrderType:    binance.DomainOrderType(o),
+			LimitPrice:   o.Price,
+			StopPrice:    o.StopPrice,
+			UnfilledSize: binance.ToContracts(o.OrigQty - o.ExecutedQty),
+			FilledSize:   binance.ToContracts(o.ExecutedQty),
+			ReceivedTime: time.UnixMilli(o.Time).UTC().Format(binance.TimeLayout),
+		})
+	}
+	return orders, nil
+}
+
+// GetFills returns the last trades of the symbol
+func (b *BinanceExchange) GetFills(symbol string) ([]domain.Fill, error) {
+	data, err := b.send(binance.UserTrades, domain.Order{Symbol: symbol})
+	if err != nil {
+		return nil, err
+	}
+
+	var trades []binance.Trade
+	if err = json.Unmarshal(data, &trades); err != nil {
+		return nil, err
+	}
+
+	fills := make([]domain.Fill, 0, len(trades))
+	for _, t := range trades {
+		fills = append(fills, domain.Fill{
+			FillID:  strconv.FormatInt(t.ID, 10),
+			OrderID: strconv.FormatInt(t.OrderID, 10),
+			Symbol:  t.Symbol,
+			Side:    binance.DomainSide(t.Side),
+			Size:    binance.ToContracts(t.Qty),
+			Price:   t.Price,
+			Time:    time.UnixMilli(t.Time).UTC().Format(binance.TimeLayout),
+		})
+	}
+	return fills, nil
+}
+
+// CancelAllOrders cancels open orders of every symbol, Binance cancels all orders only by symbol
+func (b *BinanceExchange) CancelAllOrders() error {
+	orders, err := b.GetOpenOrders()
+	if err != nil {
+		return err
+	}
+
+	cancelled := make(map[string]bool)
+	for _, o := range orders {
+		if cancelled[o.Symbol] {
+			continue
+		}
+		if _, err = b.send(binance.CancelAllOrders, domain.Order{Symbol: o.Symbol}); err != nil {
+			return err
+		}
+		cancelled[o.Symbol] = true
+	}
+	return nil
+}
+
+func (b *BinanceExchange) GetOpenPositions() ([]domain.Position, error) {
+	data, err := b.send(binance.PositionRisk, domain.Order{})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []binance.PositionRiskEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	positions := make([]domain.Position, 0)
+	for _, p := range entries {
+		if p.PositionAmt == 0 {
+			continue
+		}
+		side := domain.LongPosition
+		if p.PositionAmt < 0 {
+			side = domain.ShortPosition
+		}
+		positions = append(positions, domain.Position{
+			Symbol: p.Symbol,
+			Side:   side,
+			Size:   binance.ToContracts(p.PositionAmt),
+			Price:  p.EntryPrice,
+		})
+	}
+	return positions, nil
+}
+
+// FlattenPositions closes all open positions with reduce-only market orders
+func (b *BinanceExchange) FlattenPositions() error {
+	return flattenPositions(b, b)
+}
+
+func (b *BinanceExchange) Venue() string {
+	return BinanceVenue
+}
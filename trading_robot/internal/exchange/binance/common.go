@@ -0,0 +1,172 @@
+package binance
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	MaxRetries = 13
+
+	// keepalive requests are sent every KeepalivePeriod, so the feed is stale if there are no messages for longer
+	StaleTimeout    = 2 * time.Minute
+	KeepalivePeriod = time.Minute
+
+	// testnet of USDⓈ-M futures, the same way Kraken adapter uses demo by default
+	Scheme = "https"
+	Host   = "testnet.binancefuture.com"
+
+	WsScheme = "wss"
+	WsHost   = "stream.binancefuture.com"
+	WsPath   = "/ws"
+
+	// RecvWindow is the max time in milliseconds the signed request is valid on the server
+	RecvWindow = "5000"
+
+	SubscribeMethod         Method = "SUBSCRIBE"
+	UnsubscribeMethod       Method = "UNSUBSCRIBE"
+	ListSubscriptionsMethod Method = "LIST_SUBSCRIPTIONS"
+
+	AggTradeStream = "aggTrade"
+	AggTradeEvent  = "aggTrade"
+
+	NewOrder        Operation = "newOrder"
+	QueryOrder      Operation = "queryOrder"
+	CancelOrder     Operation = "cancelOrder"
+	CancelAllOrders Operation = "cancelAllOrders"
+	OpenOrders      Operation = "openOrders"
+	PositionRisk    Operation = "positionRisk"
+	UserTrades      Operation = "userTrades"
+
+	OrderPath         = "/fapi/v1/order"
+	AllOpenOrdersPath = "/fapi/v1/allOpenOrders"
+	OpenOrdersPath    = "/fapi/v1/openOrders"
+	PositionRiskPath  = "/fapi/v2/positionRisk"
+	UserTradesPath    = "/fapi/v1/userTrades"
+
+	APIKey RequestHeader = "X-MBX-APIKEY"
+
+	Symbol           QueryParam = "symbol"
+	Side             QueryParam = "side"
+	Type             QueryParam = "type"
+	TimeInForce      QueryParam = "timeInForce"
+	Quantity         QueryParam = "quantity"
+	Price            QueryParam = "price"
+	StopPrice        QueryParam = "stopPrice"
+	ReduceOnly       QueryParam = "reduceOnly"
+	WorkingType      QueryParam = "workingType"
+	NewClientOrderID QueryParam = "newClientOrderId"
+	OrigClientOrdID  QueryParam = "origClientOrderId"
+	OrderID          QueryParam = "orderId"
+	NewOrderRespType QueryParam = "newOrderRespType"
+	Timestamp        QueryParam = "timestamp"
+	RecvWindowParam  QueryParam = "recvWindow"
+	Signature        QueryParam = "signature"
+
+	// TimeLayout is the layout of order times reported by adapter, the same as of Kraken
+	TimeLayout = "2006-01-02T15:04:05.000Z"
+
+	// ResultResponse makes order endpoint respond with the final status of ioc and market orders
+	ResultResponse = "RESULT"
+)
+
+// Order sides, types, time in force values and statuses of Binance API
+const (
+	BuySide  = "BUY"
+	SellSide = "SELL"
+
+	LimitType            = "LIMIT"
+	MarketType           = "MARKET"
+	StopType             = "STOP"
+	StopMarketType       = "STOP_MARKET"
+	TakeProfitType       = "TAKE_PROFIT"
+	TakeProfitMarketType = "TAKE_PROFIT_MARKET"
+
+	// stop prices are compared with mark price or last contract price, Binance has no index price trigger
+	MarkPrice     = "MARK_PRICE"
+	ContractPrice = "CONTRACT_PRICE"
+
+	GTC = "GTC"
+	IOC = "IOC"
+	GTX = "GTX" // post only
+
+	NewStatus             = "NEW"
+	PartiallyFilledStatus = "PARTIALLY_FILLED"
+	FilledStatus          = "FILLED"
+	CanceledStatus        = "CANCELED"
+	ExpiredStatus         = "EXPIRED"
+	RejectedStatus        = "REJECTED"
+)
+
+// Until instrument metadata is loaded from exchange info, contracts are converted to quantities with
+// ContractSize and prices are rounded to PricePrecision decimals
+const (
+	ContractSize   = 0.001
+	PricePrecision = 1
+)
+
+type (
+	Method    string
+	Operation string
+
+	RequestHeader = string
+
+	QueryParam  = string
+	QueryParams map[QueryParam]string
+
+	Request struct {
+		Method Method   `json:"method"`
+		Params []string `json:"params,omitempty"`
+		ID     int64    `json:"id"`
+	}
+
+	AggTrade struct {
+		Event     string `json:"e"`
+		EventTime int64  `json:"E"`
+		Symbol    string `json:"s"`
+		Price     string `json:"p"`
+		Quantity  string `json:"q"`
+		TradeTime int64  `json:"T"`
+	}
+
+	Order struct {
+		OrderID       int64   `json:"orderId"`
+		ClientOrderID string  `json:"clientOrderId"`
+		Symbol        string  `json:"symbol"`
+		Status        string  `json:"status"`
+		Side          string  `json:"side"`
+		Type          string  `json:"type"`
+		TimeInForce   string  `json:"timeInForce"`
+		Price         float64 `json:"price,string"`
+		AvgPrice      float64 `json:"avgPrice,string"`
+		StopPrice     float64 `json:"stopPrice,string"`
+		OrigQty       float64 `json:"origQty,string"`
+		ExecutedQty   float64 `json:"executedQty,string"`
+		ReduceOnly    bool    `json:"reduceOnly"`
+		Time          int64   `json:"time,omitempty"`
+		UpdateTime    int64   `json:"updateTime"`
+	}
+
+	Trade struct {
+		ID      int64   `json:"id"`
+		OrderID int64   `json:"orderId"`
+		Symbol  string  `json:"symbol"`
+		Side    string  `json:"side"`
+		Price   float64 `json:"price,string"`
+		Qty     float64 `json:"qty,string"`
+		Time    int64   `json:"time"`
+	}
+
+	PositionRiskEntry struct {
+		Symbol      string  `json:"symbol"`
+		PositionAmt float64 `json:"positionAmt,string"` // negative for short
+		EntryPrice  float64 `json:"entryPrice,string"`
+	}
+
+	ErrorResponse struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+)
+
+var ErrOperationNotFound = errors.New("given operation not found")
@@ -0,0 +1,116 @@
+package binance
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+)
+
+const (
+	// StatusIPBanned is returned instead of 429 when the IP is banned for violating rate limits
+	StatusIPBanned = 418
+
+	// TooManyRequests is the error code returned when the rate limit is exceeded
+	TooManyRequests = -1003
+)
+
+// APIError is an error reported by Binance API, it unwraps to one of the domain order errors
+type APIError struct {
+	Operation  Operation
+	Code       int    // error code returned by Binance, zero for order statuses and errors without body
+	Message    string // error message or order status
+	HTTPStatus int
+	kind       error
+}
+
+func NewAPIError(operation Operation, code int, message string, httpStatus int) *APIError {
+	kind, ok := codeErrors[code]
+	if !ok {
+		kind = domain.ErrOrderRejected
+		if httpStatus >= http.StatusInternalServerError {
+			kind = domain.ErrExchangeFailure
+		}
+	}
+	return &APIError{
+		Operation:  operation,
+		Code:       code,
+		Message:    message,
+		HTTPStatus: httpStatus,
+		kind:       kind,
+	}
+}
+
+func (e *APIError) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Operation, e.kind, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %d %s", e.Operation, e.kind, e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// codeErrors maps Binance error codes to domain errors
+var codeErrors = map[int]error{
+	TooManyRequests: domain.ErrRateLimited,
+	-1015:           domain.ErrRateLimited,
+	-1021:           domain.ErrAuthentication,
+	-1022:           domain.ErrAuthentication,
+	-2014:           domain.ErrAuthentication,
+	-2015:           domain.ErrAuthentication,
+	-1102:           domain.ErrInvalidRequest,
+	-1106:           domain.ErrInvalidRequest,
+	-1111:           domain.ErrInvalidRequest,
+	-1116:           domain.ErrInvalidRequest,
+	-1117:           domain.ErrInvalidRequest,
+	-4015:           domain.ErrInvalidRequest,
+	-1121:           domain.ErrMarketUnavailable,
+	-2010:           domain.ErrOrderRejected,
+	-2011:           domain.ErrOrderNotFound,
+	-2013:           domain.ErrOrderNotFound,
+	-2019:           domain.ErrInsufficientFunds,
+	-2022:           domain.ErrPositionLimit,
+	-2027:           domain.ErrPositionLimit,
+	-4003:           domain.ErrInvalidSize,
+	-4005:           domain.ErrInvalidSize,
+	-4164:           domain.ErrInvalidSize,
+	-4014:           domain.ErrInvalidPrice,
+	-4016:           domain.ErrInvalidPrice,
+	-4131:           domain.ErrInvalidPrice,
+	-4116:           domain.ErrDuplicateOrder,
+	-5021:           domain.ErrWouldNotExecute,
+	-5022:           domain.ErrWouldNotExecute,
+}
+
+// statusErrors maps final statuses of orders that were not placed to domain errors
+var statusErrors = map[string]error{
+	ExpiredStatus:  domain.ErrWouldNotExecute,
+	RejectedStatus: domain.ErrOrderRejected,
+	CanceledStatus: domain.ErrOrderRejected,
+}
+
+// CheckResponse returns APIError if HTTP status of the response reports failure, body is nil if it is not JSON
+func CheckResponse(operation Operation, httpStatus int, body *ErrorResponse) error {
+	if httpStatus == http.StatusTooManyRequests || httpStatus == StatusIPBanned {
+		return NewAPIError(operation, TooManyRequests, http.StatusText(httpStatus), httpStatus)
+	}
+	if httpStatus >= http.StatusOK && httpStatus < http.StatusMultipleChoices {
+		return nil
+	}
+
+	if body == nil || body.Code == 0 {
+		return NewAPIError(operation, 0, http.StatusText(httpStatus), httpStatus)
+	}
+	return NewAPIError(operation, body.Code, body.Msg, httpStatus)
+}
+
+// CheckOrder returns APIError if the new order was not placed: ioc order expired without fills or order was rejected
+func CheckOrder(o Order) error {
+	kind, ok := statusErrors[o.Status]
+	if !ok || o.ExecutedQty > 0 {
+		return nil
+	}
+	return &APIError{Operation: NewOrder, Message: o.Status, HTTPStatus: http.StatusOK, kind: kind}
+}
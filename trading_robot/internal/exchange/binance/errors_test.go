@@ -0,0 +1,82 @@
+package binance
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResponse(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tsuccess", testID)
+	{
+		a.NoError(CheckResponse(NewOrder, http.StatusOK, nil))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\terror codes mapped to domain errors", testID)
+	{
+		cases := map[int]error{
+			-2019: domain.ErrInsufficientFunds,
+			-5022: domain.ErrWouldNotExecute,
+			-4164: domain.ErrInvalidSize,
+			-4014: domain.ErrInvalidPrice,
+			-2015: domain.ErrAuthentication,
+			-4116: domain.ErrDuplicateOrder,
+			-2011: domain.ErrOrderNotFound,
+			-9999: domain.ErrOrderRejected,
+		}
+		for code, kind := range cases {
+			err := CheckResponse(NewOrder, http.StatusBadRequest, &ErrorResponse{Code: code, Msg: "message"})
+			a.Truef(errors.Is(err, kind), "%d should be %s, got %v", code, kind, err)
+
+			var apiErr *APIError
+			a.True(errors.As(err, &apiErr))
+			a.Equal(code, apiErr.Code)
+			a.Equal("message", apiErr.Message)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trate limit statuses", testID)
+	{
+		a.ErrorIs(CheckResponse(NewOrder, http.StatusTooManyRequests, nil), domain.ErrRateLimited)
+		a.ErrorIs(CheckResponse(OpenOrders, StatusIPBanned, &ErrorResponse{Code: TooManyRequests}), domain.ErrRateLimited)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tresponses without error body", testID)
+	{
+		a.ErrorIs(CheckResponse(NewOrder, http.StatusBadGateway, nil), domain.ErrExchangeFailure)
+		a.ErrorIs(CheckResponse(NewOrder, http.StatusBadRequest, &ErrorResponse{}), domain.ErrOrderRejected)
+	}
+}
+
+func TestCheckOrder(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tfilled and resting orders", testID)
+	{
+		a.NoError(CheckOrder(Order{Status: FilledStatus, ExecutedQty: 0.1}))
+		a.NoError(CheckOrder(Order{Status: NewStatus}))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tioc order expired without fills", testID)
+	{
+		a.ErrorIs(CheckOrder(Order{Status: ExpiredStatus}), domain.ErrWouldNotExecute)
+		a.NoError(CheckOrder(Order{Status: ExpiredStatus, ExecutedQty: 0.05}), "Partially filled order is placed")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trejected order", testID)
+	{
+		a.ErrorIs(CheckOrder(Order{Status: RejectedStatus}), domain.ErrOrderRejected)
+	}
+}
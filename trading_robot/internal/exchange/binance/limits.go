@@ -0,0 +1,37 @@
+package binance
+
+import "time"
+
+const (
+	// RateLimitBudget is the request weight budget per RateLimitInterval for an IP
+	RateLimitBudget   = 2400
+	RateLimitInterval = time.Minute
+
+	// OrderRateLimit is the max number of orders per OrderRateInterval for an account
+	OrderRateLimit    = 300
+	OrderRateInterval = 10 * time.Second
+
+	// MaxRateLimitWait is the max time request is queued for the budget, requests that need more are rejected
+	MaxRateLimitWait = 2 * time.Second
+
+	defaultWeight = 1
+)
+
+// endpointWeights are weights of endpoints in the rate limit budget according to Binance docs
+var endpointWeights = map[Operation]float64{
+	NewOrder:        1,
+	QueryOrder:      1,
+	CancelOrder:     1,
+	CancelAllOrders: 1,
+	OpenOrders:      40, // without symbol
+	PositionRisk:    5,
+	UserTrades:      5,
+}
+
+// EndpointWeight returns weight of the endpoint call in the rate limit budget
+func EndpointWeight(operation Operation) float64 {
+	if weight, ok := endpointWeights[operation]; ok {
+		return weight
+	}
+	return defaultWeight
+}
@@ -0,0 +1,242 @@
+package binance
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+)
+
+// GetEndpoint returns HTTP method and path of the operation
+func GetEndpoint(operation Operation) (string, string, error) {
+	switch operation {
+	case NewOrder:
+		return http.MethodPost, OrderPath, nil
+	case QueryOrder:
+		return http.MethodGet, OrderPath, nil
+	case CancelOrder:
+		return http.MethodDelete, OrderPath, nil
+	case CancelAllOrders:
+		return http.MethodDelete, AllOpenOrdersPath, nil
+	case OpenOrders:
+		return http.MethodGet, OpenOrdersPath, nil
+	case PositionRisk:
+		return http.MethodGet, PositionRiskPath, nil
+	case UserTrades:
+		return http.MethodGet, UserTradesPath, nil
+	default:
+		return "", "", ErrOperationNotFound
+	}
+}
+
+// Sign returns hex encoded HMAC-SHA256 of the query string with the secret key
+func Sign(secretKey, query string) string {
+	h := hmac.New(sha256.New, []byte(secretKey))
+	h.Write([]byte(query))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FormatQuantity converts contracts to quantity in base asset
+func FormatQuantity(contracts int) string {
+	return strconv.FormatFloat(float64(contracts)*ContractSize, 'f', -1, 64)
+}
+
+// ToContracts converts quantity in base asset to contracts
+func ToContracts(quantity float64) float64 {
+	return math.Round(math.Abs(quantity) / ContractSize)
+}
+
+func FormatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', PricePrecision, 64)
+}
+
+func QueryByOperation(order domain.Order, operation Operation) (QueryParams, error) {
+	switch operation {
+	case NewOrder:
+		side, ok := sides[domain.OrderType(order.Side)]
+		if !ok {
+			return nil, fmt.Errorf("%w: side %q", domain.ErrInvalidRequest, order.Side)
+		}
+		params := QueryParams{
+			Symbol:           order.Symbol,
+			Side:             side,
+			Quantity:         FormatQuantity(order.Size),
+			NewOrderRespType: ResultResponse,
+		}
+
+		switch order.OrderType {
+		case domain.IocOrder:
+			params[Type], params[TimeInForce], params[Price] = LimitType, IOC, FormatPrice(order.LimitPrice)
+		case domain.LimitOrder:
+			params[Type], params[TimeInForce], params[Price] = LimitType, GTC, FormatPrice(order.LimitPrice)
+		case domain.PostOnlyOrder:
+			params[Type], params[TimeInForce], params[Price] = LimitType, GTX, FormatPrice(order.LimitPrice)
+		case domain.MarketOrder:
+			params[Type] = MarketType
+		case domain.StopOrder, domain.TakeProfitOrder:
+			if err := setTrigger(params, order); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: order type %q", domain.ErrInvalidRequest, order.OrderType)
+		}
+
+		if order.ReduceOnly != "" {
+			params[ReduceOnly] = order.ReduceOnly
+		}
+		if order.CliOrdID != "" {
+			params[NewClientOrderID] = order.CliOrdID
+		}
+		return params, nil
+
+	case QueryOrder, CancelOrder:
+		params := QueryParams{Symbol: order.Symbol}
+		if order.OrderID != "" {
+			params[OrderID] = order.OrderID
+		} else {
+			params[OrigClientOrdID] = order.CliOrdID
+		}
+		return params, nil
+
+	case CancelAllOrders, UserTrades:
+		return QueryParams{Symbol: order.Symbol}, nil
+
+	case OpenOrders, PositionRisk:
+		return QueryParams{}, nil
+
+	default:
+		return nil, ErrOperationNotFound
+	}
+}
+
+// setTrigger sets type, prices and working type of stop and take profit order, orders with limit price
+// become limit orders when triggered
+func setTrigger(params QueryParams, order domain.Order) error {
+	workingType, ok := workingTypes[order.TriggerSignal]
+	if !ok {
+		return fmt.Errorf("%w: trigger signal %q", domain.ErrInvalidRequest, order.TriggerSignal)
+	}
+
+	types := triggerTypes[order.OrderType]
+	params[Type] = types.market
+	if order.LimitPrice > 0 {
+		params[Type], params[TimeInForce], params[Price] = types.limit, GTC, FormatPrice(order.LimitPrice)
+	}
+	params[StopPrice] = FormatPrice(order.StopPrice)
+	if workingType != "" {
+		params[WorkingType] = workingType
+	}
+	return nil
+}
+
+// triggerTypes maps domain stop and take profit orders to Binance market and limit order types
+var triggerTypes = map[string]struct{ market, limit string }{
+	domain.StopOrder:       {market: StopMarketType, limit: StopType},
+	domain.TakeProfitOrder: {market: TakeProfitMarketType, limit: TakeProfitType},
+}
+
+// workingTypes maps domain trigger signals to Binance working types, empty trigger signal is Binance default
+var workingTypes = map[string]string{
+	"":                      "",
+	domain.MarkPriceTrigger: MarkPrice,
+	domain.LastPriceTrigger: ContractPrice,
+}
+
+var sides = map[domain.OrderType]string{
+	domain.BuyOrder:  BuySide,
+	domain.SellOrder: SellSide,
+}
+
+// orderTypes maps Binance order types and time in force values to domain order types
+var orderTypes = map[string]string{
+	LimitType + IOC:      domain.IocOrder,
+	LimitType + GTC:      domain.LimitOrder,
+	LimitType + GTX:      domain.PostOnlyOrder,
+	MarketType:           domain.MarketOrder,
+	StopType + GTC:       domain.StopOrder,
+	StopMarketType:       domain.StopOrder,
+	TakeProfitType + GTC: domain.TakeProfitOrder,
+	TakeProfitMarketType: domain.TakeProfitOrder,
+}
+
+// DomainOrderType returns domain order type of the Binance order, Binance type if it has no domain analogue
+func DomainOrderType(o Order) string {
+	if t, ok := orderTypes[o.Type+o.TimeInForce]; ok {
+		return t
+	}
+	if t, ok := orderTypes[o.Type]; ok {
+		return t
+	}
+	return o.Type
+}
+
+// DomainSide returns domain side of the Binance order side
+func DomainSide(side string) string {
+	return strings.ToLower(side)
+}
+
+// StreamName returns name of the pair stream, Binance stream names are lower case
+func StreamName(pair, stream string) string {
+	return strings.ToLower(pair) + "@" + stream
+}
+
+// ParseTrade returns price of aggregated trade message, false for other messages and invalid trades
+func ParseTrade(data []byte) (domain.Price, bool) {
+	var trade AggTrade
+	if err := json.Unmarshal(data, &trade); err != nil {
+		metrics.ValidationFailures.WithLabelValues("unmarshal").Inc()
+		return domain.Price{}, false
+	}
+	if trade.Event != AggTradeEvent {
+		return domain.Price{}, false
+	}
+
+	price, priceErr := strconv.ParseFloat(trade.Price, 64)
+	qty, qtyErr := strconv.ParseFloat(trade.Quantity, 64)
+	if priceErr != nil || qtyErr != nil {
+		metrics.ValidationFailures.WithLabelValues("unmarshal").Inc()
+		return domain.Price{}, false
+	}
+
+	p := domain.Price{
+		Time:      domain.UnixTS(time.UnixMilli(trade.TradeTime)),
+		ProductID: trade.Symbol,
+		Quantity:  qty,
+		Price:     price,
+	}
+	if err := validator.New().Struct(p); err != nil {
+		metrics.ValidationFailures.WithLabelValues("validation").Inc()
+		return domain.Price{}, false
+	}
+	return p, true
+}
+
+// ParseFeed returns event type of the WS message, "result" for responses to requests or "unknown"
+func ParseFeed(data []byte) string {
+	var msg struct {
+		Event string `json:"e"`
+		ID    *int64 `json:"id"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "unknown"
+	}
+
+	switch {
+	case msg.Event != "":
+		return msg.Event
+	case msg.ID != nil:
+		return "result"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,157 @@
+package binance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryByOperation(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tioc order", testID)
+	{
+		q, err := QueryByOperation(domain.CreateIocOrder(domain.BuyOrder, "BTCUSDT", 57000.04, 100), NewOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{
+			Symbol:           "BTCUSDT",
+			Side:             BuySide,
+			Type:             LimitType,
+			TimeInForce:      IOC,
+			Quantity:         "0.1",
+			Price:            "57000.0",
+			NewOrderRespType: ResultResponse,
+		}, q)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\treduce only market order", testID)
+	{
+		q, err := QueryByOperation(domain.CreateMarketOrder(domain.SellOrder, "BTCUSDT", 5), NewOrder)
+		a.NoError(err)
+		a.Equal(MarketType, q[Type])
+		a.Equal(SellSide, q[Side])
+		a.Equal("0.005", q[Quantity])
+		a.Equal("true", q[ReduceOnly])
+		a.NotContains(q, Price)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlimit and post-only orders", testID)
+	{
+		q, err := QueryByOperation(domain.CreateLimitOrder(domain.BuyOrder, "BTCUSDT", 57000, 100, false), NewOrder)
+		a.NoError(err)
+		a.Equal(LimitType, q[Type])
+		a.Equal(GTC, q[TimeInForce])
+		a.Equal("57000.0", q[Price])
+
+		q, err = QueryByOperation(domain.CreateLimitOrder(domain.BuyOrder, "BTCUSDT", 57000, 100, true), NewOrder)
+		a.NoError(err)
+		a.Equal(GTX, q[TimeInForce])
+		a.NotContains(q, ReduceOnly)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop and take profit orders", testID)
+	{
+		q, err := QueryByOperation(domain.CreateStopOrder(domain.SellOrder, "BTCUSDT", 56000, 100, domain.MarkPriceTrigger), NewOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{
+			Symbol:           "BTCUSDT",
+			Side:             SellSide,
+			Type:             StopMarketType,
+			Quantity:         "0.1",
+			StopPrice:        "56000.0",
+			WorkingType:      MarkPrice,
+			ReduceOnly:       "true",
+			NewOrderRespType: ResultResponse,
+		}, q)
+
+		q, err = QueryByOperation(domain.CreateTakeProfitOrder(domain.SellOrder, "BTCUSDT", 58000, 100, domain.LastPriceTrigger), NewOrder)
+		a.NoError(err)
+		a.Equal(TakeProfitMarketType, q[Type])
+		a.Equal(ContractPrice, q[WorkingType])
+		a.NotContains(q, Price)
+
+		order := domain.CreateStopOrder(domain.SellOrder, "BTCUSDT", 56000, 100, "")
+		order.LimitPrice = 55900
+		q, err = QueryByOperation(order, NewOrder)
+		a.NoError(err)
+		a.Equal(StopType, q[Type])
+		a.Equal(GTC, q[TimeInForce])
+		a.Equal("55900.0", q[Price])
+		a.NotContains(q, WorkingType)
+		a.Equal(domain.StopOrder, DomainOrderType(Order{Type: StopType, TimeInForce: GTC}))
+
+		_, err = QueryByOperation(domain.CreateStopOrder(domain.SellOrder, "BTCUSDT", 56000, 100, domain.IndexPriceTrigger), NewOrder)
+		a.True(errors.Is(err, domain.ErrInvalidRequest))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid order", testID)
+	{
+		_, err := QueryByOperation(domain.Order{OrderType: domain.IocOrder, Side: "hold"}, NewOrder)
+		a.True(errors.Is(err, domain.ErrInvalidRequest))
+		_, err = QueryByOperation(domain.Order{OrderType: "fok", Side: string(domain.BuyOrder)}, NewOrder)
+		a.True(errors.Is(err, domain.ErrInvalidRequest))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcancel order by id or client id", testID)
+	{
+		q, err := QueryByOperation(domain.Order{Symbol: "BTCUSDT", OrderID: "42"}, CancelOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{Symbol: "BTCUSDT", OrderID: "42"}, q)
+
+		q, err = QueryByOperation(domain.Order{Symbol: "BTCUSDT", CliOrdID: "cli"}, CancelOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{Symbol: "BTCUSDT", OrigClientOrdID: "cli"}, q)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tunknown operation", testID)
+	{
+		_, err := QueryByOperation(domain.Order{}, "TEST_OPERATION")
+		a.Equal(ErrOperationNotFound, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	a := assert.New(t)
+
+	// example from Binance API docs
+	secret := "NhqPtmdSJYdKjVHjA7PZj4Mge3R5YNiP1e3UZjInClVN65XAbvqqM6A7H5fATj0j"
+	query := "symbol=LTCBTC&side=BUY&type=LIMIT&timeInForce=GTC&quantity=1&price=0.1&recvWindow=5000&timestamp=1499827319559"
+	a.Equal("c8db56825ae71d6d79447849e617115f4a920fa2acdcab2b053c4b2838bd6b71", Sign(secret, query))
+}
+
+func TestParseTrade(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\taggregated trade", testID)
+	{
+		data := []byte(`{"e":"aggTrade","E":1637867103671,"s":"BTCUSDT","a":5933014,"p":"57010.5","q":"0.012","f":100,"l":105,"T":1637867103670,"m":true}`)
+		p, ok := ParseTrade(data)
+		a.True(ok)
+		a.Equal("BTCUSDT", p.ProductID)
+		a.Equal(57010.5, p.Price)
+		a.Equal(0.012, p.Quantity)
+		a.True(time.UnixMilli(1637867103670).Equal(time.Time(p.Time)))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tother messages", testID)
+	{
+		_, ok := ParseTrade([]byte(`{"result":null,"id":1}`))
+		a.False(ok)
+		_, ok = ParseTrade([]byte(`{"e":"aggTrade","s":"BTCUSDT","p":"0","q":"1","T":1637867103670}`))
+		a.False(ok, "Zero price is invalid")
+		a.Equal("result", ParseFeed([]byte(`{"result":null,"id":1}`)))
+		a.Equal(AggTradeEvent, ParseFeed([]byte(`{"e":"aggTrade"}`)))
+	}
+}
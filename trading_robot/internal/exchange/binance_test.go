@@ -0,0 +1,601 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/binance"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testBinanceSecret = "secret"
+	testBinancePair   = "BTCUSDT"
+)
+
+// binanceStub is a local stub of Binance USDⓈ-M futures API: ioc and market orders are executed
+// at the stub price, limit orders rest until cancelled
+type binanceStub struct {
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu        sync.Mutex
+	price     float64
+	nextID    int64
+	open      []binance.Order
+	trades    []binance.Trade
+	clientIDs map[string]binance.Order // orders by client order ID
+	positions map[string]float64       // base asset quantity, negative for short
+	fault     *binance.ErrorResponse
+	faultCode int
+	drop      bool                                // close connection without response after the next order is executed
+	conns     map[*websocket.Conn]map[string]bool // subscribed streams of connections
+}
+
+func newBinanceStub() *binanceStub {
+	s := &binanceStub{
+		clientIDs: make(map[string]binance.Order),
+		positions: make(map[string]float64),
+		conns:     make(map[*websocket.Conn]map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(binance.WsPath, s.serveWS)
+	mux.HandleFunc("/fapi/", s.serveREST)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+func (s *binanceStub) urls() (url.URL, url.URL) {
+	rest, _ := url.Parse(s.srv.URL)
+	ws := *rest
+	ws.Scheme = "ws"
+	ws.Path = binance.WsPath
+	return *rest, ws
+}
+
+func (s *binanceStub) close() {
+	s.disconnect()
+	s.srv.Close()
+}
+
+// failNext makes the next REST request fail, body is not JSON if it is nil
+func (s *binanceStub) failNext(httpStatus int, body *binance.ErrorResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faultCode, s.fault = httpStatus, body
+}
+
+// dropNextResponse makes the next order executed, but its response lost
+func (s *binanceStub) dropNextResponse() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drop = true
+}
+
+func (s *binanceStub) setPrice(price float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.price = price
+}
+
+func (s *binanceStub) position(pair string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.positions[pair]
+}
+
+func (s *binanceStub) subscribed(stream string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, streams := range s.conns {
+		if streams[stream] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *binanceStub) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, conn)
+	}
+}
+
+// trade broadcasts aggregated trade to subscribed connections
+func (s *binanceStub) trade(pair string, price, qty float64, ts time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.price = price
+	msg := binance.AggTrade{
+		Event:     binance.AggTradeEvent,
+		EventTime: ts.UnixMilli(),
+		Symbol:    pair,
+		Price:     strconv.FormatFloat(price, 'f', -1, 64),
+		Quantity:  strconv.FormatFloat(qty, 'f', -1, 64),
+		TradeTime: ts.UnixMilli(),
+	}
+	for conn, streams := range s.conns {
+		if streams[binance.StreamName(pair, binance.AggTradeStream)] {
+			_ = conn.WriteJSON(msg)
+		}
+	}
+}
+
+func (s *binanceStub) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns[conn] = make(map[string]bool)
+	s.mu.Unlock()
+
+	for {
+		var req binance.Request
+		if err = conn.ReadJSON(&req); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		streams, ok := s.conns[conn]
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+		var result interface{}
+		switch req.Method {
+		case binance.SubscribeMethod:
+			for _, stream := range req.Params {
+				streams[stream] = true
+			}
+		case binance.UnsubscribeMethod:
+			for _, stream := range req.Params {
+				delete(streams, stream)
+			}
+		case binance.ListSubscriptionsMethod:
+			list := make([]string, 0, len(streams))
+			for stream := range streams {
+				list = append(list, stream)
+			}
+			result = list
+		}
+		_ = conn.WriteJSON(map[string]interface{}{"result": result, "id": req.ID})
+		s.mu.Unlock()
+	}
+}
+
+func (s *binanceStub) serveREST(w http.ResponseWriter, r *http.Request) {
+	query, signature := r.URL.RawQuery, ""
+	if i := strings.LastIndex(query, "&"+binance.Signature+"="); i >= 0 {
+		query, signature = query[:i], query[i+len(binance.Signature)+2:]
+	}
+	if r.Header.Get(binance.APIKey) != testPublicKey || binance.Sign(testBinanceSecret, query) != signature {
+		writeJSON(w, http.StatusUnauthorized, binance.ErrorResponse{Code: -2015, Msg: "Invalid API-key, IP, or permissions for action."})
+		return
+	}
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.faultCode != 0 {
+		status, body := s.faultCode, s.fault
+		s.faultCode, s.fault = 0, nil
+		if body == nil {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("<html>gateway error</html>"))
+			return
+		}
+		writeJSON(w, status, body)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == binance.OrderPath:
+		if _, ok := s.clientIDs[q.Get(binance.NewClientOrderID)]; ok {
+			writeJSON(w, http.StatusBadRequest, binance.ErrorResponse{Code: -4116, Msg: "ClientOrderId is duplicated."})
+			return
+		}
+		o := s.newOrder(q)
+		if s.drop {
+			s.drop = false
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		writeJSON(w, http.StatusOK, o)
+	case r.Method == http.MethodGet && r.URL.Path == binance.OrderPath:
+		if o, ok := s.clientIDs[q.Get(binance.OrigClientOrdID)]; ok {
+			writeJSON(w, http.StatusOK, o)
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, binance.ErrorResponse{Code: -2013, Msg: "Order does not exist."})
+	case r.Method == http.MethodDelete && r.URL.Path == binance.OrderPath:
+		for i, o := range s.open {
+			if strconv.FormatInt(o.OrderID, 10) == q.Get(binance.OrderID) {
+				s.open = append(s.open[:i], s.open[i+1:]...)
+				o.Status = binance.CanceledStatus
+				writeJSON(w, http.StatusOK, o)
+				return
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, binance.ErrorResponse{Code: -2011, Msg: "Unknown order sent."})
+	case r.Method == http.MethodDelete && r.URL.Path == binance.AllOpenOrdersPath:
+		open := s.open[:0]
+		for _, o := range s.open {
+			if o.Symbol != q.Get(binance.Symbol) {
+				open = append(open, o)
+			}
+		}
+		s.open = open
+		writeJSON(w, http.StatusOK, binance.ErrorResponse{Code: 200, Msg: "The operation of cancel all open order is done."})
+	case r.Method == http.MethodGet && r.URL.Path == binance.OpenOrdersPath:
+		writeJSON(w, http.StatusOK, s.open)
+	case r.Method == http.MethodGet && r.URL.Path == binance.UserTradesPath:
+		trades := make([]binance.Trade, 0)
+		for _, t := range s.trades {
+			if t.Symbol == q.Get(binance.Symbol) {
+				trades = append(trades, t)
+			}
+		}
+		writeJSON(w, http.StatusOK, trades)
+	case r.Method == http.MethodGet && r.URL.Path == binance.PositionRiskPath:
+		entries := []map[string]string{{"symbol": "ETHUSDT", "positionAmt": "0.000", "entryPrice": "0.0"}}
+		for symbol, amt := range s.positions {
+			entries = append(entries, map[string]string{
+				"symbol":      symbol,
+				"positionAmt": strconv.FormatFloat(amt, 'f', -1, 64),
+				"entryPrice":  strconv.FormatFloat(s.price, 'f', -1, 64),
+			})
+		}
+		writeJSON(w, http.StatusOK, entries)
+	default:
+		writeJSON(w, http.StatusNotFound, binance.ErrorResponse{Code: -5000, Msg: "Path not found"})
+	}
+}
+
+func (s *binanceStub) newOrder(q url.Values) binance.Order {
+	s.nextID++
+	qty, _ := strconv.ParseFloat(q.Get(binance.Quantity), 64)
+	price, _ := strconv.ParseFloat(q.Get(binance.Price), 64)
+	o := binance.Order{
+		OrderID:       s.nextID,
+		ClientOrderID: q.Get(binance.NewClientOrderID),
+		Symbol:        q.Get(binance.Symbol),
+		Side:          q.Get(binance.Side),
+		Type:          q.Get(binance.Type),
+		TimeInForce:   q.Get(binance.TimeInForce),
+		Price:         price,
+		OrigQty:       qty,
+		Time:          time.Now().UnixMilli(),
+		UpdateTime:    time.Now().UnixMilli(),
+	}
+
+	executable := o.Type == binance.MarketType ||
+		(o.Side == binance.BuySide && price >= s.price) || (o.Side == binance.SellSide && price <= s.price)
+	switch {
+	case o.TimeInForce == binance.GTC:
+		o.Status = binance.NewStatus
+		s.open = append(s.open, o)
+		sort.Slice(s.open, func(i, j int) bool { return s.open[i].OrderID < s.open[j].OrderID })
+	case executable:
+		o.Status, o.ExecutedQty, o.AvgPrice = binance.FilledStatus, qty, s.price
+		s.trades = append(s.trades, binance.Trade{
+			ID:      int64(len(s.trades) + 1),
+			OrderID: o.OrderID,
+			Symbol:  o.Symbol,
+			Side:    o.Side,
+			Price:   s.price,
+			Qty:     qty,
+			Time:    o.UpdateTime,
+		})
+		if o.Side == binance.SellSide {
+			qty = -qty
+		}
+		s.positions[o.Symbol] += qty
+		if s.positions[o.Symbol] == 0 {
+			delete(s.positions, o.Symbol)
+		}
+	default:
+		o.Status = binance.ExpiredStatus
+	}
+	if o.ClientOrderID != "" {
+		s.clientIDs[o.ClientOrderID] = o
+	}
+	return o
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func newTestBinanceExchange(stub *binanceStub, secret string) (*BinanceExchange, error) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	rest, ws := stub.urls()
+	return NewBinanceExchange(logger,
+		WithEndpoints(rest, ws),
+		WithCredentials(testPublicKey, secret),
+		WithWSConn(func(c *utils.RetryableWSConn) {
+			c.MaxRetries = 3
+			c.MinBackoff = time.Millisecond
+		}),
+	)
+}
+
+type binanceEnvironment struct {
+	suite.Suite
+	stub *binanceStub
+	ex   *BinanceExchange
+}
+
+func (b *binanceEnvironment) SetupTest() {
+	b.stub = newBinanceStub()
+	var err error
+	b.ex, err = newTestBinanceExchange(b.stub, testBinanceSecret)
+	b.Require().NoError(err)
+}
+
+func (b *binanceEnvironment) TearDownTest() {
+	_ = b.ex.CloseConnection()
+	b.stub.close()
+}
+
+func TestNew(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	testID := 0
+	t.Logf("\tTest %d:\tadapter of the venue", testID)
+	{
+		stub := newBinanceStub()
+		defer stub.close()
+		rest, ws := stub.urls()
+		ex, err := New(BinanceVenue, logger, WithEndpoints(rest, ws))
+		a.NoError(err)
+		a.Equal(BinanceVenue, ex.Venue())
+		a.NoError(ex.CloseConnection())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tunknown venue", testID)
+	{
+		_, err := New("ftx", logger)
+		a.ErrorIs(err, ErrUnknownVenue)
+	}
+}
+
+func (b *binanceEnvironment) TestSubscribePairs() {
+	stream := binance.StreamName(testBinancePair, binance.AggTradeStream)
+
+	testID := 0
+	b.T().Logf("\tTest %d:\tsubscribe pairs success", testID)
+	{
+		b.NoError(b.ex.SubscribePairs(testBinancePair))
+		b.Equal([]string{testBinancePair}, b.ex.GetPairs())
+		b.Eventually(func() bool { return b.stub.subscribed(stream) }, waitFor, tick)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tsubscribe pairs more than one", testID)
+	{
+		b.ErrorIs(b.ex.SubscribePairs("ETHUSDT"), ErrTooManyPairs)
+		b.Equal([]string{testBinancePair}, b.ex.GetPairs())
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tunsubscribe pairs success", testID)
+	{
+		b.NoError(b.ex.UnsubscribePairs(testBinancePair))
+		b.Empty(b.ex.GetPairs())
+		b.Eventually(func() bool { return !b.stub.subscribed(stream) }, waitFor, tick)
+	}
+}
+
+func (b *binanceEnvironment) receive(prices <-chan domain.Price) domain.Price {
+	select {
+	case p := <-prices:
+		return p
+	case <-time.After(waitFor):
+		b.FailNow("price not received")
+		return domain.Price{}
+	}
+}
+
+func (b *binanceEnvironment) TestGetPrices() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prices := b.ex.GetPrices(ctx)
+	stream := binance.StreamName(testBinancePair, binance.AggTradeStream)
+
+	testID := 0
+	b.T().Logf("\tTest %d:\ttrades of subscribed pair", testID)
+	{
+		b.NoError(b.ex.SubscribePairs(testBinancePair))
+		b.Eventually(func() bool { return b.stub.subscribed(stream) }, waitFor, tick)
+
+		ts := time.Date(2021, 11, 25, 19, 5, 3, 0, time.UTC)
+		b.stub.trade(testBinancePair, 57010.5, 0.012, ts)
+		p := b.receive(prices)
+		b.Equal(testBinancePair, p.ProductID)
+		b.Equal(57010.5, p.Price)
+		b.Equal(0.012, p.Quantity)
+		b.True(ts.Equal(time.Time(p.Time)))
+
+		last, ok := b.ex.GetLastPrice()
+		b.True(ok)
+		b.Equal(p, last)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tresubscribe after disconnect", testID)
+	{
+		b.stub.disconnect()
+		b.Eventually(func() bool { return b.stub.subscribed(stream) }, waitFor, tick, "Should resubscribe")
+
+		b.stub.trade(testBinancePair, 56000, 1, time.Now())
+		b.Equal(56000.0, b.receive(prices).Price)
+		b.True(b.ex.IsConnected())
+	}
+}
+
+func (b *binanceEnvironment) TestCreateOrder() {
+	b.stub.setPrice(100)
+
+	testID := 0
+	b.T().Logf("\tTest %d:\tioc order executed", testID)
+	{
+		resp, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testBinancePair, 101, 100))
+		b.NoError(err)
+		b.Equal(domain.PlacedStatus, resp.Status)
+		b.Equal(domain.ExecutionEvent, resp.OrderEventType)
+		b.Equal(100, resp.Size)
+		b.InDelta(100, resp.FilledSize, 1e-9)
+		b.NotEmpty(resp.OrderID)
+		b.InDelta(0.1, b.stub.position(testBinancePair), 1e-9)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tioc order would not execute", testID)
+	{
+		_, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testBinancePair, 99, 100))
+		b.ErrorIs(err, domain.ErrWouldNotExecute)
+		b.InDelta(0.1, b.stub.position(testBinancePair), 1e-9)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\topen positions and flatten", testID)
+	{
+		positions, err := b.ex.GetOpenPositions()
+		b.NoError(err)
+		b.Equal([]domain.Position{{Symbol: testBinancePair, Side: domain.LongPosition, Size: 100, Price: 100}}, positions)
+
+		fills, err := b.ex.GetFills(testBinancePair)
+		b.NoError(err)
+		b.Len(fills, 1)
+		b.Equal(100.0, fills[0].Size)
+		b.Equal(string(domain.BuyOrder), fills[0].Side)
+
+		b.NoError(b.ex.FlattenPositions())
+		b.Zero(b.stub.position(testBinancePair))
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\topen orders and cancel", testID)
+	{
+		limit := domain.Order{OrderType: domain.LimitOrder, Symbol: testBinancePair, Side: string(domain.SellOrder), Size: 10, LimitPrice: 120}
+		resp, err := b.ex.CreateOrder(limit)
+		b.NoError(err)
+		b.Empty(resp.OrderEventType, "Resting order should not be executed")
+		resp, err = b.ex.CreateOrder(limit)
+		b.NoError(err)
+
+		orders, err := b.ex.GetOpenOrders()
+		b.NoError(err)
+		b.Len(orders, 2)
+		b.Equal(domain.LimitOrder, orders[0].OrderType)
+		b.Equal(string(domain.SellOrder), orders[0].Side)
+		b.Equal(10.0, orders[0].UnfilledSize)
+		b.Equal(resp.OrderID, orders[1].OrderID)
+
+		b.NoError(b.ex.CancelOrder(domain.Order{Symbol: testBinancePair, OrderID: resp.OrderID}))
+		b.ErrorIs(b.ex.CancelOrder(domain.Order{Symbol: testBinancePair, OrderID: resp.OrderID}), domain.ErrOrderNotFound)
+
+		b.NoError(b.ex.CancelAllOrders())
+		orders, err = b.ex.GetOpenOrders()
+		b.NoError(err)
+		b.Empty(orders)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\terror codes", testID)
+	{
+		b.stub.failNext(http.StatusBadRequest, &binance.ErrorResponse{Code: -2019, Msg: "Margin is insufficient."})
+		_, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testBinancePair, 99, 100))
+		b.ErrorIs(err, domain.ErrInsufficientFunds)
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tgateway error", testID)
+	{
+		b.stub.failNext(http.StatusBadGateway, nil)
+		_, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testBinancePair, 99, 100))
+		b.ErrorIs(err, domain.ErrExchangeFailure)
+		b.Zero(b.stub.position(testBinancePair), "Order should not be resent")
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tlost response of order with client order ID", testID)
+	{
+		order := domain.CreateIocOrder(domain.BuyOrder, testBinancePair, 101, 100)
+		order.CliOrdID = "BTCUSDT-1637866800-buy-1"
+		b.stub.dropNextResponse()
+		resp, err := b.ex.CreateOrder(order)
+		b.NoError(err)
+		b.Equal(order.CliOrdID, resp.CliOrdID)
+		b.Equal(domain.ExecutionEvent, resp.OrderEventType)
+		b.Equal(100, resp.Size)
+		b.InDelta(0.1, b.stub.position(testBinancePair), 1e-9, "Resent order should not be executed twice")
+
+		_, err = b.ex.CreateOrder(order)
+		b.ErrorIs(err, domain.ErrDuplicateOrder)
+		b.InDelta(0.1, b.stub.position(testBinancePair), 1e-9)
+		b.NoError(b.ex.FlattenPositions())
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\tlost response of order without client order ID", testID)
+	{
+		b.stub.dropNextResponse()
+		_, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testBinancePair, 101, 100))
+		b.Error(err)
+		b.InDelta(0.1, b.stub.position(testBinancePair), 1e-9, "Order should not be resent")
+		b.NoError(b.ex.FlattenPositions())
+	}
+
+	testID++
+	b.T().Logf("\tTest %d:\trate limited by server", testID)
+	{
+		b.stub.failNext(http.StatusTooManyRequests, &binance.ErrorResponse{Code: binance.TooManyRequests, Msg: "Too many requests."})
+		_, err := b.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testBinancePair, 99, 100))
+		b.ErrorIs(err, domain.ErrRateLimited)
+		b.Less(b.ex.limiter.Available(), 1.0, "Budget should be drained")
+	}
+}
+
+func (b *binanceEnvironment) TestAuthentication() {
+	b.stub.setPrice(100)
+	ex, err := newTestBinanceExchange(b.stub, "wrong")
+	b.Require().NoError(err)
+	defer ex.CloseConnection()
+
+	_, err = ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testBinancePair, 101, 100))
+	b.ErrorIs(err, domain.ErrAuthentication)
+	b.Zero(b.stub.position(testBinancePair), "Order should not be accepted")
+	b.Contains(fmt.Sprint(err), "-2015")
+}
+
+func TestBinanceExchange(t *testing.T) {
+	suite.Run(t, new(binanceEnvironment))
+}
@@ -0,0 +1,381 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// dryRunSuffix is added to the venue of dry run, so state of simulated orders is not restored by live runs
+const dryRunSuffix = "-dry-run"
+
+// dryRunOrderPrefix is the prefix of simulated order IDs
+const dryRunOrderPrefix = "dry-"
+
+// DryRunExchange streams market data of the wrapped exchange and simulates order entry on it, orders are never
+// sent to the exchange. Market orders and marketable limit orders are filled at the last trade price, resting
+// orders are filled when a trade crosses their price. Stop and take profit orders are triggered by the last
+// trade price whatever their trigger signal is. Responses are marked as simulated.
+type DryRunExchange struct {
+	Exchange
+	logger *log.Logger
+	now    func() time.Time // clock of order and fill times
+
+	mu        sync.Mutex
+	lastPrice map[string]float64
+	nextID    int
+	cliOrdIDs map[string]bool
+	orders    map[string]domain.OpenOrder
+	fills     []domain.Fill
+	positions map[string]domain.Position
+}
+
+var (
+	_ Exchange    = (*DryRunExchange)(nil)
+	_ OrderEditor = (*DryRunExchange)(nil)
+	_ Instruments = (*DryRunExchange)(nil)
+	_ Balances    = (*DryRunExchange)(nil)
+)
+
+func NewDryRun(ex Exchange, logger *log.Logger) *DryRunExchange {
+	return &DryRunExchange{
+		Exchange:  ex,
+		logger:    logger,
+		now:       time.Now,
+		lastPrice: make(map[string]float64),
+		cliOrdIDs: make(map[string]bool),
+		orders:    make(map[string]domain.OpenOrder),
+		positions: make(map[string]domain.Position),
+	}
+}
+
+// SetClock makes orders and fills timed by now instead of the wall clock, e.g. by the time of replayed trades.
+// It should be called before the exchange is used.
+func (d *DryRunExchange) SetClock(now func() time.Time) {
+	d.now = now
+}
+
+func (d *DryRunExchange) Venue() string {
+	return d.Exchange.Venue() + dryRunSuffix
+}
+
+// GetPrices passes trades of the wrapped exchange and fills resting orders crossed by them
+func (d *DryRunExchange) GetPrices(ctx context.Context) <-chan domain.Price {
+	out := make(chan domain.Price)
+
+	go func() {
+		defer close(out)
+		for price := range d.Exchange.GetPrices(ctx) {
+			d.onTrade(price)
+			select {
+			case out <- price:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+func (d *DryRunExchange) onTrade(price domain.Price) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastPrice[price.ProductID] = price.Price
+	for id, o := range d.orders {
+		if o.Symbol != price.ProductID || !crossed(o, price.Price) {
+			continue
+		}
+		delete(d.orders, id)
+		fillPrice := price.Price
+		if o.OrderType == domain.LimitOrder || o.OrderType == domain.PostOnlyOrder {
+			fillPrice = o.LimitPrice
+		}
+		d.fill(o.OrderID, o.CliOrdID, o.Symbol, o.Side, o.UnfilledSize, fillPrice)
+		d.logger.Infof("Simulated %s %s order %s filled at %v", o.Side, o.OrderType, o.OrderID, fillPrice)
+	}
+}
+
+// crossed reports whether the resting order is executed by a trade at the price
+func crossed(o domain.OpenOrder, price float64) bool {
+	buy := o.Side == string(domain.BuyOrder)
+	switch o.OrderType {
+	case domain.StopOrder:
+		return buy && price >= o.StopPrice || !buy && price <= o.StopPrice
+	case domain.TakeProfitOrder:
+		return buy && price <= o.StopPrice || !buy && price >= o.StopPrice
+	default:
+		return marketable(buy, o.LimitPrice, price)
+	}
+}
+
+// marketable reports whether limit order is executed at once at the last trade price
+func marketable(buy bool, limit, last float64) bool {
+	return buy && limit >= last || !buy && limit <= last
+}
+
+// GetInstrument returns the instrument of the wrapped exchange, it fails if the venue does not report them
+func (d *DryRunExchange) GetInstrument(symbol string) (domain.Instrument, error) {
+	instruments, ok := d.Exchange.(Instruments)
+	if !ok {
+		return domain.Instrument{}, fmt.Errorf("%s venue does not report instruments", d.Exchange.Venue())
+	}
+	return instruments.GetInstrument(symbol)
+}
+
+// GetBalance returns the balance of the wrapped exchange account, it fails if the venue does not report them
+func (d *DryRunExchange) GetBalance() (domain.Balance, error) {
+	balances, ok := d.Exchange.(Balances)
+	if !ok {
+		return domain.Balance{}, fmt.Errorf("%s venue does not report balances", d.Exchange.Venue())
+	}
+	return balances.GetBalance()
+}
+
+// CreateOrder simulates the order, it is normalized by the instrument if the wrapped exchange reports them
+func (d *DryRunExchange) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	if instruments, ok := d.Exchange.(Instruments); ok {
+		instrument, err := instruments.GetInstrument(order.Symbol)
+		if err != nil {
+			return domain.CreateOrderResponse{}, err
+		}
+		if order, err = instrument.Normalize(order); err != nil {
+			return domain.CreateOrderResponse{}, err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if order.Size <= 0 {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: size %d", domain.ErrInvalidSize, order.Size)
+	}
+	if order.CliOrdID != "" && d.cliOrdIDs[order.CliOrdID] {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: %s", domain.ErrDuplicateOrder, order.CliOrdID)
+	}
+	last, ok := d.lastPrice[order.Symbol]
+	if !ok {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: no trades of %s yet", domain.ErrMarketUnavailable, order.Symbol)
+	}
+	if order.ReduceOnly == domain.ReduceOnly {
+		size := d.reducible(order.Symbol, domain.OrderType(order.Side))
+		if size == 0 {
+			return domain.CreateOrderResponse{}, fmt.Errorf("%w: reduce-only %s order of %s would not reduce position",
+				domain.ErrPositionLimit, order.Side, order.Symbol)
+		}
+		if order.Size > size {
+			order.Size = size
+		}
+	}
+
+	buy := order.Side == string(domain.BuyOrder)
+	fill := false
+	switch order.OrderType {
+	case domain.MarketOrder:
+		fill = true
+	case domain.IocOrder:
+		if !marketable(buy, order.LimitPrice, last) {
+			return domain.CreateOrderResponse{}, fmt.Errorf("%w: ioc order at %v, last price %v",
+				domain.ErrWouldNotExecute, order.LimitPrice, last)
+		}
+		fill = true
+	case domain.PostOnlyOrder:
+		if marketable(buy, order.LimitPrice, last) {
+			return domain.CreateOrderResponse{}, fmt.Errorf("%w: post-only order at %v, last price %v",
+				domain.ErrWouldNotExecute, order.LimitPrice, last)
+		}
+	case domain.LimitOrder:
+		fill = marketable(buy, order.LimitPrice, last)
+	case domain.StopOrder, domain.TakeProfitOrder:
+	default:
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: unknown order type %q", domain.ErrInvalidRequest, order.OrderType)
+	}
+
+	d.nextID++
+	resp := domain.CreateOrderResponse{
+		OrderType:    order.OrderType,
+		Symbol:       order.Symbol,
+		Side:         order.Side,
+		Size:         order.Size,
+		LimitPrice:   order.LimitPrice,
+		Result:       "success",
+		Status:       domain.PlacedStatus,
+		OrderID:      fmt.Sprintf("%s%d", dryRunOrderPrefix, d.nextID),
+		CliOrdID:     order.CliOrdID,
+		ReceivedTime: d.receivedTime(),
+		Simulated:    true,
+	}
+	if order.CliOrdID != "" {
+		d.cliOrdIDs[order.CliOrdID] = true
+	}
+
+	if fill {
+		d.fill(resp.OrderID, order.CliOrdID, order.Symbol, order.Side, float64(order.Size), last)
+		resp.FilledSize = float64(order.Size)
+		resp.OrderEventType = domain.ExecutionEvent
+	} else {
+		d.orders[resp.OrderID] = domain.OpenOrder{
+			OrderID:      resp.OrderID,
+			CliOrdID:     order.CliOrdID,
+			Symbol:       order.Symbol,
+			Side:         order.Side,
+			OrderType:    order.OrderType,
+			LimitPrice:   order.LimitPrice,
+			StopPrice:    order.StopPrice,
+			UnfilledSize: float64(order.Size),
+			ReceivedTime: resp.ReceivedTime,
+		}
+	}
+	d.logger.Infof("Simulated %s %s order %s of %d %s", order.Side, order.OrderType, resp.OrderID, order.Size, order.Symbol)
+	return resp, nil
+}
+
+// EditOrder changes size, limit or stop price of the resting simulated order
+func (d *DryRunExchange) EditOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o, ok := d.orders[order.OrderID]
+	if !ok {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%w: %s", domain.ErrOrderNotFound, order.OrderID)
+	}
+	if order.Size > 0 {
+		o.UnfilledSize = float64(order.Size)
+	}
+	if order.LimitPrice > 0 {
+		o.LimitPrice = order.LimitPrice
+	}
+	if order.StopPrice > 0 {
+		o.StopPrice = order.StopPrice
+	}
+	d.orders[o.OrderID] = o
+	return domain.CreateOrderResponse{
+		OrderType:    o.OrderType,
+		Symbol:       o.Symbol,
+		Side:         o.Side,
+		Size:         int(o.UnfilledSize),
+		LimitPrice:   o.LimitPrice,
+		Result:       "success",
+		Status:       domain.PlacedStatus,
+		OrderID:      o.OrderID,
+		CliOrdID:     o.CliOrdID,
+		ReceivedTime: d.receivedTime(),
+		Simulated:    true,
+	}, nil
+}
+
+func (d *DryRunExchange) CancelOrder(order domain.Order) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for id, o := range d.orders {
+		if id == order.OrderID || order.CliOrdID != "" && o.CliOrdID == order.CliOrdID {
+			delete(d.orders, id)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", domain.ErrOrderNotFound, order.OrderID)
+}
+
+func (d *DryRunExchange) CancelAllOrders() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.orders = make(map[string]domain.OpenOrder)
+	return nil
+}
+
+func (d *DryRunExchange) GetOpenOrders() ([]domain.OpenOrder, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	orders := make([]domain.OpenOrder, 0, len(d.orders))
+	for _, o := range d.orders {
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+func (d *DryRunExchange) GetFills(symbol string) ([]domain.Fill, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var fills []domain.Fill
+	for _, f := range d.fills {
+		if symbol == "" || f.Symbol == symbol {
+			fills = append(fills, f)
+		}
+	}
+	return fills, nil
+}
+
+func (d *DryRunExchange) GetOpenPositions() ([]domain.Position, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	positions := make([]domain.Position, 0, len(d.positions))
+	for _, p := range d.positions {
+		positions = append(positions, p)
+	}
+	return positions, nil
+}
+
+func (d *DryRunExchange) FlattenPositions() error {
+	return flattenPositions(d, d)
+}
+
+// reducible returns number of contracts the order of the side can reduce position by. Should be called with locked mutex.
+func (d *DryRunExchange) reducible(symbol string, side domain.OrderType) int {
+	p, ok := d.positions[symbol]
+	if !ok || p.Side == domain.LongPosition && side == domain.BuyOrder || p.Side == domain.ShortPosition && side == domain.SellOrder {
+		return 0
+	}
+	return int(p.Size)
+}
+
+// fill records execution and updates position of the symbol. Should be called with locked mutex.
+func (d *DryRunExchange) fill(orderID, cliOrdID, symbol, side string, size, price float64) {
+	d.fills = append(d.fills, domain.Fill{
+		FillID:   fmt.Sprintf("%s%d", orderID, len(d.fills)),
+		OrderID:  orderID,
+		CliOrdID: cliOrdID,
+		Symbol:   symbol,
+		Side:     side,
+		Size:     size,
+		Price:    price,
+		Time:     d.receivedTime(),
+	})
+
+	p := d.positions[symbol]
+	net := p.Size
+	if p.Side == domain.ShortPosition {
+		net = -net
+	}
+	change := size
+	if side == string(domain.SellOrder) {
+		change = -size
+	}
+
+	after := net + change
+	switch {
+	case after == 0:
+		delete(d.positions, symbol)
+		return
+	case net == 0 || net*change > 0:
+		// position is opened or increased, entry price is averaged
+		p.Price = (math.Abs(net)*p.Price + size*price) / math.Abs(after)
+	case net*after < 0:
+		// position is reversed, the rest is entered at the price
+		p.Price = price
+	}
+	p.Symbol = symbol
+	p.Size = math.Abs(after)
+	p.Side = domain.LongPosition
+	if after < 0 {
+		p.Side = domain.ShortPosition
+	}
+	d.positions[symbol] = p
+}
+
+// receivedTime returns current time of the clock in the format of exchange timestamps
+func (d *DryRunExchange) receivedTime() string {
+	return d.now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
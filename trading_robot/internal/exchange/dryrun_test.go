@@ -0,0 +1,180 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// liveExchangeStub streams prices of the channel and counts orders sent to it
+type liveExchangeStub struct {
+	Exchange
+	prices chan domain.Price
+	sent   int
+}
+
+func (l *liveExchangeStub) GetPrices(_ context.Context) <-chan domain.Price {
+	return l.prices
+}
+
+func (l *liveExchangeStub) Venue() string {
+	return KrakenVenue
+}
+
+func (l *liveExchangeStub) CreateOrder(_ domain.Order) (domain.CreateOrderResponse, error) {
+	l.sent++
+	return domain.CreateOrderResponse{}, nil
+}
+
+func newTestDryRun() (*DryRunExchange, *liveExchangeStub, <-chan domain.Price) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	live := &liveExchangeStub{prices: make(chan domain.Price)}
+	d := NewDryRun(live, logger)
+	d.SetClock(func() time.Time { return time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC) })
+	return d, live, d.GetPrices(context.Background())
+}
+
+func trade(live *liveExchangeStub, prices <-chan domain.Price, price float64) {
+	live.prices <- domain.Price{ProductID: testPair, Price: price, Quantity: 1}
+	<-prices
+}
+
+func TestDryRunExchange(t *testing.T) {
+	a := assert.New(t)
+	d, live, prices := newTestDryRun()
+
+	testID := 0
+	t.Logf("\tTest %d:\torders are rejected before the first trade", testID)
+	{
+		_, err := d.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 100, 10))
+		a.True(errors.Is(err, domain.ErrMarketUnavailable))
+		a.Equal("kraken-dry-run", d.Venue())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tmarketable orders are filled at the last price", testID)
+	{
+		trade(live, prices, 100)
+		order := domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10)
+		order.CliOrdID = "PI_XBTUSD-1637866800-buy-1"
+		resp, err := d.CreateOrder(order)
+		a.NoError(err)
+		a.True(resp.Simulated)
+		a.Equal(domain.PlacedStatus, resp.Status)
+		a.Equal(domain.ExecutionEvent, resp.OrderEventType)
+		a.Equal(10.0, resp.FilledSize)
+		a.Equal("dry-1", resp.OrderID)
+
+		_, err = d.CreateOrder(order)
+		a.True(errors.Is(err, domain.ErrDuplicateOrder))
+
+		_, err = d.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 99, 10))
+		a.True(errors.Is(err, domain.ErrWouldNotExecute))
+
+		positions, err := d.GetOpenPositions()
+		a.NoError(err)
+		a.Equal([]domain.Position{{Symbol: testPair, Side: domain.LongPosition, Size: 10, Price: 100}}, positions)
+		fills, err := d.GetFills(testPair)
+		a.NoError(err)
+		a.Len(fills, 1)
+		a.Equal("2021-11-25T19:00:00.000Z", fills[0].Time, "Fills are timed by the clock")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tresting orders are filled when a trade crosses them", testID)
+	{
+		resp, err := d.CreateOrder(domain.CreateStopOrder(domain.SellOrder, testPair, 95, 20, domain.MarkPriceTrigger))
+		a.NoError(err)
+		a.Empty(resp.OrderEventType)
+		open, err := d.GetOpenOrders()
+		a.NoError(err)
+		a.Len(open, 1)
+
+		_, err = d.EditOrder(domain.Order{OrderID: resp.OrderID, StopPrice: 98})
+		a.NoError(err)
+		trade(live, prices, 99)
+		open, _ = d.GetOpenOrders()
+		a.Len(open, 1)
+
+		trade(live, prices, 97.5)
+		open, _ = d.GetOpenOrders()
+		a.Empty(open)
+		positions, _ := d.GetOpenPositions()
+		a.Empty(positions, "Reduce-only stop closes the position only")
+
+		_, err = d.CreateOrder(domain.CreateMarketOrder(domain.SellOrder, testPair, 10))
+		a.True(errors.Is(err, domain.ErrPositionLimit))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tpositions are flattened and orders cancelled locally", testID)
+	{
+		_, err := d.CreateOrder(domain.CreateLimitOrder(domain.SellOrder, testPair, 97, 5, false))
+		a.NoError(err)
+		resp, err := d.CreateOrder(domain.CreateLimitOrder(domain.SellOrder, testPair, 105, 5, true))
+		a.NoError(err)
+		a.True(errors.Is(d.CancelOrder(domain.Order{OrderID: "unknown"}), domain.ErrOrderNotFound))
+		a.NoError(d.CancelOrder(domain.Order{OrderID: resp.OrderID}))
+
+		a.NoError(d.FlattenPositions())
+		positions, _ := d.GetOpenPositions()
+		a.Empty(positions)
+		a.Equal(0, live.sent, "Orders are never sent to exchange")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tpositions of fractional contracts are not flattened", testID)
+	{
+		d.positions[testPair] = domain.Position{Symbol: testPair, Side: domain.LongPosition, Size: 2.5, Price: 100}
+		a.True(errors.Is(d.FlattenPositions(), domain.ErrInvalidSize))
+		positions, _ := d.GetOpenPositions()
+		a.Equal(2.5, positions[0].Size, "Nothing should be closed")
+	}
+}
+
+// accountExchangeStub is the live exchange reporting balance and instruments
+type accountExchangeStub struct {
+	liveExchangeStub
+}
+
+func (a *accountExchangeStub) GetBalance() (domain.Balance, error) {
+	return domain.Balance{Equity: 1000}, nil
+}
+
+func (a *accountExchangeStub) GetInstrument(symbol string) (domain.Instrument, error) {
+	return domain.Instrument{Symbol: symbol}, nil
+}
+
+func TestDryRunExchange_Account(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	testID := 0
+	t.Logf("\tTest %d:\tbalance and instruments of the live account", testID)
+	{
+		d := NewDryRun(&accountExchangeStub{}, logger)
+		balance, err := d.GetBalance()
+		a.NoError(err)
+		a.Equal(1000.0, balance.Equity)
+		instrument, err := d.GetInstrument(testPair)
+		a.NoError(err)
+		a.Equal(testPair, instrument.Symbol)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tvenue without balances", testID)
+	{
+		d := NewDryRun(&liveExchangeStub{}, logger)
+		_, err := d.GetBalance()
+		a.Error(err)
+		_, err = d.GetInstrument(testPair)
+		a.Error(err)
+	}
+}
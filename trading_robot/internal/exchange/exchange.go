@@ -0,0 +1,248 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
+)
+
+// Supported venues
+const (
+	KrakenVenue  = "kraken"
+	BinanceVenue = "binance"
+)
+
+// MaxOrderResends is the max number of resends of an order with client order ID after network errors.
+// Exchanges reject duplicated client order IDs, so a resent order is never executed twice.
+const MaxOrderResends = 2
+
+// positionSizeEpsilon is the tolerance of whole position sizes, sizes of some venues are converted from base asset
+const positionSizeEpsilon = 1e-9
+
+var (
+	ErrUnknownVenue = errors.New("unknown exchange venue")
+	ErrTooManyPairs = errors.New("can't subscribe to more than one ticker feed")
+)
+
+// MarketData streams trades of subscribed pairs
+type MarketData interface {
+	GetPrices(ctx context.Context) <-chan domain.Price
+	GetLastPrice() (domain.Price, bool)
+	SubscribePairs(pairs ...string) error
+	UnsubscribePairs(pairs ...string) error
+	GetPairs() []string
+	IsConnected() bool
+	ConnectionStates() <-chan utils.ConnEvent
+}
+
+// OrderEntry places and cancels orders. Responses have domain statuses and failures are reported
+// with errors wrapping domain order errors, so callers don't depend on the venue
+type OrderEntry interface {
+	CreateOrder(order domain.Order) (domain.CreateOrderResponse, error)
+	CancelOrder(order domain.Order) error
+	CancelAllOrders() error
+	GetOpenOrders() ([]domain.OpenOrder, error)
+	GetFills(symbol string) ([]domain.Fill, error)
+}
+
+// OrderEditor changes resting orders in place, it is implemented by venues supporting it only
+type OrderEditor interface {
+	EditOrder(order domain.Order) (domain.CreateOrderResponse, error)
+}
+
+// Account reports and closes positions
+type Account interface {
+	GetOpenPositions() ([]domain.Position, error)
+	FlattenPositions() error
+}
+
+// Instruments reports instrument specifications, it is implemented by venues supporting it only
+type Instruments interface {
+	GetInstrument(symbol string) (domain.Instrument, error)
+}
+
+// Balances reports margin account balance, it is implemented by venues supporting it only
+type Balances interface {
+	GetBalance() (domain.Balance, error)
+}
+
+// Journal records what adapters receive and send, e.g. pkg/journal.Journal
+type Journal interface {
+	Record(recordType, pair string, data interface{})
+}
+
+type discardJournal struct{}
+
+func (discardJournal) Record(string, string, interface{}) {}
+
+// exchangeRequest is the journal record of a REST request changing orders
+type exchangeRequest struct {
+	Operation string       `json:"operation"`
+	Order     domain.Order `json:"order"`
+}
+
+// exchangeResponse is the journal record of the response of a REST request changing orders, body is raw
+type exchangeResponse struct {
+	Operation string      `json:"operation"`
+	Status    int         `json:"status,omitempty"`
+	Body      interface{} `json:"body,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// rawMessage returns message for journal records, JSON is kept as is and other messages are quoted
+func rawMessage(data []byte) interface{} {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	return string(data)
+}
+
+// Exchange is a venue adapter
+type Exchange interface {
+	MarketData
+	OrderEntry
+	Account
+	Venue() string
+	CloseConnection() error
+}
+
+var (
+	_ Exchange    = (*KrakenExchange)(nil)
+	_ Exchange    = (*BinanceExchange)(nil)
+	_ OrderEditor = (*KrakenExchange)(nil)
+	_ Instruments = (*KrakenExchange)(nil)
+	_ Balances    = (*KrakenExchange)(nil)
+)
+
+// New creates adapter of the venue
+func New(venue string, logger *log.Logger, opts ...Option) (Exchange, error) {
+	switch venue {
+	case KrakenVenue:
+		return NewKrakenExchange(logger, opts...)
+	case BinanceVenue:
+		return NewBinanceExchange(logger, opts...)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownVenue, venue)
+	}
+}
+
+// acquire takes cost from the rate limit budget. Request is queued if the budget is available
+// in maxWait, otherwise it is rejected with ErrRateLimited.
+func acquire(limiter *ratelimit.TokenBucket, cost float64, maxWait time.Duration, endpoint string) error {
+	if limiter.Reserve(cost) > maxWait {
+		metrics.RateLimited.WithLabelValues(endpoint).Inc()
+		return fmt.Errorf("%s: %w", endpoint, domain.ErrRateLimited)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxWait)
+	defer cancel()
+	if err := limiter.Wait(ctx, cost); err != nil {
+		metrics.RateLimited.WithLabelValues(endpoint).Inc()
+		return fmt.Errorf("%s: %w", endpoint, domain.ErrRateLimited)
+	}
+	return nil
+}
+
+// checkRateLimit drains the budget if the server rejected request because of rate limit, so next requests are delayed
+func checkRateLimit(limiter *ratelimit.TokenBucket, endpoint string, err error) error {
+	if errors.Is(err, domain.ErrRateLimited) {
+		limiter.Drain()
+		metrics.RateLimited.WithLabelValues(endpoint).Inc()
+	}
+	return err
+}
+
+// sendOrder sends the order and resends it after network errors if it has client order ID. If a resend
+// is rejected as duplicate, the order was received by exchange before the error and it is looked up with find.
+// Orders of the client order ID that reached exchange, but were not executed, are reported with
+// domain.ErrNotExecuted, so the caller can send the order again with a new client order ID.
+func sendOrder(order domain.Order, send, find func(domain.Order) (domain.CreateOrderResponse, error), logger *log.Logger) (domain.CreateOrderResponse, error) {
+	resp, err := send(order)
+	if errors.Is(err, domain.ErrDuplicateOrder) && order.CliOrdID != "" {
+		// the order was sent by a previous call, it is a duplicate only if it is resting or executed
+		if _, findErr := find(order); errors.Is(findErr, domain.ErrNotExecuted) {
+			return domain.CreateOrderResponse{}, findErr
+		}
+		return resp, err
+	}
+	for resend := 1; resend <= MaxOrderResends && order.CliOrdID != "" && isNetworkError(err); resend++ {
+		logger.Warnf("Send order %s failed: %s, resend %d", order.CliOrdID, err, resend)
+		resp, err = send(order)
+		if errors.Is(err, domain.ErrDuplicateOrder) {
+			return find(order)
+		}
+	}
+	return resp, err
+}
+
+// isNetworkError reports whether request failed without response, so it is unknown if the server received it
+func isNetworkError(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// flattenPositions closes all open positions with reduce-only market orders. Orders are of whole contracts, so
+// nothing is sent if a position is not of whole contracts, as its rest would stay open.
+func flattenPositions(a Account, e OrderEntry) error {
+	positions, err := a.GetOpenPositions()
+	if err != nil {
+		return err
+	}
+
+	sizes := make([]int, len(positions))
+	for i, p := range positions {
+		size := math.Round(p.Size)
+		if math.Abs(p.Size-size) > positionSizeEpsilon {
+			return fmt.Errorf("close %s position: %w: %g is not a whole number of contracts", p.Symbol, domain.ErrInvalidSize, p.Size)
+		}
+		sizes[i] = int(size)
+	}
+
+	for i, p := range positions {
+		side := domain.SellOrder
+		if p.Side == domain.ShortPosition {
+			side = domain.BuyOrder
+		}
+
+		resp, err := e.CreateOrder(domain.CreateMarketOrder(side, p.Symbol, sizes[i]))
+		if err != nil {
+			return err
+		}
+		if resp.Status != domain.PlacedStatus {
+			return fmt.Errorf("close %s position failed: %s", p.Symbol, resp.Status)
+		}
+	}
+	return nil
+}
+
+// resubscribe restores subscriptions after redial with update, failed attempts are reported as connection state
+// events and retried with backoff. It returns false if ctx is done or the connection is closed.
+func resubscribe(ctx context.Context, conn *utils.RetryableWSConn, update func() error, logger *log.Logger) bool {
+	for attempt := 0; ; attempt++ {
+		err := update()
+		if err == nil {
+			return true
+		}
+		if errors.Is(err, utils.ErrClosed) {
+			return false
+		}
+		logger.Errorf("Restore subscriptions failed: %s", err)
+		conn.Emit(utils.StateResubscribing, err)
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(utils.Backoff(conn.MinBackoff, conn.MaxBackoff, attempt)):
+		}
+	}
+}
@@ -4,39 +4,74 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"net/http"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	rhttp "github.com/hashicorp/go-retryablehttp"
-	"github.com/keruch/tfs-go-hw/trading_robot/config"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
 )
 
 type KrakenExchange struct {
 	logger *log.Logger
 
-	client *rhttp.Client
-	conn   *utils.RetryableWSConn
+	restURL    url.URL
+	publicKey  string
+	privateKey string
+
+	client  *rhttp.Client
+	conn    *utils.RetryableWSConn
+	limiter *ratelimit.TokenBucket
 
 	mu    sync.RWMutex
 	pairs map[string]bool
+
+	priceMu   sync.RWMutex
+	lastPrice domain.Price
+
+	journal Journal
+
+	instrumentsMu sync.RWMutex
+	instruments   map[string]domain.Instrument
 }
 
-func NewKrakenExchange(logger *log.Logger) (*KrakenExchange, error) {
-	u := url.URL{
-		Scheme: kraken.WsScheme,
-		Host:   kraken.Host,
-		Path:   kraken.WsPath,
+// NewKrakenExchange creates Kraken Futures adapter, by default it connects to Kraken demo
+func NewKrakenExchange(logger *log.Logger, opts ...Option) (*KrakenExchange, error) {
+	o := newOptions(
+		url.URL{Scheme: kraken.Scheme, Host: kraken.Host},
+		url.URL{Scheme: kraken.WsScheme, Host: kraken.Host, Path: kraken.WsPath},
+		opts,
+	)
+	k := &KrakenExchange{
+		logger:      logger,
+		restURL:     o.restURL,
+		publicKey:   o.publicKey,
+		privateKey:  o.privateKey,
+		pairs:       make(map[string]bool),
+		instruments: make(map[string]domain.Instrument),
+		journal:     o.journal,
 	}
 
 	rwsconn := &utils.RetryableWSConn{
-		URL:           u,
+		URL:           o.wsURL,
 		MaxRetries:    kraken.MaxRetries,
 		RequestHeader: nil,
+		StaleTimeout:  kraken.StaleTimeout,
+	}
+	if o.configureWS != nil {
+		o.configureWS(rwsconn)
 	}
 
 	_, err := rwsconn.RetryableDial()
@@ -44,15 +79,27 @@ func NewKrakenExchange(logger *log.Logger) (*KrakenExchange, error) {
 		return nil, err
 	}
 
+	limiter := ratelimit.NewTokenBucket(kraken.RateLimitBudget, kraken.RateLimitInterval)
+
 	client := rhttp.NewClient()
 	client.Logger = logger
+	// retries spend the rate limit budget too
+	client.RequestLogHook = func(_ rhttp.Logger, req *http.Request, retry int) {
+		if retry > 0 {
+			_ = limiter.Wait(req.Context(), kraken.EndpointCost(kraken.OperationEndpoint(strings.TrimPrefix(req.URL.Path, kraken.Path))))
+		}
+	}
 
-	return &KrakenExchange{
-		logger: logger,
-		client: client,
-		conn:   rwsconn,
-		pairs:  make(map[string]bool),
-	}, nil
+	k.client = client
+	k.conn = rwsconn
+	k.limiter = limiter
+
+	// heartbeats keep the feed alive when no pairs are subscribed
+	if err = k.sendRequest(kraken.SubscribeEvent, kraken.HeartbeatFeed); err != nil {
+		return nil, err
+	}
+
+	return k, nil
 }
 
 func (k *KrakenExchange) sendOrder(order domain.Order, operation kraken.OperationEndpoint) (*kraken.ReceiveOrder, error) {
@@ -66,8 +113,22 @@ func (k *KrakenExchange) sendOrder(order domain.Order, operation kraken.Operatio
 		return nil, err
 	}
 
-	resp, err := k.client.Do(req)
+	if err = k.acquire(operation); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var resp *http.Response
+	if req.Method == http.MethodPost {
+		// orders must not be resent on failure, the first attempt could have been executed
+		k.journal.Record(journal.ExchangeRequest, order.Symbol, exchangeRequest{Operation: string(operation), Order: order})
+		resp, err = k.client.HTTPClient.Do(req.Request)
+	} else {
+		resp, err = k.client.Do(req)
+	}
+	metrics.RESTLatency.WithLabelValues(string(operation)).Observe(time.Since(start).Seconds())
 	if err != nil {
+		k.recordResponse(req.Method, order, exchangeResponse{Operation: string(operation), Error: err.Error()})
 		return nil, err
 	}
 
@@ -77,21 +138,42 @@ func (k *KrakenExchange) sendOrder(order domain.Order, operation kraken.Operatio
 	}
 	defer resp.Body.Close()
 
+	k.logger.Trace(string(data))
+	k.recordResponse(req.Method, order, exchangeResponse{Operation: string(operation), Status: resp.StatusCode, Body: rawMessage(data)})
+
 	var ro *kraken.ReceiveOrder
-	err = json.Unmarshal(data, &ro)
-	if err != nil {
-		return nil, err
+	if err = json.Unmarshal(data, &ro); err != nil {
+		// error responses of proxies and gateways are not JSON
+		if apiErr := kraken.CheckResponse(operation, resp.StatusCode, nil); apiErr != nil {
+			err = apiErr
+		}
+		return nil, checkRateLimit(k.limiter, string(operation), err)
 	}
 
-	k.logger.Trace(string(data))
+	if err = kraken.CheckResponse(operation, resp.StatusCode, ro); err != nil {
+		return ro, checkRateLimit(k.limiter, string(operation), err)
+	}
 
 	return ro, nil
 }
 
+// recordResponse records response of requests changing orders, responses of reads are not recorded
+func (k *KrakenExchange) recordResponse(method string, order domain.Order, r exchangeResponse) {
+	if method == http.MethodPost {
+		k.journal.Record(journal.ExchangeResponse, order.Symbol, r)
+	}
+}
+
+// acquire takes endpoint cost from the rate limit budget. Request is queued if the budget
+// is available in MaxRateLimitWait, otherwise it is rejected with ErrRateLimited.
+func (k *KrakenExchange) acquire(operation kraken.OperationEndpoint) error {
+	return acquire(k.limiter, kraken.EndpointCost(operation), kraken.MaxRateLimitWait, string(operation))
+}
+
 func (k *KrakenExchange) createOrderRequest(operation kraken.OperationEndpoint, queryParams kraken.QueryParams) (*rhttp.Request, error) {
 	u := &url.URL{
-		Scheme:   kraken.Scheme,
-		Host:     kraken.Host,
+		Scheme:   k.restURL.Scheme,
+		Host:     k.restURL.Host,
 		Path:     kraken.Path + string(operation),
 		RawQuery: kraken.WsQuery,
 	}
@@ -112,12 +194,12 @@ func (k *KrakenExchange) createOrderRequest(operation kraken.OperationEndpoint,
 		return nil, err
 	}
 
-	token, err := kraken.GenerateToken(config.GetPrivateKey(), string(operation), q.Encode())
+	token, err := kraken.GenerateToken(k.privateKey, string(operation), q.Encode())
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set(kraken.Authent, token)
-	req.Header.Set(kraken.APIKey, config.GetPublicKey())
+	req.Header.Set(kraken.APIKey, k.publicKey)
 
 	return req, nil
 }
@@ -134,21 +216,31 @@ func (k *KrakenExchange) GetPrices(ctx context.Context) <-chan domain.Price {
 				return
 			default:
 				_, data, reconnected, err := k.conn.ReadMessage()
+				if errors.Is(err, utils.ErrClosed) {
+					k.logger.Info("Get Prices done: connection closed")
+					return
+				}
 				if err != nil {
 					k.logger.Error(err)
 					continue
 				}
 
 				if reconnected {
-					if err = k.updateConnection(); err != nil {
-						k.logger.Panic(err)
+					if !resubscribe(ctx, k.conn, k.updateConnection, k.logger) {
+						return
 					}
+					continue
 				}
 
 				k.logger.Trace(string(data))
+				k.journal.Record(journal.WSMessage, "", rawMessage(data))
+				metrics.WSMessages.WithLabelValues(utils.ParseFeed(data)).Inc()
 
 				price, ok := utils.ValidateDataIsPrice(data)
 				if ok {
+					k.priceMu.Lock()
+					k.lastPrice = price
+					k.priceMu.Unlock()
 					out <- price
 				}
 			}
@@ -158,6 +250,33 @@ func (k *KrakenExchange) GetPrices(ctx context.Context) <-chan domain.Price {
 	return out
 }
 
+// GetLastPrice returns the last received price, false if there were no prices yet
+func (k *KrakenExchange) GetLastPrice() (domain.Price, bool) {
+	k.priceMu.RLock()
+	defer k.priceMu.RUnlock()
+	return k.lastPrice, k.lastPrice.ProductID != ""
+}
+
+func (k *KrakenExchange) IsConnected() bool {
+	return k.conn.IsConnected()
+}
+
+// ConnectionStates returns WebSocket connection state events
+func (k *KrakenExchange) ConnectionStates() <-chan utils.ConnEvent {
+	return k.conn.States()
+}
+
+func (k *KrakenExchange) GetPairs() []string {
+	k.mu.RLock()
+	pairs := make([]string, 0, len(k.pairs))
+	for pair := range k.pairs {
+		pairs = append(pairs, pair)
+	}
+	k.mu.RUnlock()
+	sort.Strings(pairs)
+	return pairs
+}
+
 func (k *KrakenExchange) CloseConnection() error {
 	return k.conn.Close()
 }
@@ -167,8 +286,7 @@ func (k *KrakenExchange) SubscribePairs(pairs ...string) error {
 	pairsCount := len(k.pairs)
 	k.mu.RUnlock()
 	if pairsCount >= 1 {
-		// TODO: delete hardcoded error
-		return errors.New("can't subscribe to more than one ticker feed")
+		return ErrTooManyPairs
 	}
 	k.mu.Lock()
 	for _, pair := range pairs {
@@ -190,7 +308,7 @@ func (k *KrakenExchange) UnsubscribePairs(pairs ...string) error {
 type request struct {
 	Event      kraken.Event `json:"event"`
 	Feed       kraken.Feed  `json:"feed"`
-	ProductIDs []string     `json:"product_ids"`
+	ProductIDs []string     `json:"product_ids,omitempty"`
 }
 
 func newRequest(event kraken.Event, feed kraken.Feed) request {
@@ -218,6 +336,10 @@ func (k *KrakenExchange) sendRequest(event kraken.Event, feed kraken.Feed, ticke
 }
 
 func (k *KrakenExchange) updateConnection() error {
+	if err := k.sendRequest(kraken.SubscribeEvent, kraken.HeartbeatFeed); err != nil {
+		return err
+	}
+
 	pairs := make([]string, 0)
 	k.mu.RLock()
 	for pair := range k.pairs {
@@ -230,31 +352,314 @@ func (k *KrakenExchange) updateConnection() error {
 	return k.SubscribePairs(pairs...)
 }
 
+// CreateOrder rounds size and prices of the order to the instrument increments and sends it,
+// orders with client order ID are resent after network errors
 func (k *KrakenExchange) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	instrument, err := k.GetInstrument(order.Symbol)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	if order, err = instrument.Normalize(order); err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	return sendOrder(order, k.createOrder, k.findOrder, k.logger)
+}
+
+func (k *KrakenExchange) createOrder(order domain.Order) (domain.CreateOrderResponse, error) {
 	req, err := k.sendOrder(order, kraken.CreateOrder)
 	if err != nil {
 		return domain.CreateOrderResponse{}, err
 	}
 
-	return domain.CreateOrderResponse{
+	resp := domain.CreateOrderResponse{
 		OrderType:    order.OrderType,
 		Symbol:       order.Symbol,
 		Side:         order.Side,
 		Size:         order.Size,
 		LimitPrice:   order.LimitPrice,
 		Result:       req.Result,
-		Status:       req.SendStatus.Status,
+		Status:       domain.PlacedStatus, // other send statuses are returned as errors
 		OrderID:      req.SendStatus.OrderID,
+		CliOrdID:     order.CliOrdID,
 		ReceivedTime: req.SendStatus.ReceivedTime,
+	}
+	return withExecutions(resp, req.SendStatus.OrderEvents), nil
+}
+
+// withExecutions sets size of the response to the size executed by the order events. The order is executed if any
+// of its events is an execution whatever the last event is, e.g. the rest of a partly filled ioc order is cancelled.
+func withExecutions(resp domain.CreateOrderResponse, events []kraken.OrderEvents) domain.CreateOrderResponse {
+	var executed float64
+	for _, e := range events {
+		if e.Type == domain.ExecutionEvent {
+			executed += e.Amount
+		}
+	}
+	switch {
+	case executed > 0:
+		resp.Size = int(math.Round(executed))
+		resp.FilledSize = executed
+		resp.OrderEventType = domain.ExecutionEvent
+	case len(events) != 0:
+		resp.OrderEventType = events[len(events)-1].Type
+	}
+	return resp
+}
+
+// findOrder looks up the order by client order ID in open orders and fills. Orders that are neither resting
+// nor filled were not executed, they are reported with domain.ErrNotExecuted.
+func (k *KrakenExchange) findOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	resp := domain.CreateOrderResponse{
+		OrderType:  order.OrderType,
+		Symbol:     order.Symbol,
+		Side:       order.Side,
+		Size:       order.Size,
+		LimitPrice: order.LimitPrice,
+		Result:     kraken.SuccessResult,
+		Status:     domain.PlacedStatus,
+		CliOrdID:   order.CliOrdID,
+	}
+
+	open, err := k.GetOpenOrders()
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	for _, o := range open {
+		if o.CliOrdID == order.CliOrdID {
+			resp.OrderID, resp.ReceivedTime = o.OrderID, o.ReceivedTime
+			return resp, nil
+		}
+	}
+
+	fills, err := k.GetFills(order.Symbol)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	var filled float64
+	for _, f := range fills {
+		if f.CliOrdID == order.CliOrdID {
+			filled += f.Size
+			resp.OrderID, resp.ReceivedTime = f.OrderID, f.Time
+		}
+	}
+	if filled == 0 {
+		return domain.CreateOrderResponse{}, fmt.Errorf("%s: %w: %s", kraken.CreateOrder, domain.ErrNotExecuted, order.CliOrdID)
+	}
+	resp.Size = int(filled)
+	resp.FilledSize = filled
+	resp.OrderEventType = domain.ExecutionEvent
+	return resp, nil
+}
+
+// EditOrder changes size, limit and stop prices of the resting order found by OrderID or CliOrdID,
+// zero values are not changed. Prices and size are rounded to the instrument increments if Symbol is set.
+func (k *KrakenExchange) EditOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	if order.Symbol != "" {
+		instrument, err := k.GetInstrument(order.Symbol)
+		if err != nil {
+			return domain.CreateOrderResponse{}, err
+		}
+		if order.Size != 0 {
+			if order.Size, err = instrument.RoundSize(order.Size); err != nil {
+				return domain.CreateOrderResponse{}, err
+			}
+		}
+		order.LimitPrice, order.StopPrice = roundPrice(instrument, order.LimitPrice), roundPrice(instrument, order.StopPrice)
+	}
+
+	req, err := k.sendOrder(order, kraken.EditOrder)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+
+	resp := domain.CreateOrderResponse{
+		OrderType:    order.OrderType,
+		Symbol:       order.Symbol,
+		Side:         order.Side,
+		Size:         order.Size,
+		LimitPrice:   order.LimitPrice,
+		Result:       req.Result,
+		Status:       domain.PlacedStatus,
+		OrderID:      req.EditStatus.OrderID,
+		CliOrdID:     order.CliOrdID,
+		ReceivedTime: req.EditStatus.ReceivedTime,
+	}
+	return withExecutions(resp, req.EditStatus.OrderEvents), nil
+}
+
+// roundPrice rounds non-zero price, zero prices are not sent
+func roundPrice(instrument domain.Instrument, price float64) float64 {
+	if price == 0 {
+		return 0
+	}
+	return instrument.RoundPrice(price)
+}
+
+// GetInstrument returns cached instrument specification, instruments are fetched again if the symbol is unknown
+func (k *KrakenExchange) GetInstrument(symbol string) (domain.Instrument, error) {
+	k.instrumentsMu.RLock()
+	instrument, ok := k.instruments[symbol]
+	k.instrumentsMu.RUnlock()
+	if ok {
+		return instrument, nil
+	}
+
+	instruments, err := k.GetInstruments()
+	if err != nil {
+		return domain.Instrument{}, err
+	}
+	k.instrumentsMu.Lock()
+	for _, i := range instruments {
+		k.instruments[i.Symbol] = i
+	}
+	instrument, ok = k.instruments[symbol]
+	k.instrumentsMu.Unlock()
+	if !ok {
+		return domain.Instrument{}, fmt.Errorf("%w: unknown instrument %q", domain.ErrInvalidRequest, symbol)
+	}
+	return instrument, nil
+}
+
+// GetInstruments fetches specifications of all instruments
+func (k *KrakenExchange) GetInstruments() ([]domain.Instrument, error) {
+	resp, err := k.sendOrder(domain.Order{}, kraken.Instruments)
+	if err != nil {
+		return nil, err
+	}
+
+	instruments := make([]domain.Instrument, 0, len(resp.Instruments))
+	for _, i := range resp.Instruments {
+		instruments = append(instruments, domain.Instrument{
+			Symbol:        i.Symbol,
+			TickSize:      i.TickSize,
+			ContractSize:  i.ContractSize,
+			SizePrecision: i.ContractValuePrecision,
+			Inverse:       i.Type == kraken.InverseType,
+			InitialMargin: initialMargin(i.MarginLevels),
+			Tradeable:     i.Tradeable,
+		})
+	}
+	return instruments, nil
+}
+
+// initialMargin returns margin of the smallest positions, margin levels are sorted by position size
+func initialMargin(levels []kraken.MarginLevel) float64 {
+	if len(levels) == 0 {
+		return 0
+	}
+	return levels[0].InitialMargin
+}
+
+// GetBalance returns the multi-collateral margin account in USD
+func (k *KrakenExchange) GetBalance() (domain.Balance, error) {
+	resp, err := k.sendOrder(domain.Order{}, kraken.Accounts)
+	if err != nil {
+		return domain.Balance{}, err
+	}
+	account, ok := resp.Accounts[kraken.FlexAccount]
+	if !ok {
+		return domain.Balance{}, fmt.Errorf("%s: %w: no %s account", kraken.Accounts, domain.ErrInvalidRequest, kraken.FlexAccount)
+	}
+	return domain.Balance{
+		Equity:          account.PortfolioValue,
+		AvailableMargin: account.AvailableMargin,
+		InitialMargin:   account.InitialMargin,
 	}, nil
 }
 
-// GetOrders TODO: implement
-func (k *KrakenExchange) GetOrders(order domain.Order) (*kraken.ReceiveOrder, error) {
-	return k.sendOrder(order, kraken.OpenOrders)
+func (k *KrakenExchange) GetOpenOrders() ([]domain.OpenOrder, error) {
+	resp, err := k.sendOrder(domain.Order{}, kraken.OpenOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	orders := make([]domain.OpenOrder, 0, len(resp.OpenOrders))
+	for _, o := range resp.OpenOrders {
+		orders = append(orders, domain.OpenOrder{
+			OrderID:      o.OrderID,
+			CliOrdID:     o.CliOrdID,
+			Symbol:       o.Symbol,
+			Side:         o.Side,
+			OrderType:    o.OrderType,
+			LimitPrice:   o.LimitPrice,
+			StopPrice:    o.StopPrice,
+			UnfilledSize: o.UnfilledSize,
+			FilledSize:   o.FilledSize,
+			ReceivedTime: o.ReceivedTime,
+		})
+	}
+	return orders, nil
+}
+
+// GetFills returns the last fills of the symbol, fills of all symbols if it is empty
+func (k *KrakenExchange) GetFills(symbol string) ([]domain.Fill, error) {
+	resp, err := k.sendOrder(domain.Order{}, kraken.Fills)
+	if err != nil {
+		return nil, err
+	}
+
+	fills := make([]domain.Fill, 0, len(resp.Fills))
+	for _, f := range resp.Fills {
+		if symbol != "" && f.Symbol != symbol {
+			continue
+		}
+		fills = append(fills, domain.Fill{
+			FillID:   f.FillID,
+			OrderID:  f.OrderID,
+			CliOrdID: f.CliOrdID,
+			Symbol:   f.Symbol,
+			Side:     f.Side,
+			Size:     f.Size,
+			Price:    f.Price,
+			Time:     f.FillTime,
+		})
+	}
+	return fills, nil
+}
+
+// CancelOrder cancels order by OrderID
+func (k *KrakenExchange) CancelOrder(order domain.Order) error {
+	_, err := k.sendOrder(order, kraken.CancelOrder)
+	return err
+}
+
+func (k *KrakenExchange) CancelAllOrders() error {
+	resp, err := k.sendOrder(domain.Order{}, kraken.CancelAllOrders)
+	if err != nil {
+		return err
+	}
+	if resp.Result != kraken.SuccessResult {
+		return fmt.Errorf("cancel all orders failed: %s", resp.Error)
+	}
+	return nil
+}
+
+func (k *KrakenExchange) GetOpenPositions() ([]domain.Position, error) {
+	resp, err := k.sendOrder(domain.Order{}, kraken.OpenPositions)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Result != kraken.SuccessResult {
+		return nil, fmt.Errorf("get open positions failed: %s", resp.Error)
+	}
+
+	positions := make([]domain.Position, 0, len(resp.OpenPositions))
+	for _, p := range resp.OpenPositions {
+		positions = append(positions, domain.Position{
+			Symbol: p.Symbol,
+			Side:   p.Side,
+			Size:   p.Size,
+			Price:  p.Price,
+		})
+	}
+	return positions, nil
+}
+
+// FlattenPositions closes all open positions with reduce-only market orders
+func (k *KrakenExchange) FlattenPositions() error {
+	return flattenPositions(k, k)
 }
 
-// DeleteOrder TODO: implement
-func (k *KrakenExchange) DeleteOrder(order domain.Order) (*kraken.ReceiveOrder, error) {
-	return k.sendOrder(order, kraken.CancelOrder)
+func (k *KrakenExchange) Venue() string {
+	return KrakenVenue
 }
@@ -2,11 +2,17 @@ package kraken
 
 import (
 	"errors"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
 )
 
 const (
 	MaxRetries = 13
 
+	// heartbeat feed sends message every minute, so the feed is stale if there are no messages for longer
+	StaleTimeout = 2 * time.Minute
+
 	Scheme = "https"
 	Host   = "demo-futures.kraken.com"
 	Path   = "/derivatives"
@@ -21,18 +27,31 @@ const (
 	Candles1mFeed Feed = "candles_trade_1m"
 	TickerFeed    Feed = "ticker"
 	TradesFeed    Feed = "trade"
+	HeartbeatFeed Feed = "heartbeat"
 
 	FeedType = TradesFeed
 
 	CreateOrder OperationEndpoint = "/api/v3/sendorder"
 	OpenOrders  OperationEndpoint = "/api/v3/openorders"
-	EditOrder   OperationEndpoint = "/api/v3/editorder" // does not supported because of orderId filed
+	EditOrder   OperationEndpoint = "/api/v3/editorder"
 	CancelOrder OperationEndpoint = "/api/v3/cancelorder"
 
+	CancelAllOrders OperationEndpoint = "/api/v3/cancelallorders"
+	OpenPositions   OperationEndpoint = "/api/v3/openpositions"
+	Fills           OperationEndpoint = "/api/v3/fills"
+	Instruments     OperationEndpoint = "/api/v3/instruments"
+	Accounts        OperationEndpoint = "/api/v3/accounts"
+
+	// InverseType is the type of inverse futures instruments, contract size is in USD
+	InverseType = "futures_inverse"
+	// FlexAccount is the multi-collateral margin account, its values are in USD
+	FlexAccount = "flex"
+
 	Authent RequestHeader = "Authent"
 	APIKey  RequestHeader = "APIKey"
 
 	OrderID       QueryParam = "order_id"
+	EditOrderID   QueryParam = "orderId" // editorder names order ID differently from cancelorder
 	OrderType     QueryParam = "orderType"
 	Symbol        QueryParam = "symbol"
 	Side          QueryParam = "side"
@@ -56,7 +75,9 @@ type (
 	QueryParams map[QueryParam]string
 
 	OrderEvents struct {
-		Type string `json:"type"`
+		Type   string  `json:"type"`
+		Price  float64 `json:"price,omitempty"`  // price of EXECUTION event
+		Amount float64 `json:"amount,omitempty"` // executed size of EXECUTION event
 	}
 	SendStatus struct {
 		Status       string        `json:"status,omitempty"`
@@ -66,6 +87,7 @@ type (
 	}
 	OpenOrder struct {
 		OrderID      string  `json:"order_id,omitempty"`
+		CliOrdID     string  `json:"cliOrdId,omitempty"`
 		Symbol       string  `json:"symbol,omitempty"`
 		Side         string  `json:"side,omitempty"`
 		OrderType    string  `json:"orderType,omitempty"`
@@ -76,19 +98,72 @@ type (
 		Status       string  `json:"status,omitempty"`
 		FilledSize   float64 `json:"filledSize,omitempty"`
 	}
+	OpenPosition struct {
+		Side     string  `json:"side,omitempty"`
+		Symbol   string  `json:"symbol,omitempty"`
+		Price    float64 `json:"price,omitempty"`
+		FillTime string  `json:"fillTime,omitempty"`
+		Size     float64 `json:"size,omitempty"`
+	}
+	Fill struct {
+		FillID   string  `json:"fill_id,omitempty"`
+		Symbol   string  `json:"symbol,omitempty"`
+		Side     string  `json:"side,omitempty"`
+		OrderID  string  `json:"order_id,omitempty"`
+		CliOrdID string  `json:"cliOrdId,omitempty"`
+		Size     float64 `json:"size,omitempty"`
+		Price    float64 `json:"price,omitempty"`
+		FillTime string  `json:"fillTime,omitempty"`
+		FillType string  `json:"fillType,omitempty"`
+	}
+	Instrument struct {
+		Symbol                 string        `json:"symbol,omitempty"`
+		Type                   string        `json:"type,omitempty"`
+		TickSize               float64       `json:"tickSize,omitempty"`
+		ContractSize           float64       `json:"contractSize,omitempty"`
+		ContractValuePrecision int           `json:"contractValuePrecision,omitempty"`
+		Tradeable              bool          `json:"tradeable,omitempty"`
+		MarginLevels           []MarginLevel `json:"marginLevels,omitempty"`
+	}
+	MarginLevel struct {
+		InitialMargin     float64 `json:"initialMargin,omitempty"`
+		MaintenanceMargin float64 `json:"maintenanceMargin,omitempty"`
+	}
+	Account struct {
+		Type            string  `json:"type,omitempty"`
+		PortfolioValue  float64 `json:"portfolioValue,omitempty"`
+		AvailableMargin float64 `json:"availableMargin,omitempty"`
+		InitialMargin   float64 `json:"initialMargin,omitempty"`
+	}
+	EditStatus struct {
+		Status       string        `json:"status,omitempty"`
+		OrderID      string        `json:"orderId,omitempty"`
+		ReceivedTime string        `json:"receivedTime,omitempty"`
+		OrderEvents  []OrderEvents `json:"orderEvents"`
+	}
 	CancelStatus struct {
 		Status       string `json:"status,omitempty"`
 		OrderID      string `json:"order_id,omitempty"`
 		ReceivedTime string `json:"receivedTime,omitempty"`
 	}
 	ReceiveOrder struct {
-		Result        string       `json:"result,omitempty"`
-		SendStatus    SendStatus   `json:"sendStatus,omitempty"`
-		OpenPositions []OpenOrder  `json:"openOrders,omitempty"`
-		CancelStatus  CancelStatus `json:"cancelStatus,omitempty"`
-		ServerTime    string       `json:"serverTime,omitempty"`
-		Error         string       `json:"error,omitempty"`
+		Result        string             `json:"result,omitempty"`
+		SendStatus    SendStatus         `json:"sendStatus,omitempty"`
+		OpenOrders    []OpenOrder        `json:"openOrders,omitempty"`
+		OpenPositions []OpenPosition     `json:"openPositions,omitempty"`
+		Fills         []Fill             `json:"fills,omitempty"`
+		Instruments   []Instrument       `json:"instruments,omitempty"`
+		Accounts      map[string]Account `json:"accounts,omitempty"`
+		EditStatus    EditStatus         `json:"editStatus,omitempty"`
+		CancelStatus  CancelStatus       `json:"cancelStatus,omitempty"`
+		ServerTime    string             `json:"serverTime,omitempty"`
+		Error         string             `json:"error,omitempty"`
 	}
 )
 
-var ErrOperationNotFound = errors.New("given operation not found")
+const SuccessResult = "success"
+
+var (
+	ErrOperationNotFound = errors.New("given operation not found")
+	ErrRateLimited       = domain.ErrRateLimited
+)
@@ -0,0 +1,128 @@
+package kraken
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+)
+
+const (
+	ErrorResult = "error"
+
+	PlacedStatus    = "placed"
+	EditedStatus    = "edited"
+	CancelledStatus = "cancelled"
+)
+
+// APIError is an error reported by Kraken API, it unwraps to one of the domain order errors
+type APIError struct {
+	Operation  OperationEndpoint
+	Code       string // error or send status code returned by Kraken
+	HTTPStatus int
+	kind       error
+}
+
+func NewAPIError(operation OperationEndpoint, code string, httpStatus int) *APIError {
+	kind, ok := codeErrors[code]
+	if !ok {
+		kind = domain.ErrOrderRejected
+		if httpStatus >= http.StatusInternalServerError {
+			kind = domain.ErrExchangeFailure
+		}
+	}
+	return &APIError{
+		Operation:  operation,
+		Code:       code,
+		HTTPStatus: httpStatus,
+		kind:       kind,
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Operation, e.kind, e.Code)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+// codeErrors maps Kraken error codes and send statuses to domain errors
+var codeErrors = map[string]error{
+	// sendorder statuses
+	"insufficientAvailableFunds": domain.ErrInsufficientFunds,
+	"iocWouldNotExecute":         domain.ErrWouldNotExecute,
+	"postWouldExecute":           domain.ErrWouldNotExecute,
+	"invalidSize":                domain.ErrInvalidSize,
+	"tooManySmallOrders":         domain.ErrInvalidSize,
+	"invalidPrice":               domain.ErrInvalidPrice,
+	"outsidePriceCollar":         domain.ErrInvalidPrice,
+	"marketSuspended":            domain.ErrMarketUnavailable,
+	"marketInactive":             domain.ErrMarketUnavailable,
+	"maxPositionViolation":       domain.ErrPositionLimit,
+	"wouldCauseLiquidation":      domain.ErrPositionLimit,
+	"wouldNotReducePosition":     domain.ErrPositionLimit,
+	"clientOrderIdAlreadyExist":  domain.ErrDuplicateOrder,
+	"invalidOrderType":           domain.ErrInvalidRequest,
+	"invalidSide":                domain.ErrInvalidRequest,
+	"clientOrderIdTooLong":       domain.ErrInvalidRequest,
+	"selfFill":                   domain.ErrOrderRejected,
+
+	// editorder statuses
+	"orderForEditNotFound": domain.ErrOrderNotFound,
+	"orderForEditNotAStop": domain.ErrInvalidRequest,
+	"invalidStopPrice":     domain.ErrInvalidPrice,
+
+	// cancelorder statuses
+	"notFound": domain.ErrOrderNotFound,
+
+	// errors
+	RateLimitError:            domain.ErrRateLimited,
+	"authenticationError":     domain.ErrAuthentication,
+	"accountInactive":         domain.ErrAuthentication,
+	"nonceBelowThreshold":     domain.ErrAuthentication,
+	"nonceDuplicate":          domain.ErrAuthentication,
+	"requiredArgumentMissing": domain.ErrInvalidRequest,
+	"invalidArgument":         domain.ErrInvalidRequest,
+	"insufficientFunds":       domain.ErrInsufficientFunds,
+	"marketUnavailable":       domain.ErrMarketUnavailable,
+	"Unavailable":             domain.ErrExchangeFailure,
+	"Server Error":            domain.ErrExchangeFailure,
+}
+
+// CheckResponse returns APIError if HTTP status, result or send status of the response report failure
+func CheckResponse(operation OperationEndpoint, httpStatus int, ro *ReceiveOrder) error {
+	if httpStatus == http.StatusTooManyRequests {
+		return NewAPIError(operation, RateLimitError, httpStatus)
+	}
+
+	if ro == nil {
+		if httpStatus < http.StatusOK || httpStatus >= http.StatusMultipleChoices {
+			return NewAPIError(operation, http.StatusText(httpStatus), httpStatus)
+		}
+		return nil
+	}
+
+	if ro.Error != "" {
+		return NewAPIError(operation, ro.Error, httpStatus)
+	}
+	if ro.Result == ErrorResult || httpStatus < http.StatusOK || httpStatus >= http.StatusMultipleChoices {
+		return NewAPIError(operation, http.StatusText(httpStatus), httpStatus)
+	}
+
+	switch operation {
+	case CreateOrder:
+		if status := ro.SendStatus.Status; status != "" && status != PlacedStatus {
+			return NewAPIError(operation, status, httpStatus)
+		}
+	case EditOrder:
+		if status := ro.EditStatus.Status; status != "" && status != EditedStatus {
+			return NewAPIError(operation, status, httpStatus)
+		}
+	case CancelOrder:
+		if status := ro.CancelStatus.Status; status != "" && status != CancelledStatus {
+			return NewAPIError(operation, status, httpStatus)
+		}
+	}
+	return nil
+}
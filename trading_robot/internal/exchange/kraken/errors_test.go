@@ -0,0 +1,68 @@
+package kraken
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResponse(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tplaced order", testID)
+	{
+		ro := &ReceiveOrder{Result: SuccessResult, SendStatus: SendStatus{Status: PlacedStatus}}
+		a.NoError(CheckResponse(CreateOrder, http.StatusOK, ro))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsend statuses mapped to domain errors", testID)
+	{
+		cases := map[string]error{
+			"insufficientAvailableFunds": domain.ErrInsufficientFunds,
+			"iocWouldNotExecute":         domain.ErrWouldNotExecute,
+			"invalidSize":                domain.ErrInvalidSize,
+			"marketSuspended":            domain.ErrMarketUnavailable,
+			"unknownStatus":              domain.ErrOrderRejected,
+		}
+		for status, kind := range cases {
+			ro := &ReceiveOrder{Result: SuccessResult, SendStatus: SendStatus{Status: status}}
+			err := CheckResponse(CreateOrder, http.StatusOK, ro)
+			a.Truef(errors.Is(err, kind), "%s should be %s, got %v", status, kind, err)
+
+			var apiErr *APIError
+			a.True(errors.As(err, &apiErr))
+			a.Equal(status, apiErr.Code)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\terror result", testID)
+	{
+		ro := &ReceiveOrder{Result: ErrorResult, Error: "apiLimitExceeded"}
+		a.ErrorIs(CheckResponse(OpenPositions, http.StatusOK, ro), domain.ErrRateLimited)
+
+		ro = &ReceiveOrder{Result: ErrorResult, Error: "authenticationError"}
+		a.ErrorIs(CheckResponse(CreateOrder, http.StatusUnauthorized, ro), domain.ErrAuthentication)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tnon-2xx statuses", testID)
+	{
+		a.ErrorIs(CheckResponse(CreateOrder, http.StatusTooManyRequests, nil), domain.ErrRateLimited)
+		a.ErrorIs(CheckResponse(CreateOrder, http.StatusBadGateway, nil), domain.ErrExchangeFailure)
+		a.ErrorIs(CheckResponse(CreateOrder, http.StatusBadRequest, &ReceiveOrder{}), domain.ErrOrderRejected)
+		a.NoError(CheckResponse(OpenOrders, http.StatusOK, nil))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcancel not found order", testID)
+	{
+		ro := &ReceiveOrder{Result: SuccessResult, CancelStatus: CancelStatus{Status: "notFound"}}
+		a.ErrorIs(CheckResponse(CancelOrder, http.StatusOK, ro), domain.ErrOrderNotFound)
+	}
+}
@@ -0,0 +1,37 @@
+package kraken
+
+import "time"
+
+const (
+	// RateLimitBudget is the cost budget of /derivatives endpoints per RateLimitInterval for an API key
+	RateLimitBudget   = 500
+	RateLimitInterval = 10 * time.Second
+
+	// MaxRateLimitWait is the max time request is queued for the budget, requests that need more are rejected
+	MaxRateLimitWait = 2 * time.Second
+
+	// RateLimitError is the error returned by Kraken when the budget is exceeded
+	RateLimitError = "apiLimitExceeded"
+
+	defaultCost = 1
+)
+
+// endpointCosts are costs of endpoints in the rate limit budget according to Kraken docs
+var endpointCosts = map[OperationEndpoint]float64{
+	CreateOrder:     10,
+	EditOrder:       10,
+	CancelOrder:     10,
+	CancelAllOrders: 25,
+	OpenOrders:      2,
+	OpenPositions:   2,
+	Fills:           2,
+	Accounts:        2,
+}
+
+// EndpointCost returns cost of the endpoint call in the rate limit budget
+func EndpointCost(operation OperationEndpoint) float64 {
+	if cost, ok := endpointCosts[operation]; ok {
+		return cost
+	}
+	return defaultCost
+}
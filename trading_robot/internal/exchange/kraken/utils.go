@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
-	"fmt"
 	"net/http"
 	"strconv"
 
@@ -14,9 +13,10 @@ import (
 
 func GetMethodByOperation(operation OperationEndpoint) (string, error) {
 	switch {
-	case operation == OpenOrders:
+	case operation == OpenOrders || operation == OpenPositions || operation == Fills || operation == Instruments ||
+		operation == Accounts:
 		return http.MethodGet, nil
-	case operation == CreateOrder || operation == EditOrder || operation == CancelOrder:
+	case operation == CreateOrder || operation == EditOrder || operation == CancelOrder || operation == CancelAllOrders:
 		return http.MethodPost, nil
 	default:
 		return "", ErrOperationNotFound
@@ -49,23 +49,68 @@ func GenerateToken(privateKey, endpoint, postData string) (string, error) {
 	return step5, nil
 }
 
+// formatPrice formats the price rounded to the instrument tick size, see domain.Instrument
+func formatPrice(price float64) string {
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
 func QueryByOperation(order domain.Order, operation OperationEndpoint) (QueryParams, error) {
 	switch operation {
 	case CreateOrder:
-		return QueryParams{
-			OrderType:     order.OrderType,
-			Symbol:        order.Symbol,
-			Side:          order.Side,
-			Size:          strconv.Itoa(order.Size),
-			LimitPrice:    fmt.Sprintf("%.1f", order.LimitPrice),
-			StopPrice:     fmt.Sprintf("%.1f", order.StopPrice),
-			TriggerSignal: order.TriggerSignal,
-		}, nil
+		params := QueryParams{
+			OrderType: order.OrderType,
+			Symbol:    order.Symbol,
+			Side:      order.Side,
+			Size:      strconv.Itoa(order.Size),
+		}
+		switch order.OrderType {
+		case domain.IocOrder, domain.LimitOrder, domain.PostOnlyOrder:
+			params[LimitPrice] = formatPrice(order.LimitPrice)
+		case domain.StopOrder, domain.TakeProfitOrder:
+			// stop and take profit orders with limit price become limit orders when triggered
+			params[StopPrice] = formatPrice(order.StopPrice)
+			if order.LimitPrice > 0 {
+				params[LimitPrice] = formatPrice(order.LimitPrice)
+			}
+			if order.TriggerSignal != "" {
+				params[TriggerSignal] = order.TriggerSignal
+			}
+		}
+		if order.ReduceOnly != "" {
+			params[ReduceOnly] = order.ReduceOnly
+		}
+		if order.CliOrdID != "" {
+			params[CliOrdID] = order.CliOrdID
+		}
+		return params, nil
+
+	case EditOrder:
+		params := QueryParams{}
+		if order.OrderID != "" {
+			params[EditOrderID] = order.OrderID
+		} else {
+			params[CliOrdID] = order.CliOrdID
+		}
+		if order.Size > 0 {
+			params[Size] = strconv.Itoa(order.Size)
+		}
+		if order.LimitPrice > 0 {
+			params[LimitPrice] = formatPrice(order.LimitPrice)
+		}
+		if order.StopPrice > 0 {
+			params[StopPrice] = formatPrice(order.StopPrice)
+		}
+		return params, nil
 
-	case OpenOrders:
+	case OpenOrders, OpenPositions, CancelAllOrders, Fills, Instruments, Accounts:
 		return QueryParams{}, nil
 
 	case CancelOrder:
+		if order.OrderID == "" && order.CliOrdID != "" {
+			return QueryParams{
+				CliOrdID: order.CliOrdID,
+			}, nil
+		}
 		return QueryParams{
 			OrderID: order.OrderID,
 		}, nil
@@ -1,6 +1,7 @@
 package kraken
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
@@ -37,6 +38,133 @@ func TestQueryByOperation(t *testing.T) {
 	}
 }
 
+func TestQueryByOperationShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\treduce only market order", testID)
+	{
+		params, err := QueryByOperation(domain.CreateMarketOrder(domain.SellOrder, "PI_XBTUSD", 10), CreateOrder)
+		a.NoError(err)
+		a.Equal("mkt", params[OrderType])
+		a.Equal("true", params[ReduceOnly])
+		a.NotContains(params, CliOrdID)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tclient order ID", testID)
+	{
+		order := domain.CreateIocOrder(domain.BuyOrder, "PI_XBTUSD", 100, 10)
+		order.CliOrdID = "PI_XBTUSD-1637866800-buy-1"
+		params, err := QueryByOperation(order, CreateOrder)
+		a.NoError(err)
+		a.Equal(order.CliOrdID, params[CliOrdID])
+
+		params, err = QueryByOperation(domain.Order{CliOrdID: order.CliOrdID}, CancelOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{CliOrdID: order.CliOrdID}, params)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlimit and post-only orders", testID)
+	{
+		params, err := QueryByOperation(domain.CreateLimitOrder(domain.BuyOrder, "PI_XBTUSD", 4571.1, 10, false), CreateOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{
+			OrderType:  "lmt",
+			Symbol:     "PI_XBTUSD",
+			Side:       "buy",
+			Size:       "10",
+			LimitPrice: "4571.1",
+		}, params)
+
+		params, err = QueryByOperation(domain.CreateLimitOrder(domain.SellOrder, "PI_XBTUSD", 4571.1, 10, true), CreateOrder)
+		a.NoError(err)
+		a.Equal("post", params[OrderType])
+		a.Equal("4571.1", params[LimitPrice])
+		a.NotContains(params, StopPrice)
+		a.NotContains(params, ReduceOnly)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop and take profit orders", testID)
+	{
+		params, err := QueryByOperation(domain.CreateStopOrder(domain.SellOrder, "PI_XBTUSD", 4500, 10, domain.MarkPriceTrigger), CreateOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{
+			OrderType:     "stp",
+			Symbol:        "PI_XBTUSD",
+			Side:          "sell",
+			Size:          "10",
+			StopPrice:     "4500",
+			TriggerSignal: "mark",
+			ReduceOnly:    "true",
+		}, params)
+
+		params, err = QueryByOperation(domain.CreateTakeProfitOrder(domain.BuyOrder, "PI_XBTUSD", 4400, 10, domain.LastPriceTrigger), CreateOrder)
+		a.NoError(err)
+		a.Equal("take_profit", params[OrderType])
+		a.Equal("4400", params[StopPrice])
+		a.Equal("last", params[TriggerSignal])
+		a.NotContains(params, LimitPrice)
+
+		order := domain.CreateStopOrder(domain.SellOrder, "PI_XBTUSD", 4500, 10, domain.IndexPriceTrigger)
+		order.LimitPrice = 4490
+		params, err = QueryByOperation(order, CreateOrder)
+		a.NoError(err)
+		a.Equal("4490", params[LimitPrice])
+		a.Equal("index", params[TriggerSignal])
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tmarket and ioc orders have no stop price", testID)
+	{
+		params, err := QueryByOperation(domain.CreateMarketOrder(domain.SellOrder, "PI_XBTUSD", 10), CreateOrder)
+		a.NoError(err)
+		a.NotContains(params, LimitPrice)
+		a.NotContains(params, StopPrice)
+		a.NotContains(params, TriggerSignal)
+
+		params, err = QueryByOperation(domain.CreateIocOrder(domain.SellOrder, "PI_XBTUSD", 4571.1, 10), CreateOrder)
+		a.NoError(err)
+		a.Equal("4571.1", params[LimitPrice])
+		a.NotContains(params, StopPrice)
+
+		params, err = QueryByOperation(domain.CreateIocOrder(domain.SellOrder, "PI_ETHUSD", 2000.05, 10), CreateOrder)
+		a.NoError(err)
+		a.Equal("2000.05", params[LimitPrice])
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tedit order", testID)
+	{
+		params, err := QueryByOperation(domain.Order{OrderID: "42", StopPrice: 4500}, EditOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{EditOrderID: "42", StopPrice: "4500"}, params)
+
+		params, err = QueryByOperation(domain.Order{CliOrdID: "cli", Size: 10, LimitPrice: 4490}, EditOrder)
+		a.NoError(err)
+		a.Equal(QueryParams{CliOrdID: "cli", Size: "10", LimitPrice: "4490"}, params)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcancel all orders and open positions operations", testID)
+	{
+		for _, operation := range []OperationEndpoint{CancelAllOrders, OpenPositions} {
+			params, err := QueryByOperation(domain.Order{}, operation)
+			a.NoError(err)
+			a.Empty(params)
+		}
+
+		method, err := GetMethodByOperation(CancelAllOrders)
+		a.NoError(err)
+		a.Equal(http.MethodPost, method)
+		method, err = GetMethodByOperation(OpenPositions)
+		a.NoError(err)
+		a.Equal(http.MethodGet, method)
+	}
+}
+
 func TestGenerateToken(t *testing.T) {
 	a := assert.New(t)
 
@@ -1,21 +1,60 @@
 package exchange
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/krakensim"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
+const (
+	testPublicKey  = "public"
+	testPrivateKey = "c2VjcmV0"
+	testPair       = "PI_XBTUSD"
+
+	waitFor = 2 * time.Second
+	tick    = 10 * time.Millisecond
+)
+
+func newTestExchange(sim *krakensim.Server, privateKey string) (*KrakenExchange, error) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	return NewKrakenExchange(logger,
+		WithEndpoints(sim.RESTURL(), sim.WSURL()),
+		WithCredentials(testPublicKey, privateKey),
+		WithWSConn(func(c *utils.RetryableWSConn) {
+			c.MaxRetries = 3
+			c.MinBackoff = time.Millisecond
+		}),
+	)
+}
+
 type krakenEnvironment struct {
 	suite.Suite
-	ex *KrakenExchange
+	sim *krakensim.Server
+	ex  *KrakenExchange
 }
 
-func (k *krakenEnvironment) SetupSuite() {
-	logger := &log.Logger{}
-	k.ex, _ = NewKrakenExchange(logger)
+func (k *krakenEnvironment) SetupTest() {
+	k.sim = krakensim.New(testPublicKey, testPrivateKey)
+	var err error
+	k.ex, err = newTestExchange(k.sim, testPrivateKey)
+	k.Require().NoError(err)
+}
+
+func (k *krakenEnvironment) TearDownTest() {
+	_ = k.ex.CloseConnection()
+	k.sim.Close()
 }
 
 func TestNewKrakenExchange(t *testing.T) {
@@ -24,9 +63,91 @@ func TestNewKrakenExchange(t *testing.T) {
 	testID := 0
 	t.Logf("\tTest %d:\tcreate kraken exchange", testID)
 	{
-		logger := &log.Logger{}
-		_, err := NewKrakenExchange(logger)
+		sim := krakensim.New(testPublicKey, testPrivateKey)
+		defer sim.Close()
+		ex, err := newTestExchange(sim, testPrivateKey)
 		a.NoErrorf(err, "Should create without error")
+		a.Eventually(func() bool { return sim.Subscribed(kraken.HeartbeatFeed, "") }, waitFor, tick, "Should subscribe heartbeats")
+		a.True(ex.IsConnected())
+		a.NoError(ex.CloseConnection())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcreate kraken exchange without server", testID)
+	{
+		sim := krakensim.New(testPublicKey, testPrivateKey)
+		sim.Close()
+		_, err := newTestExchange(sim, testPrivateKey)
+		a.Errorf(err, "Should fail after retries")
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	testID := 0
+	t.Logf("\tTest %d:\tfailed resubscription is reported and retried", testID)
+	{
+		conn := &utils.RetryableWSConn{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+		states := conn.States()
+		attempts := 0
+		update := func() error {
+			if attempts++; attempts < 3 {
+				return errors.New("subscribe failed")
+			}
+			return nil
+		}
+		a.True(resubscribe(context.Background(), conn, update, logger))
+		a.Equal(3, attempts)
+		for i := 0; i < 2; i++ {
+			e := <-states
+			a.Equal(utils.StateResubscribing, e.State)
+			a.EqualError(e.Err, "subscribe failed")
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tretries stop when context is done or connection is closed", testID)
+	{
+		conn := &utils.RetryableWSConn{MinBackoff: time.Hour, MaxBackoff: time.Hour}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		a.False(resubscribe(ctx, conn, func() error { return errors.New("subscribe failed") }, logger))
+		a.False(resubscribe(context.Background(), conn, func() error { return utils.ErrClosed }, logger))
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tendpoint costs are taken from budget", testID)
+	{
+		k := &KrakenExchange{limiter: ratelimit.NewTokenBucket(kraken.RateLimitBudget, kraken.RateLimitInterval)}
+		a.NoError(k.acquire(kraken.CreateOrder))
+		a.NoError(k.acquire(kraken.CancelAllOrders))
+		a.InDelta(kraken.RateLimitBudget-35, k.limiter.Available(), 1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trequest is queued while budget is refilled", testID)
+	{
+		k := &KrakenExchange{limiter: ratelimit.NewTokenBucket(10, 100*time.Millisecond)}
+		a.NoError(k.acquire(kraken.CreateOrder))
+		start := time.Now()
+		a.NoError(k.acquire(kraken.CreateOrder))
+		a.GreaterOrEqual(int64(time.Since(start)), int64(50*time.Millisecond), "Request should wait for budget")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trequest is rejected if budget is exhausted for long", testID)
+	{
+		k := &KrakenExchange{limiter: ratelimit.NewTokenBucket(10, time.Minute)}
+		k.limiter.Drain()
+		err := k.acquire(kraken.CreateOrder)
+		a.True(errors.Is(err, kraken.ErrRateLimited))
 	}
 }
 
@@ -37,6 +158,7 @@ func (k *krakenEnvironment) TestSubscribePairs() {
 		err := k.ex.SubscribePairs("TEST_PAIR")
 		k.NoError(err)
 		k.Equal(1, len(k.ex.pairs))
+		k.Eventually(func() bool { return k.sim.Subscribed(kraken.TradesFeed, "TEST_PAIR") }, waitFor, tick)
 	}
 
 	testID++
@@ -53,13 +175,332 @@ func (k *krakenEnvironment) TestSubscribePairs() {
 		err := k.ex.UnsubscribePairs("TEST_PAIR")
 		k.NoError(err)
 		k.Equal(0, len(k.ex.pairs))
+		k.Eventually(func() bool { return !k.sim.Subscribed(kraken.TradesFeed, "TEST_PAIR") }, waitFor, tick)
 	}
 }
 
-func (k *krakenEnvironment) TearDownSuite() {
-	k.ex.CloseConnection()
+func (k *krakenEnvironment) receive(prices <-chan domain.Price) domain.Price {
+	select {
+	case p := <-prices:
+		return p
+	case <-time.After(waitFor):
+		k.FailNow("price not received")
+		return domain.Price{}
+	}
+}
+
+func (k *krakenEnvironment) TestGetPrices() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	prices := k.ex.GetPrices(ctx)
+
+	testID := 0
+	k.T().Logf("\tTest %d:\ttrades of subscribed pair", testID)
+	{
+		k.NoError(k.ex.SubscribePairs(testPair))
+		k.Eventually(func() bool { return k.sim.Subscribed(kraken.TradesFeed, testPair) }, waitFor, tick)
+
+		ts := time.Date(2021, 11, 25, 19, 5, 3, 0, time.UTC)
+		k.sim.PlayPath(testPair, ts, time.Second, 57000, 57010.5)
+		first, second := k.receive(prices), k.receive(prices)
+		k.Equal(57000.0, first.Price)
+		k.Equal(57010.5, second.Price)
+		k.True(ts.Add(time.Second).Equal(time.Time(second.Time)))
+
+		last, ok := k.ex.GetLastPrice()
+		k.True(ok)
+		k.Equal(second, last)
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tresubscribe after disconnect", testID)
+	{
+		k.sim.Disconnect()
+		k.Eventually(func() bool { return k.sim.Subscribed(kraken.TradesFeed, testPair) }, waitFor, tick, "Should resubscribe")
+		k.Eventually(func() bool { return k.sim.Subscribed(kraken.HeartbeatFeed, "") }, waitFor, tick, "Should resubscribe heartbeats")
+
+		k.sim.Trade(testPair, 56000, 1, time.Now())
+		k.Equal(56000.0, k.receive(prices).Price)
+		k.True(k.ex.IsConnected())
+	}
+}
+
+func (k *krakenEnvironment) TestCreateOrder() {
+	k.sim.SetPrice(testPair, 100)
+
+	testID := 0
+	k.T().Logf("\tTest %d:\tioc order executed", testID)
+	{
+		resp, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+		k.NoError(err)
+		k.Equal(kraken.PlacedStatus, resp.Status)
+		k.Equal(domain.ExecutionEvent, resp.OrderEventType)
+		k.NotEmpty(resp.OrderID)
+		k.Equal(10.0, k.sim.Position(testPair))
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tioc order would not execute", testID)
+	{
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 99, 10))
+		k.ErrorIs(err, domain.ErrWouldNotExecute)
+		k.Equal(10.0, k.sim.Position(testPair))
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\topen positions and flatten", testID)
+	{
+		positions, err := k.ex.GetOpenPositions()
+		k.NoError(err)
+		k.Equal([]domain.Position{{Symbol: testPair, Side: domain.LongPosition, Size: 10, Price: 100}}, positions)
+
+		fills, err := k.ex.GetFills(testPair)
+		k.NoError(err)
+		k.Len(fills, 1)
+		k.Equal(10.0, fills[0].Size)
+		fills, err = k.ex.GetFills("PI_ETHUSD")
+		k.NoError(err)
+		k.Empty(fills)
+		fills, err = k.ex.GetFills("")
+		k.NoError(err)
+		k.Len(fills, 1, "Fills of all symbols should be returned for empty symbol")
+
+		k.NoError(k.ex.FlattenPositions())
+		k.Equal(0.0, k.sim.Position(testPair))
+		k.NoError(k.ex.CancelAllOrders())
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tioc order filled partly, the rest is cancelled", testID)
+	{
+		k.sim.SetLiquidity(testPair, 4)
+		resp, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+		k.NoError(err)
+		k.Equal(domain.ExecutionEvent, resp.OrderEventType, "Execution should be reported though the last event is cancel")
+		k.Equal(4, resp.Size, "Executed size should be reported")
+		k.Equal(4.0, resp.FilledSize)
+		k.Equal(4.0, k.sim.Position(testPair))
+		k.sim.SetLiquidity(testPair, 0)
+		k.NoError(k.ex.FlattenPositions())
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\topen orders and cancel", testID)
+	{
+		limit := domain.Order{OrderType: domain.LimitOrder, Symbol: testPair, Side: string(domain.SellOrder), Size: 10, LimitPrice: 120}
+		resp, err := k.ex.CreateOrder(limit)
+		k.NoError(err)
+
+		orders, err := k.ex.GetOpenOrders()
+		k.NoError(err)
+		k.Len(orders, 1)
+		k.Equal(resp.OrderID, orders[0].OrderID)
+		k.Equal(domain.LimitOrder, orders[0].OrderType)
+		k.Equal(10.0, orders[0].UnfilledSize)
+
+		k.NoError(k.ex.CancelOrder(domain.Order{OrderID: resp.OrderID}))
+		k.ErrorIs(k.ex.CancelOrder(domain.Order{OrderID: resp.OrderID}), domain.ErrOrderNotFound)
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tedit stop order", testID)
+	{
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+		k.NoError(err)
+		stop := domain.CreateStopOrder(domain.SellOrder, testPair, 90, 10, domain.MarkPriceTrigger)
+		resp, err := k.ex.CreateOrder(stop)
+		k.NoError(err)
+
+		stop.OrderID, stop.StopPrice = resp.OrderID, 95
+		edited, err := k.ex.EditOrder(stop)
+		k.NoError(err)
+		k.Equal(resp.OrderID, edited.OrderID)
+		orders, err := k.ex.GetOpenOrders()
+		k.NoError(err)
+		k.Len(orders, 1)
+		k.Equal(95.0, orders[0].StopPrice)
+
+		limit, err := k.ex.CreateOrder(domain.CreateLimitOrder(domain.SellOrder, testPair, 120, 10, false))
+		k.NoError(err)
+		_, err = k.ex.EditOrder(domain.Order{OrderID: limit.OrderID, StopPrice: 95})
+		k.ErrorIs(err, domain.ErrInvalidRequest)
+
+		k.NoError(k.ex.CancelAllOrders())
+		_, err = k.ex.EditOrder(stop)
+		k.ErrorIs(err, domain.ErrOrderNotFound)
+		k.NoError(k.ex.FlattenPositions())
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tinjected send status", testID)
+	{
+		k.sim.FailNext(kraken.CreateOrder, "insufficientAvailableFunds", http.StatusOK)
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testPair, 99, 10))
+		k.ErrorIs(err, domain.ErrInsufficientFunds)
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\torders are not retried on server error", testID)
+	{
+		k.sim.FailNext(kraken.CreateOrder, "Server Error", http.StatusInternalServerError)
+		k.sim.FailNext(kraken.CreateOrder, "Unavailable", http.StatusServiceUnavailable)
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testPair, 99, 10))
+		k.ErrorIs(err, domain.ErrExchangeFailure)
+
+		var apiErr *kraken.APIError
+		k.True(errors.As(err, &apiErr))
+		k.Equal("Server Error", apiErr.Code)
+
+		_, err = k.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testPair, 99, 10))
+		k.True(errors.As(err, &apiErr))
+		k.Equal("Unavailable", apiErr.Code, "Each request should consume one fault")
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tlost response of order with client order ID", testID)
+	{
+		k.NoError(k.ex.FlattenPositions())
+		order := domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10)
+		order.CliOrdID = "PI_XBTUSD-1637866800-buy-1"
+		k.sim.DropNextResponse(kraken.CreateOrder)
+		resp, err := k.ex.CreateOrder(order)
+		k.NoError(err)
+		k.Equal(order.CliOrdID, resp.CliOrdID)
+		k.Equal(domain.ExecutionEvent, resp.OrderEventType)
+		k.Equal(10, resp.Size)
+		k.NotEmpty(resp.OrderID)
+		k.Equal(10.0, k.sim.Position(testPair), "Resent order should not be executed twice")
+
+		_, err = k.ex.CreateOrder(order)
+		k.ErrorIs(err, domain.ErrDuplicateOrder, "Order of the same signal should be rejected")
+		k.Equal(10.0, k.sim.Position(testPair))
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tlost response of resting and not executed orders", testID)
+	{
+		limit := domain.Order{OrderType: domain.LimitOrder, Symbol: testPair, Side: string(domain.SellOrder), Size: 10, LimitPrice: 120, CliOrdID: "limit-1"}
+		k.sim.DropNextResponse(kraken.CreateOrder)
+		resp, err := k.ex.CreateOrder(limit)
+		k.NoError(err)
+		k.Empty(resp.OrderEventType)
+		k.NotEmpty(resp.OrderID)
+		k.NoError(k.ex.CancelOrder(domain.Order{CliOrdID: limit.CliOrdID}))
+
+		ioc := domain.CreateIocOrder(domain.BuyOrder, testPair, 99, 10)
+		ioc.CliOrdID = "ioc-1"
+		k.sim.DropNextResponse(kraken.CreateOrder)
+		_, err = k.ex.CreateOrder(ioc)
+		k.ErrorIs(err, domain.ErrNotExecuted)
+		k.Equal(10.0, k.sim.Position(testPair))
+
+		_, err = k.ex.CreateOrder(ioc)
+		k.ErrorIs(err, domain.ErrNotExecuted, "Order of the same signal should be sent again with new client order ID")
+		k.Equal(10.0, k.sim.Position(testPair))
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tlost response of order without client order ID", testID)
+	{
+		k.sim.DropNextResponse(kraken.CreateOrder)
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+		k.Error(err)
+		k.Equal(20.0, k.sim.Position(testPair), "Order should not be resent")
+		k.NoError(k.ex.FlattenPositions())
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\trate limited by server", testID)
+	{
+		k.sim.FailNext(kraken.CreateOrder, kraken.RateLimitError, http.StatusTooManyRequests)
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testPair, 99, 10))
+		k.ErrorIs(err, domain.ErrRateLimited)
+		k.Less(k.ex.limiter.Available(), 1.0, "Budget should be drained")
+	}
+}
+
+func (k *krakenEnvironment) TestInstruments() {
+	k.sim.SetPrice(testPair, 100)
+
+	testID := 0
+	k.T().Logf("\tTest %d:\tinstrument specs are fetched", testID)
+	{
+		instrument, err := k.ex.GetInstrument(testPair)
+		k.NoError(err)
+		k.Equal(domain.Instrument{Symbol: testPair, TickSize: 0.5, ContractSize: 1, Inverse: true, InitialMargin: 0.02, Tradeable: true}, instrument)
+
+		_, err = k.ex.GetInstrument("PI_UNKNOWN")
+		k.ErrorIs(err, domain.ErrInvalidRequest)
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tprices are rounded to tick size", testID)
+	{
+		_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+		k.NoError(err)
+		resp, err := k.ex.CreateOrder(domain.CreateStopOrder(domain.SellOrder, testPair, 95.3, 10, domain.MarkPriceTrigger))
+		k.NoError(err)
+		k.Equal(kraken.PlacedStatus, resp.Status)
+		orders := k.sim.Orders()
+		k.Equal(95.5, orders[len(orders)-1].StopPrice)
+
+		resp, err = k.ex.EditOrder(domain.Order{OrderID: resp.OrderID, Symbol: testPair, StopPrice: 96.1})
+		k.NoError(err)
+		open, err := k.ex.GetOpenOrders()
+		k.NoError(err)
+		k.Equal(96.0, open[0].StopPrice)
+	}
+
+	testID++
+	k.T().Logf("\tTest %d:\tsizes are rounded to size increment and small orders are rejected", testID)
+	{
+		k.sim.SetInstrument(kraken.Instrument{Symbol: "PF_XBTUSD", TickSize: 1, ContractSize: 1, ContractValuePrecision: -1, Tradeable: true})
+		k.sim.SetPrice("PF_XBTUSD", 100)
+		count := len(k.sim.Orders())
+
+		resp, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, "PF_XBTUSD", 101.4, 25))
+		k.NoError(err)
+		k.Equal(20, resp.Size)
+		k.Equal(101.0, resp.LimitPrice)
+
+		_, err = k.ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, "PF_XBTUSD", 101, 5))
+		k.ErrorIs(err, domain.ErrInvalidSize)
+		k.Len(k.sim.Orders(), count+1, "Order below min size should not be sent")
+	}
+}
+
+func (k *krakenEnvironment) TestGetBalance() {
+	testID := 0
+	k.T().Logf("\tTest %d:\tflex account balance", testID)
+	{
+		k.sim.SetAccount(5000, 4000)
+		balance, err := k.ex.GetBalance()
+		k.NoError(err)
+		k.Equal(domain.Balance{Equity: 5000, AvailableMargin: 4000}, balance)
+	}
+}
+
+func (k *krakenEnvironment) TestDelayedAck() {
+	k.sim.SetPrice(testPair, 100)
+	k.sim.SetAckDelay(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err := k.ex.CreateOrder(domain.CreateIocOrder(domain.SellOrder, testPair, 99, 10))
+	k.NoError(err)
+	k.GreaterOrEqual(int64(time.Since(start)), int64(100*time.Millisecond))
+}
+
+func (k *krakenEnvironment) TestAuthentication() {
+	k.sim.SetPrice(testPair, 100)
+	ex, err := newTestExchange(k.sim, "d3Jvbmc=")
+	k.Require().NoError(err)
+	defer ex.CloseConnection()
+
+	_, err = ex.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, testPair, 101, 10))
+	k.ErrorIs(err, domain.ErrAuthentication)
+	k.Empty(k.sim.Orders(), "Order should not be accepted")
 }
 
-func TestDatabase(t *testing.T) {
+func TestKrakenExchange(t *testing.T) {
 	suite.Run(t, new(krakenEnvironment))
 }
@@ -0,0 +1,528 @@
+package krakensim
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+)
+
+const (
+	LimitOrder      = "lmt"
+	PostOnlyOrder   = "post"
+	StopOrder       = "stp"
+	TakeProfitOrder = "take_profit"
+
+	executionEvent = "EXECUTION"
+	placeEvent     = "PLACE"
+	cancelEvent    = "CANCEL"
+
+	tickSize = 0.5
+)
+
+// Order is an order received by the simulator
+type Order struct {
+	ID            string
+	CliOrdID      string
+	Type          string
+	Symbol        string
+	Side          string
+	Size          float64
+	LimitPrice    float64
+	StopPrice     float64
+	TriggerSignal string
+	ReduceOnly    bool
+	Status        string  // send status returned to the client
+	FillPrice     float64 // zero if order is not filled
+	Received      time.Time
+}
+
+type position struct {
+	size  float64 // positive for long
+	price float64 // average entry price
+}
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	operation := kraken.OperationEndpoint(strings.TrimPrefix(r.URL.Path, kraken.Path))
+	if !s.authenticated(r, operation) {
+		writeJSON(w, http.StatusUnauthorized, errorResponse("authenticationError"))
+		return
+	}
+
+	s.mu.Lock()
+	delay := s.ackDelay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	if fault, ok := s.popFault(operation); ok {
+		if operation == kraken.CreateOrder && fault.HTTPStatus == http.StatusOK {
+			writeJSON(w, http.StatusOK, successResponse(kraken.ReceiveOrder{
+				SendStatus: kraken.SendStatus{Status: fault.Code, ReceivedTime: serverTime()},
+			}))
+			return
+		}
+		writeJSON(w, fault.HTTPStatus, errorResponse(fault.Code))
+		return
+	}
+
+	query := r.URL.Query()
+	switch operation {
+	case kraken.CreateOrder:
+		resp := s.sendOrder(query)
+		if s.popDrop(operation) {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	case kraken.OpenOrders:
+		writeJSON(w, http.StatusOK, s.openOrders())
+	case kraken.Fills:
+		writeJSON(w, http.StatusOK, s.getFills())
+	case kraken.EditOrder:
+		writeJSON(w, http.StatusOK, s.editOrder(query))
+	case kraken.CancelOrder:
+		writeJSON(w, http.StatusOK, s.cancelOrder(query.Get(kraken.OrderID), query.Get(kraken.CliOrdID)))
+	case kraken.CancelAllOrders:
+		writeJSON(w, http.StatusOK, s.cancelAllOrders())
+	case kraken.OpenPositions:
+		writeJSON(w, http.StatusOK, s.openPositions())
+	case kraken.Instruments:
+		writeJSON(w, http.StatusOK, s.getInstruments())
+	case kraken.Accounts:
+		writeJSON(w, http.StatusOK, s.getAccounts())
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse("notFound"))
+	}
+}
+
+// authenticated verifies APIKey and Authent headers the same way as Kraken does
+func (s *Server) authenticated(r *http.Request, operation kraken.OperationEndpoint) bool {
+	if r.Header.Get(kraken.APIKey) != s.publicKey {
+		return false
+	}
+	token, err := kraken.GenerateToken(s.privateKey, string(operation), r.URL.RawQuery)
+	if err != nil {
+		return false
+	}
+	return r.Header.Get(kraken.Authent) == token
+}
+
+func (s *Server) sendOrder(query map[string][]string) kraken.ReceiveOrder {
+	get := func(key string) string {
+		if v := query[key]; len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+	parse := func(key string) float64 {
+		f, _ := strconv.ParseFloat(get(key), 64)
+		return f
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id := get(kraken.CliOrdID); id != "" {
+		for _, o := range s.orders {
+			if o.CliOrdID == id {
+				return successResponse(kraken.ReceiveOrder{SendStatus: kraken.SendStatus{
+					Status:       "clientOrderIdAlreadyExist",
+					ReceivedTime: serverTime(),
+				}})
+			}
+		}
+	}
+
+	s.seq++
+	o := Order{
+		ID:            fmt.Sprintf("sim-%d", s.seq),
+		CliOrdID:      get(kraken.CliOrdID),
+		Type:          get(kraken.OrderType),
+		Symbol:        get(kraken.Symbol),
+		Side:          get(kraken.Side),
+		Size:          parse(kraken.Size),
+		LimitPrice:    parse(kraken.LimitPrice),
+		StopPrice:     parse(kraken.StopPrice),
+		TriggerSignal: get(kraken.TriggerSignal),
+		ReduceOnly:    get(kraken.ReduceOnly) == "true",
+		Received:      time.Now(),
+	}
+
+	events := s.execute(&o)
+	s.orders = append(s.orders, o)
+
+	status := kraken.SendStatus{
+		Status:       o.Status,
+		OrderID:      o.ID,
+		ReceivedTime: o.Received.UTC().Format(time.RFC3339),
+		OrderEvents:  events,
+	}
+	return successResponse(kraken.ReceiveOrder{SendStatus: status})
+}
+
+// execute sets order status and fills or rests it, returns order events. Should be called with locked mutex.
+func (s *Server) execute(o *Order) []kraken.OrderEvents {
+	price, ok := s.prices[o.Symbol]
+	switch {
+	case !ok:
+		o.Status = "marketInactive"
+		return nil
+	case o.Side != string(domain.BuyOrder) && o.Side != string(domain.SellOrder):
+		o.Status = "invalidSide"
+		return nil
+	case o.Size <= 0:
+		o.Status = "invalidSize"
+		return nil
+	case !s.onTick(o.Symbol, o.LimitPrice) || !s.onTick(o.Symbol, o.StopPrice):
+		o.Status = "invalidPrice"
+		return nil
+	}
+
+	if o.ReduceOnly {
+		pos := s.positionSize(o.Symbol)
+		if (o.Side == string(domain.BuyOrder) && pos >= 0) || (o.Side == string(domain.SellOrder) && pos <= 0) {
+			o.Status = "wouldNotReducePosition"
+			return nil
+		}
+		o.Size = math.Min(o.Size, math.Abs(pos))
+	}
+
+	crosses := (o.Side == string(domain.BuyOrder) && o.LimitPrice >= price) ||
+		(o.Side == string(domain.SellOrder) && o.LimitPrice <= price)
+
+	switch o.Type {
+	case domain.MarketOrder:
+		return s.take(o, price)
+	case domain.IocOrder:
+		if !crosses {
+			o.Status = "iocWouldNotExecute"
+			return nil
+		}
+		return s.take(o, price)
+	case LimitOrder:
+		if !crosses {
+			return s.rest(o)
+		}
+		s.fill(o, price, o.Size)
+		return []kraken.OrderEvents{{Type: executionEvent, Price: price, Amount: o.Size}}
+	case PostOnlyOrder:
+		if crosses {
+			o.Status = "postWouldExecute"
+			return nil
+		}
+		return s.rest(o)
+	case StopOrder, TakeProfitOrder:
+		if o.StopPrice <= 0 {
+			o.Status = "invalidPrice"
+			return nil
+		}
+		return s.rest(o)
+	default:
+		o.Status = "invalidOrderType"
+		return nil
+	}
+}
+
+// take fills market or ioc order up to the liquidity of the pair, the rest is cancelled.
+// Should be called with locked mutex.
+func (s *Server) take(o *Order, price float64) []kraken.OrderEvents {
+	size := o.Size
+	if liquidity, ok := s.liquidity[o.Symbol]; ok && liquidity < size {
+		size = liquidity
+	}
+	s.fill(o, price, size)
+	events := []kraken.OrderEvents{{Type: executionEvent, Price: price, Amount: size}}
+	if size < o.Size {
+		events = append(events, kraken.OrderEvents{Type: cancelEvent})
+	}
+	return events
+}
+
+// onTick reports if the price is a multiple of the instrument tick size. Should be called with locked mutex.
+func (s *Server) onTick(symbol string, price float64) bool {
+	instrument, ok := s.instruments[symbol]
+	if !ok || instrument.TickSize == 0 {
+		return true
+	}
+	ticks := price / instrument.TickSize
+	return math.Abs(ticks-math.Round(ticks)) < 1e-9
+}
+
+func (s *Server) rest(o *Order) []kraken.OrderEvents {
+	o.Status = kraken.PlacedStatus
+	resting := *o
+	s.open[o.ID] = &resting
+	return []kraken.OrderEvents{{Type: placeEvent}}
+}
+
+// fill executes size of the order at price and updates position. Should be called with locked mutex.
+func (s *Server) fill(o *Order, price, size float64) {
+	o.Status = kraken.PlacedStatus
+	o.FillPrice = price
+	s.fills = append(s.fills, kraken.Fill{
+		FillID:   fmt.Sprintf("fill-%d", len(s.fills)+1),
+		Symbol:   o.Symbol,
+		Side:     o.Side,
+		OrderID:  o.ID,
+		CliOrdID: o.CliOrdID,
+		Size:     size,
+		Price:    price,
+		FillTime: serverTime(),
+		FillType: "taker",
+	})
+
+	delta := size
+	if o.Side == string(domain.SellOrder) {
+		delta = -delta
+	}
+
+	p, ok := s.positions[o.Symbol]
+	if !ok {
+		p = &position{}
+		s.positions[o.Symbol] = p
+	}
+	next := p.size + delta
+	switch {
+	case p.size == 0 || next*p.size < 0:
+		// opened or flipped
+		p.price = price
+	case math.Abs(next) > math.Abs(p.size):
+		p.price = (p.price*math.Abs(p.size) + price*math.Abs(delta)) / math.Abs(next)
+	}
+	p.size = next
+	if p.size == 0 {
+		delete(s.positions, o.Symbol)
+	}
+
+	for i := range s.orders {
+		if s.orders[i].ID == o.ID {
+			s.orders[i] = *o
+		}
+	}
+}
+
+func (s *Server) positionSize(pair string) float64 {
+	if p, ok := s.positions[pair]; ok {
+		return p.size
+	}
+	return 0
+}
+
+// matchResting fills resting orders triggered by the price. Should be called with locked mutex.
+func (s *Server) matchResting(pair string, price float64) {
+	ids := make([]string, 0, len(s.open))
+	for id := range s.open {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		o := s.open[id]
+		if o.Symbol != pair {
+			continue
+		}
+
+		buy := o.Side == string(domain.BuyOrder)
+		var triggered bool
+		switch o.Type {
+		case LimitOrder, PostOnlyOrder:
+			triggered = (buy && price <= o.LimitPrice) || (!buy && price >= o.LimitPrice)
+		case StopOrder:
+			triggered = (buy && price >= o.StopPrice) || (!buy && price <= o.StopPrice)
+		case TakeProfitOrder:
+			triggered = (buy && price <= o.StopPrice) || (!buy && price >= o.StopPrice)
+		}
+		if !triggered {
+			continue
+		}
+
+		delete(s.open, id)
+		if o.ReduceOnly {
+			pos := s.positionSize(pair)
+			if (buy && pos >= 0) || (!buy && pos <= 0) {
+				continue
+			}
+			o.Size = math.Min(o.Size, math.Abs(pos))
+		}
+		s.fill(o, price, o.Size)
+	}
+}
+
+func (s *Server) openOrders() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make([]kraken.OpenOrder, 0, len(s.open))
+	for _, o := range s.open {
+		orders = append(orders, kraken.OpenOrder{
+			OrderID:      o.ID,
+			CliOrdID:     o.CliOrdID,
+			Symbol:       o.Symbol,
+			Side:         o.Side,
+			OrderType:    o.Type,
+			LimitPrice:   o.LimitPrice,
+			StopPrice:    o.StopPrice,
+			UnfilledSize: o.Size,
+			ReceivedTime: o.Received.UTC().Format(time.RFC3339),
+			Status:       "untouched",
+		})
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID < orders[j].OrderID })
+	return successResponse(kraken.ReceiveOrder{OpenOrders: orders})
+}
+
+func (s *Server) getFills() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return successResponse(kraken.ReceiveOrder{Fills: append([]kraken.Fill(nil), s.fills...)})
+}
+
+// editOrder changes size and prices of resting order found by ID or client order ID, stop price can be changed
+// for stop and take profit orders only
+func (s *Server) editOrder(query map[string][]string) kraken.ReceiveOrder {
+	get := func(key string) string {
+		if v := query[key]; len(v) != 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := kraken.EditStatus{Status: "orderForEditNotFound", ReceivedTime: serverTime()}
+	for _, o := range s.open {
+		if o.ID != get(kraken.EditOrderID) && (o.CliOrdID == "" || o.CliOrdID != get(kraken.CliOrdID)) {
+			continue
+		}
+		stopPrice, err := strconv.ParseFloat(get(kraken.StopPrice), 64)
+		if err == nil && o.Type != StopOrder && o.Type != TakeProfitOrder {
+			status.Status = "orderForEditNotAStop"
+			break
+		}
+		if err == nil {
+			o.StopPrice = stopPrice
+		}
+		if size, err := strconv.ParseFloat(get(kraken.Size), 64); err == nil {
+			o.Size = size
+		}
+		if price, err := strconv.ParseFloat(get(kraken.LimitPrice), 64); err == nil {
+			o.LimitPrice = price
+		}
+		status.Status, status.OrderID = kraken.EditedStatus, o.ID
+		status.OrderEvents = []kraken.OrderEvents{{Type: "EDIT"}}
+		break
+	}
+	return successResponse(kraken.ReceiveOrder{EditStatus: status})
+}
+
+// cancelOrder cancels resting order by ID or client order ID
+func (s *Server) cancelOrder(id, cliOrdID string) kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == "" && cliOrdID != "" {
+		for _, o := range s.open {
+			if o.CliOrdID == cliOrdID {
+				id = o.ID
+			}
+		}
+	}
+	status := "notFound"
+	if _, ok := s.open[id]; ok {
+		delete(s.open, id)
+		status = kraken.CancelledStatus
+	}
+	return successResponse(kraken.ReceiveOrder{CancelStatus: kraken.CancelStatus{
+		Status:       status,
+		OrderID:      id,
+		ReceivedTime: serverTime(),
+	}})
+}
+
+func (s *Server) cancelAllOrders() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := "noOrdersToCancel"
+	if len(s.open) != 0 {
+		status = kraken.CancelledStatus
+	}
+	s.open = make(map[string]*Order)
+	return successResponse(kraken.ReceiveOrder{CancelStatus: kraken.CancelStatus{
+		Status:       status,
+		ReceivedTime: serverTime(),
+	}})
+}
+
+func (s *Server) openPositions() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make([]kraken.OpenPosition, 0, len(s.positions))
+	for pair, p := range s.positions {
+		side := domain.LongPosition
+		if p.size < 0 {
+			side = domain.ShortPosition
+		}
+		positions = append(positions, kraken.OpenPosition{
+			Side:     side,
+			Symbol:   pair,
+			Price:    p.price,
+			FillTime: serverTime(),
+			Size:     math.Abs(p.size),
+		})
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Symbol < positions[j].Symbol })
+	return successResponse(kraken.ReceiveOrder{OpenPositions: positions})
+}
+
+func (s *Server) getInstruments() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instruments := make([]kraken.Instrument, 0, len(s.instruments))
+	for _, i := range s.instruments {
+		instruments = append(instruments, i)
+	}
+	sort.Slice(instruments, func(i, j int) bool { return instruments[i].Symbol < instruments[j].Symbol })
+	return successResponse(kraken.ReceiveOrder{Instruments: instruments})
+}
+
+func (s *Server) getAccounts() kraken.ReceiveOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return successResponse(kraken.ReceiveOrder{Accounts: map[string]kraken.Account{kraken.FlexAccount: s.account}})
+}
+
+func serverTime() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func successResponse(ro kraken.ReceiveOrder) kraken.ReceiveOrder {
+	ro.Result = kraken.SuccessResult
+	ro.ServerTime = serverTime()
+	return ro
+}
+
+func errorResponse(code string) kraken.ReceiveOrder {
+	return kraken.ReceiveOrder{
+		Result:     kraken.ErrorResult,
+		Error:      code,
+		ServerTime: serverTime(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
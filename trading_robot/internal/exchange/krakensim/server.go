@@ -0,0 +1,232 @@
+// Package krakensim is a local Kraken Futures simulator for offline tests. It serves enough of REST
+// (sendorder, editorder, openorders, fills, cancelorder, cancelallorders, openpositions, instruments, accounts) and WebSocket
+// (subscribe, trade, ticker, heartbeat) protocols and verifies Authent signatures. Prices are scripted with
+// Trade and PlayPath, faults are injected with Disconnect, SetAckDelay, FailNext and DropNextResponse.
+package krakensim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+)
+
+// marginLevels are margins of default instruments, positions need 2% of their value
+var marginLevels = []kraken.MarginLevel{{InitialMargin: 0.02, MaintenanceMargin: 0.01}}
+
+// Fault is an injected error response
+type Fault struct {
+	Code       string // Kraken error code, or send status for sendorder with 200 HTTP status
+	HTTPStatus int
+}
+
+type Server struct {
+	publicKey  string
+	privateKey string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu          sync.Mutex
+	seq         int
+	prices      map[string]float64
+	orders      []Order           // all orders received by sendorder
+	open        map[string]*Order // resting orders by ID
+	fills       []kraken.Fill
+	positions   map[string]*position
+	instruments map[string]kraken.Instrument
+	account     kraken.Account     // flex account, it is not changed by fills
+	liquidity   map[string]float64 // size taken at once by market and ioc orders, unlimited if missing
+	faults      map[kraken.OperationEndpoint][]Fault
+	drops       map[kraken.OperationEndpoint]int // requests executed without response
+	ackDelay    time.Duration
+
+	clientsMu sync.Mutex
+	clients   map[*client]bool
+}
+
+// New starts simulator accepting requests signed with the given keys, private key must be base64 encoded
+func New(publicKey, privateKey string) *Server {
+	s := &Server{
+		publicKey:  publicKey,
+		privateKey: privateKey,
+		prices:     make(map[string]float64),
+		open:       make(map[string]*Order),
+		positions:  make(map[string]*position),
+		liquidity:  make(map[string]float64),
+		instruments: map[string]kraken.Instrument{
+			"PI_XBTUSD": {Symbol: "PI_XBTUSD", Type: kraken.InverseType, TickSize: tickSize, ContractSize: 1, Tradeable: true, MarginLevels: marginLevels},
+			"PI_ETHUSD": {Symbol: "PI_ETHUSD", Type: kraken.InverseType, TickSize: 0.05, ContractSize: 1, Tradeable: true, MarginLevels: marginLevels},
+		},
+		account: kraken.Account{Type: "multiCollateralMarginAccount", PortfolioValue: 10000, AvailableMargin: 10000},
+		faults:  make(map[kraken.OperationEndpoint][]Fault),
+		drops:   make(map[kraken.OperationEndpoint]int),
+		clients: make(map[*client]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(kraken.WsPath, s.serveWS)
+	mux.HandleFunc(kraken.Path+"/", s.serveREST)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) Close() {
+	s.Disconnect()
+	s.srv.Close()
+}
+
+// RESTURL returns URL of REST API root, path is added by client
+func (s *Server) RESTURL() url.URL {
+	u, _ := url.Parse(s.srv.URL)
+	return *u
+}
+
+// WSURL returns URL of WebSocket API
+func (s *Server) WSURL() url.URL {
+	u, _ := url.Parse(s.srv.URL)
+	u.Scheme = "ws"
+	u.Path = kraken.WsPath
+	return *u
+}
+
+// SetAckDelay delays every REST response
+func (s *Server) SetAckDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackDelay = d
+}
+
+// FailNext makes the next request to the endpoint fail with the fault, faults are queued
+func (s *Server) FailNext(operation kraken.OperationEndpoint, code string, httpStatus int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[operation] = append(s.faults[operation], Fault{Code: code, HTTPStatus: httpStatus})
+}
+
+// DropNextResponse makes the next request to the endpoint executed, but the connection is closed
+// without response, the way it happens on network failures
+func (s *Server) DropNextResponse(operation kraken.OperationEndpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drops[operation]++
+}
+
+func (s *Server) popDrop(operation kraken.OperationEndpoint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drops[operation] == 0 {
+		return false
+	}
+	s.drops[operation]--
+	return true
+}
+
+func (s *Server) popFault(operation kraken.OperationEndpoint) (Fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	faults := s.faults[operation]
+	if len(faults) == 0 {
+		return Fault{}, false
+	}
+	s.faults[operation] = faults[1:]
+	return faults[0], true
+}
+
+// SetAccount sets portfolio value and available margin of the flex account
+func (s *Server) SetAccount(portfolioValue, availableMargin float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account.PortfolioValue, s.account.AvailableMargin = portfolioValue, availableMargin
+}
+
+// SetInstrument adds or replaces the instrument specification, orders off its tick size are rejected
+func (s *Server) SetInstrument(instrument kraken.Instrument) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instruments[instrument.Symbol] = instrument
+}
+
+// SetLiquidity limits the size of market and ioc orders of the pair filled at once, the rest of them is cancelled.
+// Zero size removes the limit.
+func (s *Server) SetLiquidity(pair string, size float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size <= 0 {
+		delete(s.liquidity, pair)
+		return
+	}
+	s.liquidity[pair] = size
+}
+
+// SetPrice sets the market price of the pair without sending trades
+func (s *Server) SetPrice(pair string, price float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prices[pair] = price
+}
+
+// Trade sets the market price, fills triggered resting orders and sends trade and ticker messages to subscribers
+func (s *Server) Trade(pair string, price, qty float64, ts time.Time) {
+	s.mu.Lock()
+	s.prices[pair] = price
+	s.matchResting(pair, price)
+	s.mu.Unlock()
+
+	s.broadcast(kraken.TradesFeed, pair, tradeMessage{
+		Feed:      string(kraken.TradesFeed),
+		ProductID: pair,
+		Side:      "buy",
+		Type:      "fill",
+		Time:      ts.UnixMilli(),
+		Qty:       qty,
+		Price:     price,
+	})
+	s.broadcast(kraken.TickerFeed, pair, tickerMessage{
+		Feed:      string(kraken.TickerFeed),
+		ProductID: pair,
+		Pair:      pair,
+		Time:      ts.UnixMilli(),
+		Bid:       price - tickSize,
+		Ask:       price + tickSize,
+		BidSize:   qty,
+		AskSize:   qty,
+		Last:      price,
+	})
+}
+
+// PlayPath sends trades with the given prices, trade times start at start and are increased by step
+func (s *Server) PlayPath(pair string, start time.Time, step time.Duration, prices ...float64) {
+	for i, price := range prices {
+		s.Trade(pair, price, 1, start.Add(time.Duration(i)*step))
+	}
+}
+
+// Heartbeat sends heartbeat message to subscribers
+func (s *Server) Heartbeat() {
+	s.broadcast(kraken.HeartbeatFeed, "", heartbeatMessage{
+		Feed: string(kraken.HeartbeatFeed),
+		Time: time.Now().UnixMilli(),
+	})
+}
+
+// Orders returns all orders received by sendorder
+func (s *Server) Orders() []Order {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Order(nil), s.orders...)
+}
+
+// Position returns net position of the pair, positive for long
+func (s *Server) Position(pair string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.positions[pair]; ok {
+		return p.size
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+package krakensim
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange/kraken"
+)
+
+type subscription struct {
+	feed kraken.Feed
+	pair string // empty for feeds without products
+}
+
+type client struct {
+	conn *websocket.Conn
+
+	mu   sync.Mutex // mutex for protecting subs and serializing writes
+	subs map[subscription]bool
+}
+
+func (c *client) write(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+type request struct {
+	Event      kraken.Event `json:"event"`
+	Feed       kraken.Feed  `json:"feed"`
+	ProductIDs []string     `json:"product_ids,omitempty"`
+}
+
+type eventMessage struct {
+	Event      string   `json:"event"`
+	Feed       string   `json:"feed,omitempty"`
+	ProductIDs []string `json:"product_ids,omitempty"`
+	Version    int      `json:"version,omitempty"`
+	Message    string   `json:"message,omitempty"`
+}
+
+type tradeMessage struct {
+	Feed      string  `json:"feed"`
+	ProductID string  `json:"product_id"`
+	Side      string  `json:"side"`
+	Type      string  `json:"type"`
+	Time      int64   `json:"time"`
+	Qty       float64 `json:"qty"`
+	Price     float64 `json:"price"`
+}
+
+type tickerMessage struct {
+	Feed      string  `json:"feed"`
+	ProductID string  `json:"product_id"`
+	Pair      string  `json:"pair"`
+	Time      int64   `json:"time"`
+	Bid       float64 `json:"bid"`
+	Ask       float64 `json:"ask"`
+	BidSize   float64 `json:"bid_size"`
+	AskSize   float64 `json:"ask_size"`
+	Last      float64 `json:"last"`
+}
+
+type heartbeatMessage struct {
+	Feed string `json:"feed"`
+	Time int64  `json:"time"`
+}
+
+func (s *Server) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &client{conn: conn, subs: make(map[subscription]bool)}
+	s.clientsMu.Lock()
+	s.clients[c] = true
+	s.clientsMu.Unlock()
+	defer func() {
+		s.clientsMu.Lock()
+		delete(s.clients, c)
+		s.clientsMu.Unlock()
+		_ = conn.Close()
+	}()
+
+	if err = c.write(eventMessage{Event: "info", Version: 1}); err != nil {
+		return
+	}
+
+	for {
+		var req request
+		if err = conn.ReadJSON(&req); err != nil {
+			return
+		}
+		if err = c.write(c.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (c *client) handle(req request) eventMessage {
+	var subscribe bool
+	switch req.Event {
+	case kraken.SubscribeEvent:
+		subscribe = true
+	case kraken.UnsubscribeEvent:
+	default:
+		return eventMessage{Event: "error", Message: "Invalid event"}
+	}
+
+	switch req.Feed {
+	case kraken.TradesFeed, kraken.TickerFeed:
+		if len(req.ProductIDs) == 0 {
+			return eventMessage{Event: "error", Message: "Invalid product id"}
+		}
+	case kraken.HeartbeatFeed:
+	default:
+		return eventMessage{Event: "error", Message: "Invalid feed"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pairs := req.ProductIDs
+	if len(pairs) == 0 {
+		pairs = []string{""}
+	}
+	for _, pair := range pairs {
+		if subscribe {
+			c.subs[subscription{feed: req.Feed, pair: pair}] = true
+		} else {
+			delete(c.subs, subscription{feed: req.Feed, pair: pair})
+		}
+	}
+
+	event := "subscribed"
+	if !subscribe {
+		event = "unsubscribed"
+	}
+	return eventMessage{Event: event, Feed: string(req.Feed), ProductIDs: req.ProductIDs}
+}
+
+func (c *client) subscribed(sub subscription) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subs[sub]
+}
+
+func (s *Server) broadcast(feed kraken.Feed, pair string, msg interface{}) {
+	sub := subscription{feed: feed, pair: pair}
+	for _, c := range s.connectedClients() {
+		if c.subscribed(sub) {
+			_ = c.write(msg)
+		}
+	}
+}
+
+func (s *Server) connectedClients() []*client {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Subscribed reports whether any connected client is subscribed to the feed of the pair
+func (s *Server) Subscribed(feed kraken.Feed, pair string) bool {
+	for _, c := range s.connectedClients() {
+		if c.subscribed(subscription{feed: feed, pair: pair}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Connections returns number of connected WebSocket clients
+func (s *Server) Connections() int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return len(s.clients)
+}
+
+// Disconnect drops all WebSocket connections abnormally, clients have to reconnect and resubscribe
+func (s *Server) Disconnect() {
+	for _, c := range s.connectedClients() {
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,65 @@
+package exchange
+
+import (
+	"net/url"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/utils"
+)
+
+// options are settings shared by adapters, by default they connect to the venue demo or testnet
+// with keys from config
+type options struct {
+	restURL     url.URL
+	wsURL       url.URL
+	publicKey   string
+	privateKey  string
+	configureWS func(c *utils.RetryableWSConn)
+	journal     Journal
+}
+
+// Option configures exchange adapter
+type Option func(o *options)
+
+func newOptions(restURL, wsURL url.URL, opts []Option) options {
+	o := options{
+		restURL:    restURL,
+		wsURL:      wsURL,
+		publicKey:  config.GetPublicKey(),
+		privateKey: config.GetPrivateKey(),
+		journal:    discardJournal{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithEndpoints sets REST API root and WebSocket URLs, e.g. of the simulator
+func WithEndpoints(rest, ws url.URL) Option {
+	return func(o *options) {
+		o.restURL = rest
+		o.wsURL = ws
+	}
+}
+
+func WithCredentials(publicKey, privateKey string) Option {
+	return func(o *options) {
+		o.publicKey = publicKey
+		o.privateKey = privateKey
+	}
+}
+
+// WithWSConn changes WebSocket connection settings before dial, e.g. retries and timeouts
+func WithWSConn(configure func(c *utils.RetryableWSConn)) Option {
+	return func(o *options) {
+		o.configureWS = configure
+	}
+}
+
+// WithJournal makes adapter record raw WebSocket messages and REST requests changing orders with their responses
+func WithJournal(j Journal) Option {
+	return func(o *options) {
+		o.journal = j
+	}
+}
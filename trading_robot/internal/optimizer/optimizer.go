@@ -0,0 +1,294 @@
+// Package optimizer searches strategy parameters by backtests of recorded trades. Candidates of a grid or random
+// search are backtested in parallel and ranked by the objective. With walk-forward the trades are split into
+// consecutive windows of a train and a test part: candidates are ranked by their train results only, and the best
+// candidate of every window train part is reported with its results on the test part, which the ranking has not seen.
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/replay"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// Objectives of the ranking
+const (
+	PnLObjective      = "pnl"
+	SharpeObjective   = "sharpe"
+	DrawdownObjective = "drawdown" // the lowest max drawdown is the best, so candidates not trading rank first
+)
+
+// DefaultTrainFraction is the train part of walk-forward windows
+const DefaultTrainFraction = 0.7
+
+var (
+	ErrNoTrades       = errors.New("no trades to backtest")
+	ErrInvalidSetting = errors.New("invalid optimizer setting")
+)
+
+// Settings set the ranking objective and walk-forward windows. Without folds all trades are the train part
+// and nothing is tested. Train fraction is the train part of every window, DefaultTrainFraction if zero.
+// Workers is the number of parallel backtests, the number of CPUs if zero.
+type Settings struct {
+	Objective     string
+	Folds         int
+	TrainFraction float64
+	Workers       int
+}
+
+// Evaluation is the result of a candidate, train and test results are combined over windows
+type Evaluation struct {
+	Rank   int             `json:"rank"`
+	Params Params          `json:"params"`
+	Score  float64         `json:"score"` // objective of train results
+	Train  replay.Metrics  `json:"train"`
+	Test   *replay.Metrics `json:"test,omitempty"`
+}
+
+// Fold is a walk-forward window with the best candidate of its train part
+type Fold struct {
+	Fold       int            `json:"fold"`
+	TrainStart time.Time      `json:"train_start"`
+	TestStart  time.Time      `json:"test_start"`
+	TestEnd    time.Time      `json:"test_end"`
+	Best       Params         `json:"best"`
+	Train      replay.Metrics `json:"train"`
+	Test       replay.Metrics `json:"test"`
+}
+
+// Report holds evaluations ranked by score, the best first. With walk-forward it holds folds and combined test
+// results of their best candidates, the estimate of results on unseen data.
+type Report struct {
+	Objective   string          `json:"objective"`
+	Evaluations []Evaluation    `json:"evaluations"`
+	Folds       []Fold          `json:"folds,omitempty"`
+	WalkForward *replay.Metrics `json:"walk_forward,omitempty"`
+}
+
+// window is a walk-forward window of trades, test part is empty without walk-forward
+type window struct {
+	train []domain.Price
+	test  []domain.Price
+}
+
+type job struct {
+	candidate int
+	window    int
+	test      bool
+}
+
+// Optimize backtests the candidates on trades ordered by time with trading settings of cfg and ranks them
+func Optimize(trades []domain.Price, candidates []Params, cfg config.Config, s Settings, logger *log.Logger) (Report, error) {
+	if err := s.validate(); err != nil {
+		return Report{}, err
+	}
+	if len(trades) == 0 {
+		return Report{}, ErrNoTrades
+	}
+	if len(candidates) == 0 {
+		return Report{}, fmt.Errorf("%w: no candidates", ErrInvalidSetting)
+	}
+	if s.TrainFraction == 0 {
+		s.TrainFraction = DefaultTrainFraction
+	}
+	if s.Workers == 0 {
+		s.Workers = runtime.NumCPU()
+	}
+
+	windows := split(trades, s.Folds, s.TrainFraction)
+	train := make([][]replay.Metrics, len(candidates))
+	test := make([][]replay.Metrics, len(candidates))
+	jobs := make([]job, 0, 2*len(candidates)*len(windows))
+	for c := range candidates {
+		train[c] = make([]replay.Metrics, len(windows))
+		test[c] = make([]replay.Metrics, len(windows))
+		for w := range windows {
+			jobs = append(jobs, job{candidate: c, window: w})
+			if s.Folds > 0 {
+				jobs = append(jobs, job{candidate: c, window: w, test: true})
+			}
+		}
+	}
+
+	err := run(jobs, s.Workers, func(j job) error {
+		trades, results := windows[j.window].train, train[j.candidate]
+		if j.test {
+			trades, results = windows[j.window].test, test[j.candidate]
+		}
+		m, err := replay.Backtest(trades, candidates[j.candidate].NewStrategy(), cfg, logger)
+		if err != nil {
+			return fmt.Errorf("backtest %s: %w", candidates[j.candidate], err)
+		}
+		results[j.window] = m
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	report := Report{Objective: s.Objective}
+	for c, p := range candidates {
+		e := Evaluation{Params: p, Train: combine(train[c])}
+		e.Score = score(s.Objective, e.Train)
+		if s.Folds > 0 {
+			m := combine(test[c])
+			e.Test = &m
+		}
+		report.Evaluations = append(report.Evaluations, e)
+	}
+	sort.SliceStable(report.Evaluations, func(i, j int) bool {
+		return report.Evaluations[i].Score > report.Evaluations[j].Score
+	})
+	for i := range report.Evaluations {
+		report.Evaluations[i].Rank = i + 1
+	}
+
+	if s.Folds == 0 {
+		return report, nil
+	}
+	tested := make([]replay.Metrics, 0, len(windows))
+	for w, win := range windows {
+		best := 0
+		for c := range candidates {
+			if score(s.Objective, train[c][w]) > score(s.Objective, train[best][w]) {
+				best = c
+			}
+		}
+		fold := Fold{
+			Fold:  w + 1,
+			Best:  candidates[best],
+			Train: train[best][w],
+			Test:  test[best][w],
+		}
+		if len(win.train) > 0 {
+			fold.TrainStart = time.Time(win.train[0].Time)
+		}
+		if len(win.test) > 0 {
+			fold.TestStart = time.Time(win.test[0].Time)
+			fold.TestEnd = time.Time(win.test[len(win.test)-1].Time)
+		}
+		report.Folds = append(report.Folds, fold)
+		tested = append(tested, fold.Test)
+	}
+	walkForward := combine(tested)
+	report.WalkForward = &walkForward
+	return report, nil
+}
+
+func (s Settings) validate() error {
+	switch s.Objective {
+	case PnLObjective, SharpeObjective, DrawdownObjective:
+	default:
+		return fmt.Errorf("%w: unknown objective %q", ErrInvalidSetting, s.Objective)
+	}
+	if s.Folds < 0 || s.Workers < 0 {
+		return fmt.Errorf("%w: negative folds or workers", ErrInvalidSetting)
+	}
+	if s.TrainFraction < 0 || s.TrainFraction >= 1 {
+		return fmt.Errorf("%w: train fraction %g is not below 1", ErrInvalidSetting, s.TrainFraction)
+	}
+	return nil
+}
+
+// run runs jobs in parallel workers, jobs left after an error are skipped and the first error is returned
+func run(jobs []job, workers int, do func(j job) error) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		queue    = make(chan job)
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+				if err := do(j); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
+
+// split splits trades into windows of equal time, without folds all trades are the train part of a single window
+func split(trades []domain.Price, folds int, trainFraction float64) []window {
+	if folds == 0 {
+		return []window{{train: trades}}
+	}
+	start := time.Time(trades[0].Time)
+	length := time.Time(trades[len(trades)-1].Time).Sub(start) / time.Duration(folds)
+	// index returns index of the first trade at or after ts
+	index := func(ts time.Time) int {
+		return sort.Search(len(trades), func(i int) bool {
+			return !time.Time(trades[i].Time).Before(ts)
+		})
+	}
+
+	windows := make([]window, folds)
+	for k := range windows {
+		from := start.Add(time.Duration(k) * length)
+		trainEnd := index(from.Add(time.Duration(float64(length) * trainFraction)))
+		end := index(from.Add(length))
+		if k == folds-1 {
+			end = len(trades)
+		}
+		windows[k] = window{
+			train: trades[index(from):trainEnd],
+			test:  trades[trainEnd:end],
+		}
+	}
+	return windows
+}
+
+// combine combines results of windows, PnL and fills are summed, drawdown is the max one and Sharpe ratio the mean one
+func combine(results []replay.Metrics) replay.Metrics {
+	var m replay.Metrics
+	for _, r := range results {
+		m.PnL += r.PnL
+		m.Fills += r.Fills
+		m.Candles += r.Candles
+		m.Sharpe += r.Sharpe
+		if r.MaxDrawdown > m.MaxDrawdown {
+			m.MaxDrawdown = r.MaxDrawdown
+		}
+	}
+	if len(results) > 0 {
+		m.Sharpe /= float64(len(results))
+	}
+	return m
+}
+
+func score(objective string, m replay.Metrics) float64 {
+	switch objective {
+	case SharpeObjective:
+		return m.Sharpe
+	case DrawdownObjective:
+		return -m.MaxDrawdown
+	default:
+		return m.PnL
+	}
+}
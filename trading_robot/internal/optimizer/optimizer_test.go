@@ -0,0 +1,228 @@
+package optimizer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/replay"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPair = "PI_XBTUSD"
+
+func newTestLogger() *log.Logger {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	return logger
+}
+
+// testTrades returns trades of two hours of minute candles opened at the previous close, the price swings around
+// a rising trend, so strategies of different periods trade differently
+func testTrades() []domain.Price {
+	start, open := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC), 100.0
+	var trades []domain.Price
+	for i := 0; i < 120; i++ {
+		close := 100 + float64(i)/10 + 5*math.Sin(float64(i)/6)
+		for j, price := range []float64{open, close} {
+			ts := start.Add(time.Duration(i)*time.Minute + time.Duration(j*30)*time.Second)
+			trades = append(trades, domain.Price{Time: domain.UnixTS(ts), ProductID: testPair, Quantity: 1, Price: price})
+		}
+		open = close
+	}
+	return trades
+}
+
+func testConfig(t *testing.T) config.Config {
+	cfg, err := config.ParseSettings(map[string]interface{}{
+		"pair":    map[string]interface{}{"period": "1m"},
+		"trading": map[string]interface{}{"quantity": 1, "multiplier": 0.01},
+	})
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestSpace(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tparse ranges", testID)
+	{
+		r, err := ParseRange("10:200:5")
+		a.NoError(err)
+		a.Equal(Range{Min: 10, Max: 200, Step: 5}, r)
+		r, err = ParseRange("14")
+		a.NoError(err)
+		a.Equal(Range{Min: 14, Max: 14, Step: 1}, r)
+
+		for _, s := range []string{"1:5", "20:10", "10:20:0", "ten", "1:2:3:4"} {
+			_, err = ParseRange(s)
+			a.True(errors.Is(err, ErrInvalidSpace), s)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tgrid of the strategy ranges", testID)
+	{
+		grid, err := Space{Strategy: EMAStrategy, EMAPeriod: Range{Min: 10, Max: 20, Step: 5}}.Grid()
+		a.NoError(err)
+		a.Equal([]Params{{Strategy: EMAStrategy, EMAPeriod: 10}, {Strategy: EMAStrategy, EMAPeriod: 15},
+			{Strategy: EMAStrategy, EMAPeriod: 20}}, grid)
+
+		grid, err = Space{
+			Strategy:   MACDStrategy,
+			MACDShort:  Range{Min: 2, Max: 4, Step: 1},
+			MACDLong:   Range{Min: 3, Max: 4, Step: 1},
+			MACDSignal: Range{Min: 9, Max: 9, Step: 1},
+		}.Grid()
+		a.NoError(err)
+		a.Equal([]Params{
+			{Strategy: MACDStrategy, MACDShort: 2, MACDLong: 3, MACDSignal: 9},
+			{Strategy: MACDStrategy, MACDShort: 2, MACDLong: 4, MACDSignal: 9},
+			{Strategy: MACDStrategy, MACDShort: 3, MACDLong: 4, MACDSignal: 9},
+		}, grid, "Short period should be below long one")
+
+		_, err = Space{Strategy: "rsi"}.Grid()
+		a.True(errors.Is(err, ErrInvalidSpace))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trandom search", testID)
+	{
+		space := Space{Strategy: EMAStrategy, EMAPeriod: Range{Min: 10, Max: 200, Step: 1}}
+		sample, err := space.Random(20, rand.New(rand.NewSource(1)))
+		a.NoError(err)
+		a.Len(sample, 20)
+		seen := make(map[Params]bool)
+		for _, p := range sample {
+			a.False(seen[p], "Candidates should be distinct")
+			seen[p] = true
+			a.True(p.EMAPeriod >= 10 && p.EMAPeriod <= 200)
+		}
+		again, _ := space.Random(20, rand.New(rand.NewSource(1)))
+		a.Equal(sample, again, "Sample should depend on seed only")
+
+		sample, err = Space{Strategy: EMAStrategy, EMAPeriod: Range{Min: 10, Max: 20, Step: 5}}.Random(10, rand.New(rand.NewSource(1)))
+		a.NoError(err)
+		a.Len(sample, 3, "Sample should be limited by the space")
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	a := assert.New(t)
+	trades, cfg := testTrades(), testConfig(t)
+	candidates, err := Space{Strategy: EMAStrategy, EMAPeriod: Range{Min: 2, Max: 30, Step: 4}}.Grid()
+	require.NoError(t, err)
+
+	testID := 0
+	t.Logf("\tTest %d:\tcandidates are ranked by objective", testID)
+	{
+		report, err := Optimize(trades, candidates, cfg, Settings{Objective: PnLObjective, Workers: 4}, newTestLogger())
+		require.NoError(t, err)
+		a.Len(report.Evaluations, len(candidates))
+		a.Empty(report.Folds)
+		a.Nil(report.WalkForward)
+		for i, e := range report.Evaluations {
+			a.Equal(i+1, e.Rank)
+			a.Equal(e.Train.PnL, e.Score)
+			a.Nil(e.Test)
+			if i > 0 {
+				a.GreaterOrEqual(report.Evaluations[i-1].Score, e.Score)
+			}
+		}
+		a.NotEqual(report.Evaluations[0].Score, report.Evaluations[len(candidates)-1].Score)
+
+		best := report.Evaluations[0]
+		m, err := replay.Backtest(trades, best.Params.NewStrategy(), cfg, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(m, best.Train, "Ranking should match a single backtest")
+
+		sequential, err := Optimize(trades, candidates, cfg, Settings{Objective: PnLObjective, Workers: 1}, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(sequential, report, "Parallel backtests should not change results")
+
+		drawdown, err := Optimize(trades, candidates, cfg, Settings{Objective: DrawdownObjective}, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(-drawdown.Evaluations[0].Train.MaxDrawdown, drawdown.Evaluations[0].Score)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\twalk-forward windows", testID)
+	{
+		report, err := Optimize(trades, candidates, cfg, Settings{Objective: SharpeObjective, Folds: 3}, newTestLogger())
+		require.NoError(t, err)
+		a.Len(report.Folds, 3)
+		var pnl float64
+		for i, fold := range report.Folds {
+			a.Equal(i+1, fold.Fold)
+			a.True(fold.TestStart.After(fold.TrainStart))
+			a.True(fold.TestEnd.After(fold.TestStart))
+			if i > 0 {
+				a.True(fold.TrainStart.After(report.Folds[i-1].TestEnd), "Windows should not overlap")
+			}
+			pnl += fold.Test.PnL
+		}
+		a.InDelta(pnl, report.WalkForward.PnL, 1e-9)
+		for _, e := range report.Evaluations {
+			a.NotNil(e.Test)
+			a.Equal(e.Train.Sharpe, e.Score)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid settings", testID)
+	{
+		_, err := Optimize(trades, candidates, cfg, Settings{Objective: "profit"}, newTestLogger())
+		a.True(errors.Is(err, ErrInvalidSetting))
+		_, err = Optimize(trades, candidates, cfg, Settings{Objective: PnLObjective, Folds: 2, TrainFraction: 1}, newTestLogger())
+		a.True(errors.Is(err, ErrInvalidSetting))
+		_, err = Optimize(nil, candidates, cfg, Settings{Objective: PnLObjective}, newTestLogger())
+		a.True(errors.Is(err, ErrNoTrades))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	a := assert.New(t)
+	report := Report{
+		Objective: PnLObjective,
+		Evaluations: []Evaluation{
+			{Rank: 1, Params: Params{Strategy: EMAStrategy, EMAPeriod: 20}, Score: 12.5,
+				Train: replay.Metrics{PnL: 12.5, Sharpe: 0.25, MaxDrawdown: 3, Fills: 4},
+				Test:  &replay.Metrics{PnL: -1, Fills: 2}},
+			{Rank: 2, Params: Params{Strategy: MACDStrategy, MACDShort: 12, MACDLong: 26, MACDSignal: 9}, Score: 2},
+		},
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\tCSV rows of evaluations", testID)
+	{
+		var buf bytes.Buffer
+		a.NoError(WriteCSV(&buf, report))
+		rows, err := csv.NewReader(&buf).ReadAll()
+		a.NoError(err)
+		a.Equal([][]string{
+			csvHeader,
+			{"1", "ema", "20", "", "", "", "12.5", "12.5", "0.25", "3", "4", "-1", "0", "0", "2"},
+			{"2", "macd", "", "12", "26", "9", "2", "0", "0", "0", "0", "", "", "", ""},
+		}, rows)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tJSON report", testID)
+	{
+		var buf bytes.Buffer
+		a.NoError(WriteJSON(&buf, report))
+		var decoded Report
+		a.NoError(json.Unmarshal(buf.Bytes(), &decoded))
+		a.Equal(report, decoded)
+	}
+}
@@ -0,0 +1,68 @@
+package optimizer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/replay"
+)
+
+var csvHeader = []string{
+	"rank", "strategy", "ema_period", "macd_short", "macd_long", "macd_signal", "score",
+	"train_pnl", "train_sharpe", "train_max_drawdown", "train_fills",
+	"test_pnl", "test_sharpe", "test_max_drawdown", "test_fills",
+}
+
+// WriteCSV writes ranked evaluations of the report as CSV rows, test columns are empty without walk-forward
+func WriteCSV(w io.Writer, r Report) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range r.Evaluations {
+		row := []string{
+			strconv.Itoa(e.Rank),
+			e.Params.Strategy,
+			formatInt(e.Params.EMAPeriod),
+			formatInt(e.Params.MACDShort),
+			formatInt(e.Params.MACDLong),
+			formatInt(e.Params.MACDSignal),
+			formatFloat(e.Score),
+		}
+		row = append(row, metricsColumns(&e.Train)...)
+		row = append(row, metricsColumns(e.Test)...)
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteJSON writes the whole report including walk-forward folds
+func WriteJSON(w io.Writer, r Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func metricsColumns(m *replay.Metrics) []string {
+	if m == nil {
+		return []string{"", "", "", ""}
+	}
+	return []string{formatFloat(m.PnL), formatFloat(m.Sharpe), formatFloat(m.MaxDrawdown), strconv.Itoa(m.Fills)}
+}
+
+// formatInt formats parameter value, parameters of other strategies are empty
+func formatInt(v int) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.Itoa(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,188 @@
+package optimizer
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+)
+
+// Strategies of the search
+const (
+	EMAStrategy  = "ema"
+	MACDStrategy = "macd"
+)
+
+var ErrInvalidSpace = errors.New("invalid parameter space")
+
+// Params are parameters of a strategy candidate, only parameters of the strategy are set
+type Params struct {
+	Strategy   string `json:"strategy"`
+	EMAPeriod  int    `json:"ema_period,omitempty"`
+	MACDShort  int    `json:"macd_short,omitempty"`
+	MACDLong   int    `json:"macd_long,omitempty"`
+	MACDSignal int    `json:"macd_signal,omitempty"`
+}
+
+func (p Params) String() string {
+	if p.Strategy == MACDStrategy {
+		return fmt.Sprintf("macd %d/%d/%d", p.MACDShort, p.MACDLong, p.MACDSignal)
+	}
+	return fmt.Sprintf("ema %d", p.EMAPeriod)
+}
+
+// NewStrategy returns a new strategy of the parameters, strategies keep state, so every backtest needs its own
+func (p Params) NewStrategy() indicator.Strategy {
+	if p.Strategy == MACDStrategy {
+		return indicator.SetupMACDStrategy(p.MACDShort, p.MACDLong, p.MACDSignal)
+	}
+	strategy, _ := indicator.SetupEMAStrategy(p.EMAPeriod)
+	return strategy
+}
+
+// Range is an inclusive range of parameter values taken with the step
+type Range struct {
+	Min  int
+	Max  int
+	Step int
+}
+
+// ParseRange parses range of min:max:step format, step is 1 if omitted, single value is a range of the value
+func ParseRange(s string) (Range, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return Range{}, fmt.Errorf("%w: range %q is not min:max:step", ErrInvalidSpace, s)
+	}
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return Range{}, fmt.Errorf("%w: range %q: %s", ErrInvalidSpace, s, err)
+		}
+		values[i] = v
+	}
+
+	r := Range{Min: values[0], Max: values[0], Step: 1}
+	if len(values) > 1 {
+		r.Max = values[1]
+	}
+	if len(values) > 2 {
+		r.Step = values[2]
+	}
+	return r, r.validate()
+}
+
+func (r Range) validate() error {
+	if r.Min < 2 || r.Max < r.Min || r.Step <= 0 {
+		return fmt.Errorf("%w: range %d:%d:%d, periods start at 2 and step is positive", ErrInvalidSpace, r.Min, r.Max, r.Step)
+	}
+	return nil
+}
+
+func (r Range) values() []int {
+	values := make([]int, 0, (r.Max-r.Min)/r.Step+1)
+	for v := r.Min; v <= r.Max; v += r.Step {
+		values = append(values, v)
+	}
+	return values
+}
+
+func (r Range) random(rnd *rand.Rand) int {
+	return r.Min + rnd.Intn((r.Max-r.Min)/r.Step+1)*r.Step
+}
+
+// Space is the searched parameter space, only ranges of the strategy are used
+type Space struct {
+	Strategy   string
+	EMAPeriod  Range
+	MACDShort  Range
+	MACDLong   Range
+	MACDSignal Range
+}
+
+func (s Space) ranges() ([]Range, error) {
+	var ranges []Range
+	switch s.Strategy {
+	case EMAStrategy:
+		ranges = []Range{s.EMAPeriod}
+	case MACDStrategy:
+		ranges = []Range{s.MACDShort, s.MACDLong, s.MACDSignal}
+	default:
+		return nil, fmt.Errorf("%w: unknown strategy %q", ErrInvalidSpace, s.Strategy)
+	}
+	for _, r := range ranges {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return ranges, nil
+}
+
+// params returns parameters of the values of the strategy ranges, false if they are not a valid combination
+func (s Space) params(values []int) (Params, bool) {
+	if s.Strategy == EMAStrategy {
+		return Params{Strategy: EMAStrategy, EMAPeriod: values[0]}, true
+	}
+	p := Params{Strategy: MACDStrategy, MACDShort: values[0], MACDLong: values[1], MACDSignal: values[2]}
+	return p, p.MACDShort < p.MACDLong
+}
+
+// Grid returns all parameter combinations of the space, MACD combinations with short period not below the long
+// one are skipped
+func (s Space) Grid() ([]Params, error) {
+	ranges, err := s.ranges()
+	if err != nil {
+		return nil, err
+	}
+
+	combinations := [][]int{{}}
+	for _, r := range ranges {
+		next := make([][]int, 0, len(combinations)*len(r.values()))
+		for _, c := range combinations {
+			for _, v := range r.values() {
+				next = append(next, append(append([]int(nil), c...), v))
+			}
+		}
+		combinations = next
+	}
+
+	var grid []Params
+	for _, values := range combinations {
+		if p, ok := s.params(values); ok {
+			grid = append(grid, p)
+		}
+	}
+	if len(grid) == 0 {
+		return nil, fmt.Errorf("%w: no valid combinations", ErrInvalidSpace)
+	}
+	return grid, nil
+}
+
+// Random returns up to n distinct random parameter combinations of the space, fewer if the space is smaller
+func (s Space) Random(n int, rnd *rand.Rand) ([]Params, error) {
+	ranges, err := s.ranges()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[Params]bool, n)
+	var sample []Params
+	// duplicates are drawn more often as the space is exhausted, so attempts are limited
+	for attempt := 0; attempt < 10*n && len(sample) < n; attempt++ {
+		values := make([]int, len(ranges))
+		for i, r := range ranges {
+			values[i] = r.random(rnd)
+		}
+		if p, ok := s.params(values); ok && !seen[p] {
+			seen[p] = true
+			sample = append(sample, p)
+		}
+	}
+	if len(sample) == 0 {
+		return nil, fmt.Errorf("%w: no valid combinations", ErrInvalidSpace)
+	}
+	return sample, nil
+}
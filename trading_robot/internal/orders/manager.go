@@ -0,0 +1,441 @@
+// Package orders tracks orders through their lifecycle. Orders are placed through the Manager, their states
+// are updated by polling open orders and fills of the exchange, orders found on exchange but unknown
+// to the bot are flagged as orphaned.
+package orders
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+const (
+	DefaultPollInterval = 10 * time.Second
+
+	// TerminalRetention is the time terminal orders are kept after the last update
+	TerminalRetention = time.Hour
+
+	// sizeEpsilon is the tolerance of fill sizes comparison, sizes of some venues are converted from base asset
+	sizeEpsilon = 1e-9
+)
+
+// Exchange is the part of exchange adapter used to place and track orders
+type Exchange interface {
+	CreateOrder(order domain.Order) (domain.CreateOrderResponse, error)
+	CancelOrder(order domain.Order) error
+	GetOpenOrders() ([]domain.OpenOrder, error)
+	GetFills(symbol string) ([]domain.Fill, error)
+}
+
+type Notifier interface {
+	NotifyError(message string)
+}
+
+// EventPublisher must not block
+type EventPublisher interface {
+	Publish(e stream.Event)
+}
+
+// Journal records orders sent and their responses, e.g. pkg/journal.Journal
+type Journal interface {
+	Record(recordType, pair string, data interface{})
+}
+
+// FillHandler is told about fills of the bot orders found by polling, e.g. of stop loss orders triggered
+// on exchange. Fills of orders executed when placed are in their responses and are not reported.
+type FillHandler interface {
+	ApplyFill(symbol string, side domain.OrderType, size float64)
+}
+
+// orderResponse is the journal record of the exchange response to an order, error is set if it was not placed
+type orderResponse struct {
+	Response *domain.CreateOrderResponse `json:"response,omitempty"`
+	Error    string                      `json:"error,omitempty"`
+}
+
+type Manager struct {
+	exchange Exchange
+	notifier Notifier
+	events   EventPublisher
+	journal  Journal     // optional
+	fills    FillHandler // optional
+	logger   *log.Logger
+	now      func() time.Time
+
+	mu       sync.RWMutex
+	orders   map[string]*Order // by exchange order ID, rejected orders by local key
+	inflight int               // orders sent, but not acknowledged yet
+	seq      int
+}
+
+func NewManager(e Exchange, n Notifier, p EventPublisher, l *log.Logger) *Manager {
+	return &Manager{
+		exchange: e,
+		notifier: n,
+		events:   p,
+		logger:   l,
+		now:      time.Now,
+		orders:   make(map[string]*Order),
+	}
+}
+
+// SetJournal makes manager record orders it sends and exchange responses to j,
+// it should be called before orders are sent
+func (m *Manager) SetJournal(j Journal) {
+	m.journal = j
+}
+
+// SetFillHandler makes manager report fills found by polling to h, it should be called before polling is started
+// and before orders are reconciled
+func (m *Manager) SetFillHandler(h FillHandler) {
+	m.fills = h
+}
+
+func (m *Manager) record(recordType, pair string, data interface{}) {
+	if m.journal != nil {
+		m.journal.Record(recordType, pair, data)
+	}
+}
+
+// CreateOrder places the order and tracks it, it can be used instead of the exchange to send orders
+func (m *Manager) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	o := newOrder(order, m.now())
+	m.mu.Lock()
+	m.inflight++
+	m.mu.Unlock()
+
+	m.record(journal.OrderRecord, order.Symbol, order)
+	resp, err := m.exchange.CreateOrder(order)
+	if err != nil {
+		m.record(journal.ResponseRecord, order.Symbol, orderResponse{Error: err.Error()})
+	} else {
+		m.record(journal.ResponseRecord, order.Symbol, orderResponse{Response: &resp})
+	}
+
+	m.mu.Lock()
+	m.inflight--
+	var changed bool
+	if err != nil {
+		o.Error = err.Error()
+		changed, _ = o.transition(StateRejected, 0, m.now())
+		m.seq++
+		m.orders["rejected-"+strconv.Itoa(m.seq)] = o
+	} else {
+		o.ID = resp.OrderID
+		changed, _ = o.transition(acknowledgedState(order, resp), executedSize(resp), m.now())
+		m.orders[o.ID] = o
+	}
+	snapshot := *o
+	m.mu.Unlock()
+
+	if changed {
+		m.publish(snapshot)
+	}
+	return resp, err
+}
+
+// acknowledgedState returns state of the order accepted by exchange
+func acknowledgedState(order domain.Order, resp domain.CreateOrderResponse) State {
+	executed := executedSize(resp)
+	switch {
+	case executed >= float64(order.Size)-sizeEpsilon:
+		return StateFilled
+	case order.OrderType == domain.IocOrder || order.OrderType == domain.MarketOrder:
+		// not filled part of ioc and market orders is cancelled at once
+		return StateCancelled
+	default:
+		return openState(executed)
+	}
+}
+
+// executedSize returns size executed on acknowledgement as reported by exchange, not the size of the order
+func executedSize(resp domain.CreateOrderResponse) float64 {
+	if resp.OrderEventType != domain.ExecutionEvent {
+		return 0
+	}
+	return resp.FilledSize
+}
+
+// CancelOrder cancels tracked order by exchange order ID
+func (m *Manager) CancelOrder(id string) error {
+	m.mu.RLock()
+	o, ok := m.orders[id]
+	var order domain.Order
+	if ok {
+		order = domain.Order{OrderID: o.ID, CliOrdID: o.CliOrdID, Symbol: o.Symbol}
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("cancel %s: %w", id, domain.ErrOrderNotFound)
+	}
+
+	if err := m.exchange.CancelOrder(order); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	changed, err := o.transition(StateCancelled, o.FilledSize, m.now())
+	snapshot := *o
+	m.mu.Unlock()
+	if changed {
+		m.publish(snapshot)
+	}
+	return err
+}
+
+// Reconcile updates tracked orders with the exchange state and flags orphaned orders. It should be called
+// on startup after tracked orders are restored.
+func (m *Manager) Reconcile() error {
+	orphans, err := m.poll()
+	if err != nil {
+		return err
+	}
+
+	active := 0
+	for _, o := range m.Orders() {
+		if !o.State.Terminal() {
+			active++
+		}
+	}
+	m.logger.Infof("Orders reconciled: %d active, %d orphaned", active, len(orphans))
+	return nil
+}
+
+// StartPolling updates orders every interval until ctx is done
+func (m *Manager) StartPolling(ctx context.Context, interval time.Duration, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				m.logger.Info("Orders polling done")
+				return
+			case <-ticker.C:
+				if _, err := m.poll(); err != nil {
+					m.logger.Errorf("Poll orders failed: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// poll updates active orders with open orders and fills of the exchange and returns new orphaned orders
+func (m *Manager) poll() ([]Order, error) {
+	open, err := m.exchange.GetOpenOrders()
+	if err != nil {
+		return nil, err
+	}
+
+	changed, orphans, gone, fills := m.updateOpen(open)
+
+	// orders that left the book are either filled or cancelled, fills tell which one
+	bySymbol := make(map[string][]string)
+	for _, id := range gone {
+		m.mu.RLock()
+		symbol := m.orders[id].Symbol
+		m.mu.RUnlock()
+		bySymbol[symbol] = append(bySymbol[symbol], id)
+	}
+	for symbol, ids := range bySymbol {
+		executions, err := m.exchange.GetFills(symbol)
+		if err != nil {
+			return orphans, err
+		}
+		closed, closedFills := m.updateClosed(ids, executions)
+		changed, fills = append(changed, closed...), append(fills, closedFills...)
+	}
+
+	m.prune()
+
+	for _, o := range changed {
+		m.publish(o)
+	}
+	for _, f := range fills {
+		m.logger.Infof("Order %s filled by %g %s", f.order.ID, f.size, f.order.Symbol)
+		if m.fills != nil {
+			m.fills.ApplyFill(f.order.Symbol, domain.OrderType(f.order.Side), f.size)
+		}
+	}
+	for _, o := range orphans {
+		m.logger.Warnf("Orphaned order %s: %s %g %s at %g", o.ID, o.Side, o.Size-o.FilledSize, o.Symbol, o.LimitPrice)
+		m.notifier.NotifyError(fmt.Sprintf("Orphaned order %s: %s %g %s at %g", o.ID, o.Side, o.Size-o.FilledSize, o.Symbol, o.LimitPrice))
+	}
+	return orphans, nil
+}
+
+// fill is the size the bot order was filled by since the previous update
+type fill struct {
+	order Order
+	size  float64
+}
+
+// newFill returns fill of the order updated from the filled size, ok is false if the order is not filled more
+// or is not placed by the bot
+func newFill(o *Order, filled float64) (fill, bool) {
+	size := o.FilledSize - filled
+	if o.Orphaned || size <= sizeEpsilon {
+		return fill{}, false
+	}
+	return fill{order: *o, size: size}, true
+}
+
+// updateOpen updates orders resting on exchange and adds unknown ones as orphaned. It returns changed orders,
+// orphaned orders, IDs of active orders missing on exchange and new fills.
+func (m *Manager) updateOpen(open []domain.OpenOrder) (changed, orphans []Order, gone []string, fills []fill) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	onExchange := make(map[string]bool, len(open))
+	for _, oo := range open {
+		onExchange[oo.OrderID] = true
+		o, ok := m.orders[oo.OrderID]
+		if !ok {
+			if m.inflight > 0 {
+				// the order may be sent right now, it is checked on the next poll
+				continue
+			}
+			o = orphanOrder(oo, now)
+			m.orders[o.ID] = o
+			orphans = append(orphans, *o)
+			continue
+		}
+
+		before := o.FilledSize
+		ok, err := o.transition(openState(oo.FilledSize), oo.FilledSize, now)
+		if err != nil {
+			m.logger.Error(err)
+		}
+		if ok {
+			changed = append(changed, *o)
+		}
+		if f, ok := newFill(o, before); ok {
+			fills = append(fills, f)
+		}
+	}
+
+	for id, o := range m.orders {
+		if !o.State.Terminal() && !onExchange[id] {
+			gone = append(gone, id)
+		}
+	}
+	sort.Strings(gone)
+	return changed, orphans, gone, fills
+}
+
+// updateClosed sets filled or cancelled state of orders missing on exchange, it returns changed orders and new fills
+func (m *Manager) updateClosed(ids []string, executions []domain.Fill) (changed []Order, fills []fill) {
+	filled := make(map[string]float64)
+	for _, f := range executions {
+		filled[f.OrderID] += f.Size
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	changed = make([]Order, 0, len(ids))
+	for _, id := range ids {
+		o := m.orders[id]
+		size := filled[id]
+		if size < o.FilledSize {
+			// old fills may be missing in the last fills of exchange
+			size = o.FilledSize
+		}
+		before := o.FilledSize
+
+		state := StateCancelled
+		if size >= o.Size-sizeEpsilon {
+			state = StateFilled
+		}
+		ok, err := o.transition(state, size, now)
+		if err != nil {
+			m.logger.Error(err)
+		}
+		if ok {
+			changed = append(changed, *o)
+		}
+		if f, ok := newFill(o, before); ok {
+			fills = append(fills, f)
+		}
+	}
+	return changed, fills
+}
+
+func orphanOrder(oo domain.OpenOrder, now time.Time) *Order {
+	return &Order{
+		ID:         oo.OrderID,
+		CliOrdID:   oo.CliOrdID,
+		Symbol:     oo.Symbol,
+		Side:       oo.Side,
+		Type:       oo.OrderType,
+		Size:       oo.UnfilledSize + oo.FilledSize,
+		FilledSize: oo.FilledSize,
+		LimitPrice: oo.LimitPrice,
+		StopPrice:  oo.StopPrice,
+		State:      openState(oo.FilledSize),
+		Orphaned:   true,
+		Created:    now,
+		Updated:    now,
+	}
+}
+
+// prune removes terminal orders older than TerminalRetention
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, o := range m.orders {
+		if o.State.Terminal() && m.now().Sub(o.Updated) > TerminalRetention {
+			delete(m.orders, key)
+		}
+	}
+}
+
+func (m *Manager) publish(o Order) {
+	m.events.Publish(stream.NewEvent(stream.OrderStateEvent, o.Symbol, o))
+}
+
+// Orders returns tracked orders sorted by creation time
+func (m *Manager) Orders() []Order {
+	m.mu.RLock()
+	orders := make([]Order, 0, len(m.orders))
+	for _, o := range m.orders {
+		orders = append(orders, *o)
+	}
+	m.mu.RUnlock()
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].Created.Equal(orders[j].Created) {
+			return orders[i].ID < orders[j].ID
+		}
+		return orders[i].Created.Before(orders[j].Created)
+	})
+	return orders
+}
+
+// Restore adds previously tracked orders, e.g. loaded on startup, orders without ID are ignored
+func (m *Manager) Restore(orders []Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range orders {
+		o := orders[i]
+		if o.ID == "" {
+			continue
+		}
+		if !o.State.valid() {
+			return fmt.Errorf("restore order %s: unknown state %q", o.ID, o.State)
+		}
+		m.orders[o.ID] = &o
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type ExchangeMock struct {
+	mock.Mock
+}
+
+func (e *ExchangeMock) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	args := e.Called(order)
+	return args.Get(0).(domain.CreateOrderResponse), args.Error(1)
+}
+
+func (e *ExchangeMock) CancelOrder(order domain.Order) error {
+	args := e.Called(order)
+	return args.Error(0)
+}
+
+func (e *ExchangeMock) GetOpenOrders() ([]domain.OpenOrder, error) {
+	args := e.Called()
+	return args.Get(0).([]domain.OpenOrder), args.Error(1)
+}
+
+func (e *ExchangeMock) GetFills(symbol string) ([]domain.Fill, error) {
+	args := e.Called(symbol)
+	return args.Get(0).([]domain.Fill), args.Error(1)
+}
+
+type NotifierMock struct {
+	mock.Mock
+}
+
+func (n *NotifierMock) NotifyError(message string) {
+	n.Called(message)
+}
+
+type PublisherStub struct {
+	mu     sync.Mutex
+	events []stream.Event
+}
+
+func (p *PublisherStub) Publish(e stream.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+}
+
+func (p *PublisherStub) last() Order {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.events[len(p.events)-1].Data.(Order)
+}
+
+type JournalStub struct {
+	types   []string
+	records []interface{}
+}
+
+func (j *JournalStub) Record(recordType, _ string, data interface{}) {
+	j.types = append(j.types, recordType)
+	j.records = append(j.records, data)
+}
+
+type FillHandlerStub struct {
+	fills []string
+}
+
+func (f *FillHandlerStub) ApplyFill(symbol string, side domain.OrderType, size float64) {
+	f.fills = append(f.fills, fmt.Sprintf("%s %g %s", side, size, symbol))
+}
+
+const testPair = "PI_XBTUSD"
+
+func newTestManager(e *ExchangeMock, n *NotifierMock) (*Manager, *PublisherStub) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	p := &PublisherStub{}
+	return NewManager(e, n, p, logger), p
+}
+
+func findOrder(m *Manager, id string) (Order, bool) {
+	for _, o := range m.Orders() {
+		if o.ID == id {
+			return o, true
+		}
+	}
+	return Order{}, false
+}
+
+func TestManager_CreateOrder(t *testing.T) {
+	a := assert.New(t)
+	e := new(ExchangeMock)
+	m, events := newTestManager(e, new(NotifierMock))
+
+	testID := 0
+	t.Logf("\tTest %d:\tioc order filled", testID)
+	{
+		order := domain.CreateIocOrder(domain.BuyOrder, testPair, 100, 10)
+		e.On("CreateOrder", order).Return(domain.CreateOrderResponse{OrderID: "1", Size: 10, Status: domain.PlacedStatus, OrderEventType: domain.ExecutionEvent, FilledSize: 10}, nil).Once()
+		_, err := m.CreateOrder(order)
+		a.NoError(err)
+
+		o, ok := findOrder(m, "1")
+		a.True(ok)
+		a.Equal(StateFilled, o.State)
+		a.Equal(10.0, o.FilledSize)
+		a.Equal(StateFilled, events.last().State)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tioc order partially filled", testID)
+	{
+		order := domain.CreateIocOrder(domain.BuyOrder, testPair, 100, 10)
+		e.On("CreateOrder", order).Return(domain.CreateOrderResponse{OrderID: "2", Size: 10, Status: domain.PlacedStatus, OrderEventType: domain.ExecutionEvent, FilledSize: 4}, nil).Once()
+		_, err := m.CreateOrder(order)
+		a.NoError(err)
+
+		o, _ := findOrder(m, "2")
+		a.Equal(StateCancelled, o.State, "Rest of ioc order should be cancelled")
+		a.Equal(4.0, o.FilledSize)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlimit order rests", testID)
+	{
+		order := domain.Order{OrderType: domain.LimitOrder, Symbol: testPair, Side: string(domain.SellOrder), Size: 10, LimitPrice: 120}
+		e.On("CreateOrder", order).Return(domain.CreateOrderResponse{OrderID: "3", Size: 10, Status: domain.PlacedStatus}, nil).Once()
+		_, err := m.CreateOrder(order)
+		a.NoError(err)
+
+		o, _ := findOrder(m, "3")
+		a.Equal(StateOpen, o.State)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trejected order", testID)
+	{
+		order := domain.CreateIocOrder(domain.BuyOrder, testPair, 90, 10)
+		e.On("CreateOrder", order).Return(domain.CreateOrderResponse{}, fmt.Errorf("sendorder: %w", domain.ErrWouldNotExecute)).Once()
+		_, err := m.CreateOrder(order)
+		a.ErrorIs(err, domain.ErrWouldNotExecute)
+
+		o := events.last()
+		a.Equal(StateRejected, o.State)
+		a.Empty(o.ID)
+		a.Contains(o.Error, domain.ErrWouldNotExecute.Error())
+		a.Len(m.Orders(), 4)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torders and responses are recorded to journal", testID)
+	{
+		j := &JournalStub{}
+		m.SetJournal(j)
+		order := domain.CreateIocOrder(domain.SellOrder, testPair, 90, 10)
+		resp := domain.CreateOrderResponse{OrderID: "5", Size: 10, Status: domain.PlacedStatus}
+		e.On("CreateOrder", order).Return(resp, nil).Once()
+		_, err := m.CreateOrder(order)
+		a.NoError(err)
+		a.Equal([]string{journal.OrderRecord, journal.ResponseRecord}, j.types)
+		a.Equal(order, j.records[0])
+		a.Equal(orderResponse{Response: &resp}, j.records[1])
+	}
+	e.AssertExpectations(t)
+}
+
+func TestManager_Poll(t *testing.T) {
+	a := assert.New(t)
+	e := new(ExchangeMock)
+	n := new(NotifierMock)
+	m, _ := newTestManager(e, n)
+	now := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+	fills := &FillHandlerStub{}
+	m.SetFillHandler(fills)
+
+	limit := func(id string) domain.OpenOrder {
+		return domain.OpenOrder{OrderID: id, Symbol: testPair, Side: string(domain.SellOrder), OrderType: domain.LimitOrder, LimitPrice: 120, UnfilledSize: 10}
+	}
+	a.NoError(m.Restore([]Order{
+		{ID: "1", Symbol: testPair, Side: string(domain.SellOrder), Size: 10, State: StateOpen},
+		{ID: "2", Symbol: testPair, Side: string(domain.SellOrder), Size: 10, State: StateOpen},
+		{ID: "3", Symbol: testPair, Side: string(domain.SellOrder), Size: 10, State: StateOpen},
+	}))
+
+	testID := 0
+	t.Logf("\tTest %d:\tpartial fill and orphaned order", testID)
+	{
+		partial := limit("1")
+		partial.UnfilledSize, partial.FilledSize = 6, 4
+		e.On("GetOpenOrders").Return([]domain.OpenOrder{partial, limit("2"), limit("3"), limit("orphan")}, nil).Once()
+		n.On("NotifyError", mock.MatchedBy(func(msg string) bool { return msg == "Orphaned order orphan: sell 10 PI_XBTUSD at 120" })).Once()
+		a.NoError(m.Reconcile())
+
+		o, _ := findOrder(m, "1")
+		a.Equal(StatePartiallyFilled, o.State)
+		a.Equal(4.0, o.FilledSize)
+		o, ok := findOrder(m, "orphan")
+		a.True(ok)
+		a.True(o.Orphaned)
+		a.Equal(StateOpen, o.State)
+		a.Equal([]string{"sell 4 PI_XBTUSD"}, fills.fills)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torders left the book are filled or cancelled by fills", testID)
+	{
+		e.On("GetOpenOrders").Return([]domain.OpenOrder{limit("orphan")}, nil).Once()
+		e.On("GetFills", testPair).Return([]domain.Fill{
+			{OrderID: "1", Size: 4}, {OrderID: "1", Size: 6}, {OrderID: "2", Size: 3}, {OrderID: "other", Size: 10},
+		}, nil).Once()
+		_, err := m.poll()
+		a.NoError(err)
+
+		o, _ := findOrder(m, "1")
+		a.Equal(StateFilled, o.State)
+		a.Equal(10.0, o.FilledSize)
+		o, _ = findOrder(m, "2")
+		a.Equal(StateCancelled, o.State)
+		a.Equal(3.0, o.FilledSize)
+		o, _ = findOrder(m, "3")
+		a.Equal(StateCancelled, o.State)
+		a.Zero(o.FilledSize)
+		a.Equal([]string{"sell 4 PI_XBTUSD", "sell 6 PI_XBTUSD", "sell 3 PI_XBTUSD"}, fills.fills,
+			"Only new fills of the bot orders should be reported")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tterminal orders are not changed", testID)
+	{
+		e.On("GetOpenOrders").Return([]domain.OpenOrder{limit("1"), limit("orphan")}, nil).Once()
+		_, err := m.poll()
+		a.NoError(err)
+		o, _ := findOrder(m, "1")
+		a.Equal(StateFilled, o.State)
+		a.Len(fills.fills, 3)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tterminal orders are pruned", testID)
+	{
+		now = now.Add(TerminalRetention + time.Minute)
+		e.On("GetOpenOrders").Return([]domain.OpenOrder{limit("orphan")}, nil).Once()
+		_, err := m.poll()
+		a.NoError(err)
+		a.Len(m.Orders(), 1)
+		_, ok := findOrder(m, "orphan")
+		a.True(ok)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\texchange error", testID)
+	{
+		e.On("GetOpenOrders").Return([]domain.OpenOrder(nil), errors.New("exchange error")).Once()
+		a.Error(m.Reconcile())
+	}
+	e.AssertExpectations(t)
+	n.AssertExpectations(t)
+}
+
+func TestManager_CancelOrder(t *testing.T) {
+	a := assert.New(t)
+	e := new(ExchangeMock)
+	m, events := newTestManager(e, new(NotifierMock))
+	a.NoError(m.Restore([]Order{{ID: "1", Symbol: testPair, Size: 10, FilledSize: 2, State: StatePartiallyFilled}}))
+
+	testID := 0
+	t.Logf("\tTest %d:\tcancel tracked order", testID)
+	{
+		e.On("CancelOrder", domain.Order{OrderID: "1", Symbol: testPair}).Return(nil).Once()
+		a.NoError(m.CancelOrder("1"))
+		a.Equal(StateCancelled, events.last().State)
+		a.Equal(2.0, events.last().FilledSize)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcancel unknown order", testID)
+	{
+		a.ErrorIs(m.CancelOrder("2"), domain.ErrOrderNotFound)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trestore unknown state", testID)
+	{
+		a.Error(m.Restore([]Order{{ID: "3", State: "done"}}))
+	}
+	e.AssertExpectations(t)
+}
+
+func TestState(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tterminal states", testID)
+	{
+		a.False(StatePending.Terminal())
+		a.False(StatePartiallyFilled.Terminal())
+		a.True(StateFilled.Terminal())
+		a.True(StateCancelled.Terminal())
+		a.True(StateRejected.Terminal())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid transition", testID)
+	{
+		o := &Order{ID: "1", State: StateFilled}
+		_, err := o.transition(StateOpen, 0, time.Now())
+		a.ErrorIs(err, ErrInvalidTransition)
+		a.Equal(StateFilled, o.State)
+	}
+}
@@ -0,0 +1,114 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+)
+
+// State is a state of the order lifecycle
+type State string
+
+const (
+	StatePending         State = "pending" // sent to exchange, not acknowledged yet
+	StateOpen            State = "open"
+	StatePartiallyFilled State = "partially_filled"
+	StateFilled          State = "filled"
+	StateCancelled       State = "cancelled" // cancelled or expired, possibly after partial fills
+	StateRejected        State = "rejected"
+)
+
+var ErrInvalidTransition = errors.New("invalid order state transition")
+
+// transitions are allowed state changes, states without transitions are terminal
+var transitions = map[State]map[State]bool{
+	StatePending: {
+		StateOpen:            true,
+		StatePartiallyFilled: true,
+		StateFilled:          true,
+		StateCancelled:       true,
+		StateRejected:        true,
+	},
+	StateOpen: {
+		StatePartiallyFilled: true,
+		StateFilled:          true,
+		StateCancelled:       true,
+	},
+	StatePartiallyFilled: {
+		StatePartiallyFilled: true,
+		StateFilled:          true,
+		StateCancelled:       true,
+	},
+}
+
+// Terminal reports whether the order can't change anymore
+func (s State) Terminal() bool {
+	return len(transitions[s]) == 0
+}
+
+func (s State) valid() bool {
+	switch s {
+	case StatePending, StateOpen, StatePartiallyFilled, StateFilled, StateCancelled, StateRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Order is an order tracked by the manager, sizes are in contracts
+type Order struct {
+	ID         string    `json:"order_id,omitempty"`
+	CliOrdID   string    `json:"cli_ord_id,omitempty"`
+	Symbol     string    `json:"symbol"`
+	Side       string    `json:"side"`
+	Type       string    `json:"order_type"`
+	Size       float64   `json:"size"`
+	FilledSize float64   `json:"filled_size"`
+	LimitPrice float64   `json:"limit_price,omitempty"`
+	StopPrice  float64   `json:"stop_price,omitempty"`
+	State      State     `json:"state"`
+	Orphaned   bool      `json:"orphaned,omitempty"` // found on exchange, but not placed by the bot
+	Error      string    `json:"error,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+func newOrder(o domain.Order, now time.Time) *Order {
+	return &Order{
+		ID:         o.OrderID,
+		CliOrdID:   o.CliOrdID,
+		Symbol:     o.Symbol,
+		Side:       o.Side,
+		Type:       o.OrderType,
+		Size:       float64(o.Size),
+		LimitPrice: o.LimitPrice,
+		StopPrice:  o.StopPrice,
+		State:      StatePending,
+		Created:    now,
+		Updated:    now,
+	}
+}
+
+// transition changes the state, it reports whether the order changed
+func (o *Order) transition(to State, filled float64, now time.Time) (bool, error) {
+	if o.State == to && o.FilledSize == filled {
+		return false, nil
+	}
+	if !transitions[o.State][to] {
+		return false, fmt.Errorf("%w: order %s %s -> %s", ErrInvalidTransition, o.ID, o.State, to)
+	}
+	o.State = to
+	o.FilledSize = filled
+	o.Updated = now
+	return true, nil
+}
+
+// openState returns state of the order resting on exchange
+func openState(filled float64) State {
+	if filled > 0 {
+		return StatePartiallyFilled
+	}
+	return StateOpen
+}
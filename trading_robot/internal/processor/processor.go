@@ -2,19 +2,45 @@ package processor
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/config"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/signals"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+)
+
+const (
+	// MaxOrderAttempts is the max number of attempts to place order for a signal
+	MaxOrderAttempts = 3
+	// OrderErrorStatus is the orders metric status of orders failed with error
+	OrderErrorStatus = "error"
+	// SignalRetention is how long executed signals are remembered to skip them if their candles are processed again
+	SignalRetention = 24 * time.Hour
+
+	defaultRetryDelay = time.Second
 )
 
 type OrdersProcessor struct {
+	strategyMu sync.Mutex // guards strategy state read by candles, snapshots and the API
 	strategy   indicator.Strategy
 	repo       Repository
 	controller OrdersSenderPricesGetter
+	sender     OrderSender   // sends orders, the controller by default
+	trailer    StopTrailer   // trails stops of entries instead of fixed stop loss, optional
+	sizer      PositionSizer // sizes orders by account balance, trading quantity is used if not set
+	detector   *signals.Detector
+	journal    Journal // records prices, candles and strategy evaluations, optional
 	notifier   OrderNotifier
+	events     EventPublisher
 	logger     *log.Logger
 
 	priceMu         sync.RWMutex
@@ -22,84 +48,505 @@ type OrdersProcessor struct {
 
 	quantityMu      sync.RWMutex
 	TradingQuantity int
+
+	protectionMu sync.RWMutex
+	protection   Protection
+
+	retryDelay time.Duration // delay before resending rate limited order
+
+	haltMu sync.Mutex // held while a candle is processed, so no order is sent by the processor once Halt returns
+	halted bool
+
+	stateMu    sync.RWMutex
+	lastCandle time.Time
+	position   int                       // net position in contracts, positive for long
+	signals    map[string]time.Time      // candle times of executed signals by signal ID
+	protective map[string][]domain.Order // stop loss and take profit orders placed on exchange by pair
+}
+
+// Protection sets stop loss and take profit orders attached after every executed entry. Stop loss and take profit
+// are fractions of the entry price, e.g. 0.02 places stop loss 2% below the entry of long position, zero disables
+// the order. Trigger signal is the price that triggers the orders.
+type Protection struct {
+	StopLoss      float64
+	TakeProfit    float64
+	TriggerSignal string
 }
 
 type Repository interface {
 	StoreToDB(ctx context.Context, response domain.CreateOrderResponse) error
 }
 
-type OrdersSenderPricesGetter interface {
+type OrderSender interface {
 	CreateOrder(order domain.Order) (domain.CreateOrderResponse, error)
+}
+
+type OrdersSenderPricesGetter interface {
+	OrderSender
+	CancelOrder(order domain.Order) error
 	GetPrices(ctx context.Context) <-chan domain.Price
 }
 
+// StopTrailer moves stop of the pair position after the best price, Update returns response of the market exit
+// if the candle crossed the stop kept by the trailer, empty response otherwise. StopDistance is the distance
+// of a stop started at the price as a fraction of the price.
+type StopTrailer interface {
+	Enabled() bool
+	Start(signalID string, stop domain.Order, entry float64, ts time.Time)
+	Update(candle domain.Candle) domain.CreateOrderResponse
+	Cancel(pair string)
+	StopDistance(pair string, price float64) float64
+}
+
+// PositionSizer returns number of contracts of the order opened at the price. Stop distance is a fraction of the
+// price, zero if the entry has no stop, fixed is the trading quantity. Update is called with every candle.
+type PositionSizer interface {
+	Update(candle domain.Candle)
+	Size(side domain.OrderType, pair string, price, stopDistance float64, fixed int) (int, error)
+}
+
 type OrderNotifier interface {
 	NotifyUsers(message string)
+	NotifyError(message string)
+}
+
+// EventPublisher must not block, events are published from the candles processing loop
+type EventPublisher interface {
+	Publish(e stream.Event)
+}
+
+// Journal records what the processor sees and decides, e.g. pkg/journal.Journal
+type Journal interface {
+	Record(recordType, pair string, data interface{})
+}
+
+// strategyEvaluation is the journal record of the strategy state of a candle and the decision taken on it
+type strategyEvaluation struct {
+	Candle   time.Time                 `json:"candle"`
+	Price    float64                   `json:"price"`
+	Long     bool                      `json:"long"`
+	Short    bool                      `json:"short"`
+	Position int                       `json:"position"`
+	Action   string                    `json:"action,omitempty"`
+	Side     string                    `json:"side,omitempty"`
+	States   []indicator.StrategyState `json:"states,omitempty"`
 }
 
 type CandlesGenerator interface {
 	GenerateCandles(ctx context.Context, wg *sync.WaitGroup) <-chan domain.Candle
 }
 
-func NewOrdersProcessor(s indicator.Strategy, r Repository, c OrdersSenderPricesGetter, n OrderNotifier, l *log.Logger) *OrdersProcessor {
+func NewOrdersProcessor(s indicator.Strategy, r Repository, c OrdersSenderPricesGetter, n OrderNotifier, e EventPublisher, l *log.Logger) *OrdersProcessor {
 	return &OrdersProcessor{
 		strategy:   s,
 		repo:       r,
 		controller: c,
+		sender:     c,
 		notifier:   n,
+		events:     e,
 		logger:     l,
+		detector:   signals.NewDetector(),
 
 		TradingQuantity: 100,
+		retryDelay:      defaultRetryDelay,
+		signals:         make(map[string]time.Time),
+		protective:      make(map[string][]domain.Order),
+		protection:      Protection{TriggerSignal: domain.MarkPriceTrigger},
 	}
 }
 
 // StartTradingBotProcessor should be started in goroutine
 func (p *OrdersProcessor) StartTradingBotProcessor(ctx context.Context, wg *sync.WaitGroup) {
-	prices := p.controller.GetPrices(ctx)
+	prices := p.publishTrades(p.controller.GetPrices(ctx))
 	wg.Add(1)
 	candles := domain.GenerateCandles(prices, config.GetPeriod(), wg)
 	wg.Add(1)
 	go p.processCandles(candles, wg)
 }
 
+func (p *OrdersProcessor) publishTrades(in <-chan domain.Price) <-chan domain.Price {
+	out := make(chan domain.Price)
+	go func() {
+		defer close(out)
+		for price := range in {
+			p.record(journal.PriceRecord, price.ProductID, price)
+			p.events.Publish(stream.NewEvent(stream.TradeEvent, price.ProductID, price))
+			out <- price
+		}
+	}()
+	return out
+}
+
 func (p *OrdersProcessor) processCandles(candles <-chan domain.Candle, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for candle := range candles {
-		p.logger.Trace(candle)
+		p.ProcessCandle(candle)
+	}
+	p.logger.Info("Candles processing done")
+}
+
+// ProcessCandle evaluates the strategy on the closed candle and executes the decision. Candles of the running
+// processor are processed in order, replay calls it directly to process candles in lockstep with trades.
+func (p *OrdersProcessor) ProcessCandle(candle domain.Candle) {
+	p.haltMu.Lock()
+	defer p.haltMu.Unlock()
+	if p.halted {
+		p.logger.Warnf("Trading is halted, candle %s %s is skipped", candle.Ticker, candle.TS)
+		return
+	}
+
+	p.logger.Trace(candle)
+	p.stateMu.Lock()
+	p.lastCandle = candle.TS
+	p.stateMu.Unlock()
+	p.record(journal.CandleRecord, candle.Ticker, candle)
+	p.events.Publish(stream.NewEvent(stream.CandleEvent, candle.Ticker, candle))
+	metrics.Candles.WithLabelValues(candle.Ticker).Inc()
+
+	var (
+		orderInfo domain.CreateOrderResponse
+		err       error
+		price     = candle.Close
+	)
+
+	if p.trailer != nil {
+		if exit := p.trailer.Update(candle); exit.Status != "" {
+			p.cancelProtective(candle.Ticker)
+			p.recordOrder(exit)
+		}
+	}
+	if p.sizer != nil {
+		p.sizer.Update(candle)
+	}
+
+	// signals are read with the update, so they are not mixed with a concurrent restore of the strategy
+	p.strategyMu.Lock()
+	p.strategy.Update(price)
+	long := p.strategy.Long()
+	short := !long && p.strategy.Short()
+	p.strategyMu.Unlock()
+
+	position := p.GetPosition()
+	decision := p.detector.Detect(candle.Ticker, candle.TS, long, short, position)
+	if p.journal != nil {
+		p.journal.Record(journal.StrategyRecord, candle.Ticker, strategyEvaluation{
+			Candle:   candle.TS,
+			Price:    price,
+			Long:     long,
+			Short:    short,
+			Position: position,
+			Action:   string(decision.Action),
+			Side:     string(decision.Side),
+			States:   p.GetStrategyState(),
+		})
+	}
+	if decision.Action != signals.NoAction {
+		p.events.Publish(stream.NewEvent(stream.SignalEvent, candle.Ticker, stream.Signal{
+			Side:   string(decision.Side),
+			Action: string(decision.Action),
+			Price:  price,
+		}))
+		metrics.Signals.WithLabelValues(candle.Ticker, string(decision.Side)).Inc()
+		orderInfo, err = p.executeSignal(decision, candle, price)
+	}
+
+	if err != nil {
+		p.logger.Error(err)
+		p.notifier.NotifyError(err.Error())
+		return
+	}
+
+	p.recordOrder(orderInfo)
+	if orderInfo.Status == domain.PlacedStatus && orderInfo.OrderEventType == domain.ExecutionEvent {
+		p.protect(candle, domain.SignalID(candle.Ticker, candle.TS, domain.OrderType(orderInfo.Side)))
+	}
+}
+
+// Halt stops trading: it waits for the candle being processed and skips the next candles until Resume is called,
+// e.g. while orders are cancelled and positions are flattened before shutdown
+func (p *OrdersProcessor) Halt() {
+	p.haltMu.Lock()
+	defer p.haltMu.Unlock()
+	p.halted = true
+	p.logger.Info("Trading halted")
+}
+
+// Resume resumes trading halted by Halt
+func (p *OrdersProcessor) Resume() {
+	p.haltMu.Lock()
+	defer p.haltMu.Unlock()
+	p.halted = false
+	p.logger.Info("Trading resumed")
+}
 
-		var (
-			orderInfo domain.CreateOrderResponse
-			err       error
-			price     = candle.Close
-		)
+// recordOrder updates position with executed order, publishes, stores and notifies about placed order
+func (p *OrdersProcessor) recordOrder(orderInfo domain.CreateOrderResponse) {
+	if orderInfo.Status != "" {
+		metrics.Orders.WithLabelValues(orderInfo.Symbol, orderInfo.Side, orderInfo.Status).Inc()
+	}
+	if orderInfo.Status != domain.PlacedStatus {
+		return
+	}
 
-		// TODO: add stop-loss/take-profit
+	p.updatePosition(orderInfo)
+	p.events.Publish(stream.NewEvent(stream.OrderEvent, orderInfo.Symbol, orderInfo))
+	if orderInfo.OrderEventType == domain.ExecutionEvent {
+		p.events.Publish(stream.NewEvent(stream.FillEvent, orderInfo.Symbol, orderInfo))
+	}
+	err := p.repo.StoreToDB(context.Background(), orderInfo)
+	if err != nil {
+		p.logger.Error(err)
+		p.notifier.NotifyError(err.Error())
+	}
+	p.notifier.NotifyUsers(orderInfo.String())
+	p.logger.Infof("Created new order: id = %v, price = %v", orderInfo.OrderID, orderInfo.LimitPrice)
+}
 
-		p.strategy.Update(price)
+// executeSignal places order of the signal decision of the candle unless the signal was executed before, e.g. the candle
+// is processed again after restart. Empty response is returned for skipped signals.
+func (p *OrdersProcessor) executeSignal(decision signals.Decision, candle domain.Candle, price float64) (domain.CreateOrderResponse, error) {
+	signalID := domain.SignalID(candle.Ticker, candle.TS, decision.Side)
+	if p.signalExecuted(signalID) {
+		p.logger.Warnf("Signal %s is already executed, order skipped", signalID)
+		return domain.CreateOrderResponse{}, nil
+	}
 
-		if p.strategy.Long() {
-			price *= 1.0 + p.GetPriceMultiplier()
-			orderInfo, err = p.controller.CreateOrder(domain.CreateIocOrder(domain.BuyOrder, candle.Ticker, price, p.GetTradingQuantity()))
-		} else if p.strategy.Short() {
-			price *= 1.0 - p.GetPriceMultiplier()
-			orderInfo, err = p.controller.CreateOrder(domain.CreateIocOrder(domain.SellOrder, candle.Ticker, price, p.GetTradingQuantity()))
+	size, err := p.signalSize(decision, candle.Ticker, price)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	resp, err := p.placeOrder(decision.Side, candle.Ticker, price, size, signalID)
+	// duplicate means the order of the signal reached exchange before and is resting or executed, orders that
+	// were not executed are reported with domain.ErrNotExecuted and leave the signal to be executed again
+	if err == nil || errors.Is(err, domain.ErrDuplicateOrder) {
+		p.addSignal(signalID, candle.TS)
+		p.detector.Record(candle.Ticker, candle.TS, decision)
+	}
+	return resp, err
+}
+
+// signalSize returns size of the decision order: exit closes the position, reverse closes it and opens
+// a new one of the order size, entries and adds are of the order size
+func (p *OrdersProcessor) signalSize(decision signals.Decision, pair string, price float64) (int, error) {
+	position := p.GetPosition()
+	if position < 0 {
+		position = -position
+	}
+	if decision.Action == signals.Exit {
+		return position, nil
+	}
+	size, err := p.orderSize(decision.Side, pair, price)
+	if err != nil {
+		return 0, err
+	}
+	if decision.Action == signals.Reverse {
+		size += position
+	}
+	return size, nil
+}
+
+func (p *OrdersProcessor) signalExecuted(signalID string) bool {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	_, ok := p.signals[signalID]
+	return ok
+}
+
+// addSignal remembers executed signal and forgets signals older than SignalRetention
+func (p *OrdersProcessor) addSignal(signalID string, candle time.Time) {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	p.signals[signalID] = candle
+	for id, ts := range p.signals {
+		if candle.Sub(ts) > SignalRetention {
+			delete(p.signals, id)
 		}
+	}
+}
+
+// placeOrder sends ioc order of the size and handles exchange errors: order is resized if funds are insufficient,
+// repriced with wider multiplier if it would not execute and resent after delay if rate limited.
+// Other errors are returned at once. Every attempt has its own client order ID derived from the signal ID,
+// so exchange rejects duplicates of an attempt.
+func (p *OrdersProcessor) placeOrder(side domain.OrderType, pair string, price float64, size int, signalID string) (domain.CreateOrderResponse, error) {
+	var (
+		err        error
+		multiplier = p.GetPriceMultiplier()
+		spread     = multiplier
+	)
+	for attempt := 1; attempt <= MaxOrderAttempts; attempt++ {
+		limitPrice := price * (1.0 + spread)
+		if side == domain.SellOrder {
+			limitPrice = price * (1.0 - spread)
+		}
+
+		order := domain.CreateIocOrder(side, pair, limitPrice, size)
+		order.CliOrdID = domain.ClientOrderID(signalID, attempt)
+
+		var resp domain.CreateOrderResponse
+		resp, err = p.sender.CreateOrder(order)
+		if err == nil {
+			return resp, nil
+		}
+		metrics.Orders.WithLabelValues(pair, string(side), OrderErrorStatus).Inc()
+
+		switch {
+		case errors.Is(err, domain.ErrInsufficientFunds) && size > 1:
+			size /= 2
+			p.logger.Warnf("Order attempt %d: %s, resize to %d", attempt, err, size)
+		case errors.Is(err, domain.ErrWouldNotExecute) && multiplier > 0:
+			spread += multiplier
+			p.logger.Warnf("Order attempt %d: %s, reprice with %g multiplier", attempt, err, spread)
+		case errors.Is(err, domain.ErrNotExecuted):
+			p.logger.Warnf("Order attempt %d: %s, retry with new client order ID", attempt, err)
+		case errors.Is(err, domain.ErrRateLimited):
+			p.logger.Warnf("Order attempt %d: %s, retry in %s", attempt, err, p.retryDelay)
+			time.Sleep(p.retryDelay)
+		default:
+			return domain.CreateOrderResponse{}, err
+		}
+	}
+	return domain.CreateOrderResponse{}, fmt.Errorf("order not placed after %d attempts: %w", MaxOrderAttempts, err)
+}
+
+// orderSize returns size of the signal order, it is the trading quantity unless the sizer is set
+func (p *OrdersProcessor) orderSize(side domain.OrderType, pair string, price float64) (int, error) {
+	quantity := p.GetTradingQuantity()
+	if p.sizer == nil {
+		return quantity, nil
+	}
+	return p.sizer.Size(side, pair, price, p.stopDistance(pair, price), quantity)
+}
+
+// stopDistance returns distance of the stop of an entry at the price as a fraction of the price,
+// zero if entries have no stop
+func (p *OrdersProcessor) stopDistance(pair string, price float64) float64 {
+	if p.trailer != nil && p.trailer.Enabled() {
+		return p.trailer.StopDistance(pair, price)
+	}
+	return p.GetProtection().StopLoss
+}
+
+// protect replaces protective orders of the pair with reduce-only stop loss and take profit orders closing
+// the position, their prices are derived from the candle close price. Stop loss is trailed by the trailer
+// if it is enabled. Errors are reported, but do not stop processing.
+func (p *OrdersProcessor) protect(candle domain.Candle, signalID string) {
+	pair, price := candle.Ticker, candle.Close
+	p.cancelProtective(pair)
+
+	protection := p.GetProtection()
+	position := p.GetPosition()
+	trailing := p.trailer != nil && p.trailer.Enabled() && position != 0
+	if p.trailer != nil && !trailing {
+		p.trailer.Cancel(pair)
+	}
+	if position == 0 {
+		return
+	}
+
+	side, size, direction := domain.SellOrder, position, 1.0
+	if position < 0 {
+		side, size, direction = domain.BuyOrder, -position, -1.0
+	}
+
+	orders := make([]domain.Order, 0, 2)
+	if trailing {
+		p.trailer.Start(signalID, domain.CreateStopOrder(side, pair, 0, size, protection.TriggerSignal), price, candle.TS)
+	} else if protection.StopLoss > 0 {
+		stopPrice := price * (1 - direction*protection.StopLoss)
+		orders = append(orders, domain.CreateStopOrder(side, pair, stopPrice, size, protection.TriggerSignal))
+	}
+	if protection.TakeProfit > 0 {
+		stopPrice := price * (1 + direction*protection.TakeProfit)
+		orders = append(orders, domain.CreateTakeProfitOrder(side, pair, stopPrice, size, protection.TriggerSignal))
+	}
 
+	placed := make([]domain.Order, 0, len(orders))
+	for _, order := range orders {
+		order.CliOrdID = domain.ProtectiveOrderID(signalID, order.OrderType)
+		resp, err := p.sender.CreateOrder(order)
 		if err != nil {
+			metrics.Orders.WithLabelValues(pair, string(side), OrderErrorStatus).Inc()
+			err = fmt.Errorf("place %s order: %w", order.OrderType, err)
 			p.logger.Error(err)
+			p.notifier.NotifyError(err.Error())
+			continue
+		}
+		metrics.Orders.WithLabelValues(resp.Symbol, resp.Side, resp.Status).Inc()
+		if resp.Status != domain.PlacedStatus {
+			p.logger.Warnf("Protective %s order is not placed: %s", order.OrderType, resp.Status)
 			continue
 		}
+		p.events.Publish(stream.NewEvent(stream.OrderEvent, resp.Symbol, resp))
+		p.logger.Infof("Placed %s order: id = %v, stop price = %v", order.OrderType, resp.OrderID, order.StopPrice)
+		order.OrderID = resp.OrderID
+		placed = append(placed, order)
+	}
 
-		if orderInfo.Status == "placed" {
-			err = p.repo.StoreToDB(context.Background(), orderInfo)
-			if err != nil {
-				p.logger.Error(err)
-			}
-			p.notifier.NotifyUsers(orderInfo.String())
-			p.logger.Infof("Created new order: id = %v, price = %v", orderInfo.OrderID, price)
+	p.stateMu.Lock()
+	p.protective[pair] = placed
+	p.stateMu.Unlock()
+}
+
+// cancelProtective cancels protective orders of the pair, orders already triggered are not found and skipped
+func (p *OrdersProcessor) cancelProtective(pair string) {
+	p.stateMu.Lock()
+	orders := p.protective[pair]
+	delete(p.protective, pair)
+	p.stateMu.Unlock()
+
+	for _, order := range orders {
+		err := p.controller.CancelOrder(order)
+		if err != nil && !errors.Is(err, domain.ErrOrderNotFound) {
+			err = fmt.Errorf("cancel %s order %s: %w", order.OrderType, order.OrderID, err)
+			p.logger.Error(err)
+			p.notifier.NotifyError(err.Error())
 		}
 	}
-	p.logger.Info("Candles processing done")
+}
+
+// GetProtectiveOrders returns protective orders of the pair placed after the last entry
+func (p *OrdersProcessor) GetProtectiveOrders(pair string) []domain.Order {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	return append([]domain.Order(nil), p.protective[pair]...)
+}
+
+// SetTrailer makes processor trail stops of entries with t, it should be called before the processor is started
+func (p *OrdersProcessor) SetTrailer(t StopTrailer) {
+	p.trailer = t
+}
+
+// SetJournal makes processor record prices, candles and strategy evaluations to j,
+// it should be called before the processor is started
+func (p *OrdersProcessor) SetJournal(j Journal) {
+	p.journal = j
+}
+
+func (p *OrdersProcessor) record(recordType, pair string, data interface{}) {
+	if p.journal != nil {
+		p.journal.Record(recordType, pair, data)
+	}
+}
+
+// SetSignalSettings sets cooldown between signal actions and the max number of adds to a position
+func (p *OrdersProcessor) SetSignalSettings(s signals.Settings) {
+	p.detector.SetSettings(s)
+}
+
+func (p *OrdersProcessor) GetSignalSettings() signals.Settings {
+	return p.detector.GetSettings()
+}
+
+// SetSizer makes processor size orders with s, it should be called before the processor is started
+func (p *OrdersProcessor) SetSizer(s PositionSizer) {
+	p.sizer = s
+}
+
+// SetOrderSender makes processor send orders with s, e.g. with orders manager tracking them.
+// It should be called before the processor is started.
+func (p *OrdersProcessor) SetOrderSender(s OrderSender) {
+	p.sender = s
 }
 
 func (p *OrdersProcessor) SetPriceMultiplier(m float64) {
@@ -114,6 +561,22 @@ func (p *OrdersProcessor) SetTradingQuantity(q int) {
 	p.TradingQuantity = q
 }
 
+// SetProtection sets protective orders attached to next entries, empty trigger signal means mark price
+func (p *OrdersProcessor) SetProtection(protection Protection) {
+	if protection.TriggerSignal == "" {
+		protection.TriggerSignal = domain.MarkPriceTrigger
+	}
+	p.protectionMu.Lock()
+	defer p.protectionMu.Unlock()
+	p.protection = protection
+}
+
+func (p *OrdersProcessor) GetProtection() Protection {
+	p.protectionMu.RLock()
+	defer p.protectionMu.RUnlock()
+	return p.protection
+}
+
 func (p *OrdersProcessor) GetPriceMultiplier() float64 {
 	p.priceMu.RLock()
 	defer p.priceMu.RUnlock()
@@ -125,3 +588,129 @@ func (p *OrdersProcessor) GetTradingQuantity() int {
 	defer p.quantityMu.RUnlock()
 	return p.TradingQuantity
 }
+
+// updatePosition adds executed size of the order response to the position, orders resting on exchange change
+// the position once their fills are applied
+func (p *OrdersProcessor) updatePosition(r domain.CreateOrderResponse) {
+	if r.OrderEventType != domain.ExecutionEvent {
+		return
+	}
+	p.changePosition(domain.OrderType(r.Side), int(math.Round(r.FilledSize)))
+}
+
+// ApplyFill applies fill of the resting order to the position, e.g. of a stop loss triggered on exchange.
+// It is called by orders manager polling the exchange, the position is in whole contracts.
+func (p *OrdersProcessor) ApplyFill(pair string, side domain.OrderType, size float64) {
+	position := p.changePosition(side, int(math.Round(size)))
+	p.logger.Infof("Fill of %s %g %s applied, position %d", side, size, pair, position)
+}
+
+// changePosition changes the position by the order size of the side and returns the new position
+func (p *OrdersProcessor) changePosition(side domain.OrderType, size int) int {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	switch side {
+	case domain.BuyOrder:
+		p.position += size
+	case domain.SellOrder:
+		p.position -= size
+	}
+	return p.position
+}
+
+// GetPosition returns net position in contracts opened by the processor, positive for long
+func (p *OrdersProcessor) GetPosition() int {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	return p.position
+}
+
+// GetLastCandleTime returns start time of the last processed candle
+func (p *OrdersProcessor) GetLastCandleTime() time.Time {
+	p.stateMu.RLock()
+	defer p.stateMu.RUnlock()
+	return p.lastCandle
+}
+
+// GetStrategyState returns indicator values of the strategy if it can describe them
+func (p *OrdersProcessor) GetStrategyState() []indicator.StrategyState {
+	if d, ok := p.strategy.(indicator.Describer); ok {
+		p.strategyMu.Lock()
+		defer p.strategyMu.Unlock()
+		return d.Describe()
+	}
+	return []indicator.StrategyState{}
+}
+
+// Snapshot is the processor state persisted between restarts
+type Snapshot struct {
+	Position        int                          `json:"position"`
+	TradingQuantity int                          `json:"trading_quantity"`
+	PriceMultiplier float64                      `json:"price_multiplier"`
+	LastCandle      time.Time                    `json:"last_candle"`
+	Signals         map[string]time.Time         `json:"signals,omitempty"` // executed signals
+	Protective      map[string][]domain.Order    `json:"protective,omitempty"`
+	Strategy        []indicator.StrategySnapshot `json:"strategy,omitempty"`
+	Detector        signals.Snapshot             `json:"detector"`
+}
+
+// Snapshot returns position, runtime settings and strategy state, strategy state is empty
+// if the strategy is not a persister
+func (p *OrdersProcessor) Snapshot() Snapshot {
+	s := Snapshot{
+		Position:        p.GetPosition(),
+		TradingQuantity: p.GetTradingQuantity(),
+		PriceMultiplier: p.GetPriceMultiplier(),
+		LastCandle:      p.GetLastCandleTime(),
+		Signals:         make(map[string]time.Time),
+		Protective:      make(map[string][]domain.Order),
+		Detector:        p.detector.Snapshot(),
+	}
+	p.stateMu.RLock()
+	for id, ts := range p.signals {
+		s.Signals[id] = ts
+	}
+	for pair, orders := range p.protective {
+		s.Protective[pair] = append([]domain.Order(nil), orders...)
+	}
+	p.stateMu.RUnlock()
+	if persister, ok := p.strategy.(indicator.Persister); ok {
+		p.strategyMu.Lock()
+		s.Strategy = persister.Snapshot()
+		p.strategyMu.Unlock()
+	}
+	return s
+}
+
+// Restore applies the snapshot, it should be called before the processor is started. Position and settings
+// are restored even if strategy state does not match the strategy, in which case error is returned.
+func (p *OrdersProcessor) Restore(s Snapshot) error {
+	p.stateMu.Lock()
+	p.position = s.Position
+	p.lastCandle = s.LastCandle
+	for id, ts := range s.Signals {
+		p.signals[id] = ts
+	}
+	for pair, orders := range s.Protective {
+		p.protective[pair] = orders
+	}
+	p.stateMu.Unlock()
+	p.detector.Restore(s.Detector)
+	if s.TradingQuantity > 0 {
+		p.SetTradingQuantity(s.TradingQuantity)
+	}
+	if s.PriceMultiplier >= 0 {
+		p.SetPriceMultiplier(s.PriceMultiplier)
+	}
+
+	persister, ok := p.strategy.(indicator.Persister)
+	if !ok || len(s.Strategy) == 0 {
+		return nil
+	}
+	p.strategyMu.Lock()
+	defer p.strategyMu.Unlock()
+	if err := persister.Restore(s.Strategy); err != nil {
+		return fmt.Errorf("restore strategy: %w", err)
+	}
+	return nil
+}
@@ -3,11 +3,19 @@ package processor
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/signals"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
@@ -30,11 +38,53 @@ func (c *OrdersSenderPricesGetterMock) CreateOrder(order domain.Order) (response
 	return args.Get(0).(domain.CreateOrderResponse), args.Error(1)
 }
 
+func (c *OrdersSenderPricesGetterMock) CancelOrder(order domain.Order) error {
+	args := c.Called(order)
+	return args.Error(0)
+}
+
 func (c *OrdersSenderPricesGetterMock) GetPrices(ctx context.Context) <-chan domain.Price {
 	c.Called(ctx)
 	return nil
 }
 
+type TrailerMock struct {
+	mock.Mock
+}
+
+func (t *TrailerMock) Enabled() bool {
+	return t.Called().Bool(0)
+}
+
+func (t *TrailerMock) Start(signalID string, stop domain.Order, entry float64, ts time.Time) {
+	t.Called(signalID, stop, entry, ts)
+}
+
+func (t *TrailerMock) Update(candle domain.Candle) domain.CreateOrderResponse {
+	return t.Called(candle).Get(0).(domain.CreateOrderResponse)
+}
+
+func (t *TrailerMock) Cancel(pair string) {
+	t.Called(pair)
+}
+
+func (t *TrailerMock) StopDistance(pair string, price float64) float64 {
+	return t.Called(pair, price).Get(0).(float64)
+}
+
+type SizerMock struct {
+	mock.Mock
+}
+
+func (s *SizerMock) Update(candle domain.Candle) {
+	s.Called(candle)
+}
+
+func (s *SizerMock) Size(side domain.OrderType, pair string, price, stopDistance float64, fixed int) (int, error) {
+	args := s.Called(side, pair, price, stopDistance, fixed)
+	return args.Int(0), args.Error(1)
+}
+
 type StrategyMock struct {
 	mock.Mock
 }
@@ -61,6 +111,46 @@ func (n *NotifierMock) NotifyUsers(message string) {
 	n.Called(message)
 }
 
+func (n *NotifierMock) NotifyError(message string) {
+	n.Called(message)
+}
+
+type PublisherStub struct {
+	mu     sync.Mutex
+	events []stream.Event
+}
+
+func (p *PublisherStub) Publish(e stream.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, e)
+}
+
+func (p *PublisherStub) count(t stream.EventType) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, e := range p.events {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+type JournalStub struct {
+	mu      sync.Mutex
+	types   []string
+	records []interface{}
+}
+
+func (j *JournalStub) Record(recordType, _ string, data interface{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.types = append(j.types, recordType)
+	j.records = append(j.records, data)
+}
+
 type Environment struct {
 	suite.Suite
 	repo       *RepoMock
@@ -93,30 +183,43 @@ var validResponse = domain.CreateOrderResponse{
 	Status:       "placed",
 	OrderID:      "8dcdbe17-b729-4fef-8b89-36e561535f38",
 	ReceivedTime: "2021-11-25T19:05:03.670Z",
+
+	OrderEventType: domain.ExecutionEvent,
+	FilledSize:     100,
 }
 
 func (e *Environment) TestProcessor() {
 	logger := log.NewLogger()
 	logger.SetLevel(0) // set panic level to prevent output spam
-	processor := NewOrdersProcessor(e.strategy, e.repo, e.controller, e.notifier, logger)
+	publisher := &PublisherStub{}
+	processor := NewOrdersProcessor(e.strategy, e.repo, e.controller, e.notifier, publisher, logger)
+	response := func(side domain.OrderType, size int) domain.CreateOrderResponse {
+		r := validResponse
+		r.Side, r.Size = string(side), size
+		return r
+	}
 
 	testID := 0
-	e.T().Logf("\tTest %d:\tprocessor all success long", testID)
+	e.T().Logf("\tTest %d:\tprocessor all success long entry", testID)
 	{
 		e.strategy.On("Update", mock.Anything).Once()
 		e.strategy.On("Long").Return(true).Once()
-		e.controller.On("CreateOrder", mock.Anything).Return(validResponse, nil).Once()
+		e.controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.CliOrdID == "TEST-1637866800-buy-1" && o.Size == 100
+		})).Return(response(domain.BuyOrder, 100), nil).Once()
 		e.repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil).Once()
 		e.notifier.On("NotifyUsers", mock.Anything).Return().Once()
 	}
 
 	testID++
-	e.T().Logf("\tTest %d:\tprocessor all success short", testID)
+	e.T().Logf("\tTest %d:\tprocessor all success reversal to short", testID)
 	{
 		e.strategy.On("Update", mock.Anything).Once()
 		e.strategy.On("Long").Return(false).Once()
 		e.strategy.On("Short").Return(true).Once()
-		e.controller.On("CreateOrder", mock.Anything).Return(validResponse, nil).Once()
+		e.controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.Side == "sell" && o.Size == 200
+		})).Return(response(domain.SellOrder, 200), nil).Once()
 		e.repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil).Once()
 		e.notifier.On("NotifyUsers", mock.Anything).Return().Once()
 	}
@@ -127,6 +230,7 @@ func (e *Environment) TestProcessor() {
 		e.strategy.On("Update", mock.Anything).Once()
 		e.strategy.On("Long").Return(true).Once()
 		e.controller.On("CreateOrder", mock.Anything).Return(domain.CreateOrderResponse{}, errors.New("CreateOrder failed")).Once()
+		e.notifier.On("NotifyError", "CreateOrder failed").Return().Once()
 	}
 
 	testID++
@@ -134,12 +238,28 @@ func (e *Environment) TestProcessor() {
 	{
 		e.strategy.On("Update", mock.Anything).Once()
 		e.strategy.On("Long").Return(true).Once()
-		e.controller.On("CreateOrder", mock.Anything).Return(validResponse, nil).Once()
+		e.controller.On("CreateOrder", mock.Anything).Return(response(domain.BuyOrder, 200), nil).Once()
 		e.repo.On("StoreToDB", mock.Anything, mock.Anything).Return(errors.New("store error")).Once()
+		e.notifier.On("NotifyError", "store error").Return().Once()
 		e.notifier.On("NotifyUsers", mock.Anything).Return().Once()
 	}
 
-	candles := []domain.Candle{{Close: 4, Ticker: "TEST"}, {Close: 5, Ticker: "TEST"}, {Close: 8, Ticker: "TEST"}, {Close: 10, Ticker: "TEST"}}
+	testID++
+	e.T().Logf("\tTest %d:\trepeated long signal does not add to long position", testID)
+	{
+		e.strategy.On("Update", mock.Anything).Times(2)
+		e.strategy.On("Long").Return(true).Times(2)
+	}
+
+	start := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+	candles := []domain.Candle{
+		{Close: 4, Ticker: "TEST", TS: start},
+		{Close: 5, Ticker: "TEST", TS: start.Add(time.Minute)},
+		{Close: 8, Ticker: "TEST", TS: start.Add(2 * time.Minute)},
+		{Close: 10, Ticker: "TEST", TS: start.Add(3 * time.Minute)},
+		{Close: 10, Ticker: "TEST", TS: start.Add(3 * time.Minute)},
+		{Close: 11, Ticker: "TEST", TS: start.Add(4 * time.Minute)},
+	}
 	out := make(chan domain.Candle)
 	go func() {
 		defer close(out)
@@ -151,8 +271,572 @@ func (e *Environment) TestProcessor() {
 	wg.Add(1)
 	go processor.processCandles(out, &wg)
 	wg.Wait()
+
+	e.Equal(100, processor.GetPosition())
+	e.Equal(6, publisher.count(stream.CandleEvent))
+	e.Equal(4, publisher.count(stream.SignalEvent))
+	e.Equal(3, publisher.count(stream.OrderEvent))
+	e.Equal(3, publisher.count(stream.FillEvent))
 }
 
 func TestOrdersProcessor_ProcessCandles(t *testing.T) {
 	suite.Run(t, new(Environment))
 }
+
+func TestOrdersProcessor_PlaceOrder(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	newProcessor := func(c *OrdersSenderPricesGetterMock) *OrdersProcessor {
+		p := NewOrdersProcessor(nil, nil, c, nil, &PublisherStub{}, logger)
+		p.retryDelay = 0
+		return p
+	}
+	rejected := func(kind error) error {
+		return fmt.Errorf("sendorder: %w", kind)
+	}
+	const signalID = "TEST-1637866800-buy"
+	enterLong := signals.Decision{Action: signals.Enter, Side: domain.BuyOrder}
+	iocOrder := func(side domain.OrderType, price float64, quantity, attempt int) domain.Order {
+		order := domain.CreateIocOrder(side, "TEST", price, quantity)
+		order.CliOrdID = domain.ClientOrderID(signalID, attempt)
+		return order
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\tresize on insufficient funds", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrInsufficientFunds)).Once()
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 50, 2)).Return(validResponse, nil).Once()
+		resp, err := newProcessor(c).placeOrder(domain.BuyOrder, "TEST", 10, 100, signalID)
+		a.NoError(err)
+		a.Equal(validResponse, resp)
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\treprice when ioc would not execute", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		p := newProcessor(c)
+		p.SetPriceMultiplier(0.1)
+		c.On("CreateOrder", iocOrder(domain.SellOrder, 90, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrWouldNotExecute)).Once()
+		c.On("CreateOrder", iocOrder(domain.SellOrder, 80, 100, 2)).Return(validResponse, nil).Once()
+		_, err := p.placeOrder(domain.SellOrder, "TEST", 100, 100, signalID)
+		a.NoError(err)
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tgive up after max attempts", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		c.On("CreateOrder", mock.Anything).Return(domain.CreateOrderResponse{}, rejected(domain.ErrRateLimited)).Times(MaxOrderAttempts)
+		_, err := newProcessor(c).placeOrder(domain.BuyOrder, "TEST", 10, 100, signalID)
+		a.ErrorIs(err, domain.ErrRateLimited)
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tgive up at once on invalid size", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		c.On("CreateOrder", mock.Anything).Return(domain.CreateOrderResponse{}, rejected(domain.ErrInvalidSize)).Once()
+		_, err := newProcessor(c).placeOrder(domain.BuyOrder, "TEST", 10, 100, signalID)
+		a.ErrorIs(err, domain.ErrInvalidSize)
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tno reprice without multiplier", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		c.On("CreateOrder", mock.Anything).Return(domain.CreateOrderResponse{}, rejected(domain.ErrWouldNotExecute)).Once()
+		_, err := newProcessor(c).placeOrder(domain.BuyOrder, "TEST", 10, 100, signalID)
+		a.ErrorIs(err, domain.ErrWouldNotExecute)
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tduplicate order marks signal executed", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		p := newProcessor(c)
+		candle := domain.Candle{Ticker: "TEST", TS: time.Unix(1637866800, 0)}
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrDuplicateOrder)).Once()
+		_, err := p.executeSignal(enterLong, candle, 10)
+		a.ErrorIs(err, domain.ErrDuplicateOrder)
+
+		resp, err := p.executeSignal(enterLong, candle, 10)
+		a.NoError(err)
+		a.Empty(resp.Status, "Signal should be skipped")
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tduplicate order that is not executed is sent with new client order ID", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		p := newProcessor(c)
+		candle := domain.Candle{Ticker: "TEST", TS: time.Unix(1637866800, 0)}
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrNotExecuted)).Once()
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 2)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrInvalidSize)).Once()
+		_, err := p.executeSignal(enterLong, candle, 10)
+		a.ErrorIs(err, domain.ErrInvalidSize)
+		a.False(p.signalExecuted(signalID), "Signal should not be used up by order that is not executed")
+
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrNotExecuted)).Once()
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 2)).Return(validResponse, nil).Once()
+		_, err = p.executeSignal(enterLong, candle, 10)
+		a.NoError(err)
+		a.True(p.signalExecuted(signalID))
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsignal is executed again if order failed", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		p := newProcessor(c)
+		candle := domain.Candle{Ticker: "TEST", TS: time.Unix(1637866800, 0)}
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(domain.CreateOrderResponse{}, rejected(domain.ErrInvalidSize)).Once()
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 100, 1)).Return(validResponse, nil).Once()
+		_, err := p.executeSignal(enterLong, candle, 10)
+		a.ErrorIs(err, domain.ErrInvalidSize)
+		_, err = p.executeSignal(enterLong, candle, 10)
+		a.NoError(err)
+		a.True(p.signalExecuted(signalID))
+		c.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torder is sized by sizer at stop loss distance", testID)
+	{
+		c := new(OrdersSenderPricesGetterMock)
+		sizer := new(SizerMock)
+		p := newProcessor(c)
+		p.SetSizer(sizer)
+		p.SetProtection(Protection{StopLoss: 0.05})
+		sizer.On("Size", domain.BuyOrder, "TEST", 10.0, 0.05, 100).Return(40, nil).Once()
+		c.On("CreateOrder", iocOrder(domain.BuyOrder, 10, 40, 1)).Return(validResponse, nil).Once()
+		_, err := p.executeSignal(enterLong, domain.Candle{Ticker: "TEST", TS: time.Unix(1637866800, 0)}, 10)
+		a.NoError(err)
+
+		trailer := new(TrailerMock)
+		p.SetTrailer(trailer)
+		trailer.On("Enabled").Return(true)
+		trailer.On("StopDistance", "TEST", 10.0).Return(0.1).Once()
+		sizer.On("Size", domain.BuyOrder, "TEST", 10.0, 0.1, 100).Return(0, rejected(domain.ErrInsufficientFunds)).Once()
+		_, err = p.executeSignal(enterLong, domain.Candle{Ticker: "TEST", TS: time.Unix(1637866860, 0)}, 10)
+		a.ErrorIs(err, domain.ErrInsufficientFunds, "Order exceeding margin should not be sent")
+		c.AssertExpectations(t)
+		sizer.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\treverse and exit orders close the position", testID)
+	{
+		p := newProcessor(nil)
+		p.SetTradingQuantity(10)
+		p.ApplyFill("TEST", domain.SellOrder, 30)
+		size, err := p.signalSize(signals.Decision{Action: signals.Reverse, Side: domain.BuyOrder}, "TEST", 10)
+		a.NoError(err)
+		a.Equal(40, size)
+		size, err = p.signalSize(signals.Decision{Action: signals.Exit, Side: domain.BuyOrder}, "TEST", 10)
+		a.NoError(err)
+		a.Equal(30, size)
+		size, err = p.signalSize(signals.Decision{Action: signals.Add, Side: domain.SellOrder}, "TEST", 10)
+		a.NoError(err)
+		a.Equal(10, size)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\told signals are forgotten", testID)
+	{
+		p := newProcessor(nil)
+		ts := time.Unix(1637866800, 0)
+		p.addSignal("old", ts)
+		p.addSignal("new", ts.Add(SignalRetention+time.Minute))
+		a.False(p.signalExecuted("old"))
+		a.True(p.signalExecuted("new"))
+	}
+}
+
+func TestOrdersProcessor_Snapshot(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	newProcessor := func(s indicator.Strategy) *OrdersProcessor {
+		return NewOrdersProcessor(s, nil, nil, nil, &PublisherStub{}, logger)
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\tstate restored from snapshot", testID)
+	{
+		strategy, _ := indicator.SetupEMAStrategy(10)
+		p := newProcessor(strategy)
+		p.SetTradingQuantity(10)
+		p.SetPriceMultiplier(0.01)
+		p.ApplyFill("TEST", domain.SellOrder, 10)
+		p.lastCandle = time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+		p.addSignal("TEST-1637866800-sell", p.lastCandle)
+		p.protective["TEST"] = []domain.Order{{OrderID: "stp-1", OrderType: domain.StopOrder, Symbol: "TEST"}}
+		strategy.Update(100)
+		strategy.Update(110)
+
+		restoredStrategy, _ := indicator.SetupEMAStrategy(10)
+		restored := newProcessor(restoredStrategy)
+		a.NoError(restored.Restore(p.Snapshot()))
+		a.Equal(p.Snapshot(), restored.Snapshot())
+		a.Equal(-10, restored.GetPosition())
+		a.Equal(10, restored.GetTradingQuantity())
+		a.Equal(0.01, restored.GetPriceMultiplier())
+		a.True(restored.signalExecuted("TEST-1637866800-sell"))
+		a.Equal(p.GetProtectiveOrders("TEST"), restored.GetProtectiveOrders("TEST"))
+		a.Equal(p.GetStrategyState(), restored.GetStrategyState())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tmismatched strategy state", testID)
+	{
+		strategy, _ := indicator.SetupEMAStrategy(10)
+		s := newProcessor(strategy).Snapshot()
+		s.Position = 100
+		s.Strategy = append(s.Strategy, s.Strategy...)
+
+		restoredStrategy, _ := indicator.SetupEMAStrategy(10)
+		restored := newProcessor(restoredStrategy)
+		a.ErrorIs(restored.Restore(s), indicator.ErrSnapshotMismatch)
+		a.Equal(100, restored.GetPosition(), "Position should be restored anyway")
+	}
+}
+
+func TestOrdersProcessor_Protection(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	start := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+	executed := func(side domain.OrderType, size int) domain.CreateOrderResponse {
+		return domain.CreateOrderResponse{
+			Symbol:         "TEST",
+			Side:           string(side),
+			Size:           size,
+			Status:         domain.PlacedStatus,
+			OrderEventType: domain.ExecutionEvent,
+			FilledSize:     float64(size),
+		}
+	}
+	placed := func(id string) domain.CreateOrderResponse {
+		return domain.CreateOrderResponse{Symbol: "TEST", Status: domain.PlacedStatus, OrderID: id}
+	}
+	process := func(p *OrdersProcessor, candles ...domain.Candle) {
+		out := make(chan domain.Candle)
+		go func() {
+			defer close(out)
+			for _, candle := range candles {
+				out <- candle
+			}
+		}()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go p.processCandles(out, &wg)
+		wg.Wait()
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\tstop loss and take profit attached after long entry", testID)
+	{
+		controller, strategy, repo, notifier := new(OrdersSenderPricesGetterMock), new(StrategyMock), new(RepoMock), new(NotifierMock)
+		p := NewOrdersProcessor(strategy, repo, controller, notifier, &PublisherStub{}, logger)
+		p.SetTradingQuantity(10)
+		p.SetProtection(Protection{StopLoss: 0.1, TakeProfit: 0.2})
+		a.Equal(domain.MarkPriceTrigger, p.GetProtection().TriggerSignal, "Mark price should be default trigger")
+
+		strategy.On("Update", mock.Anything)
+		strategy.On("Long").Return(true).Once()
+		repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil)
+		notifier.On("NotifyUsers", mock.Anything).Return()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.IocOrder
+		})).Return(executed(domain.BuyOrder, 10), nil).Once()
+		controller.On("CreateOrder", domain.Order{
+			OrderType:     domain.StopOrder,
+			Symbol:        "TEST",
+			Side:          "sell",
+			Size:          10,
+			StopPrice:     90,
+			TriggerSignal: domain.MarkPriceTrigger,
+			CliOrdID:      "TEST-1637866800-buy-sl",
+			ReduceOnly:    domain.ReduceOnly,
+		}).Return(placed("sl-1"), nil).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.TakeProfitOrder && o.Side == "sell" && o.StopPrice == 120 &&
+				o.CliOrdID == "TEST-1637866800-buy-tp"
+		})).Return(placed("tp-1"), nil).Once()
+
+		process(p, domain.Candle{Close: 100, Ticker: "TEST", TS: start})
+
+		orders := p.GetProtectiveOrders("TEST")
+		a.Len(orders, 2)
+		a.Equal("sl-1", orders[0].OrderID)
+		a.Equal("tp-1", orders[1].OrderID)
+		controller.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tprotective orders replaced after reversal to short", testID)
+	{
+		controller, strategy, repo, notifier := new(OrdersSenderPricesGetterMock), new(StrategyMock), new(RepoMock), new(NotifierMock)
+		p := NewOrdersProcessor(strategy, repo, controller, notifier, &PublisherStub{}, logger)
+		p.SetTradingQuantity(20)
+		p.SetProtection(Protection{StopLoss: 0.1, TriggerSignal: domain.LastPriceTrigger})
+		p.updatePosition(executed(domain.BuyOrder, 10))
+		p.protective["TEST"] = []domain.Order{
+			{OrderID: "sl-1", OrderType: domain.StopOrder, Symbol: "TEST"},
+			{OrderID: "tp-1", OrderType: domain.TakeProfitOrder, Symbol: "TEST"},
+		}
+
+		strategy.On("Update", mock.Anything)
+		strategy.On("Long").Return(false).Once()
+		strategy.On("Short").Return(true).Once()
+		repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil)
+		notifier.On("NotifyUsers", mock.Anything).Return()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.IocOrder && o.Size == 30
+		})).Return(executed(domain.SellOrder, 30), nil).Once()
+		controller.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "sl-1" })).Return(nil).Once()
+		controller.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "tp-1" })).
+			Return(domain.ErrOrderNotFound).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.StopOrder && o.Side == "buy" && o.Size == 20 &&
+				math.Abs(o.StopPrice-110) < 1e-9 && o.TriggerSignal == domain.LastPriceTrigger
+		})).Return(placed("sl-2"), nil).Once()
+
+		process(p, domain.Candle{Close: 100, Ticker: "TEST", TS: start})
+
+		orders := p.GetProtectiveOrders("TEST")
+		a.Len(orders, 1)
+		a.Equal("sl-2", orders[0].OrderID)
+		controller.AssertExpectations(t)
+		notifier.AssertNotCalled(t, "NotifyError", mock.Anything)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tfailed protective order is reported, closed position has no protection", testID)
+	{
+		controller, notifier := new(OrdersSenderPricesGetterMock), new(NotifierMock)
+		p := NewOrdersProcessor(nil, nil, controller, notifier, &PublisherStub{}, logger)
+		p.SetProtection(Protection{StopLoss: 0.1, TakeProfit: 0.1})
+		p.updatePosition(executed(domain.BuyOrder, 10))
+
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.StopOrder
+		})).Return(domain.CreateOrderResponse{}, domain.ErrInvalidPrice).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.TakeProfitOrder
+		})).Return(placed("tp-1"), nil).Once()
+		notifier.On("NotifyError", "place stp order: invalid order price").Return().Once()
+		p.protect(domain.Candle{Ticker: "TEST", Close: 100, TS: start}, "TEST-1637866800-buy")
+		a.Len(p.GetProtectiveOrders("TEST"), 1)
+
+		controller.On("CancelOrder", mock.Anything).Return(nil).Once()
+		p.updatePosition(executed(domain.SellOrder, 10))
+		p.protect(domain.Candle{Ticker: "TEST", Close: 100, TS: start.Add(time.Minute)}, "TEST-1637866860-sell")
+		a.Empty(p.GetProtectiveOrders("TEST"))
+		controller.AssertExpectations(t)
+		notifier.AssertExpectations(t)
+	}
+	testID++
+	t.Logf("\tTest %d:\tstop loss filled on exchange closes position before the next signal", testID)
+	{
+		controller, strategy, repo, notifier := new(OrdersSenderPricesGetterMock), new(StrategyMock), new(RepoMock), new(NotifierMock)
+		p := NewOrdersProcessor(strategy, repo, controller, notifier, &PublisherStub{}, logger)
+		p.SetTradingQuantity(10)
+		p.SetProtection(Protection{StopLoss: 0.1})
+
+		strategy.On("Update", mock.Anything)
+		strategy.On("Long").Return(true).Once()
+		strategy.On("Long").Return(false).Once()
+		strategy.On("Short").Return(true).Once()
+		repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil)
+		notifier.On("NotifyUsers", mock.Anything).Return()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.IocOrder && o.Side == "buy" && o.Size == 10
+		})).Return(executed(domain.BuyOrder, 10), nil).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.StopOrder && o.Side == "sell" && o.Size == 10
+		})).Return(placed("sl-1"), nil).Once()
+
+		process(p, domain.Candle{Close: 100, Ticker: "TEST", TS: start})
+		a.Equal(10, p.GetPosition())
+
+		p.updatePosition(placed("sl-1"))
+		a.Equal(10, p.GetPosition(), "Order resting on exchange should not change position")
+		p.ApplyFill("TEST", domain.SellOrder, 10)
+		a.Zero(p.GetPosition())
+
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.IocOrder && o.Side == "sell" && o.Size == 10
+		})).Return(executed(domain.SellOrder, 10), nil).Once()
+		controller.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "sl-1" })).
+			Return(domain.ErrOrderNotFound).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.StopOrder && o.Side == "buy" && o.Size == 10
+		})).Return(placed("sl-2"), nil).Once()
+
+		process(p, domain.Candle{Close: 90, Ticker: "TEST", TS: start.Add(time.Minute)})
+		a.Equal(-10, p.GetPosition(), "Short should be entered, not reversed from the closed long")
+		controller.AssertExpectations(t)
+		strategy.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\ttrailing stop replaces stop loss, exit of trailing stop closes position", testID)
+	{
+		controller, strategy, repo, notifier := new(OrdersSenderPricesGetterMock), new(StrategyMock), new(RepoMock), new(NotifierMock)
+		trailer := new(TrailerMock)
+		p := NewOrdersProcessor(strategy, repo, controller, notifier, &PublisherStub{}, logger)
+		p.SetTradingQuantity(10)
+		p.SetProtection(Protection{StopLoss: 0.1, TakeProfit: 0.2})
+		p.SetTrailer(trailer)
+
+		strategy.On("Update", mock.Anything)
+		strategy.On("Long").Return(true).Once()
+		strategy.On("Long").Return(false).Once()
+		strategy.On("Short").Return(false).Once()
+		repo.On("StoreToDB", mock.Anything, mock.Anything).Return(nil)
+		notifier.On("NotifyUsers", mock.Anything).Return()
+		trailer.On("Enabled").Return(true)
+		trailer.On("Update", mock.MatchedBy(func(c domain.Candle) bool { return c.TS.Equal(start) })).
+			Return(domain.CreateOrderResponse{}).Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.IocOrder
+		})).Return(executed(domain.BuyOrder, 10), nil).Once()
+		trailer.On("Start", "TEST-1637866800-buy", domain.CreateStopOrder(domain.SellOrder, "TEST", 0, 10, domain.MarkPriceTrigger), 100.0, start).
+			Return().Once()
+		controller.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.TakeProfitOrder
+		})).Return(placed("tp-1"), nil).Once()
+
+		trailer.On("Update", mock.MatchedBy(func(c domain.Candle) bool { return c.TS.After(start) })).
+			Return(executed(domain.SellOrder, 10)).Once()
+		controller.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "tp-1" })).Return(nil).Once()
+
+		process(p, domain.Candle{Close: 100, Ticker: "TEST", TS: start}, domain.Candle{Close: 90, Ticker: "TEST", TS: start.Add(time.Minute)})
+
+		a.Equal(0, p.GetPosition())
+		a.Empty(p.GetProtectiveOrders("TEST"))
+		controller.AssertExpectations(t)
+		trailer.AssertExpectations(t)
+		repo.AssertNumberOfCalls(t, "StoreToDB", 2)
+	}
+}
+
+func TestOrdersProcessor_Journal(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	strategy := new(StrategyMock)
+	p := NewOrdersProcessor(strategy, nil, nil, nil, &PublisherStub{}, logger)
+	j := &JournalStub{}
+	p.SetJournal(j)
+
+	testID := 0
+	t.Logf("\tTest %d:\tcandle and strategy evaluation are recorded", testID)
+	{
+		strategy.On("Update", 100.0).Once()
+		strategy.On("Long").Return(false).Once()
+		strategy.On("Short").Return(false).Once()
+		candle := domain.Candle{Close: 100, Ticker: "TEST", TS: time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)}
+		out := make(chan domain.Candle, 1)
+		out <- candle
+		close(out)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		p.processCandles(out, &wg)
+
+		a.Equal([]string{journal.CandleRecord, journal.StrategyRecord}, j.types)
+		a.Equal(candle, j.records[0])
+		a.Equal(strategyEvaluation{Candle: candle.TS, Price: 100, States: []indicator.StrategyState{}}, j.records[1])
+		strategy.AssertExpectations(t)
+	}
+}
+
+func TestOrdersProcessor_Halt(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	strategy, controller := new(StrategyMock), new(OrdersSenderPricesGetterMock)
+	p := NewOrdersProcessor(strategy, nil, controller, nil, &PublisherStub{}, logger)
+	candle := domain.Candle{Close: 100, Ticker: "TEST", TS: time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)}
+
+	testID := 0
+	t.Logf("\tTest %d:\tcandles are skipped while trading is halted", testID)
+	{
+		p.Halt()
+		p.ProcessCandle(candle)
+		strategy.AssertNotCalled(t, "Update", mock.Anything)
+		controller.AssertNotCalled(t, "CreateOrder", mock.Anything)
+		a.True(p.GetLastCandleTime().IsZero())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcandles are processed after resume", testID)
+	{
+		strategy.On("Update", 100.0).Once()
+		strategy.On("Long").Return(false).Once()
+		strategy.On("Short").Return(false).Once()
+		p.Resume()
+		p.ProcessCandle(candle)
+		a.Equal(candle.TS, p.GetLastCandleTime())
+		strategy.AssertExpectations(t)
+	}
+}
+
+// unsafeStrategy has no synchronization of its own, so the processor must serialize access to it
+type unsafeStrategy struct {
+	last float64
+}
+
+func (s *unsafeStrategy) Update(p float64) {
+	s.last = p
+}
+
+func (s *unsafeStrategy) Long() bool {
+	return s.last > 100
+}
+
+func (s *unsafeStrategy) Short() bool {
+	return s.last < 100
+}
+
+func (s *unsafeStrategy) Describe() []indicator.StrategyState {
+	return []indicator.StrategyState{{Name: "unsafe", Values: map[string]float64{"last": s.last}}}
+}
+
+func TestOrdersProcessor_StrategyAccess(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	p := NewOrdersProcessor(&unsafeStrategy{}, nil, nil, nil, &PublisherStub{}, logger)
+
+	t.Logf("\tTest %d:\tstrategy state is read while candles are processed", 0)
+	{
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				p.ProcessCandle(domain.Candle{Close: 100, Ticker: "TEST", TS: time.Unix(int64(i*60), 0)})
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			a.Len(p.GetStrategyState(), 1)
+		}
+		<-done
+		a.Equal(100.0, p.GetStrategyState()[0].Values["last"])
+	}
+}
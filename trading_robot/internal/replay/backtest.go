@@ -0,0 +1,115 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// Metrics are results of a backtest. PnL and drawdown are in price units per contract, equity starts at zero.
+// Sharpe ratio is the mean of equity changes between candles divided by their standard deviation.
+type Metrics struct {
+	PnL         float64 `json:"pnl"`
+	Sharpe      float64 `json:"sharpe"`
+	MaxDrawdown float64 `json:"max_drawdown"`
+	Fills       int     `json:"fills"`
+	Candles     int     `json:"candles"`
+}
+
+// Backtest runs the strategy over the trades with trading settings of cfg, orders are simulated as in replay and
+// positions are valued at the last trade price. Strategy settings of cfg are not used.
+func Backtest(trades []domain.Price, strategy indicator.Strategy, cfg config.Config, logger *log.Logger) (Metrics, error) {
+	r := newReplayer(strategy, nil, cfg, logger)
+	defer r.stop()
+
+	for _, trade := range trades {
+		if err := r.trade(trade); err != nil {
+			return Metrics{}, err
+		}
+	}
+	r.flush()
+	return r.metrics(), nil
+}
+
+func (r *replayer) metrics() Metrics {
+	m := Metrics{
+		Fills:   r.fills,
+		Candles: len(r.equity),
+	}
+	if len(r.equity) == 0 {
+		return m
+	}
+
+	var peak, prev float64
+	changes := make([]float64, len(r.equity))
+	for i, equity := range r.equity {
+		peak = math.Max(peak, equity)
+		m.MaxDrawdown = math.Max(m.MaxDrawdown, peak-equity)
+		changes[i] = equity - prev
+		prev = equity
+	}
+	m.PnL = prev
+	m.Sharpe = sharpe(changes)
+	return m
+}
+
+// sharpe returns mean of the changes divided by their standard deviation, zero if they do not change
+func sharpe(changes []float64) float64 {
+	var mean float64
+	for _, c := range changes {
+		mean += c
+	}
+	mean /= float64(len(changes))
+
+	var variance float64
+	for _, c := range changes {
+		variance += (c - mean) * (c - mean)
+	}
+	std := math.Sqrt(variance / float64(len(changes)))
+	if std == 0 {
+		return 0
+	}
+	return mean / std
+}
+
+// LoadTrades returns trades of the journal and the config of the last bot startup recorded in it,
+// cfg is returned if the journal has no startup config
+func LoadTrades(records Reader, cfg config.Config) ([]domain.Price, config.Config, error) {
+	var trades []domain.Price
+	for {
+		record, err := records.Next()
+		if errors.Is(err, io.EOF) {
+			return trades, cfg, nil
+		}
+		if err != nil {
+			return nil, config.Config{}, err
+		}
+
+		switch record.Type {
+		case journal.PriceRecord:
+			var price domain.Price
+			if err = json.Unmarshal(record.Data, &price); err != nil {
+				return nil, config.Config{}, err
+			}
+			trades = append(trades, price)
+		case journal.ConfigRecord:
+			var change configRecord
+			if err = json.Unmarshal(record.Data, &change); err != nil {
+				return nil, config.Config{}, err
+			}
+			if change.Source != journal.StartupSource || change.Settings == nil {
+				continue
+			}
+			if cfg, err = config.ParseSettings(change.Settings); err != nil {
+				return nil, config.Config{}, err
+			}
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Package replay feeds a recorded journal back through candles generation, strategy and processor and checks that
+// they take the decisions recorded in the journal. Trades are fed one by one with a clock following their time,
+// orders are simulated by the dry run exchange and candles are processed in lockstep with trades, so replay of
+// a journal always takes the same decisions. Backtests run other strategies over the recorded trades the same way.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/exchange"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/processor"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/signals"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/trailing"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// venue is the venue of the replayed feed
+const venue = "replay"
+
+// Reader returns journal records in order and io.EOF after the last one, e.g. pkg/journal.Reader
+type Reader interface {
+	Next() (journal.Record, error)
+}
+
+// Decision is the action taken on a candle and the position it was taken at, action is empty if nothing was done
+type Decision struct {
+	Action   string `json:"action"`
+	Side     string `json:"side"`
+	Position int    `json:"position"`
+}
+
+func (d *Decision) String() string {
+	switch {
+	case d == nil:
+		return "not evaluated"
+	case d.Action == "":
+		return fmt.Sprintf("no action at position %d", d.Position)
+	default:
+		return fmt.Sprintf("%s %s at position %d", d.Action, d.Side, d.Position)
+	}
+}
+
+// sameAction reports whether decisions take the same action, missing decision takes no action
+func sameAction(a, b *Decision) bool {
+	var none Decision
+	if a == nil {
+		a = &none
+	}
+	if b == nil {
+		b = &none
+	}
+	return a.Action == b.Action && a.Side == b.Side
+}
+
+// Divergence is a candle decided differently by replay, decision is nil if the candle was not evaluated
+type Divergence struct {
+	Pair     string
+	Candle   time.Time
+	Recorded *Decision
+	Replayed *Decision
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("%s %s: recorded %s, replayed %s", d.Pair, d.Candle.UTC().Format(time.RFC3339), d.Recorded, d.Replayed)
+}
+
+// Report sums up the replay, the journal is reproduced if there are no divergences
+type Report struct {
+	Records     int // journal records read
+	Trades      int // trades fed to candles generation
+	Candles     int // candles processed by replay
+	Actions     int // candles replay took action on
+	Divergences []Divergence
+}
+
+// evaluation is the part of strategy records compared by replay
+type evaluation struct {
+	Candle time.Time `json:"candle"`
+	Decision
+}
+
+// configRecord is the part of config records applied by replay, control requests do not record settings
+type configRecord struct {
+	Source   string                 `json:"source"`
+	Settings map[string]interface{} `json:"settings"`
+}
+
+type candleKey struct {
+	pair   string
+	candle time.Time
+}
+
+// decisions are decisions of candles in the order of evaluation, a candle is evaluated twice if it is processed
+// on shutdown and again after restart
+type decisions map[candleKey][]Decision
+
+func (d decisions) add(pair string, e evaluation) {
+	key := candleKey{pair: pair, candle: e.Candle.UTC()}
+	d[key] = append(d[key], e.Decision)
+}
+
+// Run replays the records with initial settings of cfg, settings of config records in the journal are applied
+// as the bot applied them. Candle period is set by the config record written on startup, the generated candles
+// are flushed on every startup as they were on shutdown.
+func Run(records Reader, cfg config.Config, logger *log.Logger) (Report, error) {
+	strategy, ema := indicator.SetupEMAStrategy(cfg.Strategy.EMAPeriod)
+	r := newReplayer(strategy, ema, cfg, logger)
+	defer r.stop()
+
+	for {
+		record, err := records.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Report{}, err
+		}
+		r.report.Records++
+		if err = r.replay(record); err != nil {
+			return Report{}, fmt.Errorf("replay record %d: %w", record.Seq, err)
+		}
+	}
+	r.flush()
+
+	r.report.Divergences = compare(r.recorded, r.replayed)
+	return r.report, nil
+}
+
+type replayer struct {
+	logger  *log.Logger
+	ex      *exchange.DryRunExchange
+	proc    *processor.OrdersProcessor
+	trailer *trailing.Trailer
+	ema     *indicator.EMAEvaluator // strategy settings are applied only if it is set
+	cancel  context.CancelFunc
+
+	now    time.Time           // time of the last trade, clock of the dry run exchange
+	feed   chan domain.Price   // trades streamed by the wrapped exchange of the dry run
+	prices <-chan domain.Price // trades passed by the dry run exchange
+
+	period   domain.CandlePeriod
+	wg       sync.WaitGroup
+	trades   chan domain.Price // trades of candles generation, nil until the first trade after startup
+	candles  <-chan domain.Candle
+	candleTS time.Time // start of the candle being generated
+
+	recorded decisions
+	replayed decisions
+	report   Report
+
+	// equity of simulated fills valued at the last trade prices after every candle
+	last      map[string]float64
+	positions map[string]float64
+	cash      float64
+	fills     int
+	equity    []float64
+	applied   int // fills applied to the processor position
+}
+
+// newReplayer returns replayer of the strategy with settings of cfg, strategy settings are applied to ema if it is set
+func newReplayer(strategy indicator.Strategy, ema *indicator.EMAEvaluator, cfg config.Config, logger *log.Logger) *replayer {
+	r := &replayer{
+		logger:    logger,
+		ema:       ema,
+		feed:      make(chan domain.Price),
+		period:    domain.CandlePeriod(cfg.Pair.Period),
+		recorded:  make(decisions),
+		replayed:  make(decisions),
+		last:      make(map[string]float64),
+		positions: make(map[string]float64),
+	}
+
+	r.ex = exchange.NewDryRun(&feed{prices: r.feed}, logger)
+	r.ex.SetClock(func() time.Time { return r.now })
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	r.prices = r.ex.GetPrices(ctx)
+
+	r.proc = processor.NewOrdersProcessor(strategy, discard{}, r.ex, discard{}, discard{}, logger)
+	r.proc.SetJournal(r)
+	r.trailer = trailing.NewTrailer(r.ex, discard{}, discard{}, logger)
+	r.proc.SetTrailer(r.trailer)
+	r.apply(cfg.Reloadable())
+	return r
+}
+
+func (r *replayer) replay(record journal.Record) error {
+	switch record.Type {
+	case journal.PriceRecord:
+		var price domain.Price
+		if err := json.Unmarshal(record.Data, &price); err != nil {
+			return err
+		}
+		return r.trade(price)
+	case journal.StrategyRecord:
+		var e evaluation
+		if err := json.Unmarshal(record.Data, &e); err != nil {
+			return err
+		}
+		r.recorded.add(record.Pair, e)
+	case journal.ConfigRecord:
+		var change configRecord
+		if err := json.Unmarshal(record.Data, &change); err != nil {
+			return err
+		}
+		if change.Settings == nil {
+			return nil
+		}
+		cfg, err := config.ParseSettings(change.Settings)
+		if err != nil {
+			return err
+		}
+		if change.Source == journal.StartupSource {
+			r.flush()
+			r.period = domain.CandlePeriod(cfg.Pair.Period)
+		}
+		r.apply(cfg.Reloadable())
+	}
+	return nil
+}
+
+// trade passes the trade through the dry run exchange to candles generation and processes the candle closed by it,
+// so the candle is processed with the trade as the last price, as the bot processes it
+func (r *replayer) trade(price domain.Price) error {
+	ts, err := domain.PeriodTS(r.period, time.Time(price.Time))
+	if err != nil {
+		return fmt.Errorf("candle period %q: %w", r.period, err)
+	}
+	if r.trades == nil {
+		r.trades = make(chan domain.Price)
+		r.wg.Add(1)
+		r.candles = domain.GenerateCandles(r.trades, r.period, &r.wg)
+		r.candleTS = ts
+	}
+
+	r.now = time.Time(price.Time)
+	r.last[price.ProductID] = price.Price
+	r.feed <- price
+	r.trades <- <-r.prices
+	r.applyFills()
+	r.report.Trades++
+
+	// candles generation sends the candle once the first trade of the next candle is received
+	if ts != r.candleTS {
+		r.candleTS = ts
+		r.process(<-r.candles)
+	}
+	return nil
+}
+
+func (r *replayer) process(candle domain.Candle) {
+	r.proc.ProcessCandle(candle)
+	r.updateEquity()
+}
+
+// applyFills applies fills of resting orders crossed by the trade to the processor position, as orders manager
+// applies fills found by polling. Orders executed when placed are in the processor responses, so fills are counted
+// as applied after every candle.
+func (r *replayer) applyFills() {
+	fills, _ := r.ex.GetFills("") // dry run never fails
+	for _, f := range fills[r.applied:] {
+		r.proc.ApplyFill(f.Symbol, domain.OrderType(f.Side), f.Size)
+	}
+	r.applied = len(fills)
+}
+
+// updateEquity applies new fills of the dry run exchange and values positions at the last trade prices
+func (r *replayer) updateEquity() {
+	fills, _ := r.ex.GetFills("") // dry run never fails
+	for _, f := range fills[r.fills:] {
+		size := f.Size
+		if f.Side == string(domain.SellOrder) {
+			size = -size
+		}
+		r.positions[f.Symbol] += size
+		r.cash -= size * f.Price
+	}
+	r.fills = len(fills)
+	r.applied = len(fills)
+
+	pairs := make([]string, 0, len(r.positions))
+	for pair := range r.positions {
+		pairs = append(pairs, pair)
+	}
+	// positions are summed in the same order, so equity of a replay is always the same
+	sort.Strings(pairs)
+	equity := r.cash
+	for _, pair := range pairs {
+		equity += r.positions[pair] * r.last[pair]
+	}
+	r.equity = append(r.equity, equity)
+}
+
+// flush processes the last candle of candles generation and stops it, as on the bot shutdown
+func (r *replayer) flush() {
+	if r.trades == nil {
+		return
+	}
+	close(r.trades)
+	for candle := range r.candles {
+		r.process(candle)
+	}
+	r.wg.Wait()
+	r.trades, r.candles = nil, nil
+}
+
+func (r *replayer) stop() {
+	r.cancel()
+	close(r.feed)
+}
+
+// apply applies reloadable settings as the bot applies them on config reload
+func (r *replayer) apply(c config.Reloadable) {
+	r.proc.SetTradingQuantity(c.Trading.Quantity)
+	r.proc.SetPriceMultiplier(c.Trading.Multiplier)
+	r.proc.SetProtection(processor.Protection{
+		StopLoss:      c.Trading.StopLoss,
+		TakeProfit:    c.Trading.TakeProfit,
+		TriggerSignal: c.Trading.TriggerSignal,
+	})
+	r.proc.SetSignalSettings(signals.Settings{
+		Cooldown:   c.Trading.Signals.Cooldown,
+		Pyramiding: c.Trading.Signals.Pyramiding,
+	})
+	r.trailer.SetSettings(trailing.Settings{
+		Mode:      c.Trading.Trailing.Mode,
+		Distance:  c.Trading.Trailing.Distance,
+		ATRPeriod: c.Trading.Trailing.ATRPeriod,
+	})
+	if r.ema != nil {
+		r.ema.SetPeriod(c.Strategy.EMAPeriod)
+	}
+}
+
+// Record collects strategy evaluations of the processor, it is the journal of the replayed processor
+func (r *replayer) Record(recordType, pair string, data interface{}) {
+	if recordType != journal.StrategyRecord {
+		return
+	}
+	// evaluations are compared in the form they are recorded
+	raw, err := json.Marshal(data)
+	if err != nil {
+		r.logger.Errorf("Encode %s evaluation: %s", pair, err)
+		return
+	}
+	var e evaluation
+	if err = json.Unmarshal(raw, &e); err != nil {
+		r.logger.Errorf("Decode %s evaluation: %s", pair, err)
+		return
+	}
+	r.replayed.add(pair, e)
+	r.report.Candles++
+	if e.Action != "" {
+		r.report.Actions++
+	}
+}
+
+// compare returns candles decided differently ordered by candle time
+func compare(recorded, replayed decisions) []Divergence {
+	keys := make(map[candleKey]struct{}, len(recorded))
+	for key := range recorded {
+		keys[key] = struct{}{}
+	}
+	for key := range replayed {
+		keys[key] = struct{}{}
+	}
+
+	var divergences []Divergence
+	for key := range keys {
+		rec, rep := recorded[key], replayed[key]
+		for i := 0; i < len(rec) || i < len(rep); i++ {
+			d := Divergence{Pair: key.pair, Candle: key.candle}
+			if i < len(rec) {
+				d.Recorded = &rec[i]
+			}
+			if i < len(rep) {
+				d.Replayed = &rep[i]
+			}
+			if !sameAction(d.Recorded, d.Replayed) {
+				divergences = append(divergences, d)
+			}
+		}
+	}
+	sort.SliceStable(divergences, func(i, j int) bool {
+		if !divergences[i].Candle.Equal(divergences[j].Candle) {
+			return divergences[i].Candle.Before(divergences[j].Candle)
+		}
+		return divergences[i].Pair < divergences[j].Pair
+	})
+	return divergences
+}
+
+// feed is the exchange wrapped by the dry run, it streams replayed trades
+type feed struct {
+	exchange.Exchange
+	prices chan domain.Price
+}
+
+func (f *feed) GetPrices(_ context.Context) <-chan domain.Price {
+	return f.prices
+}
+
+func (f *feed) Venue() string {
+	return venue
+}
+
+// discard is the repository, notifier and events publisher of the replay
+type discard struct{}
+
+func (discard) StoreToDB(context.Context, domain.CreateOrderResponse) error { return nil }
+func (discard) StoreStopMove(context.Context, domain.StopMove) error        { return nil }
+func (discard) NotifyUsers(string)                                          {}
+func (discard) NotifyError(string)                                          {}
+func (discard) Publish(stream.Event)                                        {}
@@ -0,0 +1,183 @@
+package replay
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/config"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPair = "PI_XBTUSD"
+
+type recordsStub struct {
+	records []journal.Record
+}
+
+func (r *recordsStub) Next() (journal.Record, error) {
+	if len(r.records) == 0 {
+		return journal.Record{}, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+func newRecord(t *testing.T, recordType, pair string, data interface{}) journal.Record {
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+	return journal.Record{Type: recordType, Pair: pair, Data: raw}
+}
+
+// session returns journal of the startup config and trades of minute candles opened at the previous close,
+// the price goes up and then down, so the strategy enters and reverses the position
+func session(t *testing.T) []journal.Record {
+	records := []journal.Record{newRecord(t, journal.ConfigRecord, "", configRecord{
+		Source: journal.StartupSource,
+		Settings: map[string]interface{}{
+			"pair":     map[string]interface{}{"period": "1m"},
+			"trading":  map[string]interface{}{"quantity": 10, "multiplier": 0.01},
+			"strategy": map[string]interface{}{"ema_period": 3},
+		},
+	})}
+	start, open := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC), 100.0
+	for i, close := range []float64{100, 102, 104, 106, 104, 98, 92, 90, 95, 101} {
+		for j, price := range []float64{open, close} {
+			ts := start.Add(time.Duration(i)*time.Minute + time.Duration(j*30)*time.Second)
+			records = append(records, newRecord(t, journal.PriceRecord, testPair,
+				domain.Price{Time: domain.UnixTS(ts), ProductID: testPair, Quantity: 1, Price: price}))
+		}
+		open = close
+	}
+	return records
+}
+
+func newTestLogger() *log.Logger {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	return logger
+}
+
+func TestRun(t *testing.T) {
+	a := assert.New(t)
+	cfg, err := config.ParseSettings(nil)
+	require.NoError(t, err)
+
+	testID := 0
+	t.Logf("\tTest %d:\tdecisions missing in journal diverge", testID)
+	var replayed []Divergence
+	{
+		report, err := Run(&recordsStub{records: session(t)}, cfg, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(21, report.Records)
+		a.Equal(20, report.Trades)
+		a.Equal(10, report.Candles)
+		a.Equal(3, report.Actions)
+		expected := []Decision{
+			{Action: "enter", Side: "buy", Position: 0},
+			{Action: "reverse", Side: "sell", Position: 10},
+			{Action: "reverse", Side: "buy", Position: -10},
+		}
+		a.Len(report.Divergences, len(expected))
+		for i, d := range report.Divergences {
+			a.Nil(d.Recorded)
+			if a.NotNil(d.Replayed) && i < len(expected) {
+				a.Equal(expected[i], *d.Replayed)
+			}
+		}
+		replayed = report.Divergences
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trecorded decisions are reproduced", testID)
+	var records []journal.Record
+	{
+		records = session(t)
+		for _, d := range replayed {
+			records = append(records, newRecord(t, journal.StrategyRecord, d.Pair, evaluation{Candle: d.Candle, Decision: *d.Replayed}))
+		}
+		for i := 0; i < 3; i++ {
+			report, err := Run(&recordsStub{records: records}, cfg, newTestLogger())
+			require.NoError(t, err)
+			a.Empty(report.Divergences, "Replay should be deterministic")
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tchanged decision diverges", testID)
+	{
+		changed := append([]journal.Record(nil), records...)
+		d := replayed[0]
+		changed[len(changed)-len(replayed)] = newRecord(t, journal.StrategyRecord, d.Pair,
+			evaluation{Candle: d.Candle, Decision: Decision{Action: "enter", Side: "sell"}})
+
+		report, err := Run(&recordsStub{records: changed}, cfg, newTestLogger())
+		require.NoError(t, err)
+		a.Len(report.Divergences, 1)
+		a.Equal(d.Candle, report.Divergences[0].Candle)
+		a.Equal("PI_XBTUSD 2021-11-25T19:01:00Z: recorded enter sell at position 0, replayed enter buy at position 0",
+			report.Divergences[0].String())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tunknown candle period", testID)
+	{
+		records := session(t)[1:]
+		_, err := Run(&recordsStub{records: records}, cfg, newTestLogger())
+		a.Error(err)
+	}
+}
+
+func TestBacktest(t *testing.T) {
+	a := assert.New(t)
+	defaults, err := config.ParseSettings(nil)
+	require.NoError(t, err)
+
+	testID := 0
+	t.Logf("\tTest %d:\ttrades and startup config are loaded from journal", testID)
+	var (
+		trades []domain.Price
+		cfg    config.Config
+	)
+	{
+		records := append(session(t), newRecord(t, journal.ConfigRecord, "", configRecord{Source: "control_api"}))
+		trades, cfg, err = LoadTrades(&recordsStub{records: records}, defaults)
+		require.NoError(t, err)
+		a.Len(trades, 20)
+		a.Equal("1m", cfg.Pair.Period)
+		a.Equal(0.01, cfg.Trading.Multiplier)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstrategy results", testID)
+	{
+		// long 10 from 102 to 104, short 10 from 104 to 95, long 10 from 95 to 101
+		for i := 0; i < 3; i++ {
+			strategy, _ := indicator.SetupEMAStrategy(3)
+			m, err := Backtest(trades, strategy, cfg, newTestLogger())
+			require.NoError(t, err)
+			a.Equal(170.0, m.PnL)
+			a.Equal(50.0, m.MaxDrawdown, "Drawdown from 160 to 110")
+			a.InDelta(17/math.Sqrt(1201), m.Sharpe, 1e-9)
+			a.Equal(3, m.Fills)
+			a.Equal(10, m.Candles)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tno trades", testID)
+	{
+		strategy, _ := indicator.SetupEMAStrategy(3)
+		m, err := Backtest(nil, strategy, cfg, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(Metrics{}, m)
+	}
+}
@@ -2,13 +2,21 @@ package repository
 
 import (
 	"context"
+	_ "embed" // schema
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
 )
 
+// schema creates and upgrades tables of the bot, it is applied on connect
+//
+//go:embed schema.sql
+var schema string
+
 type PostgreSQLPool struct {
 	pool   *pgxpool.Pool
 	logger *log.Logger
@@ -24,21 +32,88 @@ func NewPostgreSQLPool(url string, logger *log.Logger) (*PostgreSQLPool, error)
 		return nil, err
 	}
 
+	if _, err = pool.Exec(context.Background(), schema); err != nil {
+		return nil, fmt.Errorf("apply schema: %w", err)
+	}
+
 	return &PostgreSQLPool{
 		pool:   pool,
 		logger: logger,
 	}, nil
 }
 
-const insertTemplate = `insert into orders 
-(order_id, TS, order_type, symbol, status, side, quantity, price) 
-values ('%s', '%s', '%s', '%s', '%s', '%s', %v,  %v);`
+// orders with the same client order ID are stored once per live and dry run, orders without it are always stored
+const insertOrderCommand = `insert into orders
+(order_id, cli_ord_id, TS, order_type, symbol, status, side, quantity, price, simulated)
+values ($1, nullif($2, ''), $3, $4, $5, $6, $7, $8, $9, $10)
+on conflict (cli_ord_id, simulated) do nothing;`
+
+func observeWrite(operation string, start time.Time) {
+	metrics.DBWriteLatency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
 
 func (p *PostgreSQLPool) StoreToDB(ctx context.Context, r domain.CreateOrderResponse) error {
-	insertCommand := fmt.Sprintf(insertTemplate,
-		r.OrderID, r.ReceivedTime, r.OrderType, r.Symbol, r.Status, r.Side, r.Size, r.LimitPrice)
-	if _, err := p.pool.Query(ctx, insertCommand); err != nil {
+	defer observeWrite("store_order", time.Now())
+	tag, err := p.pool.Exec(ctx, insertOrderCommand,
+		r.OrderID, r.CliOrdID, r.ReceivedTime, r.OrderType, r.Symbol, r.Status, r.Side, r.Size, r.LimitPrice, r.Simulated)
+	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		p.logger.Warnf("Order %s with client order ID %s is already stored", r.OrderID, r.CliOrdID)
+	}
 	return nil
 }
+
+const insertStopMoveCommand = `insert into stop_moves
+(pair, side, order_id, method, best_price, old_stop, new_stop, TS)
+values ($1, $2, $3, $4, $5, $6, $7, $8);`
+
+func (p *PostgreSQLPool) StoreStopMove(ctx context.Context, m domain.StopMove) error {
+	defer observeWrite("store_stop_move", time.Now())
+	_, err := p.pool.Exec(ctx, insertStopMoveCommand,
+		m.Pair, m.Side, m.OrderID, m.Method, m.BestPrice, m.OldStop, m.NewStop, m.Time)
+	return err
+}
+
+const (
+	upsertSubscriberCommand = `insert into subscribers (chat_id, username, preference)
+values ($1, $2, $3)
+on conflict (chat_id) do update set username = excluded.username, preference = excluded.preference;`
+	deleteSubscriberCommand = `delete from subscribers where chat_id = $1;`
+	selectSubscribersQuery  = `select chat_id, username, preference from subscribers;`
+)
+
+func (p *PostgreSQLPool) StoreSubscriber(ctx context.Context, s domain.Subscriber) error {
+	defer observeWrite("store_subscriber", time.Now())
+	_, err := p.pool.Exec(ctx, upsertSubscriberCommand, s.ChatID, s.Username, string(s.Preference))
+	return err
+}
+
+func (p *PostgreSQLPool) DeleteSubscriber(ctx context.Context, chatID int64) error {
+	defer observeWrite("delete_subscriber", time.Now())
+	_, err := p.pool.Exec(ctx, deleteSubscriberCommand, chatID)
+	return err
+}
+
+func (p *PostgreSQLPool) GetSubscribers(ctx context.Context) ([]domain.Subscriber, error) {
+	rows, err := p.pool.Query(ctx, selectSubscribersQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscribers := make([]domain.Subscriber, 0)
+	for rows.Next() {
+		var (
+			s          domain.Subscriber
+			preference string
+		)
+		if err = rows.Scan(&s.ChatID, &s.Username, &preference); err != nil {
+			return nil, err
+		}
+		s.Preference = domain.NotifyPreference(preference)
+		subscribers = append(subscribers, s)
+	}
+	return subscribers, rows.Err()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/config"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
@@ -68,6 +69,82 @@ func (db *DatabaseSuite) TestStoreToDB() {
 		err := db.repo.StoreToDB(context.Background(), respOrder)
 		db.Error(err)
 	}
+
+	testID++
+	db.T().Logf("\tTest %d:\tduplicate client order ID is skipped", testID)
+	{
+		respOrder := domain.CreateOrderResponse{
+			OrderType:    "ioc",
+			Symbol:       "TEST_SYM",
+			Side:         "buy",
+			Size:         100,
+			LimitPrice:   4213.1,
+			Result:       "success",
+			Status:       "placed",
+			OrderID:      "c5a9f1b2-7d0e-4a5b-9f3c-2e1d0a8b7c6d",
+			CliOrdID:     "TEST_SYM-1637866800-buy-1",
+			ReceivedTime: "2021-11-25T19:05:03.670Z",
+		}
+		db.NoError(db.repo.StoreToDB(context.Background(), respOrder))
+		db.NoError(db.repo.StoreToDB(context.Background(), respOrder))
+	}
+}
+
+func (db *DatabaseSuite) TestStoreStopMove() {
+	testID := 0
+	db.T().Logf("\tTest %d:\tstore stop move", testID)
+	{
+		m := domain.StopMove{
+			Pair:      "TEST_SYM",
+			Side:      "sell",
+			OrderID:   "8dcdbe17-b729-4fef-8b89-36e561535f38",
+			Method:    domain.StopEdited,
+			BestPrice: 4300,
+			OldStop:   4100,
+			NewStop:   4200,
+			Time:      time.Date(2021, 11, 25, 19, 5, 0, 0, time.UTC),
+		}
+		db.NoError(db.repo.StoreStopMove(context.Background(), m))
+	}
+}
+
+func (db *DatabaseSuite) TestSubscribers() {
+	testID := 0
+	db.T().Logf("\tTest %d:\tstore and restore subscriber", testID)
+	{
+		s := domain.Subscriber{ChatID: 1234567, Username: "", Preference: domain.NotifyOrders}
+		err := db.repo.StoreSubscriber(context.Background(), s)
+		db.NoError(err)
+
+		subscribers, err := db.repo.GetSubscribers(context.Background())
+		db.NoError(err)
+		db.Contains(subscribers, s)
+	}
+
+	testID++
+	db.T().Logf("\tTest %d:\tupdate subscriber preference", testID)
+	{
+		s := domain.Subscriber{ChatID: 1234567, Username: "test_user", Preference: domain.NotifySummary}
+		err := db.repo.StoreSubscriber(context.Background(), s)
+		db.NoError(err)
+
+		subscribers, err := db.repo.GetSubscribers(context.Background())
+		db.NoError(err)
+		db.Contains(subscribers, s)
+	}
+
+	testID++
+	db.T().Logf("\tTest %d:\tdelete subscriber", testID)
+	{
+		err := db.repo.DeleteSubscriber(context.Background(), 1234567)
+		db.NoError(err)
+
+		subscribers, err := db.repo.GetSubscribers(context.Background())
+		db.NoError(err)
+		for _, s := range subscribers {
+			db.NotEqual(int64(1234567), s.ChatID)
+		}
+	}
 }
 
 func TestDatabase(t *testing.T) {
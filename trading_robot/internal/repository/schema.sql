@@ -0,0 +1,37 @@
+-- Schema of the trading robot, it is applied on every start, so statements must be idempotent.
+-- Tables of previous versions are upgraded in place.
+
+create table if not exists orders (
+    order_id   text             not null,
+    TS         timestamptz      not null,
+    order_type text             not null,
+    symbol     text             not null,
+    status     text             not null,
+    side       text             not null,
+    quantity   integer          not null,
+    price      double precision not null
+);
+
+-- orders are stored once per client order ID, orders without it are always stored. Client order IDs are derived
+-- from signals, so simulated orders of a dry run have the same IDs as live orders and are kept apart.
+alter table orders add column if not exists cli_ord_id text;
+alter table orders add column if not exists simulated boolean not null default false;
+alter table orders drop constraint if exists orders_cli_ord_id_key;
+create unique index if not exists orders_cli_ord_id_simulated_idx on orders (cli_ord_id, simulated);
+
+create table if not exists stop_moves (
+    pair       text             not null,
+    side       text             not null,
+    order_id   text             not null default '',
+    method     text             not null,
+    best_price double precision not null,
+    old_stop   double precision not null,
+    new_stop   double precision not null,
+    TS         timestamptz      not null
+);
+
+create table if not exists subscribers (
+    chat_id    bigint primary key,
+    username   text not null default '',
+    preference text not null default 'all'
+);
@@ -0,0 +1,216 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+const (
+	AuthNone   AuthMode = "none"
+	AuthAPIKey AuthMode = "api_key"
+	AuthHMAC   AuthMode = "hmac"
+
+	APIKeyHeader    = "X-API-Key"
+	BearerPrefix    = "Bearer "
+	ClientHeader    = "X-API-Client"
+	TimestampHeader = "X-API-Timestamp"
+	SignatureHeader = "X-API-Signature"
+
+	DefaultMaxSkew = 30 * time.Second
+
+	anonymous = "anonymous"
+)
+
+var (
+	ErrUnknownAuthMode  = errors.New("unknown auth mode")
+	ErrNoAuthKeys       = errors.New("auth keys are not configured")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrInvalidTimestamp = errors.New("request timestamp is invalid or expired")
+)
+
+type AuthMode string
+
+type clientKey struct{}
+
+// Authenticator checks requests either by API key or by HMAC signature.
+// Keys map client names to their API keys or HMAC secrets.
+//
+// HMAC signature is hex encoded HMAC-SHA256 of timestamp + method + request URI + body,
+// where timestamp is unix seconds sent in X-API-Timestamp header.
+type Authenticator struct {
+	mode    AuthMode
+	keys    map[string]string
+	maxSkew time.Duration
+
+	now func() time.Time
+}
+
+func NewAuthenticator(mode AuthMode, keys map[string]string, maxSkew time.Duration) (*Authenticator, error) {
+	switch mode {
+	case AuthNone:
+	case AuthAPIKey, AuthHMAC:
+		if len(keys) == 0 {
+			return nil, ErrNoAuthKeys
+		}
+	default:
+		return nil, ErrUnknownAuthMode
+	}
+
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+
+	return &Authenticator{
+		mode:    mode,
+		keys:    keys,
+		maxSkew: maxSkew,
+		now:     time.Now,
+	}, nil
+}
+
+// Authenticate returns name of the client who sent the request
+func (a *Authenticator) Authenticate(request *http.Request) (string, error) {
+	switch a.mode {
+	case AuthAPIKey:
+		return a.authenticateAPIKey(request)
+	case AuthHMAC:
+		return a.authenticateHMAC(request)
+	default:
+		return anonymous, nil
+	}
+}
+
+// authenticateAPIKey accepts key either in X-API-Key header or as bearer token,
+// the latter is supported by Prometheus scrape config
+func (a *Authenticator) authenticateAPIKey(request *http.Request) (string, error) {
+	key := request.Header.Get(APIKeyHeader)
+	if auth := request.Header.Get("Authorization"); key == "" && strings.HasPrefix(auth, BearerPrefix) {
+		key = strings.TrimPrefix(auth, BearerPrefix)
+	}
+	if key == "" {
+		return "", ErrUnauthorized
+	}
+	for client, expected := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1 {
+			return client, nil
+		}
+	}
+	return "", ErrUnauthorized
+}
+
+func (a *Authenticator) authenticateHMAC(request *http.Request) (string, error) {
+	client := request.Header.Get(ClientHeader)
+	secret, ok := a.keys[client]
+	if !ok {
+		return "", ErrUnauthorized
+	}
+
+	timestamp := request.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidTimestamp
+	}
+	if skew := a.now().Sub(time.Unix(unix, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return "", ErrInvalidTimestamp
+	}
+
+	var body []byte
+	if request.Body != nil {
+		body, err = io.ReadAll(request.Body)
+		if err != nil {
+			return "", err
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	signature, err := hex.DecodeString(request.Header.Get(SignatureHeader))
+	if err != nil {
+		return "", ErrUnauthorized
+	}
+	expected := Sign(secret, timestamp, request.Method, request.URL.RequestURI(), body)
+	if !hmac.Equal(signature, expected) {
+		return "", ErrUnauthorized
+	}
+	return client, nil
+}
+
+// Sign returns HMAC signature of the request, clients should send it hex encoded
+func Sign(secret, timestamp, method, requestURI string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte(method))
+	h.Write([]byte(requestURI))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// UseAuth protects all endpoints with the authenticator and writes audit records
+// of the setting changes to the audit logger
+func (r *Router) UseAuth(auth *Authenticator, audit *log.Logger) {
+	r.Use(r.authMiddleware(auth), r.auditMiddleware(audit))
+}
+
+func (r *Router) authMiddleware(auth *Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			client, err := auth.Authenticate(request)
+			if err != nil {
+				r.writeError(writer, http.StatusUnauthorized, request.URL.Path, err)
+				return
+			}
+			next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), clientKey{}, client)))
+		})
+	}
+}
+
+// statusRecorder saves response status for audit log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *Router) auditMiddleware(audit *log.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == http.MethodGet {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			rec := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+			next.ServeHTTP(rec, request)
+
+			client, _ := request.Context().Value(clientKey{}).(string)
+			audit.WithFields(map[string]interface{}{
+				"client": client,
+				"remote": request.RemoteAddr,
+				"method": request.Method,
+				"path":   request.URL.Path,
+				"status": rec.status,
+			}).Info("control request")
+		})
+	}
+}
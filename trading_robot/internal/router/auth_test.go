@@ -0,0 +1,192 @@
+package router
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tunknown mode", testID)
+	{
+		_, err := NewAuthenticator("password", map[string]string{"alice": "key"}, 0)
+		a.Equal(ErrUnknownAuthMode, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tno keys", testID)
+	{
+		_, err := NewAuthenticator(AuthHMAC, nil, 0)
+		a.Equal(ErrNoAuthKeys, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tdisabled auth", testID)
+	{
+		auth, err := NewAuthenticator(AuthNone, nil, 0)
+		a.NoError(err)
+		client, err := auth.Authenticate(httptest.NewRequest(http.MethodPost, "/quantity/10", nil))
+		a.NoError(err)
+		a.Equal(anonymous, client)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	a := assert.New(t)
+	auth, err := NewAuthenticator(AuthAPIKey, map[string]string{"alice": "alice-key", "bob": "bob-key"}, 0)
+	a.NoError(err)
+
+	testID := 0
+	t.Logf("\tTest %d:\tvalid key", testID)
+	{
+		req := httptest.NewRequest(http.MethodPost, "/quantity/10", nil)
+		req.Header.Set(APIKeyHeader, "bob-key")
+		client, err := auth.Authenticate(req)
+		a.NoError(err)
+		a.Equal("bob", client)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tvalid bearer token", testID)
+	{
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer alice-key")
+		client, err := auth.Authenticate(req)
+		a.NoError(err)
+		a.Equal("alice", client)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid key", testID)
+	{
+		req := httptest.NewRequest(http.MethodPost, "/quantity/10", nil)
+		req.Header.Set(APIKeyHeader, "eve-key")
+		_, err := auth.Authenticate(req)
+		a.Equal(ErrUnauthorized, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tno key", testID)
+	{
+		_, err := auth.Authenticate(httptest.NewRequest(http.MethodPost, "/quantity/10", nil))
+		a.Equal(ErrUnauthorized, err)
+	}
+}
+
+func signedRequest(client, secret string, ts time.Time, method, target string, body []byte) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader(body))
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set(ClientHeader, client)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, hex.EncodeToString(Sign(secret, timestamp, method, req.URL.RequestURI(), body)))
+	return req
+}
+
+func TestHMACAuth(t *testing.T) {
+	a := assert.New(t)
+	auth, err := NewAuthenticator(AuthHMAC, map[string]string{"alice": "alice-secret"}, 10*time.Second)
+	a.NoError(err)
+	now := time.Date(2021, 11, 25, 19, 5, 3, 0, time.UTC)
+	auth.now = func() time.Time { return now }
+
+	testID := 0
+	t.Logf("\tTest %d:\tvalid signature", testID)
+	{
+		req := signedRequest("alice", "alice-secret", now, http.MethodPost, "/multiplier/0.01", []byte("{}"))
+		client, err := auth.Authenticate(req)
+		a.NoError(err)
+		a.Equal("alice", client)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\twrong secret", testID)
+	{
+		req := signedRequest("alice", "guess", now, http.MethodPost, "/multiplier/0.01", nil)
+		_, err := auth.Authenticate(req)
+		a.Equal(ErrUnauthorized, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tunknown client", testID)
+	{
+		req := signedRequest("eve", "alice-secret", now, http.MethodPost, "/multiplier/0.01", nil)
+		_, err := auth.Authenticate(req)
+		a.Equal(ErrUnauthorized, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\ttampered path", testID)
+	{
+		req := signedRequest("alice", "alice-secret", now, http.MethodPost, "/multiplier/0.01", nil)
+		req.URL.Path = "/multiplier/10"
+		_, err := auth.Authenticate(req)
+		a.Equal(ErrUnauthorized, err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\texpired timestamp", testID)
+	{
+		req := signedRequest("alice", "alice-secret", now.Add(-time.Minute), http.MethodPost, "/multiplier/0.01", nil)
+		_, err := auth.Authenticate(req)
+		a.Equal(ErrInvalidTimestamp, err)
+	}
+}
+
+func (e *Environment) TestUseAuth() {
+	auth, err := NewAuthenticator(AuthAPIKey, map[string]string{"alice": "alice-key"}, 0)
+	e.NoError(err)
+	audit, hook := test.NewNullLogger()
+	e.router.UseAuth(auth, audit)
+
+	testID := 0
+	e.T().Logf("\tTest %d:\tunauthorized request rejected", testID)
+	{
+		rec := httptest.NewRecorder()
+		e.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/quantity/10", nil))
+		e.Equal(http.StatusUnauthorized, rec.Code)
+		var resp errorResponse
+		e.NoError(json.Unmarshal(rec.Body.Bytes(), &resp))
+		e.Equal(ErrUnauthorized.Error(), resp.Error)
+		e.Empty(hook.AllEntries())
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tauthorized change audited", testID)
+	{
+		e.processor.On("SetTradingQuantity", 10).Once()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/quantity/10", nil)
+		req.Header.Set(APIKeyHeader, "alice-key")
+		e.router.ServeHTTP(rec, req)
+		e.Equal(http.StatusOK, rec.Code)
+
+		entry := hook.LastEntry()
+		e.Equal("alice", entry.Data["client"])
+		e.Equal("/quantity/10", entry.Data["path"])
+		e.Equal(http.StatusOK, entry.Data["status"])
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tread requests are not audited", testID)
+	{
+		hook.Reset()
+		e.exchange.On("GetPairs").Return([]string{}).Once()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/pairs", nil)
+		req.Header.Set(APIKeyHeader, "alice-key")
+		e.router.ServeHTTP(rec, req)
+		e.Equal(http.StatusOK, rec.Code)
+		e.Empty(hook.AllEntries())
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+)
+
+// Journal records control requests changing the bot, e.g. pkg/journal.Journal
+type Journal interface {
+	Record(recordType, pair string, data interface{})
+}
+
+// controlChange is the journal record of a control request
+type controlChange struct {
+	Source string `json:"source"`
+	Client string `json:"client,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// ControlSource is the source of config records of control requests
+const ControlSource = "control_api"
+
+// UseJournal records every change request to the journal after it is handled
+func (r *Router) UseJournal(j Journal) {
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method == http.MethodGet {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			rec := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+			next.ServeHTTP(rec, request)
+
+			client, _ := request.Context().Value(clientKey{}).(string)
+			j.Record(journal.ConfigRecord, "", controlChange{
+				Source: ControlSource,
+				Client: client,
+				Method: request.Method,
+				Path:   request.URL.Path,
+				Status: rec.status,
+			})
+		})
+	})
+}
@@ -0,0 +1,47 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/journal"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type JournalStub struct {
+	types   []string
+	records []interface{}
+}
+
+func (j *JournalStub) Record(recordType, _ string, data interface{}) {
+	j.types = append(j.types, recordType)
+	j.records = append(j.records, data)
+}
+
+func TestUseJournal(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	processor := new(ProcessorMock)
+	processor.On("SetTradingQuantity", 10).Once()
+	j := &JournalStub{}
+	r := NewRouter(nil, processor, nil, logger)
+	r.UseJournal(j)
+
+	testID := 0
+	t.Logf("\tTest %d:\tchange requests are recorded", testID)
+	{
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/quantity/10", nil))
+		a.Equal([]string{journal.ConfigRecord}, j.types)
+		a.Equal(controlChange{Source: ControlSource, Method: http.MethodPost, Path: "/quantity/10", Status: http.StatusOK}, j.records[0])
+	}
+
+	testID++
+	t.Logf("\tTest %d:\treads are not recorded", testID)
+	{
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/pairs/unknown", nil))
+		a.Len(j.records, 1)
+	}
+}
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/orders"
+)
+
+const StatesParam = "state"
+
+type OrdersLister interface {
+	Orders() []orders.Order
+}
+
+type ordersResponse struct {
+	Orders []orders.Order `json:"orders"`
+}
+
+// HandleOrders adds endpoint listing tracked orders. Clients can filter orders with comma separated
+// state query param, e.g. /orders?state=open,partially_filled
+func (r *Router) HandleOrders(lister OrdersLister) {
+	r.Methods(http.MethodGet).Path(domain.OrdersOperation).HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		states := parseSetParam(request, StatesParam)
+		list := make([]orders.Order, 0)
+		for _, o := range lister.Orders() {
+			if len(states) == 0 || states[string(o.State)] {
+				list = append(list, o)
+			}
+		}
+		r.writeJSON(writer, http.StatusOK, ordersResponse{Orders: list})
+	})
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/orders"
+)
+
+type ordersStub []orders.Order
+
+func (s ordersStub) Orders() []orders.Order {
+	return s
+}
+
+func (e *Environment) TestOrders() {
+	e.router.HandleOrders(ordersStub{
+		{ID: "1", Symbol: "PI_XBTUSD", State: orders.StateFilled},
+		{ID: "2", Symbol: "PI_XBTUSD", State: orders.StateOpen, Orphaned: true},
+		{ID: "3", Symbol: "PI_XBTUSD", State: orders.StatePartiallyFilled},
+	})
+
+	testID := 0
+	e.T().Logf("\tTest %d:\tall orders", testID)
+	{
+		var resp ordersResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/orders", &resp))
+		e.Len(resp.Orders, 3)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\torders filtered by state", testID)
+	{
+		var resp ordersResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/orders?state=open,partially_filled", &resp))
+		e.Len(resp.Orders, 2)
+		e.Equal("2", resp.Orders[0].ID)
+		e.True(resp.Orders[0].Orphaned)
+		e.Equal(orders.StatePartiallyFilled, resp.Orders[1].State)
+	}
+}
@@ -1,12 +1,22 @@
 package router
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+)
+
+var (
+	ErrInvalidQuantity   = errors.New("quantity must be a positive integer")
+	ErrInvalidMultiplier = errors.New("multiplier must be a non-negative number")
 )
 
 type Subscriber interface {
@@ -19,66 +29,190 @@ type PriceQuantitySetter interface {
 	SetTradingQuantity(q int)
 }
 
+type ExchangeState interface {
+	GetPairs() []string
+	IsConnected() bool
+	GetLastPrice() (domain.Price, bool)
+}
+
+type ProcessorState interface {
+	GetPriceMultiplier() float64
+	GetTradingQuantity() int
+	GetPosition() int
+	GetLastCandleTime() time.Time
+	GetStrategyState() []indicator.StrategyState
+}
+
+type Exchange interface {
+	Subscriber
+	ExchangeState
+}
+
+type Processor interface {
+	PriceQuantitySetter
+	ProcessorState
+}
+
+// SettingsGetter returns effective config settings with secrets redacted
+type SettingsGetter func() map[string]interface{}
+
 type Router struct {
 	*mux.Router
-	subscriber Subscriber
-	options    PriceQuantitySetter
-	logger     *log.Logger
+	exchange  Exchange
+	processor Processor
+	settings  SettingsGetter
+	logger    *log.Logger
 }
 
-func NewRouter(subscriber Subscriber, options PriceQuantitySetter, logger *log.Logger) *Router {
+func NewRouter(exchange Exchange, processor Processor, settings SettingsGetter, logger *log.Logger) *Router {
 	r := &Router{
-		subscriber: subscriber,
-		options:    options,
-		logger:     logger,
-		Router:     mux.NewRouter(),
+		exchange:  exchange,
+		processor: processor,
+		settings:  settings,
+		logger:    logger,
+		Router:    mux.NewRouter(),
 	}
 	r.Methods(http.MethodPost).PathPrefix(domain.SubscribePair).HandlerFunc(r.postSubscribe)
 	r.Methods(http.MethodPost).PathPrefix(domain.UnsubscribePair).HandlerFunc(r.postUnsubscribe)
 	r.Methods(http.MethodPost).PathPrefix(domain.SetQuantity).HandlerFunc(r.postQuantity)
 	r.Methods(http.MethodPost).PathPrefix(domain.SetMultiplier).HandlerFunc(r.postMultiplier)
 
+	r.Methods(http.MethodGet).Path(domain.StatusOperation).HandlerFunc(r.getStatus)
+	r.Methods(http.MethodGet).Path(domain.ConfigOperation).HandlerFunc(r.getConfig)
+	r.Methods(http.MethodGet).Path(domain.StrategyOperation).HandlerFunc(r.getStrategy)
+	r.Methods(http.MethodGet).Path(domain.PairsOperation).HandlerFunc(r.getPairs)
+	r.Methods(http.MethodGet).Path(domain.MetricsOperation).Handler(metrics.Handler())
+
 	return r
 }
 
+type errorResponse struct {
+	Error    string `json:"error"`
+	Endpoint string `json:"endpoint"`
+}
+
+type pairsResponse struct {
+	Pairs []string `json:"pairs"`
+}
+
+type quantityResponse struct {
+	Quantity int `json:"quantity"`
+}
+
+type multiplierResponse struct {
+	Multiplier float64 `json:"multiplier"`
+}
+
+type statusResponse struct {
+	Pairs          []string   `json:"pairs"`
+	Connected      bool       `json:"connected"`
+	LastPrice      float64    `json:"last_price,omitempty"`
+	LastPriceTime  *time.Time `json:"last_price_time,omitempty"`
+	LastCandleTime *time.Time `json:"last_candle_time,omitempty"`
+	Position       int        `json:"position"`
+}
+
+type configResponse struct {
+	Settings   map[string]interface{} `json:"settings"`
+	Quantity   int                    `json:"quantity"`
+	Multiplier float64                `json:"multiplier"`
+}
+
+type strategyResponse struct {
+	Strategies []indicator.StrategyState `json:"strategies"`
+}
+
+func (r *Router) writeJSON(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		r.logger.Errorf("Write response failed: %s", err)
+	}
+}
+
+func (r *Router) writeError(writer http.ResponseWriter, status int, endpoint string, err error) {
+	r.logger.Errorf("%s endpoint: %s", endpoint, err)
+	r.writeJSON(writer, status, errorResponse{
+		Error:    err.Error(),
+		Endpoint: endpoint,
+	})
+}
+
 func (r *Router) postSubscribe(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	ticker := vars[domain.PairVar]
-	err := r.subscriber.SubscribePairs(ticker)
+	err := r.exchange.SubscribePairs(ticker)
 	if err != nil {
-		r.logger.Errorf("%s endpoint: %s", domain.SubscribePair, err)
-		writer.WriteHeader(http.StatusBadRequest)
+		r.writeError(writer, http.StatusBadRequest, domain.SubscribePair, err)
+		return
 	}
+	r.writeJSON(writer, http.StatusOK, pairsResponse{Pairs: r.exchange.GetPairs()})
 }
 
 func (r *Router) postUnsubscribe(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	ticker := vars[domain.PairVar]
-	err := r.subscriber.UnsubscribePairs(ticker)
+	err := r.exchange.UnsubscribePairs(ticker)
 	if err != nil {
-		r.logger.Errorf("%s endpoint: %s", domain.UnsubscribePair, err)
-		writer.WriteHeader(http.StatusBadRequest)
+		r.writeError(writer, http.StatusBadRequest, domain.UnsubscribePair, err)
+		return
 	}
+	r.writeJSON(writer, http.StatusOK, pairsResponse{Pairs: r.exchange.GetPairs()})
 }
 
 func (r *Router) postQuantity(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	quantity := vars[domain.ValueVal]
 	quantityInt, err := strconv.Atoi(quantity)
-	if err != nil {
-		r.logger.Errorf("%s endpoint: %s", domain.SetQuantity, err)
-		writer.WriteHeader(http.StatusBadRequest)
+	if err != nil || quantityInt <= 0 {
+		r.writeError(writer, http.StatusBadRequest, domain.SetQuantity, ErrInvalidQuantity)
+		return
 	}
-	r.options.SetTradingQuantity(quantityInt)
+	r.processor.SetTradingQuantity(quantityInt)
+	r.writeJSON(writer, http.StatusOK, quantityResponse{Quantity: quantityInt})
 }
 
 func (r *Router) postMultiplier(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	multiplier := vars[domain.ValueVal]
-	multiplierFloat, err := strconv.ParseFloat(multiplier, 32)
-	if err != nil {
-		r.logger.Errorf("%s endpoint: %s", domain.SetMultiplier, err)
-		writer.WriteHeader(http.StatusBadRequest)
+	multiplierFloat, err := strconv.ParseFloat(multiplier, 64)
+	if err != nil || multiplierFloat < 0 {
+		r.writeError(writer, http.StatusBadRequest, domain.SetMultiplier, ErrInvalidMultiplier)
+		return
 	}
-	r.options.SetPriceMultiplier(multiplierFloat)
+	r.processor.SetPriceMultiplier(multiplierFloat)
+	r.writeJSON(writer, http.StatusOK, multiplierResponse{Multiplier: multiplierFloat})
+}
+
+func (r *Router) getStatus(writer http.ResponseWriter, _ *http.Request) {
+	resp := statusResponse{
+		Pairs:     r.exchange.GetPairs(),
+		Connected: r.exchange.IsConnected(),
+		Position:  r.processor.GetPosition(),
+	}
+	if price, ok := r.exchange.GetLastPrice(); ok {
+		ts := time.Time(price.Time)
+		resp.LastPrice = price.Price
+		resp.LastPriceTime = &ts
+	}
+	if ts := r.processor.GetLastCandleTime(); !ts.IsZero() {
+		resp.LastCandleTime = &ts
+	}
+	r.writeJSON(writer, http.StatusOK, resp)
+}
+
+func (r *Router) getConfig(writer http.ResponseWriter, _ *http.Request) {
+	r.writeJSON(writer, http.StatusOK, configResponse{
+		Settings:   r.settings(),
+		Quantity:   r.processor.GetTradingQuantity(),
+		Multiplier: r.processor.GetPriceMultiplier(),
+	})
+}
+
+func (r *Router) getStrategy(writer http.ResponseWriter, _ *http.Request) {
+	r.writeJSON(writer, http.StatusOK, strategyResponse{Strategies: r.processor.GetStrategyState()})
+}
+
+func (r *Router) getPairs(writer http.ResponseWriter, _ *http.Request) {
+	r.writeJSON(writer, http.StatusOK, pairsResponse{Pairs: r.exchange.GetPairs()})
 }
@@ -0,0 +1,265 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExchangeMock struct {
+	mock.Mock
+}
+
+func (e *ExchangeMock) SubscribePairs(pairs ...string) error {
+	args := e.Called(pairs)
+	return args.Error(0)
+}
+
+func (e *ExchangeMock) UnsubscribePairs(pairs ...string) error {
+	args := e.Called(pairs)
+	return args.Error(0)
+}
+
+func (e *ExchangeMock) GetPairs() []string {
+	args := e.Called()
+	return args.Get(0).([]string)
+}
+
+func (e *ExchangeMock) IsConnected() bool {
+	args := e.Called()
+	return args.Bool(0)
+}
+
+func (e *ExchangeMock) GetLastPrice() (domain.Price, bool) {
+	args := e.Called()
+	return args.Get(0).(domain.Price), args.Bool(1)
+}
+
+type ProcessorMock struct {
+	mock.Mock
+}
+
+func (p *ProcessorMock) SetPriceMultiplier(m float64) {
+	p.Called(m)
+}
+
+func (p *ProcessorMock) SetTradingQuantity(q int) {
+	p.Called(q)
+}
+
+func (p *ProcessorMock) GetPriceMultiplier() float64 {
+	args := p.Called()
+	return args.Get(0).(float64)
+}
+
+func (p *ProcessorMock) GetTradingQuantity() int {
+	args := p.Called()
+	return args.Int(0)
+}
+
+func (p *ProcessorMock) GetPosition() int {
+	args := p.Called()
+	return args.Int(0)
+}
+
+func (p *ProcessorMock) GetLastCandleTime() time.Time {
+	args := p.Called()
+	return args.Get(0).(time.Time)
+}
+
+func (p *ProcessorMock) GetStrategyState() []indicator.StrategyState {
+	args := p.Called()
+	return args.Get(0).([]indicator.StrategyState)
+}
+
+type Environment struct {
+	suite.Suite
+	exchange  *ExchangeMock
+	processor *ProcessorMock
+	router    *Router
+}
+
+func (e *Environment) SetupTest() {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	e.exchange = new(ExchangeMock)
+	e.processor = new(ProcessorMock)
+	settings := func() map[string]interface{} {
+		return map[string]interface{}{"api": map[string]interface{}{"private_key": "REDACTED"}}
+	}
+	e.router = NewRouter(e.exchange, e.processor, settings, logger)
+}
+
+func (e *Environment) TearDownTest() {
+	e.exchange.AssertExpectations(e.T())
+	e.processor.AssertExpectations(e.T())
+}
+
+func (e *Environment) serve(method, target string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	e.Equal("application/json", rec.Header().Get("Content-Type"))
+	e.NoError(json.Unmarshal(rec.Body.Bytes(), v))
+	return rec.Code
+}
+
+func (e *Environment) TestSetters() {
+	testID := 0
+	e.T().Logf("\tTest %d:\tsubscribe success", testID)
+	{
+		e.exchange.On("SubscribePairs", []string{"PI_XBTUSD"}).Return(nil).Once()
+		e.exchange.On("GetPairs").Return([]string{"PI_XBTUSD"}).Once()
+		var resp pairsResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodPost, "/subscribe/PI_XBTUSD", &resp))
+		e.Equal([]string{"PI_XBTUSD"}, resp.Pairs)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tsubscribe error", testID)
+	{
+		e.exchange.On("SubscribePairs", []string{"PI_ETHUSD"}).Return(errors.New("can't subscribe")).Once()
+		var resp errorResponse
+		e.Equal(http.StatusBadRequest, e.serve(http.MethodPost, "/subscribe/PI_ETHUSD", &resp))
+		e.Equal(errorResponse{Error: "can't subscribe", Endpoint: domain.SubscribePair}, resp)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tquantity success", testID)
+	{
+		e.processor.On("SetTradingQuantity", 10).Once()
+		var resp quantityResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodPost, "/quantity/10", &resp))
+		e.Equal(10, resp.Quantity)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tquantity is not applied on parse error", testID)
+	{
+		var resp errorResponse
+		e.Equal(http.StatusBadRequest, e.serve(http.MethodPost, "/quantity/ten", &resp))
+		e.Equal(ErrInvalidQuantity.Error(), resp.Error)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tquantity is not applied if negative", testID)
+	{
+		var resp errorResponse
+		e.Equal(http.StatusBadRequest, e.serve(http.MethodPost, "/quantity/-5", &resp))
+		e.Equal(ErrInvalidQuantity.Error(), resp.Error)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tmultiplier success", testID)
+	{
+		e.processor.On("SetPriceMultiplier", 0.005).Once()
+		var resp multiplierResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodPost, "/multiplier/0.005", &resp))
+		e.Equal(0.005, resp.Multiplier)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tmultiplier parse error", testID)
+	{
+		var resp errorResponse
+		e.Equal(http.StatusBadRequest, e.serve(http.MethodPost, "/multiplier/abc", &resp))
+		e.Equal(ErrInvalidMultiplier.Error(), resp.Error)
+	}
+}
+
+func (e *Environment) TestGetters() {
+	testID := 0
+	e.T().Logf("\tTest %d:\tstatus", testID)
+	{
+		priceTime := time.Date(2021, 11, 25, 19, 5, 3, 0, time.UTC)
+		candleTime := time.Date(2021, 11, 25, 19, 4, 0, 0, time.UTC)
+		e.exchange.On("GetPairs").Return([]string{"PI_XBTUSD"}).Once()
+		e.exchange.On("IsConnected").Return(true).Once()
+		e.exchange.On("GetLastPrice").Return(domain.Price{Time: domain.UnixTS(priceTime), ProductID: "PI_XBTUSD", Price: 57000.5}, true).Once()
+		e.processor.On("GetPosition").Return(-100).Once()
+		e.processor.On("GetLastCandleTime").Return(candleTime).Once()
+
+		var resp statusResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/status", &resp))
+		e.Equal([]string{"PI_XBTUSD"}, resp.Pairs)
+		e.True(resp.Connected)
+		e.Equal(57000.5, resp.LastPrice)
+		e.True(priceTime.Equal(*resp.LastPriceTime))
+		e.True(candleTime.Equal(*resp.LastCandleTime))
+		e.Equal(-100, resp.Position)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tstatus without data", testID)
+	{
+		e.exchange.On("GetPairs").Return([]string{}).Once()
+		e.exchange.On("IsConnected").Return(false).Once()
+		e.exchange.On("GetLastPrice").Return(domain.Price{}, false).Once()
+		e.processor.On("GetPosition").Return(0).Once()
+		e.processor.On("GetLastCandleTime").Return(time.Time{}).Once()
+
+		var resp statusResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/status", &resp))
+		e.Nil(resp.LastPriceTime)
+		e.Nil(resp.LastCandleTime)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tconfig", testID)
+	{
+		e.processor.On("GetTradingQuantity").Return(100).Once()
+		e.processor.On("GetPriceMultiplier").Return(0.01).Once()
+
+		var resp configResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/config", &resp))
+		e.Equal(100, resp.Quantity)
+		e.Equal(0.01, resp.Multiplier)
+		e.Equal("REDACTED", resp.Settings["api"].(map[string]interface{})["private_key"])
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tstrategy", testID)
+	{
+		states := []indicator.StrategyState{{Name: "ema", Values: map[string]float64{"ema": 10, "price": 11}, Long: true}}
+		e.processor.On("GetStrategyState").Return(states).Once()
+
+		var resp strategyResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/strategy", &resp))
+		e.Equal(states, resp.Strategies)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tpairs", testID)
+	{
+		e.exchange.On("GetPairs").Return([]string{"PI_ETHUSD"}).Once()
+
+		var resp pairsResponse
+		e.Equal(http.StatusOK, e.serve(http.MethodGet, "/pairs", &resp))
+		e.Equal([]string{"PI_ETHUSD"}, resp.Pairs)
+	}
+}
+
+func (e *Environment) TestMetrics() {
+	e.T().Logf("\tTest %d:\tmetrics exposed", 0)
+	{
+		metrics.Candles.WithLabelValues("PI_XBTUSD").Inc()
+		rec := httptest.NewRecorder()
+		e.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		e.Equal(http.StatusOK, rec.Code)
+		e.Contains(rec.Body.String(), `trading_robot_candles_total{pair="PI_XBTUSD"}`)
+		e.Contains(rec.Body.String(), "go_goroutines")
+	}
+}
+
+func TestRouter(t *testing.T) {
+	suite.Run(t, new(Environment))
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+)
+
+const (
+	CancelOrdersParam = "cancel_orders"
+	FlattenParam      = "flatten"
+
+	cancelOrdersStep = "cancel_orders"
+	flattenStep      = "flatten"
+	shutdownStep     = "shutdown"
+
+	stepStarted = "started"
+	stepDone    = "done"
+	stepFailed  = "failed"
+)
+
+type ShutdownExchange interface {
+	CancelAllOrders() error
+	FlattenPositions() error
+}
+
+// Trading is halted before orders are cancelled and positions are flattened, so no new orders are sent meanwhile
+type Trading interface {
+	Halt()
+	Resume()
+}
+
+type Notifier interface {
+	NotifyUsers(message string)
+	NotifyError(message string)
+}
+
+type progressResponse struct {
+	Step   string `json:"step"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleShutdown adds endpoint that halts trading, optionally cancels all orders and flattens positions and then
+// calls shutdown. Progress is streamed to the response as JSON lines and sent to the notifier.
+// Shutdown is not called and trading is resumed if any of the requested steps fails.
+func (r *Router) HandleShutdown(exchange ShutdownExchange, trading Trading, notifier Notifier, shutdown func()) {
+	r.Methods(http.MethodPost).Path(domain.ShutdownOperation).HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		r.postShutdown(writer, request, exchange, trading, notifier, shutdown)
+	})
+}
+
+func (r *Router) postShutdown(writer http.ResponseWriter, request *http.Request, exchange ShutdownExchange, trading Trading,
+	notifier Notifier, shutdown func()) {
+	cancelOrders, err := parseBoolParam(request, CancelOrdersParam)
+	if err != nil {
+		r.writeError(writer, http.StatusBadRequest, domain.ShutdownOperation, err)
+		return
+	}
+	flatten, err := parseBoolParam(request, FlattenParam)
+	if err != nil {
+		r.writeError(writer, http.StatusBadRequest, domain.ShutdownOperation, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	writer.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(writer)
+	report := func(p progressResponse) {
+		if err := enc.Encode(p); err != nil {
+			r.logger.Errorf("Write shutdown progress failed: %s", err)
+		}
+		if f, ok := writer.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		message := fmt.Sprintf("Shutdown: %s %s", p.Step, p.Status)
+		if p.Error != "" {
+			notifier.NotifyError(message + ": " + p.Error)
+		} else {
+			notifier.NotifyUsers(message)
+		}
+	}
+
+	steps := []struct {
+		name    string
+		enabled bool
+		run     func() error
+	}{
+		{cancelOrdersStep, cancelOrders, exchange.CancelAllOrders},
+		{flattenStep, flatten, exchange.FlattenPositions},
+	}
+	trading.Halt()
+	for _, step := range steps {
+		if !step.enabled {
+			continue
+		}
+
+		report(progressResponse{Step: step.name, Status: stepStarted})
+		if err = step.run(); err != nil {
+			r.logger.Errorf("%s endpoint: %s", domain.ShutdownOperation, err)
+			report(progressResponse{Step: step.name, Status: stepFailed, Error: err.Error()})
+			trading.Resume()
+			return
+		}
+		report(progressResponse{Step: step.name, Status: stepDone})
+	}
+
+	report(progressResponse{Step: shutdownStep, Status: stepStarted})
+	shutdown()
+}
+
+func parseBoolParam(request *http.Request, name string) (bool, error) {
+	val := request.URL.Query().Get(name)
+	if val == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("%s must be a boolean", name)
+	}
+	return b, nil
+}
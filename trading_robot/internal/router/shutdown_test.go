@@ -0,0 +1,133 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type ShutdownExchangeMock struct {
+	mock.Mock
+}
+
+func (e *ShutdownExchangeMock) CancelAllOrders() error {
+	args := e.Called()
+	return args.Error(0)
+}
+
+func (e *ShutdownExchangeMock) FlattenPositions() error {
+	args := e.Called()
+	return args.Error(0)
+}
+
+type TradingStub struct {
+	calls []string
+}
+
+func (t *TradingStub) Halt() {
+	t.calls = append(t.calls, "halt")
+}
+
+func (t *TradingStub) Resume() {
+	t.calls = append(t.calls, "resume")
+}
+
+type NotifierMock struct {
+	mock.Mock
+}
+
+func (n *NotifierMock) NotifyUsers(message string) {
+	n.Called(message)
+}
+
+func (n *NotifierMock) NotifyError(message string) {
+	n.Called(message)
+}
+
+func (e *Environment) serveShutdown(exchange *ShutdownExchangeMock, trading *TradingStub, target string) ([]progressResponse, int, bool) {
+	notifier := new(NotifierMock)
+	notifier.On("NotifyUsers", mock.Anything).Return()
+	notifier.On("NotifyError", mock.Anything).Return()
+
+	called := false
+	e.SetupTest() // new router for each shutdown handler
+	e.router.HandleShutdown(exchange, trading, notifier, func() { called = true })
+
+	rec := httptest.NewRecorder()
+	e.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+
+	var progress []progressResponse
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		var p progressResponse
+		e.NoError(json.Unmarshal(scanner.Bytes(), &p))
+		progress = append(progress, p)
+	}
+	exchange.AssertExpectations(e.T())
+	return progress, rec.Code, called
+}
+
+func (e *Environment) TestShutdown() {
+	testID := 0
+	e.T().Logf("\tTest %d:\tplain shutdown", testID)
+	{
+		trading := &TradingStub{}
+		progress, code, called := e.serveShutdown(new(ShutdownExchangeMock), trading, "/shutdown")
+		e.Equal(http.StatusOK, code)
+		e.True(called)
+		e.Equal([]string{"halt"}, trading.calls)
+		e.Equal([]progressResponse{{Step: shutdownStep, Status: stepStarted}}, progress)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tcancel orders and flatten positions", testID)
+	{
+		exchange := new(ShutdownExchangeMock)
+		trading := &TradingStub{}
+		exchange.On("CancelAllOrders").Run(func(mock.Arguments) {
+			e.Equal([]string{"halt"}, trading.calls, "Trading should be halted before orders are cancelled")
+		}).Return(nil).Once()
+		exchange.On("FlattenPositions").Return(nil).Once()
+		progress, code, called := e.serveShutdown(exchange, trading, "/shutdown?cancel_orders=true&flatten=1")
+		e.Equal(http.StatusOK, code)
+		e.True(called)
+		e.Equal([]progressResponse{
+			{Step: cancelOrdersStep, Status: stepStarted},
+			{Step: cancelOrdersStep, Status: stepDone},
+			{Step: flattenStep, Status: stepStarted},
+			{Step: flattenStep, Status: stepDone},
+			{Step: shutdownStep, Status: stepStarted},
+		}, progress)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tfailed step aborts shutdown", testID)
+	{
+		exchange := new(ShutdownExchangeMock)
+		trading := &TradingStub{}
+		exchange.On("CancelAllOrders").Return(errors.New("cancel failed")).Once()
+		progress, _, called := e.serveShutdown(exchange, trading, "/shutdown?cancel_orders=true&flatten=true")
+		e.False(called)
+		e.Equal([]string{"halt", "resume"}, trading.calls, "Trading should be resumed if shutdown is aborted")
+		e.Equal([]progressResponse{
+			{Step: cancelOrdersStep, Status: stepStarted},
+			{Step: cancelOrdersStep, Status: stepFailed, Error: "cancel failed"},
+		}, progress)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tinvalid param", testID)
+	{
+		rec := httptest.NewRecorder()
+		called := false
+		e.SetupTest()
+		e.router.HandleShutdown(new(ShutdownExchangeMock), &TradingStub{}, new(NotifierMock), func() { called = true })
+		e.router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/shutdown?flatten=maybe", nil))
+		e.Equal(http.StatusBadRequest, rec.Code)
+		e.False(called)
+	}
+}
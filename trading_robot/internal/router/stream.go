@@ -0,0 +1,106 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+)
+
+const (
+	PairsParam = "pair"
+	TypesParam = "type"
+
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+)
+
+type Streamer interface {
+	Subscribe(f stream.Filter) *stream.Client
+	Unsubscribe(c *stream.Client)
+}
+
+// HandleStream adds WebSocket endpoint streaming JSON events of the hub. Clients can filter events with
+// comma separated pair and type query params, e.g. /stream?pair=PI_XBTUSD&type=candle,order
+func (r *Router) HandleStream(hub Streamer) {
+	upgrader := websocket.Upgrader{}
+	r.Methods(http.MethodGet).Path(domain.StreamOperation).HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		r.getStream(writer, request, hub, upgrader)
+	})
+}
+
+func (r *Router) getStream(writer http.ResponseWriter, request *http.Request, hub Streamer, upgrader websocket.Upgrader) {
+	filter := stream.Filter{
+		Pairs: parseSetParam(request, PairsParam),
+		Types: make(map[stream.EventType]bool),
+	}
+	for t := range parseSetParam(request, TypesParam) {
+		filter.Types[stream.EventType(t)] = true
+	}
+
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		// upgrader has already replied with error
+		r.logger.Errorf("%s endpoint: %s", domain.StreamOperation, err)
+		return
+	}
+	defer conn.Close()
+
+	client := hub.Subscribe(filter)
+	defer hub.Unsubscribe(client)
+
+	// read loop handles pongs and detects closed connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		_ = conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+				return
+			}
+		case event, ok := <-client.Events():
+			if !ok {
+				// client was dropped by hub for being too slow
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait))
+				return
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err = conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func parseSetParam(request *http.Request, name string) map[string]bool {
+	set := make(map[string]bool)
+	for _, val := range request.URL.Query()[name] {
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				set[item] = true
+			}
+		}
+	}
+	return set
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/stream"
+)
+
+func (e *Environment) TestStream() {
+	hub := stream.NewHub(1, e.router.logger)
+	e.router.HandleStream(hub)
+	srv := httptest.NewServer(e.router)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/stream"
+
+	waitClients := func(n int) {
+		e.Eventually(func() bool { return hub.Len() == n }, time.Second, time.Millisecond)
+	}
+
+	testID := 0
+	e.T().Logf("\tTest %d:\tfiltered events streamed", testID)
+	{
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?pair=PI_XBTUSD&type=candle,order", nil)
+		e.NoError(err)
+		defer conn.Close()
+		waitClients(1)
+
+		hub.Publish(stream.NewEvent(stream.TradeEvent, "PI_XBTUSD", nil))
+		hub.Publish(stream.NewEvent(stream.OrderEvent, "PI_ETHUSD", nil))
+		hub.Publish(stream.NewEvent(stream.CandleEvent, "PI_XBTUSD", map[string]float64{"Close": 10}))
+
+		var event struct {
+			Type string             `json:"type"`
+			Pair string             `json:"pair"`
+			Data map[string]float64 `json:"data"`
+		}
+		e.NoError(conn.ReadJSON(&event))
+		e.Equal("candle", event.Type)
+		e.Equal("PI_XBTUSD", event.Pair)
+		e.Equal(10.0, event.Data["Close"])
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tclosed client unsubscribed", testID)
+	{
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		e.NoError(err)
+		waitClients(2)
+		conn.Close()
+		waitClients(1)
+	}
+}
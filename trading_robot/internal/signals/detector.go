@@ -0,0 +1,143 @@
+// Package signals turns level-based strategy states into discrete actions on the position: a strategy stays long
+// while the price is above its indicator, but the position is entered once and then held until the state changes.
+package signals
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+)
+
+// Action is what the order of the decision does with the position
+type Action string
+
+const (
+	NoAction Action = ""
+	Enter    Action = "enter"   // open position from flat
+	Add      Action = "add"     // increase position in its direction, only with pyramiding
+	Exit     Action = "exit"    // close position, strategy is neither long nor short
+	Reverse  Action = "reverse" // close position and open the opposite one with a single order
+)
+
+// Decision is the action and side of the order to send for the candle
+type Decision struct {
+	Action Action
+	Side   domain.OrderType
+}
+
+// Settings set how often actions are taken. Cooldown is the min candle time between actions of a pair,
+// pyramiding is the max number of adds to an open position, zero disables adds.
+type Settings struct {
+	Cooldown   time.Duration
+	Pyramiding int
+}
+
+type pairState struct {
+	LastAction time.Time `json:"last_action"`
+	Adds       int       `json:"adds,omitempty"`
+}
+
+type Detector struct {
+	mu       sync.Mutex
+	settings Settings
+	pairs    map[string]pairState
+}
+
+func NewDetector() *Detector {
+	return &Detector{pairs: make(map[string]pairState)}
+}
+
+// SetSettings changes cooldown and pyramiding of the next decisions, negative values are zero
+func (d *Detector) SetSettings(s Settings) {
+	if s.Cooldown < 0 {
+		s.Cooldown = 0
+	}
+	if s.Pyramiding < 0 {
+		s.Pyramiding = 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.settings = s
+}
+
+func (d *Detector) GetSettings() Settings {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.settings
+}
+
+// Detect returns decision for the strategy state of the pair candle started at ts, position is the net position
+// in contracts, positive for long. Nothing is done while the position already follows the state or the last
+// action of the pair is within cooldown.
+func (d *Detector) Detect(pair string, ts time.Time, long, short bool, position int) Decision {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := d.pairs[pair]
+
+	var decision Decision
+	switch {
+	case long && position < 0:
+		decision = Decision{Action: Reverse, Side: domain.BuyOrder}
+	case short && position > 0:
+		decision = Decision{Action: Reverse, Side: domain.SellOrder}
+	case long && position == 0:
+		decision = Decision{Action: Enter, Side: domain.BuyOrder}
+	case short && position == 0:
+		decision = Decision{Action: Enter, Side: domain.SellOrder}
+	case long || short:
+		if state.Adds < d.settings.Pyramiding {
+			decision = Decision{Action: Add, Side: domain.BuyOrder}
+			if short {
+				decision.Side = domain.SellOrder
+			}
+		}
+	case position > 0:
+		decision = Decision{Action: Exit, Side: domain.SellOrder}
+	case position < 0:
+		decision = Decision{Action: Exit, Side: domain.BuyOrder}
+	}
+
+	if decision.Action != NoAction && !state.LastAction.IsZero() && ts.Sub(state.LastAction) < d.settings.Cooldown {
+		return Decision{}
+	}
+	return decision
+}
+
+// Record remembers the executed decision of the pair candle started at ts, cooldown starts at the candle
+func (d *Detector) Record(pair string, ts time.Time, decision Decision) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := d.pairs[pair]
+	state.LastAction = ts
+	if decision.Action == Add {
+		state.Adds++
+	} else {
+		state.Adds = 0
+	}
+	d.pairs[pair] = state
+}
+
+// Snapshot is the detector state persisted between restarts
+type Snapshot struct {
+	Pairs map[string]pairState `json:"pairs,omitempty"`
+}
+
+func (d *Detector) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := Snapshot{Pairs: make(map[string]pairState, len(d.pairs))}
+	for pair, state := range d.pairs {
+		s.Pairs[pair] = state
+	}
+	return s
+}
+
+// Restore applies the snapshot, it should be called before the detector is used
+func (d *Detector) Restore(s Snapshot) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for pair, state := range s.Pairs {
+		d.pairs[pair] = state
+	}
+}
@@ -0,0 +1,75 @@
+package signals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPair = "PI_XBTUSD"
+
+func TestDetector_Detect(t *testing.T) {
+	a := assert.New(t)
+	start := time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+
+	testID := 0
+	t.Logf("\tTest %d:\tstates are turned into actions by position", testID)
+	{
+		d := NewDetector()
+		for _, tc := range []struct {
+			long, short bool
+			position    int
+			expected    Decision
+		}{
+			{true, false, 0, Decision{Enter, domain.BuyOrder}},
+			{false, true, 0, Decision{Enter, domain.SellOrder}},
+			{true, false, 100, Decision{}},
+			{false, true, -100, Decision{}},
+			{true, false, -100, Decision{Reverse, domain.BuyOrder}},
+			{false, true, 100, Decision{Reverse, domain.SellOrder}},
+			{false, false, 100, Decision{Exit, domain.SellOrder}},
+			{false, false, -100, Decision{Exit, domain.BuyOrder}},
+			{false, false, 0, Decision{}},
+		} {
+			a.Equal(tc.expected, d.Detect(testPair, start, tc.long, tc.short, tc.position),
+				"long %v short %v position %d", tc.long, tc.short, tc.position)
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tactions are skipped within cooldown", testID)
+	{
+		d := NewDetector()
+		d.SetSettings(Settings{Cooldown: 5 * time.Minute})
+		d.Record(testPair, start, Decision{Enter, domain.BuyOrder})
+		a.Equal(Decision{}, d.Detect(testPair, start.Add(4*time.Minute), false, true, 100))
+		a.Equal(Decision{Reverse, domain.SellOrder}, d.Detect(testPair, start.Add(5*time.Minute), false, true, 100))
+		a.Equal(Decision{Enter, domain.BuyOrder}, d.Detect("PI_ETHUSD", start, true, false, 0), "Cooldown is per pair")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tadds are limited by pyramiding", testID)
+	{
+		d := NewDetector()
+		d.SetSettings(Settings{Pyramiding: 2})
+		d.Record(testPair, start, Decision{Enter, domain.SellOrder})
+		for i := 1; i <= 2; i++ {
+			decision := d.Detect(testPair, start.Add(time.Duration(i)*time.Minute), false, true, -100*i)
+			a.Equal(Decision{Add, domain.SellOrder}, decision)
+			d.Record(testPair, start.Add(time.Duration(i)*time.Minute), decision)
+		}
+		a.Equal(Decision{}, d.Detect(testPair, start.Add(3*time.Minute), false, true, -300))
+
+		restored := NewDetector()
+		restored.SetSettings(Settings{Pyramiding: 2})
+		restored.Restore(d.Snapshot())
+		a.Equal(d.Snapshot(), restored.Snapshot())
+		a.Equal(Decision{}, restored.Detect(testPair, start.Add(3*time.Minute), false, true, -300))
+
+		d.Record(testPair, start.Add(4*time.Minute), Decision{Reverse, domain.BuyOrder})
+		a.Equal(Decision{Add, domain.BuyOrder}, d.Detect(testPair, start.Add(5*time.Minute), true, false, 100),
+			"Adds are counted from the last entry")
+	}
+}
@@ -0,0 +1,245 @@
+// Package sizing sizes orders of signals by the account balance: a fixed number of contracts, a fraction of equity,
+// a fixed risk per trade at the stop distance or a volatility target. Orders that would need more margin than
+// available are blocked.
+package sizing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// Sizing rules
+const (
+	FixedRule      = "fixed"      // fixed number of contracts
+	EquityRule     = "equity"     // position value is the fraction of equity
+	RiskRule       = "risk"       // loss at the stop is the fraction of equity
+	VolatilityRule = "volatility" // position value change by one ATR is the fraction of equity
+)
+
+// DefaultATRPeriod is the number of candles of ATR used if the period is not set
+const DefaultATRPeriod = 14
+
+// Settings set how orders are sized, fraction is not used by the fixed rule
+type Settings struct {
+	Rule      string
+	Fraction  float64
+	ATRPeriod int
+}
+
+var (
+	ErrNoStop       = errors.New("stop distance is unknown")
+	ErrNoVolatility = errors.New("volatility is unknown")
+)
+
+// Account is the exchange reporting balance, positions and instruments
+type Account interface {
+	GetBalance() (domain.Balance, error)
+	GetOpenPositions() ([]domain.Position, error)
+	GetInstrument(symbol string) (domain.Instrument, error)
+}
+
+type Sizer struct {
+	account Account
+	logger  *log.Logger
+
+	settingsMu sync.RWMutex
+	settings   Settings
+
+	atrMu sync.Mutex
+	atr   map[string]*indicator.ATREvaluator
+}
+
+func NewSizer(a Account, l *log.Logger) *Sizer {
+	return &Sizer{
+		account:  a,
+		logger:   l,
+		settings: Settings{Rule: FixedRule, ATRPeriod: DefaultATRPeriod},
+		atr:      make(map[string]*indicator.ATREvaluator),
+	}
+}
+
+// SetSettings changes sizing of the next orders, empty rule is FixedRule and ATR period of zero is DefaultATRPeriod
+func (s *Sizer) SetSettings(settings Settings) {
+	if settings.Rule == "" {
+		settings.Rule = FixedRule
+	}
+	if settings.ATRPeriod <= 0 {
+		settings.ATRPeriod = DefaultATRPeriod
+	}
+	s.settingsMu.Lock()
+	s.settings = settings
+	s.settingsMu.Unlock()
+
+	s.atrMu.Lock()
+	defer s.atrMu.Unlock()
+	for _, atr := range s.atr {
+		atr.SetPeriod(settings.ATRPeriod)
+	}
+}
+
+func (s *Sizer) GetSettings() Settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.settings
+}
+
+// Update adds the candle to ATR of the pair
+func (s *Sizer) Update(candle domain.Candle) {
+	s.atrMu.Lock()
+	defer s.atrMu.Unlock()
+	s.evaluator(candle.Ticker).UpdateATR(candle.High, candle.Low, candle.Close)
+}
+
+// evaluator returns ATR of the pair. Should be called with locked mutex.
+func (s *Sizer) evaluator(pair string) *indicator.ATREvaluator {
+	atr, ok := s.atr[pair]
+	if !ok {
+		atr = indicator.NewATREvaluator(s.GetSettings().ATRPeriod)
+		s.atr[pair] = atr
+	}
+	return atr
+}
+
+func (s *Sizer) getATR(pair string) float64 {
+	s.atrMu.Lock()
+	defer s.atrMu.Unlock()
+	return s.evaluator(pair).GetATR()
+}
+
+// Size returns number of contracts of the order opened at the price. Stop distance is a fraction of the price
+// used by the risk rule, fixed is the number of contracts of the fixed rule. Orders increasing position by more
+// than available margin allows are rejected with domain.ErrInsufficientFunds.
+func (s *Sizer) Size(side domain.OrderType, pair string, price, stopDistance float64, fixed int) (int, error) {
+	instrument, err := s.account.GetInstrument(pair)
+	if err != nil {
+		return 0, err
+	}
+	balance, err := s.account.GetBalance()
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := s.size(instrument, balance, price, stopDistance, fixed)
+	if err != nil {
+		return 0, fmt.Errorf("size %s order: %w", pair, err)
+	}
+
+	position, err := s.position(pair)
+	if err != nil {
+		return 0, err
+	}
+	if err = checkMargin(instrument, balance, side, position, size, price); err != nil {
+		return 0, err
+	}
+	s.logger.Debugf("Sized %s %s order: %d contracts by %s rule, equity %.2f", side, pair, size, s.GetSettings().Rule, balance.Equity)
+	return size, nil
+}
+
+func (s *Sizer) size(instrument domain.Instrument, balance domain.Balance, price, stopDistance float64, fixed int) (int, error) {
+	settings := s.GetSettings()
+	contractValue := instrument.ContractValue(price)
+	if settings.Rule != FixedRule && contractValue <= 0 {
+		return 0, fmt.Errorf("%w: contract value of %s is unknown", domain.ErrInvalidRequest, instrument.Symbol)
+	}
+
+	var contracts float64
+	switch settings.Rule {
+	case FixedRule:
+		contracts = float64(fixed)
+	case EquityRule:
+		contracts = balance.Equity * settings.Fraction / contractValue
+	case RiskRule:
+		if stopDistance <= 0 {
+			return 0, ErrNoStop
+		}
+		contracts = balance.Equity * settings.Fraction / (contractValue * stopDistance)
+	case VolatilityRule:
+		atr := s.getATR(instrument.Symbol)
+		if atr <= 0 {
+			return 0, ErrNoVolatility
+		}
+		contracts = balance.Equity * settings.Fraction / (contractValue * atr / price)
+	default:
+		return 0, fmt.Errorf("%w: unknown sizing rule %q", domain.ErrInvalidRequest, settings.Rule)
+	}
+
+	size := int(math.Floor(contracts))
+	if size < 1 {
+		return 0, fmt.Errorf("%w: %s rule gives %.2f contracts", domain.ErrInvalidSize, settings.Rule, contracts)
+	}
+	return size, nil
+}
+
+// position returns net position of the pair on exchange, positive for long
+func (s *Sizer) position(pair string) (float64, error) {
+	positions, err := s.account.GetOpenPositions()
+	if err != nil {
+		return 0, err
+	}
+	var net float64
+	for _, p := range positions {
+		if p.Symbol != pair {
+			continue
+		}
+		if p.Side == domain.ShortPosition {
+			net -= p.Size
+		} else {
+			net += p.Size
+		}
+	}
+	return net, nil
+}
+
+// checkMargin rejects orders that increase the position by more contracts than available margin allows,
+// orders reducing the position need no margin. Position is margined in full if initial margin is unknown.
+func checkMargin(instrument domain.Instrument, balance domain.Balance, side domain.OrderType, position float64, size int, price float64) error {
+	after := position + float64(size)
+	if side == domain.SellOrder {
+		after = position - float64(size)
+	}
+	increase := math.Abs(after) - math.Abs(position)
+	if increase <= 0 {
+		return nil
+	}
+
+	margin := instrument.InitialMargin
+	if margin <= 0 {
+		margin = 1
+	}
+	required := increase * instrument.ContractValue(price) * margin
+	if required > balance.AvailableMargin {
+		return fmt.Errorf("%w: %d contracts of %s need %.2f margin, available %.2f",
+			domain.ErrInsufficientFunds, size, instrument.Symbol, required, balance.AvailableMargin)
+	}
+	return nil
+}
+
+// Snapshot is the sizer state persisted between restarts
+type Snapshot struct {
+	ATR map[string]indicator.ATRSnapshot `json:"atr,omitempty"`
+}
+
+func (s *Sizer) Snapshot() Snapshot {
+	s.atrMu.Lock()
+	defer s.atrMu.Unlock()
+	snapshot := Snapshot{ATR: make(map[string]indicator.ATRSnapshot)}
+	for pair, atr := range s.atr {
+		snapshot.ATR[pair] = atr.Snapshot()
+	}
+	return snapshot
+}
+
+// Restore applies the snapshot, it should be called before the sizer is used
+func (s *Sizer) Restore(snapshot Snapshot) {
+	s.atrMu.Lock()
+	defer s.atrMu.Unlock()
+	for pair, atr := range snapshot.ATR {
+		s.evaluator(pair).Restore(atr)
+	}
+}
@@ -0,0 +1,150 @@
+package sizing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type AccountStub struct {
+	balance     domain.Balance
+	positions   []domain.Position
+	instruments map[string]domain.Instrument
+}
+
+func (a *AccountStub) GetBalance() (domain.Balance, error) {
+	return a.balance, nil
+}
+
+func (a *AccountStub) GetOpenPositions() ([]domain.Position, error) {
+	return a.positions, nil
+}
+
+func (a *AccountStub) GetInstrument(symbol string) (domain.Instrument, error) {
+	instrument, ok := a.instruments[symbol]
+	if !ok {
+		return domain.Instrument{}, domain.ErrInvalidRequest
+	}
+	return instrument, nil
+}
+
+const (
+	inversePair = "PI_XBTUSD"
+	linearPair  = "BTCUSDT"
+)
+
+func newTestSizer(settings Settings) (*Sizer, *AccountStub) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	account := &AccountStub{
+		balance: domain.Balance{Equity: 10000, AvailableMargin: 10000},
+		instruments: map[string]domain.Instrument{
+			inversePair: {Symbol: inversePair, ContractSize: 1, Inverse: true, InitialMargin: 0.02, Tradeable: true},
+			linearPair:  {Symbol: linearPair, ContractSize: 0.001, InitialMargin: 0.05, Tradeable: true},
+		},
+	}
+	s := NewSizer(account, logger)
+	s.SetSettings(settings)
+	return s, account
+}
+
+func TestSizer_Rules(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tfixed contracts", testID)
+	{
+		s, _ := newTestSizer(Settings{})
+		size, err := s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.NoError(err)
+		a.Equal(100, size)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tpercent of equity", testID)
+	{
+		s, _ := newTestSizer(Settings{Rule: EquityRule, Fraction: 0.5})
+		size, err := s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.NoError(err)
+		a.Equal(5000, size)
+
+		size, err = s.Size(domain.SellOrder, linearPair, 50000, 0, 100)
+		a.NoError(err)
+		a.Equal(100, size, "Contract of 0.001 BTC is worth 50 USD")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tfixed risk per trade", testID)
+	{
+		s, _ := newTestSizer(Settings{Rule: RiskRule, Fraction: 0.01})
+		size, err := s.Size(domain.BuyOrder, inversePair, 50000, 0.02, 100)
+		a.NoError(err)
+		a.Equal(5000, size, "Loss of 2% of 5000 USD is 1% of equity")
+
+		_, err = s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.True(errors.Is(err, ErrNoStop))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tvolatility target", testID)
+	{
+		s, _ := newTestSizer(Settings{Rule: VolatilityRule, Fraction: 0.01, ATRPeriod: 2})
+		_, err := s.Size(domain.BuyOrder, linearPair, 50000, 0, 100)
+		a.True(errors.Is(err, ErrNoVolatility))
+
+		s.Update(domain.Candle{Ticker: linearPair, High: 50250, Low: 49750, Close: 50000})
+		size, err := s.Size(domain.BuyOrder, linearPair, 50000, 0, 100)
+		a.NoError(err)
+		a.Equal(200, size, "ATR of 500 USD moves 200 contracts by 1% of equity")
+
+		restored, _ := newTestSizer(Settings{Rule: VolatilityRule, Fraction: 0.01, ATRPeriod: 2})
+		restored.Restore(s.Snapshot())
+		a.Equal(s.Snapshot(), restored.Snapshot())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsize below one contract", testID)
+	{
+		s, _ := newTestSizer(Settings{Rule: EquityRule, Fraction: 0.001})
+		_, err := s.Size(domain.BuyOrder, linearPair, 50000, 0, 100)
+		a.True(errors.Is(err, domain.ErrInvalidSize))
+	}
+}
+
+func TestSizer_Margin(t *testing.T) {
+	a := assert.New(t)
+	s, account := newTestSizer(Settings{Rule: EquityRule, Fraction: 0.5})
+	account.balance.AvailableMargin = 50
+
+	testID := 0
+	t.Logf("\tTest %d:\torder exceeding available margin is blocked", testID)
+	{
+		_, err := s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.True(errors.Is(err, domain.ErrInsufficientFunds))
+		a.EqualError(err, "insufficient available funds: 5000 contracts of PI_XBTUSD need 100.00 margin, available 50.00")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\torder reducing position needs no margin", testID)
+	{
+		account.positions = []domain.Position{{Symbol: inversePair, Side: domain.LongPosition, Size: 5000}}
+		size, err := s.Size(domain.SellOrder, inversePair, 50000, 0, 100)
+		a.NoError(err)
+		a.Equal(5000, size)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tonly increase of reversed position is margined", testID)
+	{
+		account.positions = []domain.Position{{Symbol: inversePair, Side: domain.ShortPosition, Size: 2000}}
+		_, err := s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.NoError(err, "Position grows from 2000 short to 3000 long, 1000 contracts need 20 USD margin")
+
+		account.positions = []domain.Position{{Symbol: inversePair, Side: domain.ShortPosition, Size: 1000}}
+		_, err = s.Size(domain.BuyOrder, inversePair, 50000, 0, 100)
+		a.True(errors.Is(err, domain.ErrInsufficientFunds), "Position grows by 3000 contracts, which need 60 USD margin")
+	}
+}
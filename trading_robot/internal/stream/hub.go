@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+type EventType string
+
+const (
+	TradeEvent  EventType = "trade"
+	CandleEvent EventType = "candle"
+	SignalEvent EventType = "signal"
+	OrderEvent  EventType = "order"
+	FillEvent   EventType = "fill"
+
+	ConnectionEvent EventType = "connection"
+	OrderStateEvent EventType = "order_state"
+)
+
+const DefaultBufferSize = 256
+
+type Event struct {
+	Type EventType   `json:"type"`
+	Pair string      `json:"pair"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+func NewEvent(t EventType, pair string, data interface{}) Event {
+	return Event{
+		Type: t,
+		Pair: pair,
+		Time: time.Now(),
+		Data: data,
+	}
+}
+
+// Signal is data of the strategy signal event, action is what the order does with the position,
+// e.g. "enter" or "reverse"
+type Signal struct {
+	Side   string  `json:"side"`
+	Action string  `json:"action"`
+	Price  float64 `json:"price"`
+}
+
+// Connection is data of the exchange connection state event
+type Connection struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// Filter selects events by pair and type, empty sets match everything
+type Filter struct {
+	Pairs map[string]bool
+	Types map[EventType]bool
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.Pairs) != 0 && !f.Pairs[e.Pair] {
+		return false
+	}
+	if len(f.Types) != 0 && !f.Types[e.Type] {
+		return false
+	}
+	return true
+}
+
+type Client struct {
+	events chan Event
+	filter Filter
+}
+
+// Events returns channel of the client events. Channel is closed when client unsubscribes
+// or is dropped by hub for being too slow.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Hub fans out events to subscribed clients. Publish never blocks: clients whose buffer is full are dropped.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+	buffer  int
+
+	logger *log.Logger
+}
+
+func NewHub(buffer int, logger *log.Logger) *Hub {
+	if buffer <= 0 {
+		buffer = DefaultBufferSize
+	}
+	return &Hub{
+		clients: make(map[*Client]struct{}),
+		buffer:  buffer,
+		logger:  logger,
+	}
+}
+
+func (h *Hub) Subscribe(f Filter) *Client {
+	c := &Client{
+		events: make(chan Event, h.buffer),
+		filter: f,
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(c)
+}
+
+// remove should be called with locked mutex
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.events)
+	}
+}
+
+func (h *Hub) Publish(e Event) {
+	var slow []*Client
+
+	h.mu.RLock()
+	for c := range h.clients {
+		if !c.filter.Match(e) {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	for _, c := range slow {
+		h.remove(c)
+	}
+	h.mu.Unlock()
+	h.logger.Warnf("Stream hub: dropped %d slow clients", len(slow))
+}
+
+// Len returns number of subscribed clients
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
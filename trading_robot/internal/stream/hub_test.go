@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	a := assert.New(t)
+	candle := NewEvent(CandleEvent, "PI_XBTUSD", nil)
+	order := NewEvent(OrderEvent, "PI_ETHUSD", nil)
+
+	testID := 0
+	t.Logf("\tTest %d:\tempty filter matches everything", testID)
+	{
+		a.True(Filter{}.Match(candle))
+		a.True(Filter{}.Match(order))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tfilter by pair and type", testID)
+	{
+		f := Filter{
+			Pairs: map[string]bool{"PI_XBTUSD": true},
+			Types: map[EventType]bool{CandleEvent: true, OrderEvent: true},
+		}
+		a.True(f.Match(candle))
+		a.False(f.Match(order))
+		a.False(f.Match(NewEvent(TradeEvent, "PI_XBTUSD", nil)))
+	}
+}
+
+func TestHub(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+
+	testID := 0
+	t.Logf("\tTest %d:\tpublish to matching clients", testID)
+	{
+		h := NewHub(10, logger)
+		all := h.Subscribe(Filter{})
+		orders := h.Subscribe(Filter{Types: map[EventType]bool{OrderEvent: true}})
+
+		h.Publish(NewEvent(CandleEvent, "PI_XBTUSD", nil))
+		h.Publish(NewEvent(OrderEvent, "PI_XBTUSD", nil))
+
+		a.Len(all.events, 2)
+		a.Len(orders.events, 1)
+		a.Equal(OrderEvent, (<-orders.Events()).Type)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tslow client dropped without blocking others", testID)
+	{
+		h := NewHub(2, logger)
+		slow := h.Subscribe(Filter{})
+		fast := h.Subscribe(Filter{})
+
+		for i := 0; i < 3; i++ {
+			h.Publish(NewEvent(TradeEvent, "PI_XBTUSD", i))
+			<-fast.Events()
+		}
+		a.Equal(1, h.Len())
+
+		received := 0
+		for range slow.Events() {
+			received++
+		}
+		a.Equal(2, received, "Buffered events should be delivered before channel is closed")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tunsubscribe closes channel", testID)
+	{
+		h := NewHub(2, logger)
+		c := h.Subscribe(Filter{})
+		h.Unsubscribe(c)
+		h.Unsubscribe(c)
+		_, ok := <-c.Events()
+		a.False(ok)
+		a.Equal(0, h.Len())
+	}
+}
@@ -0,0 +1,449 @@
+// Package trailing moves stop orders of open positions after the best price since entry. Exchange stop orders
+// are edited in place if the venue supports it and cancelled and placed again otherwise. If the exchange can't
+// keep the stop, it is kept by the bot and the position is closed with a market order when the price crosses it.
+package trailing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/indicator"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// Distance modes of trailing stops
+const (
+	PercentMode  = "percent"  // distance is a fraction of the best price
+	AbsoluteMode = "absolute" // distance is a price difference
+	ATRMode      = "atr"      // distance is a multiple of average true range of the pair candles
+)
+
+// DefaultATRPeriod is the number of candles of ATR used if the period is not set
+const DefaultATRPeriod = 14
+
+// Settings set how far stops follow the best price, trailing is disabled if mode is empty or distance is zero
+type Settings struct {
+	Mode      string
+	Distance  float64
+	ATRPeriod int
+}
+
+func (s Settings) Enabled() bool {
+	return s.Mode != "" && s.Distance > 0
+}
+
+type OrderSender interface {
+	CreateOrder(order domain.Order) (domain.CreateOrderResponse, error)
+}
+
+type Exchange interface {
+	OrderSender
+	CancelOrder(order domain.Order) error
+}
+
+// Editor is implemented by exchanges that can change stop price of resting orders
+type Editor interface {
+	EditOrder(order domain.Order) (domain.CreateOrderResponse, error)
+}
+
+type Repository interface {
+	StoreStopMove(ctx context.Context, m domain.StopMove) error
+}
+
+type Notifier interface {
+	NotifyUsers(message string)
+	NotifyError(message string)
+}
+
+// Stop is a trailing stop of the pair position
+type Stop struct {
+	SignalID string       `json:"signal_id"` // signal of the entry
+	Order    domain.Order `json:"order"`     // exit side and size, order ID is empty until placed and for local stops
+	Best     float64      `json:"best"`      // best price since entry
+	Level    float64      `json:"level"`     // stop price, zero until the stop is placed
+	Local    bool         `json:"local"`     // stop is kept by the bot
+}
+
+func (s *Stop) long() bool {
+	return s.Order.Side == string(domain.SellOrder)
+}
+
+// crossed reports whether the candle reached the stop
+func (s *Stop) crossed(candle domain.Candle) bool {
+	if s.Level == 0 {
+		return false
+	}
+	if s.long() {
+		return candle.Low <= s.Level
+	}
+	return candle.High >= s.Level
+}
+
+func (s *Stop) updateBest(candle domain.Candle) {
+	if s.long() && candle.High > s.Best {
+		s.Best = candle.High
+	}
+	if !s.long() && candle.Low < s.Best {
+		s.Best = candle.Low
+	}
+}
+
+// improves reports whether the level is closer to the best price than the stop, stops are never moved back
+func (s *Stop) improves(level float64) bool {
+	if s.Level == 0 {
+		return true
+	}
+	if s.long() {
+		return level > s.Level
+	}
+	return level < s.Level
+}
+
+// errStopGone means exchange stop order is not found, it was triggered or cancelled
+var errStopGone = errors.New("stop order is gone")
+
+type Trailer struct {
+	exchange Exchange
+	sender   OrderSender // places orders, the exchange by default
+	repo     Repository
+	notifier Notifier
+	logger   *log.Logger
+
+	settingsMu sync.RWMutex
+	settings   Settings
+
+	mu    sync.Mutex
+	atr   map[string]*indicator.ATREvaluator
+	stops map[string]*Stop
+}
+
+func NewTrailer(e Exchange, r Repository, n Notifier, l *log.Logger) *Trailer {
+	return &Trailer{
+		exchange: e,
+		sender:   e,
+		repo:     r,
+		notifier: n,
+		logger:   l,
+		settings: Settings{ATRPeriod: DefaultATRPeriod},
+		atr:      make(map[string]*indicator.ATREvaluator),
+		stops:    make(map[string]*Stop),
+	}
+}
+
+// SetOrderSender makes trailer place orders with s, e.g. with orders manager tracking them.
+// It should be called before the trailer is used.
+func (t *Trailer) SetOrderSender(s OrderSender) {
+	t.sender = s
+}
+
+// SetSettings changes distance of the next moves, ATR period of zero is DefaultATRPeriod
+func (t *Trailer) SetSettings(s Settings) {
+	if s.ATRPeriod <= 0 {
+		s.ATRPeriod = DefaultATRPeriod
+	}
+	t.settingsMu.Lock()
+	t.settings = s
+	t.settingsMu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, atr := range t.atr {
+		atr.SetPeriod(s.ATRPeriod)
+	}
+}
+
+func (t *Trailer) GetSettings() Settings {
+	t.settingsMu.RLock()
+	defer t.settingsMu.RUnlock()
+	return t.settings
+}
+
+func (t *Trailer) Enabled() bool {
+	return t.GetSettings().Enabled()
+}
+
+// Start replaces stop of the pair with trailing stop of the position entered at the price. The stop is the
+// reduce-only stop order closing the position, its stop price is set by the trailer.
+func (t *Trailer) Start(signalID string, stop domain.Order, entry float64, ts time.Time) {
+	t.Cancel(stop.Symbol)
+
+	stop.OrderID = ""
+	stop.CliOrdID = domain.ProtectiveOrderID(signalID, domain.StopOrder)
+	s := &Stop{
+		SignalID: signalID,
+		Order:    stop,
+		Best:     entry,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stops[stop.Symbol] = s
+	t.trail(s, entry, ts)
+}
+
+// Cancel stops trailing of the pair and cancels its exchange stop order
+func (t *Trailer) Cancel(pair string) {
+	t.mu.Lock()
+	s, ok := t.stops[pair]
+	delete(t.stops, pair)
+	t.mu.Unlock()
+	if !ok || s.Order.OrderID == "" {
+		return
+	}
+
+	err := t.exchange.CancelOrder(s.Order)
+	if err != nil && !errors.Is(err, domain.ErrOrderNotFound) {
+		t.report(fmt.Errorf("cancel trailing stop %s: %w", s.Order.OrderID, err))
+	}
+}
+
+// Update adds the candle to ATR of the pair and moves its stop after the best price. If the candle crossed
+// the stop kept by the bot, the position is closed with market order and the response is returned,
+// otherwise the response is empty.
+func (t *Trailer) Update(candle domain.Candle) domain.CreateOrderResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.evaluator(candle.Ticker).UpdateATR(candle.High, candle.Low, candle.Close)
+
+	s, ok := t.stops[candle.Ticker]
+	if !ok {
+		return domain.CreateOrderResponse{}
+	}
+
+	if s.Local && s.crossed(candle) {
+		resp, err := t.exit(s)
+		if err != nil {
+			t.report(fmt.Errorf("trailing stop exit: %w", err))
+			return domain.CreateOrderResponse{}
+		}
+		delete(t.stops, candle.Ticker)
+		return resp
+	}
+
+	s.updateBest(candle)
+	t.trail(s, candle.Close, candle.TS)
+	return domain.CreateOrderResponse{}
+}
+
+func (t *Trailer) evaluator(pair string) *indicator.ATREvaluator {
+	atr, ok := t.atr[pair]
+	if !ok {
+		atr = indicator.NewATREvaluator(t.GetSettings().ATRPeriod)
+		t.atr[pair] = atr
+	}
+	return atr
+}
+
+// StopDistance returns distance of a stop placed at the price as a fraction of the price,
+// zero if trailing is disabled or the distance is not known yet
+func (t *Trailer) StopDistance(pair string, price float64) float64 {
+	if price <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.distance(pair, price) / price
+}
+
+// distance returns price difference between the price and the stop of the pair. Should be called with locked mutex.
+func (t *Trailer) distance(pair string, price float64) float64 {
+	settings := t.GetSettings()
+	switch settings.Mode {
+	case PercentMode:
+		return price * settings.Distance
+	case AbsoluteMode:
+		return settings.Distance
+	case ATRMode:
+		return t.evaluator(pair).GetATR() * settings.Distance
+	}
+	return 0
+}
+
+// level returns stop price at the distance from the best price, zero if the distance is not known yet
+func (t *Trailer) level(s *Stop) float64 {
+	distance := t.distance(s.Order.Symbol, s.Best)
+	if distance <= 0 {
+		return 0
+	}
+	if s.long() {
+		return s.Best - distance
+	}
+	return s.Best + distance
+}
+
+// trail moves the stop to the level if it is closer to the best price and is not crossed by the price.
+// Should be called with locked mutex.
+func (t *Trailer) trail(s *Stop, price float64, ts time.Time) {
+	level := t.level(s)
+	if level <= 0 || !s.improves(level) || (s.long() && level >= price) || (!s.long() && level <= price) {
+		return
+	}
+
+	m, err := t.move(s, level, ts)
+	switch {
+	case errors.Is(err, errStopGone):
+		t.logger.Infof("Trailing stop %s of %s is triggered or cancelled", s.Order.OrderID, s.Order.Symbol)
+		delete(t.stops, s.Order.Symbol)
+		return
+	case err != nil:
+		t.report(fmt.Errorf("move trailing stop of %s: %w", s.Order.Symbol, err))
+		return
+	}
+
+	t.logger.Infof("Trailing stop of %s moved from %v to %v (%s)", m.Pair, m.OldStop, m.NewStop, m.Method)
+	t.notifier.NotifyUsers(m.String())
+	if err = t.repo.StoreStopMove(context.Background(), m); err != nil {
+		t.report(err)
+	}
+}
+
+// move changes stop price on exchange, the stop is kept by the bot if exchange can't place it
+func (t *Trailer) move(s *Stop, level float64, ts time.Time) (domain.StopMove, error) {
+	m := domain.StopMove{
+		Pair:      s.Order.Symbol,
+		Side:      s.Order.Side,
+		Method:    domain.StopLocal,
+		BestPrice: s.Best,
+		OldStop:   s.Level,
+		NewStop:   level,
+		Time:      ts,
+	}
+
+	if !s.Local {
+		order := s.Order
+		order.StopPrice = level
+
+		var err error
+		editor, canEdit := t.exchange.(Editor)
+		switch {
+		case order.OrderID == "":
+			m.Method = domain.StopPlaced
+			order, err = t.place(order)
+			if errors.Is(err, domain.ErrInvalidRequest) || errors.Is(err, domain.ErrOrderRejected) {
+				t.keepLocally(s, err)
+				err = nil
+			}
+		case canEdit:
+			m.Method = domain.StopEdited
+			_, err = editor.EditOrder(order)
+		default:
+			m.Method = domain.StopReplaced
+			order, err = t.replace(s, order)
+		}
+		if errors.Is(err, domain.ErrOrderNotFound) {
+			return domain.StopMove{}, errStopGone
+		}
+		if err != nil {
+			return domain.StopMove{}, err
+		}
+		if s.Local {
+			m.Method = domain.StopLocal
+		} else {
+			s.Order = order
+		}
+	}
+
+	s.Level = level
+	m.OrderID = s.Order.OrderID
+	return m, nil
+}
+
+func (t *Trailer) place(order domain.Order) (domain.Order, error) {
+	resp, err := t.sender.CreateOrder(order)
+	if err != nil {
+		return domain.Order{}, err
+	}
+	if resp.Status != domain.PlacedStatus {
+		return domain.Order{}, fmt.Errorf("%w: stop order status %s", domain.ErrOrderRejected, resp.Status)
+	}
+	order.OrderID = resp.OrderID
+	return order, nil
+}
+
+// replace cancels the stop order and places the order instead. Replacements have no client order ID,
+// as the ID of the first order can't be reused. If the order can't be placed after cancel, the stop is kept
+// by the bot not to leave the position unprotected.
+func (t *Trailer) replace(s *Stop, order domain.Order) (domain.Order, error) {
+	if err := t.exchange.CancelOrder(s.Order); err != nil {
+		return domain.Order{}, err
+	}
+
+	order.OrderID, order.CliOrdID = "", ""
+	placed, err := t.place(order)
+	if err != nil {
+		t.keepLocally(s, err)
+		return domain.Order{}, nil
+	}
+	return placed, nil
+}
+
+func (t *Trailer) keepLocally(s *Stop, err error) {
+	s.Local = true
+	s.Order.OrderID = ""
+	t.report(fmt.Errorf("trailing stop of %s is kept by the bot: %w", s.Order.Symbol, err))
+}
+
+// exit closes the position of the stop with reduce-only market order
+func (t *Trailer) exit(s *Stop) (domain.CreateOrderResponse, error) {
+	order := domain.CreateMarketOrder(domain.OrderType(s.Order.Side), s.Order.Symbol, s.Order.Size)
+	order.CliOrdID = domain.ProtectiveOrderID(s.SignalID, domain.MarketOrder)
+	resp, err := t.sender.CreateOrder(order)
+	if err != nil {
+		return domain.CreateOrderResponse{}, err
+	}
+	t.logger.Infof("Trailing stop of %s crossed at %v, position closed", s.Order.Symbol, s.Level)
+	return resp, nil
+}
+
+func (t *Trailer) report(err error) {
+	t.logger.Error(err)
+	t.notifier.NotifyError(err.Error())
+}
+
+// Stops returns trailing stops by pair
+func (t *Trailer) Stops() map[string]Stop {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stops := make(map[string]Stop, len(t.stops))
+	for pair, s := range t.stops {
+		stops[pair] = *s
+	}
+	return stops
+}
+
+// Snapshot is the trailer state persisted between restarts
+type Snapshot struct {
+	Stops map[string]Stop                  `json:"stops,omitempty"`
+	ATR   map[string]indicator.ATRSnapshot `json:"atr,omitempty"`
+}
+
+func (t *Trailer) Snapshot() Snapshot {
+	s := Snapshot{
+		Stops: t.Stops(),
+		ATR:   make(map[string]indicator.ATRSnapshot),
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pair, atr := range t.atr {
+		s.ATR[pair] = atr.Snapshot()
+	}
+	return s
+}
+
+// Restore applies the snapshot, it should be called before the trailer is used
+func (t *Trailer) Restore(s Snapshot) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for pair, stop := range s.Stops {
+		stop := stop
+		t.stops[pair] = &stop
+	}
+	for pair, atr := range s.ATR {
+		t.evaluator(pair).Restore(atr)
+	}
+}
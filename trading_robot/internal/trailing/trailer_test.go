@@ -0,0 +1,285 @@
+package trailing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type ExchangeMock struct {
+	mock.Mock
+}
+
+func (e *ExchangeMock) CreateOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	args := e.Called(order)
+	return args.Get(0).(domain.CreateOrderResponse), args.Error(1)
+}
+
+func (e *ExchangeMock) CancelOrder(order domain.Order) error {
+	return e.Called(order).Error(0)
+}
+
+type EditorMock struct {
+	ExchangeMock
+}
+
+func (e *EditorMock) EditOrder(order domain.Order) (domain.CreateOrderResponse, error) {
+	args := e.Called(order)
+	return args.Get(0).(domain.CreateOrderResponse), args.Error(1)
+}
+
+type RepoStub struct {
+	mu    sync.Mutex
+	moves []domain.StopMove
+}
+
+func (r *RepoStub) StoreStopMove(_ context.Context, m domain.StopMove) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.moves = append(r.moves, m)
+	return nil
+}
+
+type NotifierStub struct {
+	users  []string
+	errors []string
+}
+
+func (n *NotifierStub) NotifyUsers(message string) {
+	n.users = append(n.users, message)
+}
+
+func (n *NotifierStub) NotifyError(message string) {
+	n.errors = append(n.errors, message)
+}
+
+const (
+	testPair = "TEST"
+	signalID = "TEST-1637866800-buy"
+)
+
+var start = time.Date(2021, 11, 25, 19, 0, 0, 0, time.UTC)
+
+func candle(minute int, high, low, close float64) domain.Candle {
+	return domain.Candle{
+		Ticker: testPair,
+		High:   high,
+		Low:    low,
+		Close:  close,
+		TS:     start.Add(time.Duration(minute) * time.Minute),
+	}
+}
+
+func placed(id string) domain.CreateOrderResponse {
+	return domain.CreateOrderResponse{Symbol: testPair, Status: domain.PlacedStatus, OrderID: id}
+}
+
+func stopOrder(side domain.OrderType) domain.Order {
+	return domain.CreateStopOrder(side, testPair, 0, 10, domain.MarkPriceTrigger)
+}
+
+func stopPrice(price float64) interface{} {
+	return mock.MatchedBy(func(o domain.Order) bool { return o.StopPrice > price-1e-9 && o.StopPrice < price+1e-9 })
+}
+
+func newTestTrailer(e Exchange, settings Settings) (*Trailer, *RepoStub, *NotifierStub) {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	repo, notifier := &RepoStub{}, &NotifierStub{}
+	t := NewTrailer(e, repo, notifier, logger)
+	t.SetSettings(settings)
+	return t, repo, notifier
+}
+
+func TestTrailer_Edit(t *testing.T) {
+	a := assert.New(t)
+	ex := new(EditorMock)
+	trailer, repo, notifier := newTestTrailer(ex, Settings{Mode: PercentMode, Distance: 0.1})
+
+	testID := 0
+	t.Logf("\tTest %d:\tstop placed at entry", testID)
+	{
+		ex.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderType == domain.StopOrder && o.Side == "sell" && o.StopPrice == 90 &&
+				o.CliOrdID == signalID+"-sl" && o.ReduceOnly == domain.ReduceOnly
+		})).Return(placed("stop-1"), nil).Once()
+		trailer.Start(signalID, stopOrder(domain.SellOrder), 100, start)
+
+		stop := trailer.Stops()[testPair]
+		a.Equal(90.0, stop.Level)
+		a.Equal("stop-1", stop.Order.OrderID)
+		a.Equal(domain.StopMove{
+			Pair:      testPair,
+			Side:      "sell",
+			OrderID:   "stop-1",
+			Method:    domain.StopPlaced,
+			BestPrice: 100,
+			NewStop:   90,
+			Time:      start,
+		}, repo.moves[0])
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop follows new high", testID)
+	{
+		ex.On("EditOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.OrderID == "stop-1" && o.StopPrice == 99
+		})).Return(placed("stop-1"), nil).Once()
+		a.Empty(trailer.Update(candle(1, 110, 100, 105)).Status)
+
+		a.Equal(99.0, trailer.Stops()[testPair].Level)
+		a.Len(repo.moves, 2)
+		a.Equal(domain.StopEdited, repo.moves[1].Method)
+		a.Equal(90.0, repo.moves[1].OldStop)
+		a.Len(notifier.users, 2)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop is not moved back", testID)
+	{
+		trailer.Update(candle(2, 108, 100, 101))
+		a.Equal(99.0, trailer.Stops()[testPair].Level)
+		a.Equal(110.0, trailer.Stops()[testPair].Best)
+		a.Len(repo.moves, 2)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\ttriggered stop is forgotten", testID)
+	{
+		ex.On("EditOrder", mock.Anything).Return(domain.CreateOrderResponse{}, domain.ErrOrderNotFound).Once()
+		trailer.Update(candle(3, 120, 105, 115))
+		a.Empty(trailer.Stops())
+		a.Empty(notifier.errors)
+	}
+	ex.AssertExpectations(t)
+}
+
+func TestTrailer_Replace(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tstop of short position is cancelled and placed again", testID)
+	{
+		ex := new(ExchangeMock)
+		trailer, repo, _ := newTestTrailer(ex, Settings{Mode: AbsoluteMode, Distance: 5})
+
+		ex.On("CreateOrder", stopPrice(105)).Return(placed("stop-1"), nil).Once()
+		trailer.Start(signalID, stopOrder(domain.BuyOrder), 100, start)
+
+		ex.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "stop-1" })).Return(nil).Once()
+		ex.On("CreateOrder", mock.MatchedBy(func(o domain.Order) bool {
+			return o.StopPrice == 95 && o.Side == "buy" && o.CliOrdID == "" && o.OrderID == ""
+		})).Return(placed("stop-2"), nil).Once()
+		trailer.Update(candle(1, 98, 90, 92))
+
+		a.Equal("stop-2", trailer.Stops()[testPair].Order.OrderID)
+		a.Equal(domain.StopReplaced, repo.moves[1].Method)
+		a.Equal("stop-2", repo.moves[1].OrderID)
+
+		ex.On("CancelOrder", mock.MatchedBy(func(o domain.Order) bool { return o.OrderID == "stop-2" })).Return(nil).Once()
+		trailer.Cancel(testPair)
+		a.Empty(trailer.Stops())
+		ex.AssertExpectations(t)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstop is kept by the bot if replacement fails", testID)
+	{
+		ex := new(ExchangeMock)
+		trailer, repo, notifier := newTestTrailer(ex, Settings{Mode: AbsoluteMode, Distance: 5})
+
+		ex.On("CreateOrder", stopPrice(95)).Return(placed("stop-1"), nil).Once()
+		trailer.Start(signalID, stopOrder(domain.SellOrder), 100, start)
+
+		ex.On("CancelOrder", mock.Anything).Return(nil).Once()
+		ex.On("CreateOrder", stopPrice(105)).Return(domain.CreateOrderResponse{}, domain.ErrInvalidPrice).Once()
+		trailer.Update(candle(1, 110, 100, 108))
+
+		stop := trailer.Stops()[testPair]
+		a.True(stop.Local)
+		a.Empty(stop.Order.OrderID)
+		a.Equal(105.0, stop.Level)
+		a.Equal(domain.StopLocal, repo.moves[1].Method)
+		a.Len(notifier.errors, 1)
+		ex.AssertExpectations(t)
+	}
+}
+
+func TestTrailer_Local(t *testing.T) {
+	a := assert.New(t)
+	ex := new(ExchangeMock)
+	trailer, repo, notifier := newTestTrailer(ex, Settings{Mode: PercentMode, Distance: 0.1})
+
+	testID := 0
+	t.Logf("\tTest %d:\tstop is kept by the bot if exchange can't place it", testID)
+	{
+		ex.On("CreateOrder", stopPrice(90)).Return(domain.CreateOrderResponse{}, domain.ErrInvalidRequest).Once()
+		trailer.Start(signalID, stopOrder(domain.SellOrder), 100, start)
+
+		stop := trailer.Stops()[testPair]
+		a.True(stop.Local)
+		a.Equal(90.0, stop.Level)
+		a.Equal(domain.StopLocal, repo.moves[0].Method)
+		a.Len(notifier.errors, 1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tlocal stop follows price without orders", testID)
+	{
+		trailer.Update(candle(1, 120, 100, 115))
+		a.Equal(108.0, trailer.Stops()[testPair].Level)
+		a.Len(repo.moves, 2)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tposition is closed with market order when price crosses stop", testID)
+	{
+		exit := domain.CreateOrderResponse{Symbol: testPair, Side: "sell", Size: 10, Status: domain.PlacedStatus, OrderEventType: domain.ExecutionEvent}
+		ex.On("CreateOrder", domain.Order{
+			OrderType:  domain.MarketOrder,
+			Symbol:     testPair,
+			Side:       "sell",
+			Size:       10,
+			CliOrdID:   signalID + "-ex",
+			ReduceOnly: domain.ReduceOnly,
+		}).Return(exit, nil).Once()
+		a.Equal(exit, trailer.Update(candle(2, 112, 105, 106)))
+		a.Empty(trailer.Stops())
+	}
+	ex.AssertExpectations(t)
+}
+
+func TestTrailer_ATR(t *testing.T) {
+	a := assert.New(t)
+	ex := new(EditorMock)
+	trailer, _, _ := newTestTrailer(ex, Settings{Mode: ATRMode, Distance: 2, ATRPeriod: 2})
+
+	testID := 0
+	t.Logf("\tTest %d:\tstop is placed at ATR multiple", testID)
+	{
+		trailer.Update(candle(0, 102, 98, 100)) // ATR 4
+		ex.On("CreateOrder", stopPrice(92)).Return(placed("stop-1"), nil).Once()
+		trailer.Start(signalID, stopOrder(domain.SellOrder), 100, start)
+		a.Equal(92.0, trailer.Stops()[testPair].Level)
+		a.Equal(0.08, trailer.StopDistance(testPair, 100))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstate restored from snapshot", testID)
+	{
+		restored, _, _ := newTestTrailer(ex, Settings{Mode: ATRMode, Distance: 2, ATRPeriod: 2})
+		restored.Restore(trailer.Snapshot())
+		a.Equal(trailer.Snapshot(), restored.Snapshot())
+
+		ex.On("EditOrder", stopPrice(96)).Return(placed("stop-1"), nil).Once() // ATR 4, best 104
+		restored.Update(candle(1, 104, 100, 103))
+		a.Equal(96.0, restored.Stops()[testPair].Level)
+	}
+	ex.AssertExpectations(t)
+}
@@ -0,0 +1,73 @@
+package indicator
+
+import (
+	"math"
+	"sync"
+)
+
+// WilderAlpha is the smoothing factor 1 / period of Wilder's moving average used by ATR
+func WilderAlpha(p int) float64 {
+	return 1 / float64(p)
+}
+
+// TrueRange is the greatest of the candle range and distances from the previous close to the candle high and low
+func TrueRange(high, low, prevClose float64) float64 {
+	return math.Max(high-low, math.Max(math.Abs(high-prevClose), math.Abs(low-prevClose)))
+}
+
+// ATREvaluator evaluates average true range, Wilder's moving average of true ranges of candles
+type ATREvaluator struct {
+	mu sync.RWMutex // mutex to protect atr evaluator
+
+	average   *EMAEvaluator
+	prevClose float64 // close of the previous candle, zero before the first candle
+}
+
+func NewATREvaluator(period int) *ATREvaluator {
+	return &ATREvaluator{
+		average: NewEMAEvaluator(period, WilderAlpha),
+	}
+}
+
+// SetPeriod changes period of the evaluator, accumulated ATR value is kept
+func (a *ATREvaluator) SetPeriod(period int) {
+	a.average.SetPeriod(period)
+}
+
+// UpdateATR adds candle, true range of the first candle is its range
+func (a *ATREvaluator) UpdateATR(high, low, close float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prevClose := a.prevClose
+	if prevClose == 0 {
+		prevClose = close
+	}
+	a.average.UpdateEMA(TrueRange(high, low, prevClose))
+	a.prevClose = close
+}
+
+func (a *ATREvaluator) GetATR() float64 {
+	return a.average.GetEMA()
+}
+
+// ATRSnapshot is the accumulated state of ATR evaluator
+type ATRSnapshot struct {
+	Average   EMASnapshot `json:"average"`
+	PrevClose float64     `json:"prev_close"`
+}
+
+func (a *ATREvaluator) Snapshot() ATRSnapshot {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return ATRSnapshot{
+		Average:   a.average.Snapshot(),
+		PrevClose: a.prevClose,
+	}
+}
+
+func (a *ATREvaluator) Restore(s ATRSnapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.average.Restore(s.Average)
+	a.prevClose = s.PrevClose
+}
@@ -0,0 +1,56 @@
+package indicator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrueRange(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tcandle range", testID)
+	{
+		a.Equal(4.0, TrueRange(12, 8, 10))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tgaps from previous close", testID)
+	{
+		a.Equal(7.0, TrueRange(12, 10, 5))
+		a.Equal(6.0, TrueRange(10, 9, 15))
+	}
+}
+
+func TestATREvaluator(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tatr of candles", testID)
+	{
+		atr := NewATREvaluator(2)
+		atr.UpdateATR(12, 8, 10) // range 4
+		a.Equal(4.0, atr.GetATR())
+		atr.UpdateATR(18, 14, 16) // gap from 10 to 18 is 8
+		a.Equal(6.0, atr.GetATR())
+		atr.UpdateATR(17, 15, 16) // range 2
+		a.Equal(4.0, atr.GetATR())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tatr restored from snapshot", testID)
+	{
+		atr := NewATREvaluator(2)
+		atr.UpdateATR(12, 8, 10)
+		atr.UpdateATR(18, 14, 16)
+
+		restored := NewATREvaluator(2)
+		restored.Restore(atr.Snapshot())
+		a.Equal(atr.Snapshot(), restored.Snapshot())
+
+		atr.UpdateATR(17, 15, 16)
+		restored.UpdateATR(17, 15, 16)
+		a.Equal(atr.GetATR(), restored.GetATR())
+	}
+}
@@ -5,20 +5,29 @@ import "sync"
 type EMAEvaluator struct {
 	mu sync.RWMutex // mutex to protect ema evaluator
 
-	counter int     // value counter
-	ema     float64 // EMA value
-	alpha   float64 // alpha coefficient
+	counter   int       // value counter
+	ema       float64   // EMA value
+	alpha     float64   // alpha coefficient
+	alphaFunc AlphaFunc // function to recalculate alpha on period change
 }
 
 type AlphaFunc func(period int) float64
 
 func NewEMAEvaluator(period int, alpha AlphaFunc) *EMAEvaluator {
 	return &EMAEvaluator{
-		ema:   0,
-		alpha: alpha(period),
+		ema:       0,
+		alpha:     alpha(period),
+		alphaFunc: alpha,
 	}
 }
 
+// SetPeriod changes period of the evaluator, accumulated EMA value is kept
+func (e *EMAEvaluator) SetPeriod(period int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.alpha = e.alphaFunc(period)
+}
+
 // EMA is exponential moving average. The EMA for a series P may be calculated recursively:
 // EMA(1) = P(1)                                    t = 0
 // EMA(t) = alpha * P(t) + (1 - alpha) * EMA(t-1)   t > 0
@@ -44,6 +53,28 @@ func (e *EMAEvaluator) GetEMA() float64 {
 	return e.ema
 }
 
+// EMASnapshot is the accumulated state of EMA evaluator, period is not included as it comes from config
+type EMASnapshot struct {
+	Counter int     `json:"counter"`
+	EMA     float64 `json:"ema"`
+}
+
+func (e *EMAEvaluator) Snapshot() EMASnapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return EMASnapshot{
+		Counter: e.counter,
+		EMA:     e.ema,
+	}
+}
+
+func (e *EMAEvaluator) Restore(s EMASnapshot) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.counter = s.Counter
+	e.ema = s.EMA
+}
+
 type EMAStrategy struct {
 	mu sync.RWMutex // mutex to protect strategy
 
@@ -75,3 +106,40 @@ func (e *EMAStrategy) Short() bool {
 	defer e.mu.RUnlock()
 	return e.curPrice < e.ema.GetEMA()
 }
+
+func (e *EMAStrategy) Snapshot() []StrategySnapshot {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	ema := e.ema.Snapshot()
+	return []StrategySnapshot{{
+		Name:  "ema",
+		EMA:   &ema,
+		Price: e.curPrice,
+	}}
+}
+
+func (e *EMAStrategy) Restore(s []StrategySnapshot) error {
+	if len(s) != 1 || s[0].Name != "ema" || s[0].EMA == nil {
+		return ErrSnapshotMismatch
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ema.Restore(*s[0].EMA)
+	e.curPrice = s[0].Price
+	return nil
+}
+
+func (e *EMAStrategy) Describe() []StrategyState {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	ema := e.ema.GetEMA()
+	return []StrategyState{{
+		Name: "ema",
+		Values: map[string]float64{
+			"ema":   ema,
+			"price": e.curPrice,
+		},
+		Long:  e.curPrice > ema,
+		Short: e.curPrice < ema,
+	}}
+}
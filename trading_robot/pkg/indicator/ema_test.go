@@ -67,6 +67,33 @@ func TestEMAEvaluator(t *testing.T) {
 		}
 		a.Equalf(7.958400000000001, e.GetEMA(), "Should be equal")
 	}
+
+	testID++
+	t.Logf("\tTest %d:\tema period change keeps value", testID)
+	{
+		e := NewEMAEvaluator(4, alphaFunc)
+		e.UpdateEMA(10)
+		e.SetPeriod(1)
+		a.Equalf(10.0, e.GetEMA(), "Value should be kept")
+		e.UpdateEMA(20)
+		a.Equalf(20.0, e.GetEMA(), "New alpha should be applied")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tema restored from snapshot", testID)
+	{
+		e := NewEMAEvaluator(6, alphaFunc)
+		for _, val := range []float64{4, 2, 3} {
+			e.UpdateEMA(val)
+		}
+		restored := NewEMAEvaluator(6, alphaFunc)
+		restored.Restore(e.Snapshot())
+		for _, val := range []float64{5, 7, 15} {
+			e.UpdateEMA(val)
+			restored.UpdateEMA(val)
+		}
+		a.Equalf(7.648003807937169, restored.GetEMA(), "Should be equal")
+	}
 }
 
 func TestEMAStrategy(t *testing.T) {
@@ -38,6 +38,37 @@ func (m *MACDEvaluator) GetMACD() (macd float64, signal float64) {
 	return m.macd, m.signal
 }
 
+// MACDSnapshot is the accumulated state of MACD evaluator
+type MACDSnapshot struct {
+	Short   EMASnapshot `json:"short"`
+	Long    EMASnapshot `json:"long"`
+	Average EMASnapshot `json:"average"`
+	MACD    float64     `json:"macd"`
+	Signal  float64     `json:"signal"`
+}
+
+func (m *MACDEvaluator) Snapshot() MACDSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return MACDSnapshot{
+		Short:   m.emaS.Snapshot(),
+		Long:    m.emaL.Snapshot(),
+		Average: m.emaA.Snapshot(),
+		MACD:    m.macd,
+		Signal:  m.signal,
+	}
+}
+
+func (m *MACDEvaluator) Restore(s MACDSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emaS.Restore(s.Short)
+	m.emaL.Restore(s.Long)
+	m.emaA.Restore(s.Average)
+	m.macd = s.MACD
+	m.signal = s.Signal
+}
+
 type MACDStrategy struct {
 	mu sync.RWMutex // mutex to protect strategy
 
@@ -72,3 +103,44 @@ func (m *MACDStrategy) Short() bool {
 	curMACD, curSignal := m.macd.GetMACD()
 	return m.prevMACD > m.prevSignal && curMACD < curSignal
 }
+
+func (m *MACDStrategy) Snapshot() []StrategySnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	macd := m.macd.Snapshot()
+	return []StrategySnapshot{{
+		Name:       "macd",
+		MACD:       &macd,
+		PrevMACD:   m.prevMACD,
+		PrevSignal: m.prevSignal,
+	}}
+}
+
+func (m *MACDStrategy) Restore(s []StrategySnapshot) error {
+	if len(s) != 1 || s[0].Name != "macd" || s[0].MACD == nil {
+		return ErrSnapshotMismatch
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.macd.Restore(*s[0].MACD)
+	m.prevMACD = s[0].PrevMACD
+	m.prevSignal = s[0].PrevSignal
+	return nil
+}
+
+func (m *MACDStrategy) Describe() []StrategyState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	curMACD, curSignal := m.macd.GetMACD()
+	return []StrategyState{{
+		Name: "macd",
+		Values: map[string]float64{
+			"macd":        curMACD,
+			"signal":      curSignal,
+			"prev_macd":   m.prevMACD,
+			"prev_signal": m.prevSignal,
+		},
+		Long:  m.prevMACD < m.prevSignal && curMACD > curSignal,
+		Short: m.prevMACD > m.prevSignal && curMACD < curSignal,
+	}}
+}
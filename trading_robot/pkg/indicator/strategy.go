@@ -1,11 +1,45 @@
 package indicator
 
+import "errors"
+
 type Strategy interface {
 	Update(p float64)
 	Long() bool
 	Short() bool
 }
 
+// StrategyState is a snapshot of strategy indicator values
+type StrategyState struct {
+	Name   string             `json:"name"`
+	Values map[string]float64 `json:"values"`
+	Long   bool               `json:"long"`
+	Short  bool               `json:"short"`
+}
+
+// Describer is implemented by strategies that can report their indicator values
+type Describer interface {
+	Describe() []StrategyState
+}
+
+// StrategySnapshot is the persisted state of a strategy, only indicator fields of the strategy are set
+type StrategySnapshot struct {
+	Name       string        `json:"name"`
+	EMA        *EMASnapshot  `json:"ema,omitempty"`
+	MACD       *MACDSnapshot `json:"macd,omitempty"`
+	Price      float64       `json:"price,omitempty"`
+	PrevMACD   float64       `json:"prev_macd,omitempty"`
+	PrevSignal float64       `json:"prev_signal,omitempty"`
+}
+
+// Persister is implemented by strategies which state can be saved and restored after restart
+type Persister interface {
+	Snapshot() []StrategySnapshot
+	Restore(s []StrategySnapshot) error
+}
+
+// ErrSnapshotMismatch is returned when snapshot was taken from a differently composed strategy
+var ErrSnapshotMismatch = errors.New("snapshot does not match strategy")
+
 type StrategiesComposition []Strategy
 
 func NewStrategiesComposition(strategies ...Strategy) Strategy {
@@ -46,11 +80,81 @@ func (sc StrategiesComposition) Short() bool {
 	return short && !long
 }
 
-func SetupEMA100Strategy() Strategy {
-	alphaFunc := func(p int) float64 {
-		return 2 / float64(p+1)
+func (sc StrategiesComposition) Describe() []StrategyState {
+	states := make([]StrategyState, 0, len(sc))
+	for _, strategy := range sc {
+		if d, ok := strategy.(Describer); ok {
+			states = append(states, d.Describe()...)
+		}
+	}
+	return states
+}
+
+func (sc StrategiesComposition) Snapshot() []StrategySnapshot {
+	snapshots := make([]StrategySnapshot, 0, len(sc))
+	for _, strategy := range sc {
+		if p, ok := strategy.(Persister); ok {
+			snapshots = append(snapshots, p.Snapshot()...)
+		}
 	}
+	return snapshots
+}
+
+// Restore restores every strategy of the composition, snapshot must be taken from the same composition.
+// Strategies are left unchanged if it does not match.
+func (sc StrategiesComposition) Restore(s []StrategySnapshot) error {
+	persisters := make([]Persister, 0, len(sc))
+	parts := make([][]StrategySnapshot, 0, len(sc))
+	rest := s
+	for _, strategy := range sc {
+		p, ok := strategy.(Persister)
+		if !ok {
+			continue
+		}
+		current := p.Snapshot()
+		if len(rest) < len(current) {
+			return ErrSnapshotMismatch
+		}
+		for i := range current {
+			if rest[i].Name != current[i].Name {
+				return ErrSnapshotMismatch
+			}
+		}
+		persisters = append(persisters, p)
+		parts = append(parts, rest[:len(current)])
+		rest = rest[len(current):]
+	}
+	if len(rest) != 0 {
+		return ErrSnapshotMismatch
+	}
+
+	for i, p := range persisters {
+		if err := p.Restore(parts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SmoothingAlpha is the common EMA smoothing factor 2 / (period + 1)
+func SmoothingAlpha(p int) float64 {
+	return 2 / float64(p+1)
+}
+
+func SetupEMA100Strategy() Strategy {
 	period := 100
-	ema := NewEMAEvaluator(period, alphaFunc)
+	ema := NewEMAEvaluator(period, SmoothingAlpha)
 	return NewStrategiesComposition(NewEMAStrategy(ema))
 }
+
+// SetupEMAStrategy returns EMA strategy and its evaluator, so period can be changed at runtime
+func SetupEMAStrategy(period int) (Strategy, *EMAEvaluator) {
+	ema := NewEMAEvaluator(period, SmoothingAlpha)
+	return NewStrategiesComposition(NewEMAStrategy(ema)), ema
+}
+
+// SetupMACDStrategy returns MACD strategy of the short and long EMA periods and the signal line period
+func SetupMACDStrategy(short, long, signal int) Strategy {
+	macd := NewMACDEvaluator(short, long, signal, SmoothingAlpha)
+	return NewStrategiesComposition(NewMACDStrategy(macd))
+}
@@ -1,6 +1,7 @@
 package indicator
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -70,3 +71,81 @@ func TestMacdEmaStrategy(t *testing.T) {
 		a.Equalf(false, sc.Short(), "Indicator should not recommend to sell")
 	}
 }
+
+func TestStrategiesCompositionDescribe(t *testing.T) {
+	a := assert.New(t)
+	alphaFunc := func(p int) float64 {
+		return 2 / float64(p+1)
+	}
+
+	t.Logf("\tTest %d:\tcomposition describes all strategies", 0)
+	{
+		se := NewEMAStrategy(NewEMAEvaluator(4, alphaFunc))
+		sm := NewMACDStrategy(NewMACDEvaluator(12, 26, 9, alphaFunc))
+		sc := NewStrategiesComposition(se, sm)
+		for _, val := range []float64{4, 2, 3, 5, 7, 15} {
+			sc.Update(val)
+		}
+
+		states := sc.(Describer).Describe()
+		a.Len(states, 2)
+		a.Equal("ema", states[0].Name)
+		a.Equal(15.0, states[0].Values["price"])
+		a.Equal(se.Long(), states[0].Long)
+		a.Equal("macd", states[1].Name)
+		a.Contains(states[1].Values, "signal")
+		a.Equal(sm.Short(), states[1].Short)
+	}
+}
+
+func TestStrategiesCompositionSnapshot(t *testing.T) {
+	a := assert.New(t)
+	alphaFunc := func(p int) float64 {
+		return 2 / float64(p+1)
+	}
+	newComposition := func() Strategy {
+		se := NewEMAStrategy(NewEMAEvaluator(4, alphaFunc))
+		sm := NewMACDStrategy(NewMACDEvaluator(12, 26, 9, alphaFunc))
+		return NewStrategiesComposition(se, sm)
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\trestored composition continues from the snapshot", testID)
+	{
+		sc := newComposition()
+		for _, val := range []float64{10, 7, 8, 9, 7, 1, 30, 12, 11, 8, 9, 16, 17, 18, 20} {
+			sc.Update(val)
+		}
+
+		data, err := json.Marshal(sc.(Persister).Snapshot())
+		a.NoError(err)
+		var snapshot []StrategySnapshot
+		a.NoError(json.Unmarshal(data, &snapshot))
+
+		restored := newComposition()
+		a.NoError(restored.(Persister).Restore(snapshot))
+		a.Equal(sc.(Describer).Describe(), restored.(Describer).Describe())
+
+		for _, val := range []float64{30, 32, 43, 12} {
+			sc.Update(val)
+			restored.Update(val)
+			a.Equal(sc.Long(), restored.Long())
+			a.Equal(sc.Short(), restored.Short())
+		}
+		a.Equal(sc.(Describer).Describe(), restored.(Describer).Describe())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsnapshot of other composition is rejected", testID)
+	{
+		sc := newComposition()
+		sc.Update(10)
+		snapshot := sc.(Persister).Snapshot()
+
+		other := NewStrategiesComposition(NewEMAStrategy(NewEMAEvaluator(4, alphaFunc)))
+		a.ErrorIs(other.(Persister).Restore(snapshot), ErrSnapshotMismatch)
+		a.ErrorIs(sc.(Persister).Restore(snapshot[1:]), ErrSnapshotMismatch)
+		a.ErrorIs(sc.(Persister).Restore([]StrategySnapshot{snapshot[1], snapshot[0]}), ErrSnapshotMismatch)
+		a.Equal(10.0, sc.(Describer).Describe()[0].Values["price"], "Strategy should not change")
+	}
+}
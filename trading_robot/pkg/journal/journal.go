@@ -0,0 +1,251 @@
+// Package journal writes an append-only JSONL journal of everything the bot sees and does: raw exchange messages,
+// prices, candles, strategy evaluations, orders, exchange responses and config changes. Records have a sequence
+// number that keeps growing across restarts and file rotations. The file is rotated when it exceeds the max size,
+// rotated files are renamed to <path>.1, <path>.2 and so on, the oldest files are removed.
+package journal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+// Record types
+const (
+	WSMessage        = "ws_message"        // raw WebSocket message of the exchange
+	ExchangeRequest  = "exchange_request"  // REST request changing orders
+	ExchangeResponse = "exchange_response" // raw response of the exchange request
+	PriceRecord      = "price"
+	CandleRecord     = "candle"
+	StrategyRecord   = "strategy" // strategy evaluation of a candle and the decision taken
+	OrderRecord      = "order"    // order sent by the bot
+	ResponseRecord   = "order_response"
+	ConfigRecord     = "config"
+)
+
+// Sources of config records written by the bot
+const (
+	StartupSource = "startup" // settings loaded on startup
+	FileSource    = "file"    // settings reloaded on config file change
+)
+
+const (
+	DefaultMaxSize  = 100 << 20 // bytes
+	DefaultMaxFiles = 10
+
+	// tailSize is the size of the file end read to find the last sequence number
+	tailSize = 1 << 20
+)
+
+// Record is a line of the journal. Time is the wall clock time of the record, elapsed is the monotonic time
+// since the journal was opened, so records are ordered even if the wall clock jumps.
+type Record struct {
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Elapsed time.Duration   `json:"elapsed_ns"`
+	Type    string          `json:"type"`
+	Pair    string          `json:"pair,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Settings set where the journal is written, max size is the size of a file in bytes before rotation
+// and max files is the number of rotated files kept
+type Settings struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+}
+
+type Journal struct {
+	settings Settings
+	logger   *log.Logger
+	start    time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	seq  uint64
+}
+
+// Open opens the journal for appending, sequence numbers continue from the last record of the journal.
+// Zero max size is DefaultMaxSize.
+func Open(s Settings, logger *log.Logger) (*Journal, error) {
+	if s.MaxSize <= 0 {
+		s.MaxSize = DefaultMaxSize
+	}
+	if s.MaxFiles < 0 {
+		s.MaxFiles = 0
+	}
+	j := &Journal{
+		settings: s,
+		logger:   logger,
+		start:    time.Now(),
+	}
+
+	seq, err := lastSeq(s.Path)
+	if err == nil && seq == 0 && s.MaxFiles > 0 {
+		seq, err = lastSeq(rotatedPath(s.Path, 1))
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.seq = seq
+
+	if err = j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) open() error {
+	file, err := os.OpenFile(j.settings.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+// Record appends record of the type with data encoded to JSON, pair is optional.
+// Failures are logged, so recording never stops the bot.
+func (j *Journal) Record(recordType, pair string, data interface{}) {
+	if err := j.record(recordType, pair, data); err != nil {
+		j.logger.Errorf("Journal %s record failed: %s", recordType, err)
+	}
+}
+
+func (j *Journal) record(recordType, pair string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	line, err := json.Marshal(Record{
+		Seq:     j.seq + 1,
+		Time:    time.Now().UTC(),
+		Elapsed: time.Since(j.start),
+		Type:    recordType,
+		Pair:    pair,
+		Data:    raw,
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if j.size > 0 && j.size+int64(len(line)) > j.settings.MaxSize {
+		if err = j.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	j.seq++
+	return nil
+}
+
+// rotate shifts rotated files, removing the oldest one, and starts a new file. Should be called with locked mutex.
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+	j.file = nil
+
+	path := j.settings.Path
+	if j.settings.MaxFiles == 0 {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return j.open()
+	}
+	if err := os.Remove(rotatedPath(path, j.settings.MaxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := j.settings.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(path, i), rotatedPath(path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(path, rotatedPath(path, 1)); err != nil {
+		return err
+	}
+	return j.open()
+}
+
+// Seq returns sequence number of the last record
+func (j *Journal) Seq() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// lastSeq returns sequence number of the last complete record of the file, zero if there are no records
+func lastSeq(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+	tail := make([]byte, info.Size()-offset)
+	if _, err = file.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	lines := bytes.Split(tail, []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var r Record
+		// the last line is incomplete if the bot crashed while writing it
+		if json.Unmarshal(lines[i], &r) == nil && r.Seq > 0 {
+			return r.Seq, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,140 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogger() *log.Logger {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	return logger
+}
+
+func readRecords(t *testing.T, path string) []Record {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestJournal(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	testID := 0
+	t.Logf("\tTest %d:\trecords are appended with sequence numbers", testID)
+	{
+		j, err := Open(Settings{Path: path}, newTestLogger())
+		require.NoError(t, err)
+		j.Record(CandleRecord, "PI_XBTUSD", map[string]float64{"close": 100})
+		j.Record(ConfigRecord, "", map[string]int{"quantity": 10})
+		a.NoError(j.Close())
+
+		records := readRecords(t, path)
+		a.Len(records, 2)
+		a.Equal(uint64(1), records[0].Seq)
+		a.Equal(uint64(2), records[1].Seq)
+		a.Equal(CandleRecord, records[0].Type)
+		a.Equal("PI_XBTUSD", records[0].Pair)
+		a.JSONEq(`{"close": 100}`, string(records[0].Data))
+		a.False(records[0].Time.IsZero())
+		a.LessOrEqual(records[0].Elapsed, records[1].Elapsed)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsequence continues after reopen and incomplete line", testID)
+	{
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"seq": 3, "type": "ca`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		j, err := Open(Settings{Path: path}, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(uint64(2), j.Seq())
+		a.NoError(j.Close())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tfiles are rotated by size", testID)
+	{
+		path := filepath.Join(t.TempDir(), "journal.jsonl")
+		j, err := Open(Settings{Path: path, MaxSize: 200, MaxFiles: 2}, newTestLogger())
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			j.Record(PriceRecord, "PI_XBTUSD", map[string]int{"price": i})
+		}
+		a.NoError(j.Close())
+
+		current := readRecords(t, path)
+		previous := readRecords(t, rotatedPath(path, 1))
+		a.NotEmpty(current)
+		a.Equal(previous[len(previous)-1].Seq+1, current[0].Seq, "Rotation should not break sequence")
+		a.Equal(uint64(10), current[len(current)-1].Seq)
+		a.FileExists(rotatedPath(path, 2))
+		a.NoFileExists(rotatedPath(path, 3), "Only max files should be kept")
+
+		reopened, err := Open(Settings{Path: path, MaxSize: 200, MaxFiles: 2}, newTestLogger())
+		require.NoError(t, err)
+		a.Equal(uint64(10), reopened.Seq())
+		a.NoError(reopened.Close())
+	}
+}
+
+func TestReader(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	testID := 0
+	t.Logf("\tTest %d:\trecords of rotated files are read in order", testID)
+	{
+		j, err := Open(Settings{Path: path, MaxSize: 200, MaxFiles: 10}, newTestLogger())
+		require.NoError(t, err)
+		for i := 0; i < 10; i++ {
+			j.Record(PriceRecord, "PI_XBTUSD", map[string]int{"price": i})
+		}
+		a.NoError(j.Close())
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"seq": 11, "type": "pr`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		r, err := NewReader(path)
+		require.NoError(t, err)
+		for seq := uint64(1); seq <= 10; seq++ {
+			record, err := r.Next()
+			require.NoError(t, err)
+			a.Equal(seq, record.Seq)
+		}
+		_, err = r.Next()
+		a.True(errors.Is(err, io.EOF), "Incomplete line should be skipped")
+		a.NoError(r.Close())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tmissing journal", testID)
+	{
+		_, err := NewReader(filepath.Join(t.TempDir(), "journal.jsonl"))
+		a.True(errors.Is(err, os.ErrNotExist))
+	}
+}
@@ -0,0 +1,83 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reader reads records of the journal in order, starting from the oldest rotated file
+type Reader struct {
+	paths  []string // files left to read, the oldest first
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// NewReader opens the journal at path and all its rotated files for reading
+func NewReader(path string) (*Reader, error) {
+	var paths []string
+	for n := 1; ; n++ {
+		rotated := rotatedPath(path, n)
+		if _, err := os.Stat(rotated); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		paths = append([]string{rotated}, paths...)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return &Reader{paths: append(paths, path)}, nil
+}
+
+// Next returns the next record, io.EOF after the last one. Incomplete line at the end of a file is skipped,
+// it is left when the bot crashes while writing the record.
+func (r *Reader) Next() (Record, error) {
+	for {
+		if r.reader == nil {
+			if len(r.paths) == 0 {
+				return Record{}, io.EOF
+			}
+			file, err := os.Open(r.paths[0])
+			if err != nil {
+				return Record{}, err
+			}
+			r.file, r.reader = file, bufio.NewReader(file)
+		}
+
+		line, err := r.reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if err = r.closeFile(); err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return Record{}, err
+		}
+
+		var record Record
+		if err = json.Unmarshal(line, &record); err != nil {
+			return Record{}, fmt.Errorf("%s: %w", r.paths[0], err)
+		}
+		return record, nil
+	}
+}
+
+func (r *Reader) closeFile() error {
+	err := r.file.Close()
+	r.file, r.reader = nil, nil
+	r.paths = r.paths[1:]
+	return err
+}
+
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.closeFile()
+}
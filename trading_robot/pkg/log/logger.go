@@ -17,3 +17,19 @@ func NewLogger() *Logger {
 	logger.SetLevel(logrus.TraceLevel)
 	return logger
 }
+
+// NewAuditLogger creates JSON logger appending to the file, or writing to stdout if path is empty
+func NewAuditLogger(path string) (*Logger, error) {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetOutput(os.Stdout)
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetOutput(f)
+	}
+	return logger, nil
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "trading_robot"
+
+// Registry holds all trading robot metrics and Go runtime collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	WSMessages = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_total",
+		Help:      "WebSocket messages received by feed.",
+	}, []string{"feed"})
+
+	ValidationFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validation_failures_total",
+		Help:      "WebSocket messages that failed price validation by reason.",
+	}, []string{"reason"})
+
+	WSReconnects = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_reconnects_total",
+		Help:      "WebSocket reconnects.",
+	})
+
+	WSConnected = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_connected",
+		Help:      "Whether exchange WebSocket is connected.",
+	})
+
+	WSStaleFeeds = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_stale_feeds_total",
+		Help:      "WebSocket restarts caused by absence of messages.",
+	})
+
+	Candles = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "candles_total",
+		Help:      "Candles emitted by pair.",
+	}, []string{"pair"})
+
+	Signals = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signals_total",
+		Help:      "Strategy signal actions by pair and side.",
+	}, []string{"pair", "side"})
+
+	Orders = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "orders_total",
+		Help:      "Orders sent by pair, side and status.",
+	}, []string{"pair", "side", "status"})
+
+	RESTLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rest_request_duration_seconds",
+		Help:      "Latency of exchange REST requests by endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Exchange REST requests rejected by client or server rate limit by endpoint.",
+	}, []string{"endpoint"})
+
+	DBWriteLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "Latency of database writes by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	TelegramSendFailures = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_send_failures_total",
+		Help:      "Failed Telegram message sends.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves metrics in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
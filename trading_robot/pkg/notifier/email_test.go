@@ -0,0 +1,123 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpStub is a minimal SMTP server accepting a single session
+type smtpStub struct {
+	ln   net.Listener
+	from string
+	to   []string
+	data string
+	done chan struct{}
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStub{ln: ln, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpStub) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 localhost ESMTP stub")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			_ = tp.PrintfLine("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			_ = tp.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.to = append(s.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "DATA":
+			_ = tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.data = strings.Join(lines, "\n")
+			_ = tp.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			_ = tp.PrintfLine("221 Bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	a := assert.New(t)
+
+	t.Logf("\tTest %d:\tsend email", 0)
+	{
+		s := newSMTPStub(t)
+		defer s.ln.Close()
+
+		e := &Email{
+			Address: s.ln.Addr().String(),
+			From:    "bot@example.com",
+			To:      []string{"trader@example.com"},
+		}
+		err := e.Send(context.Background(), NewMessage(domain.NotifyErrors, "first line\nsecond line"))
+		a.NoError(err)
+		<-s.done
+
+		a.Equal("bot@example.com", s.from)
+		a.Equal([]string{"trader@example.com"}, s.to)
+		r := textproto.NewReader(bufio.NewReader(strings.NewReader(s.data + "\n")))
+		header, err := r.ReadMIMEHeader()
+		a.NoError(err)
+		a.Equal("Trading robot errors notification", header.Get("Subject"))
+		a.Contains(s.data, "first line\nsecond line")
+	}
+
+	t.Logf("\tTest %d:\tsilent server does not block beyond the deadline", 1)
+	{
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		a.NoError(err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				defer conn.Close()
+				time.Sleep(time.Second) // never greets
+			}
+		}()
+
+		e := &Email{Address: ln.Addr().String(), From: "bot@example.com", To: []string{"trader@example.com"}}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		a.Error(e.Send(ctx, NewMessage(domain.NotifyErrors, "oops")))
+		a.Less(int64(time.Since(start)), int64(500*time.Millisecond))
+	}
+}
@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Writer writes messages line by line to file or stdout
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+	}
+}
+
+// NewFile opens the file for appending, "stdout" or "-" means standard output
+func NewFile(path string) (*Writer, error) {
+	if path == "stdout" || path == "-" {
+		return NewWriter(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(f), nil
+}
+
+func (w *Writer) Name() string {
+	return "file"
+}
+
+func (w *Writer) Send(_ context.Context, m Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.w, "%s [%s] %s\n", m.Time.Format(time.RFC3339), m.Kind, m.Text)
+	return err
+}
+
+func (w *Writer) Close() error {
+	if c, ok := w.w.(io.Closer); ok && w.w != os.Stdout {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter(t *testing.T) {
+	a := assert.New(t)
+	m := Message{
+		Kind: domain.NotifyOrders,
+		Text: "order",
+		Time: time.Date(2021, 11, 25, 19, 5, 3, 0, time.UTC),
+	}
+
+	testID := 0
+	t.Logf("\tTest %d:\twrite to buffer", testID)
+	{
+		var buf bytes.Buffer
+		a.NoError(NewWriter(&buf).Send(context.Background(), m))
+		a.Equal("2021-11-25T19:05:03Z [orders] order\n", buf.String())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tappend to file", testID)
+	{
+		path := filepath.Join(t.TempDir(), "notifications.log")
+		for i := 0; i < 2; i++ {
+			w, err := NewFile(path)
+			a.NoError(err)
+			a.NoError(w.Send(context.Background(), m))
+			a.NoError(w.Close())
+		}
+
+		data, err := os.ReadFile(path)
+		a.NoError(err)
+		a.Equal("2021-11-25T19:05:03Z [orders] order\n2021-11-25T19:05:03Z [orders] order\n", string(data))
+	}
+}
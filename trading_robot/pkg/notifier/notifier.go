@@ -0,0 +1,254 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+)
+
+const (
+	DefaultTemplate  = "{{.Text}}"
+	DefaultTimeout   = 10 * time.Second
+	DefaultQueueSize = 100 // messages waiting for delivery, new messages are dropped when the queue is full
+)
+
+type Message struct {
+	Kind domain.NotifyPreference `json:"kind"`
+	Text string                  `json:"text"`
+	Time time.Time               `json:"time"`
+}
+
+func NewMessage(kind domain.NotifyPreference, text string) Message {
+	return Message{
+		Kind: kind,
+		Text: text,
+		Time: time.Now(),
+	}
+}
+
+// Backend delivers a message with already rendered text
+type Backend interface {
+	Name() string
+	Send(ctx context.Context, m Message) error
+}
+
+// Channel describes how messages are delivered to the backend
+type Channel struct {
+	Backend  Backend
+	Template string        // text/template applied to Message, DefaultTemplate if empty
+	Retries  int           // number of retries after failed send
+	Backoff  time.Duration // delay before the first retry, doubled for each next one
+	Limiter  *ratelimit.TokenBucket
+}
+
+type channel struct {
+	Channel
+	tmpl *template.Template
+}
+
+// Composite fans out every message to all channels. NotifyUsers and NotifyError do not wait for delivery,
+// messages are queued and sent one by one in the background.
+type Composite struct {
+	channels []channel
+	timeout  time.Duration
+	logger   *log.Logger
+
+	mu     sync.RWMutex // mutex for protecting queue from sends after close
+	closed bool
+	queue  chan Message
+	done   chan struct{} // closed when queued messages are sent
+
+	ctx    context.Context // cancelled when queued messages are not sent in time after close
+	cancel context.CancelFunc
+}
+
+func NewComposite(timeout time.Duration, logger *log.Logger, channels ...Channel) (*Composite, error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Composite{
+		timeout: timeout,
+		logger:  logger,
+		queue:   make(chan Message, DefaultQueueSize),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, ch := range channels {
+		text := ch.Template
+		if text == "" {
+			text = DefaultTemplate
+		}
+		tmpl, err := template.New(ch.Backend.Name()).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s template: %w", ch.Backend.Name(), err)
+		}
+		c.channels = append(c.channels, channel{Channel: ch, tmpl: tmpl})
+	}
+	go c.dispatch()
+	return c, nil
+}
+
+func (c *Composite) NotifyUsers(message string) {
+	c.enqueue(NewMessage(domain.NotifyOrders, message))
+}
+
+func (c *Composite) NotifyError(message string) {
+	c.enqueue(NewMessage(domain.NotifyErrors, message))
+}
+
+// Close stops accepting messages and waits until queued messages are sent, messages that are not sent within
+// the timeout are dropped. Backends implementing io.Closer, e.g. file, are closed after that.
+func (c *Composite) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	close(c.queue)
+	c.mu.Unlock()
+
+	timer := time.AfterFunc(c.timeout, c.cancel)
+	defer timer.Stop()
+	<-c.done
+	c.cancel()
+
+	for _, ch := range c.channels {
+		if closer, ok := ch.Backend.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				c.logger.Errorf("Close %s notifier failed: %s", ch.Backend.Name(), err)
+			}
+		}
+	}
+}
+
+func (c *Composite) enqueue(m Message) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		c.logger.Errorf("Notifier closed, message dropped: %s", m.Text)
+		return
+	}
+	select {
+	case c.queue <- m:
+	default:
+		c.logger.Errorf("Notification queue is full, message dropped: %s", m.Text)
+	}
+}
+
+func (c *Composite) dispatch() {
+	defer close(c.done)
+	for m := range c.queue {
+		c.notifyWithTimeout(m)
+	}
+}
+
+func (c *Composite) notifyWithTimeout(m Message) {
+	ctx, cancel := context.WithTimeout(c.ctx, c.timeout)
+	defer cancel()
+	if err := c.Notify(ctx, m); err != nil {
+		c.logger.Errorf("Notify failed: %s", err)
+	}
+}
+
+// Notify sends message to all channels concurrently and returns joined errors of failed channels
+func (c *Composite) Notify(ctx context.Context, m Message) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for i := range c.channels {
+		wg.Add(1)
+		go func(ch *channel) {
+			defer wg.Done()
+			if err := ch.send(ctx, m); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("%s: %s", ch.Backend.Name(), err))
+				mu.Unlock()
+			}
+		}(&c.channels[i])
+	}
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (ch *channel) send(ctx context.Context, m Message) error {
+	var buf bytes.Buffer
+	if err := ch.tmpl.Execute(&buf, m); err != nil {
+		return err
+	}
+	m.Text = buf.String()
+
+	backoff := ch.Backoff
+	var err error
+	for attempt := 0; attempt <= ch.Retries; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%w, last error: %s", ctx.Err(), err)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		if ch.Limiter != nil {
+			if lerr := ch.Limiter.Wait(ctx, 1); lerr != nil {
+				return lerr
+			}
+		}
+
+		if err = ch.Backend.Send(ctx, m); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// UsersNotifier is implemented by notifiers that manage their own subscribers, e.g. Telegram bot
+type UsersNotifier interface {
+	NotifyUsers(message string)
+	NotifyError(message string)
+}
+
+type usersBackend struct {
+	name string
+	n    UsersNotifier
+}
+
+func NewUsersBackend(name string, n UsersNotifier) Backend {
+	return &usersBackend{
+		name: name,
+		n:    n,
+	}
+}
+
+func (b *usersBackend) Name() string {
+	return b.name
+}
+
+func (b *usersBackend) Send(_ context.Context, m Message) error {
+	if m.Kind == domain.NotifyErrors {
+		b.n.NotifyError(m.Text)
+	} else {
+		b.n.NotifyUsers(m.Text)
+	}
+	return nil
+}
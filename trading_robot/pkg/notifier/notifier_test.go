@@ -0,0 +1,244 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+// backendStub fails first failures sends and records the rest
+type backendStub struct {
+	name     string
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	messages []Message
+}
+
+func (b *backendStub) Name() string {
+	return b.name
+}
+
+func (b *backendStub) Send(_ context.Context, m Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.attempts++
+	if b.attempts <= b.failures {
+		return errors.New("send failed")
+	}
+	b.messages = append(b.messages, m)
+	return nil
+}
+
+type usersNotifierStub struct {
+	users, errors []string
+}
+
+func (n *usersNotifierStub) NotifyUsers(message string) {
+	n.users = append(n.users, message)
+}
+
+func (n *usersNotifierStub) NotifyError(message string) {
+	n.errors = append(n.errors, message)
+}
+
+func newTestLogger() *log.Logger {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	return logger
+}
+
+func TestComposite(t *testing.T) {
+	a := assert.New(t)
+	logger := newTestLogger()
+
+	testID := 0
+	t.Logf("\tTest %d:\tfan out with template", testID)
+	{
+		first := &backendStub{name: "first"}
+		second := &backendStub{name: "second"}
+		c, err := NewComposite(time.Second, logger,
+			Channel{Backend: first},
+			Channel{Backend: second, Template: "{{.Kind}}: {{.Text}}"},
+		)
+		a.NoError(err)
+
+		c.NotifyUsers("order")
+		c.NotifyError("oops")
+		c.Close()
+		a.Len(first.messages, 2)
+		a.Equal("order", first.messages[0].Text)
+		a.Equal(domain.NotifyOrders, first.messages[0].Kind)
+		a.Equal("orders: order", second.messages[0].Text)
+		a.Equal("errors: oops", second.messages[1].Text)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tinvalid template", testID)
+	{
+		_, err := NewComposite(time.Second, logger, Channel{Backend: &backendStub{name: "bad"}, Template: "{{.Text"})
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tretry succeeded", testID)
+	{
+		b := &backendStub{name: "flaky", failures: 2}
+		c, err := NewComposite(time.Second, logger, Channel{Backend: b, Retries: 2, Backoff: time.Millisecond})
+		a.NoError(err)
+
+		a.NoError(c.Notify(context.Background(), NewMessage(domain.NotifyOrders, "order")))
+		a.Equal(3, b.attempts)
+		a.Len(b.messages, 1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tretries exhausted do not affect other backends", testID)
+	{
+		broken := &backendStub{name: "broken", failures: 100}
+		ok := &backendStub{name: "ok"}
+		c, err := NewComposite(time.Second, logger,
+			Channel{Backend: broken, Retries: 1, Backoff: time.Millisecond},
+			Channel{Backend: ok},
+		)
+		a.NoError(err)
+
+		err = c.Notify(context.Background(), NewMessage(domain.NotifyOrders, "order"))
+		a.EqualError(err, "broken: send failed")
+		a.Equal(2, broken.attempts)
+		a.Len(ok.messages, 1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\trate limited backend", testID)
+	{
+		b := &backendStub{name: "limited"}
+		c, err := NewComposite(time.Second, logger,
+			Channel{Backend: b, Limiter: ratelimit.NewTokenBucket(1, time.Hour)},
+		)
+		a.NoError(err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		a.NoError(c.Notify(ctx, NewMessage(domain.NotifyOrders, "first")))
+		a.Error(c.Notify(ctx, NewMessage(domain.NotifyOrders, "second")))
+		a.Len(b.messages, 1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tusers backend", testID)
+	{
+		n := &usersNotifierStub{}
+		c, err := NewComposite(time.Second, logger, Channel{Backend: NewUsersBackend("telegram", n)})
+		a.NoError(err)
+
+		c.NotifyUsers("order")
+		c.NotifyError("oops")
+		c.Close()
+		a.Equal([]string{"order"}, n.users)
+		a.Equal([]string{"oops"}, n.errors)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tslow backend does not block callers and the queue is bounded", testID)
+	{
+		b := &blockingBackend{release: make(chan struct{})}
+		c, err := NewComposite(time.Second, logger, Channel{Backend: b})
+		a.NoError(err)
+
+		start := time.Now()
+		for i := 0; i < DefaultQueueSize+10; i++ {
+			c.NotifyUsers("order")
+		}
+		a.Less(int64(time.Since(start)), int64(time.Second), "Callers should not wait for delivery")
+
+		close(b.release)
+		c.Close()
+		a.LessOrEqual(b.sent(), DefaultQueueSize+1, "Messages over the queue size should be dropped")
+		c.NotifyUsers("after close")
+		a.LessOrEqual(b.sent(), DefaultQueueSize+1)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tclose drops messages not sent in time", testID)
+	{
+		b := &blockingBackend{release: make(chan struct{})}
+		c, err := NewComposite(20*time.Millisecond, logger, Channel{Backend: b})
+		a.NoError(err)
+
+		c.NotifyUsers("first")
+		c.NotifyUsers("second")
+		start := time.Now()
+		c.Close()
+		a.Less(int64(time.Since(start)), int64(time.Second))
+		a.Zero(b.sent())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tclose closes backends after queued messages are sent", testID)
+	{
+		b := &closingBackend{backendStub: backendStub{name: "file"}}
+		c, err := NewComposite(time.Second, logger, Channel{Backend: b})
+		a.NoError(err)
+
+		c.NotifyUsers("order")
+		c.Close()
+		a.Equal(1, b.closed)
+		a.Equal(1, b.sentOnClose, "Queued messages should be sent before backend is closed")
+		c.Close()
+		a.Equal(1, b.closed, "Backend should be closed once")
+	}
+}
+
+// closingBackend records how many times it is closed and how many messages were sent by then
+type closingBackend struct {
+	backendStub
+	closed      int
+	sentOnClose int
+}
+
+func (b *closingBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed++
+	b.sentOnClose = len(b.messages)
+	return nil
+}
+
+// blockingBackend waits for release or context before the send succeeds
+type blockingBackend struct {
+	release chan struct{}
+
+	mu    sync.Mutex
+	count int
+}
+
+func (b *blockingBackend) Name() string {
+	return "blocking"
+}
+
+func (b *blockingBackend) Send(ctx context.Context, _ Message) error {
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	b.mu.Lock()
+	b.count++
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *blockingBackend) sent() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
@@ -0,0 +1,46 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	a := assert.New(t)
+
+	var received Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		a.Equal(http.MethodPost, r.Method)
+		a.Equal("application/json", r.Header.Get("Content-Type"))
+		a.NoError(json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer srv.Close()
+
+	testID := 0
+	t.Logf("\tTest %d:\tsend json payload", testID)
+	{
+		m := NewMessage(domain.NotifyOrders, "order")
+		err := NewWebhook(srv.URL+"/hook").Send(context.Background(), m)
+		a.NoError(err)
+		a.Equal(m.Kind, received.Kind)
+		a.Equal(m.Text, received.Text)
+		a.True(m.Time.Equal(received.Time))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tnon 2xx status", testID)
+	{
+		err := NewWebhook(srv.URL+"/fail").Send(context.Background(), NewMessage(domain.NotifyErrors, "oops"))
+		a.EqualError(err, "unexpected status 500 Internal Server Error")
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrCostExceedsCapacity = errors.New("cost exceeds bucket capacity")
+
+// TokenBucket is a token bucket rate limiter. Bucket holds up to capacity tokens and
+// is refilled with capacity tokens every interval. Each call takes the given cost from the bucket.
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64 // tokens per nanosecond
+	tokens   float64
+	last     time.Time
+
+	now func() time.Time
+}
+
+func NewTokenBucket(capacity float64, interval time.Duration) *TokenBucket {
+	return &TokenBucket{
+		capacity: capacity,
+		rate:     capacity / float64(interval),
+		tokens:   capacity,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// refill should be called with locked mutex
+func (b *TokenBucket) refill() {
+	now := b.now()
+	b.tokens += float64(now.Sub(b.last)) * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// Allow takes cost tokens if they are available and reports whether it did
+func (b *TokenBucket) Allow(cost float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < cost {
+		return false
+	}
+	b.tokens -= cost
+	return true
+}
+
+// Reserve returns the time to wait until cost tokens are available
+func (b *TokenBucket) Reserve(cost float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens >= cost {
+		return 0
+	}
+	return time.Duration((cost - b.tokens) / b.rate)
+}
+
+// Wait blocks until cost tokens are available and takes them, or until ctx is done
+func (b *TokenBucket) Wait(ctx context.Context, cost float64) error {
+	if cost > b.capacity {
+		return ErrCostExceedsCapacity
+	}
+
+	for {
+		if b.Allow(cost) {
+			return nil
+		}
+
+		timer := time.NewTimer(b.Reserve(cost))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Available returns the current number of tokens in the bucket
+func (b *TokenBucket) Available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return b.tokens
+}
+
+// Drain empties the bucket, e.g. when the server reports that the limit is exceeded
+func (b *TokenBucket) Drain() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens = 0
+}
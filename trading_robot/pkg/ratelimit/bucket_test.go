@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tbucket exhausted", testID)
+	{
+		b := NewTokenBucket(10, time.Second)
+		now := b.last
+		b.now = func() time.Time { return now }
+
+		a.True(b.Allow(6))
+		a.True(b.Allow(4))
+		a.False(b.Allow(1))
+		a.Equal(100*time.Millisecond, b.Reserve(1))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tbucket refilled", testID)
+	{
+		b := NewTokenBucket(10, time.Second)
+		now := b.last
+		b.now = func() time.Time { return now }
+
+		a.True(b.Allow(10))
+		now = now.Add(500 * time.Millisecond)
+		a.InDelta(5.0, b.Available(), 1e-9)
+		now = now.Add(time.Hour)
+		a.InDelta(10.0, b.Available(), 1e-9)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tbucket drained", testID)
+	{
+		b := NewTokenBucket(10, time.Second)
+		now := b.last
+		b.now = func() time.Time { return now }
+
+		b.Drain()
+		a.False(b.Allow(1))
+		a.Equal(time.Second, b.Reserve(10))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\twait cost exceeds capacity", testID)
+	{
+		b := NewTokenBucket(10, time.Second)
+		a.Equal(ErrCostExceedsCapacity, b.Wait(context.Background(), 11))
+	}
+
+	testID++
+	t.Logf("\tTest %d:\twait context cancelled", testID)
+	{
+		b := NewTokenBucket(1, time.Hour)
+		a.True(b.Allow(1))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		a.ErrorIs(b.Wait(ctx, 1), context.DeadlineExceeded)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\twait for refill", testID)
+	{
+		b := NewTokenBucket(1, 20*time.Millisecond)
+		a.True(b.Allow(1))
+		a.NoError(b.Wait(context.Background(), 1))
+	}
+}
@@ -0,0 +1,108 @@
+// Package snapshot persists state between restarts in a JSON file. The file is replaced atomically,
+// so a crash while saving leaves the previous snapshot intact.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+)
+
+type Store struct {
+	mu     sync.Mutex // serializes saves
+	path   string
+	logger *log.Logger
+}
+
+func NewStore(path string, logger *log.Logger) *Store {
+	return &Store{
+		path:   path,
+		logger: logger,
+	}
+}
+
+// Save writes v to a temporary file next to the snapshot, syncs it and renames it over the snapshot
+func (s *Store) Save(v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after successful rename
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes rename durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Load reads the snapshot into v, it returns false if there is no snapshot yet
+func (s *Store) Load(v interface{}) (bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// StartSaving saves state returned by collect every interval until ctx is done, errors are logged.
+// The final snapshot should be saved by caller after state stops changing.
+func (s *Store) StartSaving(ctx context.Context, interval time.Duration, collect func() interface{}, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Save(collect()); err != nil {
+					s.logger.Errorf("Save snapshot failed: %s", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type state struct {
+	Position int      `json:"position"`
+	Pairs    []string `json:"pairs"`
+}
+
+func TestStore(t *testing.T) {
+	a := assert.New(t)
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	store := NewStore(path, logger)
+
+	testID := 0
+	t.Logf("\tTest %d:\tno snapshot yet", testID)
+	{
+		var s state
+		found, err := store.Load(&s)
+		a.NoError(err)
+		a.False(found)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tsaved snapshot is loaded", testID)
+	{
+		a.NoError(store.Save(state{Position: 10, Pairs: []string{"PI_XBTUSD"}}))
+		a.NoError(store.Save(state{Position: -10, Pairs: []string{"PI_ETHUSD"}}))
+
+		var s state
+		found, err := NewStore(path, logger).Load(&s)
+		a.NoError(err)
+		a.True(found)
+		a.Equal(state{Position: -10, Pairs: []string{"PI_ETHUSD"}}, s)
+
+		entries, err := os.ReadDir(dir)
+		a.NoError(err)
+		a.Len(entries, 1, "Temporary files should be removed")
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tfailed save keeps previous snapshot", testID)
+	{
+		a.Error(store.Save(func() {}))
+
+		var s state
+		found, err := store.Load(&s)
+		a.NoError(err)
+		a.True(found)
+		a.Equal(-10, s.Position)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tcorrupted snapshot", testID)
+	{
+		a.NoError(os.WriteFile(path, []byte(`{"position":`), 0o644))
+		var s state
+		_, err := store.Load(&s)
+		a.Error(err)
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tperiodic saving", testID)
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			position int
+		)
+		store.StartSaving(ctx, 10*time.Millisecond, func() interface{} {
+			mu.Lock()
+			defer mu.Unlock()
+			position++
+			return state{Position: position}
+		}, &wg)
+
+		a.Eventually(func() bool {
+			var s state
+			found, err := store.Load(&s)
+			return err == nil && found && s.Position > 1
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		wg.Wait()
+	}
+}
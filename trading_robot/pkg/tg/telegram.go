@@ -2,37 +2,83 @@ package tg
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	tgbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
 	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
 )
 
 const (
-	startMsg = "/start"
-	stopMsg  = "/stop"
+	startMsg   = "/start"
+	stopMsg    = "/stop"
+	allMsg     = "/all"
+	ordersMsg  = "/orders"
+	errorsMsg  = "/errors"
+	summaryMsg = "/summary"
+
+	summaryHour   = 0  // UTC hour of the daily summary
+	summaryOrders = 20 // number of the last orders listed in the daily summary, older ones are only counted
 )
 
+// preferences maps bot commands to notification preferences
+var preferences = map[string]domain.NotifyPreference{
+	startMsg:   domain.NotifyAll,
+	allMsg:     domain.NotifyAll,
+	ordersMsg:  domain.NotifyOrders,
+	errorsMsg:  domain.NotifyErrors,
+	summaryMsg: domain.NotifySummary,
+}
+
+type SubscribersRepository interface {
+	StoreSubscriber(ctx context.Context, s domain.Subscriber) error
+	DeleteSubscriber(ctx context.Context, chatID int64) error
+	GetSubscribers(ctx context.Context) ([]domain.Subscriber, error)
+}
+
+type sender interface {
+	Send(c tgbot.Chattable) (tgbot.Message, error)
+}
+
 type TelegramBot struct {
-	bot *tgbot.BotAPI
+	bot  *tgbot.BotAPI
+	send sender
+	repo SubscribersRepository
+
+	mu    sync.RWMutex                // mutex for protecting map
+	users map[int64]domain.Subscriber // map with users by chat ID
 
-	mu    sync.RWMutex     // mutex for protecting map
-	users map[string]int64 // map with users
+	// orders since the last daily summary are kept in memory, so they are lost on restart
+	summaryMu    sync.Mutex // mutex for protecting summary
+	summary      []string   // the last orders since the last daily summary
+	summaryCount int        // number of orders since the last daily summary
 
 	logger *log.Logger
 }
 
-func NewTelegramBot(token string, logger *log.Logger) (*TelegramBot, error) {
+func NewTelegramBot(token string, repo SubscribersRepository, logger *log.Logger) (*TelegramBot, error) {
 	bot, err := tgbot.NewBotAPI(token)
 	if err != nil {
 		return nil, err
 	}
 
-	return &TelegramBot{
+	tg := &TelegramBot{
 		bot:    bot,
-		users:  make(map[string]int64),
+		send:   bot,
+		repo:   repo,
+		users:  make(map[int64]domain.Subscriber),
 		logger: logger,
-	}, nil
+	}
+
+	if err = tg.restoreUsers(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return tg, nil
 }
 
 func (tg *TelegramBot) Serve(ctx context.Context) {
@@ -41,48 +87,144 @@ func (tg *TelegramBot) Serve(ctx context.Context) {
 
 	updates := tg.bot.GetUpdatesChan(u)
 
+	summaryTimer := time.NewTimer(time.Until(nextSummary(time.Now())))
+	defer summaryTimer.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			tg.logger.Info("Telegram bot: serve done")
 			return
+		case <-summaryTimer.C:
+			tg.sendSummary()
+			summaryTimer.Reset(time.Until(nextSummary(time.Now())))
 		case update := <-updates:
 			if update.Message == nil {
 				continue
 			}
 
-			if update.Message.Text == startMsg {
-				tg.addUser(update.Message.From.UserName, update.Message.Chat.ID)
-			} else if update.Message.Text == stopMsg {
-				tg.removeUser(update.Message.From.UserName)
-			}
+			tg.handleCommand(ctx, update.Message.Chat.ID, senderName(update.Message), update.Message.Text)
 		}
 	}
 }
 
+// nextSummary returns the time of the daily summary following now
+func nextSummary(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), summaryHour, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// senderName returns username of the message sender, messages of channels and anonymous admins have no user
+func senderName(m *tgbot.Message) string {
+	switch {
+	case m.From != nil:
+		return m.From.UserName
+	case m.SenderChat != nil:
+		return m.SenderChat.UserName
+	default:
+		return ""
+	}
+}
+
+func (tg *TelegramBot) handleCommand(ctx context.Context, chatID int64, username, text string) {
+	if text == stopMsg {
+		tg.removeUser(ctx, chatID)
+		return
+	}
+
+	if preference, ok := preferences[text]; ok {
+		s := domain.NewSubscriber(chatID, username)
+		s.Preference = preference
+		tg.addUser(ctx, s)
+	}
+}
+
+// NotifyUsers sends order message to users and keeps it for the daily summary
 func (tg *TelegramBot) NotifyUsers(message string) {
+	tg.summaryMu.Lock()
+	tg.summaryCount++
+	tg.summary = append(tg.summary, message)
+	if len(tg.summary) > summaryOrders {
+		tg.summary = tg.summary[len(tg.summary)-summaryOrders:]
+	}
+	tg.summaryMu.Unlock()
+
+	tg.notify(domain.NotifyOrders, message)
+}
+
+func (tg *TelegramBot) NotifyError(message string) {
+	tg.notify(domain.NotifyErrors, message)
+}
+
+func (tg *TelegramBot) sendSummary() {
+	tg.summaryMu.Lock()
+	orders, count := tg.summary, tg.summaryCount
+	tg.summary, tg.summaryCount = nil, 0
+	tg.summaryMu.Unlock()
+
+	message := fmt.Sprintf("Daily summary: %d orders", count)
+	if count > len(orders) {
+		message += fmt.Sprintf(", the last %d are listed", len(orders))
+	}
+	if len(orders) != 0 {
+		message += "\n\n" + strings.Join(orders, "\n\n")
+	}
+	tg.notify(domain.NotifySummary, message)
+}
+
+func (tg *TelegramBot) notify(kind domain.NotifyPreference, message string) {
 	tg.mu.RLock()
-	for user, ID := range tg.users {
-		msg := tgbot.NewMessage(ID, message)
+	defer tg.mu.RUnlock()
+	for ID, user := range tg.users {
+		if !user.Accepts(kind) {
+			continue
+		}
 
-		_, err := tg.bot.Send(msg)
+		_, err := tg.send.Send(tgbot.NewMessage(ID, message))
 		if err != nil {
-			tg.logger.Errorf("Send msg to %s user failed: %s", user, err)
+			metrics.TelegramSendFailures.Inc()
+			tg.logger.Errorf("Send msg to %d chat (%s) failed: %s", ID, user.Username, err)
 		}
 	}
-	tg.mu.RUnlock()
 }
 
-func (tg *TelegramBot) addUser(username string, chatID int64) {
+func (tg *TelegramBot) restoreUsers(ctx context.Context) error {
+	subscribers, err := tg.repo.GetSubscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	tg.mu.Lock()
+	for _, s := range subscribers {
+		tg.users[s.ChatID] = s
+	}
+	tg.mu.Unlock()
+	tg.logger.Debugf("Restored %d users", len(subscribers))
+	return nil
+}
+
+func (tg *TelegramBot) addUser(ctx context.Context, s domain.Subscriber) {
 	tg.mu.Lock()
-	tg.logger.Debugf("Added new user %s", username)
-	tg.users[username] = chatID
+	tg.logger.Debugf("Added user %d (%s) with %s preference", s.ChatID, s.Username, s.Preference)
+	tg.users[s.ChatID] = s
 	tg.mu.Unlock()
+
+	if err := tg.repo.StoreSubscriber(ctx, s); err != nil {
+		tg.logger.Errorf("Store user %d failed: %s", s.ChatID, err)
+	}
 }
 
-func (tg *TelegramBot) removeUser(username string) {
+func (tg *TelegramBot) removeUser(ctx context.Context, chatID int64) {
 	tg.mu.Lock()
-	tg.logger.Debugf("Removed user %s", username)
-	delete(tg.users, username)
+	tg.logger.Debugf("Removed user %d", chatID)
+	delete(tg.users, chatID)
 	tg.mu.Unlock()
+
+	if err := tg.repo.DeleteSubscriber(ctx, chatID); err != nil {
+		tg.logger.Errorf("Delete user %d failed: %s", chatID, err)
+	}
 }
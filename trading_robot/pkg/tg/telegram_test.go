@@ -0,0 +1,201 @@
+package tg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/log"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type RepoMock struct {
+	mock.Mock
+}
+
+func (r *RepoMock) StoreSubscriber(ctx context.Context, s domain.Subscriber) error {
+	args := r.Called(ctx, s)
+	return args.Error(0)
+}
+
+func (r *RepoMock) DeleteSubscriber(ctx context.Context, chatID int64) error {
+	args := r.Called(ctx, chatID)
+	return args.Error(0)
+}
+
+func (r *RepoMock) GetSubscribers(ctx context.Context) ([]domain.Subscriber, error) {
+	args := r.Called(ctx)
+	return args.Get(0).([]domain.Subscriber), args.Error(1)
+}
+
+type SenderMock struct {
+	mock.Mock
+}
+
+func (s *SenderMock) Send(c tgbot.Chattable) (tgbot.Message, error) {
+	msg := c.(tgbot.MessageConfig)
+	args := s.Called(msg.ChatID, msg.Text)
+	return tgbot.Message{}, args.Error(0)
+}
+
+type Environment struct {
+	suite.Suite
+	repo   *RepoMock
+	sender *SenderMock
+	bot    *TelegramBot
+}
+
+func (e *Environment) SetupTest() {
+	logger := log.NewLogger()
+	logger.SetLevel(0) // set panic level to prevent output spam
+	e.repo = new(RepoMock)
+	e.sender = new(SenderMock)
+	e.bot = &TelegramBot{
+		send:   e.sender,
+		repo:   e.repo,
+		users:  make(map[int64]domain.Subscriber),
+		logger: logger,
+	}
+}
+
+func (e *Environment) TearDownTest() {
+	e.repo.AssertExpectations(e.T())
+	e.sender.AssertExpectations(e.T())
+}
+
+func (e *Environment) TestRestoreUsers() {
+	e.T().Logf("\tTest %d:\trestore users on startup", 0)
+	{
+		subscribers := []domain.Subscriber{
+			{ChatID: 1, Username: "first", Preference: domain.NotifyAll},
+			{ChatID: 2, Username: "", Preference: domain.NotifyErrors},
+			{ChatID: 3, Username: "", Preference: domain.NotifyOrders},
+		}
+		e.repo.On("GetSubscribers", mock.Anything).Return(subscribers, nil).Once()
+
+		err := e.bot.restoreUsers(context.Background())
+		e.NoError(err)
+		e.Len(e.bot.users, 3)
+	}
+}
+
+func (e *Environment) TestCommands() {
+	ctx := context.Background()
+
+	testID := 0
+	e.T().Logf("\tTest %d:\tusers without username do not collide", testID)
+	{
+		e.repo.On("StoreSubscriber", ctx, domain.Subscriber{ChatID: 1, Preference: domain.NotifyAll}).Return(nil).Once()
+		e.repo.On("StoreSubscriber", ctx, domain.Subscriber{ChatID: 2, Preference: domain.NotifyAll}).Return(nil).Once()
+		e.bot.handleCommand(ctx, 1, "", startMsg)
+		e.bot.handleCommand(ctx, 2, "", startMsg)
+		e.Len(e.bot.users, 2)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tchange preference", testID)
+	{
+		e.repo.On("StoreSubscriber", ctx, domain.Subscriber{ChatID: 2, Preference: domain.NotifyErrors}).Return(nil).Once()
+		e.bot.handleCommand(ctx, 2, "", errorsMsg)
+		e.Equal(domain.NotifyErrors, e.bot.users[2].Preference)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tunknown command ignored", testID)
+	{
+		e.bot.handleCommand(ctx, 3, "", "hello")
+		e.Len(e.bot.users, 2)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tstop removes user", testID)
+	{
+		e.repo.On("DeleteSubscriber", ctx, int64(1)).Return(nil).Once()
+		e.bot.handleCommand(ctx, 1, "", stopMsg)
+		e.Len(e.bot.users, 1)
+	}
+}
+
+func (e *Environment) TestNotify() {
+	e.bot.users = map[int64]domain.Subscriber{
+		1: {ChatID: 1, Preference: domain.NotifyAll},
+		2: {ChatID: 2, Preference: domain.NotifyOrders},
+		3: {ChatID: 3, Preference: domain.NotifyErrors},
+		4: {ChatID: 4, Preference: domain.NotifySummary},
+	}
+
+	testID := 0
+	e.T().Logf("\tTest %d:\torder notification", testID)
+	{
+		e.sender.On("Send", int64(1), "order").Return(nil).Once()
+		e.sender.On("Send", int64(2), "order").Return(nil).Once()
+		e.bot.NotifyUsers("order")
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\terror notification", testID)
+	{
+		e.sender.On("Send", int64(1), "error").Return(nil).Once()
+		e.sender.On("Send", int64(3), "error").Return(nil).Once()
+		e.bot.NotifyError("error")
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tdaily summary", testID)
+	{
+		summary := "Daily summary: 1 orders\n\norder"
+		e.sender.On("Send", int64(1), summary).Return(nil).Once()
+		e.sender.On("Send", int64(4), summary).Return(nil).Once()
+		e.bot.sendSummary()
+		e.Empty(e.bot.summary)
+	}
+
+	testID++
+	e.T().Logf("\tTest %d:\tdaily summary lists the last orders only", testID)
+	{
+		e.bot.users = map[int64]domain.Subscriber{4: {ChatID: 4, Preference: domain.NotifySummary}}
+		var orders []string
+		for i := 0; i < summaryOrders+5; i++ {
+			e.bot.NotifyUsers(fmt.Sprint("order ", i))
+			orders = append(orders, fmt.Sprint("order ", i))
+		}
+		e.Len(e.bot.summary, summaryOrders)
+
+		summary := fmt.Sprintf("Daily summary: %d orders, the last %d are listed\n\n%s",
+			summaryOrders+5, summaryOrders, strings.Join(orders[5:], "\n\n"))
+		e.sender.On("Send", int64(4), summary).Return(nil).Once()
+		e.bot.sendSummary()
+		e.Zero(e.bot.summaryCount)
+	}
+}
+
+func (e *Environment) TestNextSummary() {
+	testID := 0
+	e.T().Logf("\tTest %d:\tsummary is sent at the fixed time of day", testID)
+	{
+		msk := time.FixedZone("MSK", 3*60*60)
+		e.Equal(time.Date(2021, 11, 26, summaryHour, 0, 0, 0, time.UTC),
+			nextSummary(time.Date(2021, 11, 25, 19, 30, 0, 0, msk)))
+		e.Equal(time.Date(2021, 11, 27, summaryHour, 0, 0, 0, time.UTC),
+			nextSummary(time.Date(2021, 11, 26, summaryHour, 0, 0, 0, time.UTC)), "Summary is not sent twice")
+	}
+}
+
+func (e *Environment) TestSenderName() {
+	testID := 0
+	e.T().Logf("\tTest %d:\tmessages without user", testID)
+	{
+		e.Equal("trader", senderName(&tgbot.Message{From: &tgbot.User{UserName: "trader"}}))
+		e.Equal("channel", senderName(&tgbot.Message{SenderChat: &tgbot.Chat{UserName: "channel"}}))
+		e.Equal("", senderName(&tgbot.Message{}))
+	}
+}
+
+func TestTelegramBot(t *testing.T) {
+	suite.Run(t, new(Environment))
+}
@@ -5,15 +5,18 @@ import (
 
 	"github.com/go-playground/validator/v10"
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
 )
 
 func ValidateDataIsPrice(data []byte) (domain.Price, bool) {
 	var price domain.Price
 	if err := json.Unmarshal(data, &price); err != nil {
+		metrics.ValidationFailures.WithLabelValues("unmarshal").Inc()
 		return domain.Price{}, false
 	}
 
 	if err := validator.New().Struct(price); err != nil {
+		metrics.ValidationFailures.WithLabelValues("validation").Inc()
 		return domain.Price{}, false
 	}
 
@@ -32,3 +35,23 @@ func ValidateDataIsTicker(data []byte) (domain.Ticker, bool) {
 
 	return ticker, true
 }
+
+// ParseFeed returns feed of the WS message, event name for event messages or "unknown"
+func ParseFeed(data []byte) string {
+	var msg struct {
+		Feed  string `json:"feed"`
+		Event string `json:"event"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return "unknown"
+	}
+
+	switch {
+	case msg.Feed != "":
+		return msg.Feed
+	case msg.Event != "":
+		return msg.Event
+	default:
+		return "unknown"
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/keruch/tfs-go-hw/trading_robot/internal/domain"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -50,6 +52,35 @@ func TestValidateDataIsPrice(t *testing.T) {
 	}
 }
 
+func TestValidateDataIsPriceMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	t.Logf("\tTest %d:\tvalidation failures counted", 0)
+	{
+		unmarshal := testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("unmarshal"))
+		validation := testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("validation"))
+
+		ValidateDataIsPrice([]byte("not json"))
+		ValidateDataIsPrice([]byte(subscribedEvent))
+		ValidateDataIsPrice([]byte(subscriptionPriceData))
+
+		a.Equal(unmarshal+1, testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("unmarshal")))
+		a.Equal(validation+1, testutil.ToFloat64(metrics.ValidationFailures.WithLabelValues("validation")))
+	}
+}
+
+func TestParseFeed(t *testing.T) {
+	a := assert.New(t)
+
+	t.Logf("\tTest %d:\tfeeds and events", 0)
+	{
+		a.Equal("trade", ParseFeed([]byte(subscriptionPriceData)))
+		a.Equal("ticker", ParseFeed([]byte(subscriptionTickerData)))
+		a.Equal("info", ParseFeed([]byte(connectedWS)))
+		a.Equal("unknown", ParseFeed([]byte("not json")))
+	}
+}
+
 func TestValidateDataIsTicker(t *testing.T) {
 	a := assert.New(t)
 
@@ -2,81 +2,315 @@ package utils
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/keruch/tfs-go-hw/trading_robot/pkg/metrics"
 )
 
-var ErrBadHandshake = errors.New("can not handshake with host")
+var (
+	ErrBadHandshake = errors.New("can not handshake with host")
+	ErrClosed       = errors.New("connection is closed")
+)
+
+const (
+	DefaultMinBackoff   = 500 * time.Millisecond
+	DefaultMaxBackoff   = 30 * time.Second
+	DefaultPingPeriod   = 20 * time.Second
+	DefaultPongWait     = 60 * time.Second
+	DefaultStaleTimeout = 60 * time.Second
+
+	writeWait    = 10 * time.Second
+	statesBuffer = 16
+)
+
+// ConnState is a state of WebSocket connection
+type ConnState string
 
-// RetryableWSConn - should be one instance for each dial
+const (
+	StateConnected    ConnState = "connected"
+	StateReconnecting ConnState = "reconnecting"
+	StateStale        ConnState = "stale"
+	StateDisconnected ConnState = "disconnected"
+	StateClosed       ConnState = "closed"
+	// StateResubscribing is emitted by users of the connection when subscriptions are not restored after redial
+	StateResubscribing ConnState = "resubscribing"
+)
+
+// ConnEvent is emitted on every change of connection state
+type ConnEvent struct {
+	State ConnState
+	Time  time.Time
+	Err   error
+}
+
+// RetryableWSConn - should be one instance for each dial. Zero durations are replaced with defaults.
 type RetryableWSConn struct {
 	URL           url.URL
 	MaxRetries    int
 	RequestHeader http.Header
 
-	conn *websocket.Conn
+	MinBackoff   time.Duration // delay before the first redial, doubled for each next one
+	MaxBackoff   time.Duration // max delay between redials
+	PingPeriod   time.Duration // period of keepalive pings
+	PongWait     time.Duration // read deadline, extended by every message and pong
+	StaleTimeout time.Duration // connection is restarted if no data message arrives in this time
+
+	once   sync.Once
+	states chan ConnEvent
+
+	mu     sync.Mutex // mutex for protecting connection and generation
+	conn   *websocket.Conn
+	gen    int           // generation of connection, incremented on every dial
+	done   chan struct{} // closed when the current connection is replaced or closed
+	closed bool
+
+	dialMu  sync.Mutex // mutex for serializing redials
+	writeMu sync.Mutex // mutex for serializing writes, gorilla allows only one concurrent writer
+
+	connected   int32
+	lastMessage int64 // unix nano time of the last data message
+}
+
+func (c *RetryableWSConn) init() {
+	c.once.Do(func() {
+		c.states = make(chan ConnEvent, statesBuffer)
+		if c.MinBackoff == 0 {
+			c.MinBackoff = DefaultMinBackoff
+		}
+		if c.MaxBackoff == 0 {
+			c.MaxBackoff = DefaultMaxBackoff
+		}
+		if c.PingPeriod == 0 {
+			c.PingPeriod = DefaultPingPeriod
+		}
+		if c.PongWait == 0 {
+			c.PongWait = DefaultPongWait
+		}
+		if c.StaleTimeout == 0 {
+			c.StaleTimeout = DefaultStaleTimeout
+		}
+	})
 }
 
+// States returns connection state events. Events are dropped if nobody reads them.
+func (c *RetryableWSConn) States() <-chan ConnEvent {
+	c.init()
+	return c.states
+}
+
+// Emit sends the state event on behalf of the connection user, e.g. when restoring subscriptions failed
+func (c *RetryableWSConn) Emit(state ConnState, err error) {
+	c.init()
+	c.emit(state, err)
+}
+
+func (c *RetryableWSConn) emit(state ConnState, err error) {
+	if state == StateConnected {
+		metrics.WSConnected.Set(1)
+	} else {
+		metrics.WSConnected.Set(0)
+	}
+	select {
+	case c.states <- ConnEvent{State: state, Time: time.Now(), Err: err}:
+	default:
+	}
+}
+
+// RetryableDial dials with jittered exponential backoff until success or MaxRetries failed retries
 func (c *RetryableWSConn) RetryableDial() (*http.Response, error) {
-	var (
-		conn         *websocket.Conn
-		resp         *http.Response
-		retriesCount int
-	)
+	c.init()
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+	return c.dial()
+}
+
+func (c *RetryableWSConn) dial() (*http.Response, error) {
+	var retriesCount int
 	for {
-		var err error
-		conn, resp, err = websocket.DefaultDialer.Dial(c.URL.String(), c.RequestHeader)
+		conn, resp, err := websocket.DefaultDialer.Dial(c.URL.String(), c.RequestHeader)
 		if err == nil {
-			break
+			if err = c.setConn(conn); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			return resp, nil
 		}
-		if errors.Is(err, websocket.ErrBadHandshake) {
-			retriesCount++
-			if retriesCount > c.MaxRetries {
+
+		retriesCount++
+		if retriesCount > c.MaxRetries {
+			if errors.Is(err, websocket.ErrBadHandshake) {
 				return nil, ErrBadHandshake
 			}
-			continue
+			return nil, fmt.Errorf("dial %s: %w", c.URL.String(), err)
 		}
-		return nil, err
+		c.emit(StateReconnecting, err)
+		time.Sleep(Backoff(c.MinBackoff, c.MaxBackoff, retriesCount-1))
+	}
+}
+
+// Backoff returns jittered delay for the attempt: random value in [d/2, d], where d = min * 2^attempt limited by max
+func Backoff(min, max time.Duration, attempt int) time.Duration {
+	d := max
+	if attempt < 32 && min<<uint(attempt) < max && min<<uint(attempt) > 0 {
+		d = min << uint(attempt)
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func (c *RetryableWSConn) setConn(conn *websocket.Conn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClosed
 	}
 
+	if c.conn != nil {
+		close(c.done)
+		_ = c.conn.Close()
+	}
 	c.conn = conn
-	return resp, nil
+	c.gen++
+	c.done = make(chan struct{})
+
+	atomic.StoreInt64(&c.lastMessage, time.Now().UnixNano())
+	_ = conn.SetReadDeadline(time.Now().Add(c.PongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(c.PongWait))
+	})
+	go c.keepalive(conn, c.done)
+
+	atomic.StoreInt32(&c.connected, 1)
+	c.emit(StateConnected, nil)
+	return nil
 }
 
-func (c *RetryableWSConn) ReadMessage() (messageType int, p []byte, reconnected bool, err error) {
-	messageType, p, err = c.conn.ReadMessage()
-	if err != nil {
-		if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			_, err = c.RetryableDial()
-			if err != nil {
+// keepalive pings the connection and closes it if the feed is stale, so the reader reconnects
+func (c *RetryableWSConn) keepalive(conn *websocket.Conn, done <-chan struct{}) {
+	ping := time.NewTicker(c.PingPeriod)
+	defer ping.Stop()
+	watchdog := time.NewTicker(c.StaleTimeout / 4)
+	defer watchdog.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			// WriteControl is safe to call concurrently with other writes
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				_ = conn.Close()
+				return
+			}
+		case <-watchdog.C:
+			last := time.Unix(0, atomic.LoadInt64(&c.lastMessage))
+			if time.Since(last) > c.StaleTimeout {
+				metrics.WSStaleFeeds.Inc()
+				c.emit(StateStale, fmt.Errorf("no messages since %s", last.Format(time.RFC3339)))
+				_ = conn.Close()
 				return
 			}
-			reconnected = true
-			return
 		}
-		return
 	}
-	return
 }
 
+func (c *RetryableWSConn) current() (*websocket.Conn, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, 0, ErrClosed
+	}
+	return c.conn, c.gen, nil
+}
+
+// reconnect redials unless connection was already replaced since gen, reports whether it dialed
+func (c *RetryableWSConn) reconnect(gen int, cause error) (bool, error) {
+	c.dialMu.Lock()
+	defer c.dialMu.Unlock()
+
+	c.mu.Lock()
+	closed, replaced := c.closed, c.gen != gen
+	c.mu.Unlock()
+	if closed {
+		return false, ErrClosed
+	}
+	if replaced {
+		return false, nil
+	}
+
+	atomic.StoreInt32(&c.connected, 0)
+	c.emit(StateDisconnected, cause)
+	if _, err := c.dial(); err != nil {
+		return false, err
+	}
+	metrics.WSReconnects.Inc()
+	return true, nil
+}
+
+// IsConnected reports whether the last dial succeeded and the connection has not failed since
+func (c *RetryableWSConn) IsConnected() bool {
+	return atomic.LoadInt32(&c.connected) == 1
+}
+
+// ReadMessage reads the next data message. On connection failure it redials and returns reconnected
+// with nil error, so the caller can restore subscriptions and read again.
+func (c *RetryableWSConn) ReadMessage() (messageType int, p []byte, reconnected bool, err error) {
+	for {
+		conn, gen, err := c.current()
+		if err != nil {
+			return 0, nil, false, err
+		}
+
+		messageType, p, err = conn.ReadMessage()
+		if err == nil {
+			atomic.StoreInt64(&c.lastMessage, time.Now().UnixNano())
+			_ = conn.SetReadDeadline(time.Now().Add(c.PongWait))
+			return messageType, p, false, nil
+		}
+
+		reconnected, err = c.reconnect(gen, err)
+		if err != nil || reconnected {
+			return 0, nil, reconnected, err
+		}
+		// connection was already replaced by writer, read from the new one
+	}
+}
+
+// WriteJSON writes message, safe for concurrent use. On failure it redials and the message is not sent.
 func (c *RetryableWSConn) WriteJSON(v interface{}) (reconnected bool, err error) {
-	err = c.conn.WriteJSON(v)
+	conn, gen, err := c.current()
 	if err != nil {
-		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-			_, err = c.RetryableDial()
-			if err != nil {
-				return
-			}
-			reconnected = true
-			return
-		}
 		return
 	}
-	return
+
+	c.writeMu.Lock()
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	err = conn.WriteJSON(v)
+	c.writeMu.Unlock()
+	if err == nil {
+		return
+	}
+
+	return c.reconnect(gen, err)
 }
 
 func (c *RetryableWSConn) Close() error {
+	c.init()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.conn == nil {
+		c.closed = true
+		return nil
+	}
+	c.closed = true
+	atomic.StoreInt32(&c.connected, 0)
+	close(c.done)
+	c.emit(StateClosed, nil)
 	return c.conn.Close()
 }
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newWSServer starts server calling handle with index of every accepted connection
+func newWSServer(handle func(i int, conn *websocket.Conn)) (*httptest.Server, url.URL) {
+	var (
+		upgrader websocket.Upgrader
+		count    int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(int(atomic.AddInt32(&count, 1))-1, conn)
+	}))
+	u, _ := url.Parse(srv.URL)
+	u.Scheme = "ws"
+	return srv, *u
+}
+
+func waitState(t *testing.T, states <-chan ConnEvent, state ConnState) {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case e := <-states:
+			if e.State == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("state %s not emitted", state)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\tbackoff grows exponentially with jitter", testID)
+	{
+		for attempt, expected := range []time.Duration{100, 200, 400, 800} {
+			d := Backoff(100, time.Second, attempt)
+			a.GreaterOrEqual(int64(d), int64(expected/2))
+			a.LessOrEqual(int64(d), int64(expected))
+		}
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tbackoff limited by max", testID)
+	{
+		a.LessOrEqual(int64(Backoff(100, time.Second, 10)), int64(time.Second))
+		a.LessOrEqual(int64(Backoff(100, time.Second, 100)), int64(time.Second))
+	}
+}
+
+func TestRetryableWSConn(t *testing.T) {
+	a := assert.New(t)
+
+	testID := 0
+	t.Logf("\tTest %d:\treconnect after connection is closed by server", testID)
+	{
+		srv, u := newWSServer(func(i int, conn *websocket.Conn) {
+			if i == 0 {
+				return
+			}
+			_ = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+			_, _, _ = conn.ReadMessage()
+		})
+		c := &RetryableWSConn{URL: u, MaxRetries: 3, MinBackoff: time.Millisecond}
+		states := c.States()
+		_, err := c.RetryableDial()
+		a.NoError(err)
+		waitState(t, states, StateConnected)
+
+		_, _, reconnected, err := c.ReadMessage()
+		a.NoError(err)
+		a.True(reconnected, "Should reconnect")
+		waitState(t, states, StateDisconnected)
+		waitState(t, states, StateConnected)
+
+		_, data, reconnected, err := c.ReadMessage()
+		a.NoError(err)
+		a.False(reconnected)
+		a.Equal("hello", string(data))
+		a.True(c.IsConnected())
+
+		a.NoError(c.Close())
+		srv.Close()
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tstale feed is restarted", testID)
+	{
+		srv, u := newWSServer(func(i int, conn *websocket.Conn) {
+			if i != 0 {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte("fresh"))
+			}
+			_, _, _ = conn.ReadMessage()
+		})
+		c := &RetryableWSConn{URL: u, MaxRetries: 3, MinBackoff: time.Millisecond, StaleTimeout: 100 * time.Millisecond}
+		states := c.States()
+		_, err := c.RetryableDial()
+		a.NoError(err)
+
+		_, _, reconnected, err := c.ReadMessage()
+		a.NoError(err)
+		a.True(reconnected, "Should reconnect stale feed")
+		waitState(t, states, StateStale)
+
+		_, data, _, err := c.ReadMessage()
+		a.NoError(err)
+		a.Equal("fresh", string(data))
+
+		a.NoError(c.Close())
+		srv.Close()
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tconcurrent writes are serialized", testID)
+	{
+		received := make(chan string, 100)
+		srv, u := newWSServer(func(i int, conn *websocket.Conn) {
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				received <- string(data)
+			}
+		})
+		c := &RetryableWSConn{URL: u}
+		_, err := c.RetryableDial()
+		a.NoError(err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reconnected, err := c.WriteJSON(map[string]string{"event": "subscribe"})
+				a.NoError(err)
+				a.False(reconnected)
+			}()
+		}
+		wg.Wait()
+		for i := 0; i < 50; i++ {
+			select {
+			case msg := <-received:
+				a.True(strings.Contains(msg, "subscribe"))
+			case <-time.After(2 * time.Second):
+				t.Fatalf("received %d of 50 messages", i)
+			}
+		}
+
+		a.NoError(c.Close())
+		srv.Close()
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tdial fails after max retries", testID)
+	{
+		srv, u := newWSServer(func(i int, conn *websocket.Conn) {})
+		srv.Close()
+		c := &RetryableWSConn{URL: u, MaxRetries: 2, MinBackoff: time.Millisecond}
+		_, err := c.RetryableDial()
+		a.Error(err)
+		a.False(c.IsConnected())
+	}
+
+	testID++
+	t.Logf("\tTest %d:\tread after close", testID)
+	{
+		srv, u := newWSServer(func(i int, conn *websocket.Conn) {
+			_, _, _ = conn.ReadMessage()
+		})
+		c := &RetryableWSConn{URL: u}
+		_, err := c.RetryableDial()
+		a.NoError(err)
+		a.NoError(c.Close())
+		_, _, _, err = c.ReadMessage()
+		a.ErrorIs(err, ErrClosed)
+		srv.Close()
+	}
+}